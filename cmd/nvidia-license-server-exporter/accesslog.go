@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
+)
+
+// Access log modes.
+const (
+	// accessLogRecord logs a regular log record, in LOG_FORMAT.
+	accessLogRecord = "log"
+	// accessLogJSON and accessLogCommon write one line per request to
+	// stdout, apart from the application logs on stderr.
+	accessLogJSON   = "json"
+	accessLogCommon = "common"
+	accessLogOff    = "off"
+)
+
+// accessLogger decides which requests are logged and how.
+type accessLogger struct {
+	mode       string
+	sampleRate float64
+	exclude    map[string]bool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newAccessLogger(cfg config.Log) (*accessLogger, error) {
+	l := &accessLogger{
+		mode:       strings.ToLower(strings.TrimSpace(cfg.AccessLog)),
+		sampleRate: cfg.AccessLogSampleRate,
+		exclude:    make(map[string]bool),
+		out:        os.Stdout,
+	}
+	switch l.mode {
+	case "":
+		l.mode = accessLogRecord
+	case accessLogRecord, accessLogJSON, accessLogCommon, accessLogOff:
+	default:
+		return nil, fmt.Errorf("unsupported access log mode %q: use log, json, common or off", cfg.AccessLog)
+	}
+	if l.sampleRate < 0 || l.sampleRate > 1 {
+		return nil, fmt.Errorf("access log sample rate %g is not between 0 and 1", l.sampleRate)
+	}
+	for _, path := range strings.Split(cfg.AccessLogExclude, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			l.exclude[path] = true
+		}
+	}
+	return l, nil
+}
+
+// accessLogEntry is one served request.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	Remote    string    `json:"remote"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// log logs a request unless its path is excluded or it is sampled out.
+// Failed requests, with a status of 400 or more, are never sampled out.
+func (l *accessLogger) log(r *http.Request, status, bytes int, start time.Time) {
+	if l.mode == accessLogOff || l.exclude[r.URL.Path] {
+		return
+	}
+	if status < 400 && l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return
+	}
+	duration := time.Since(start)
+	switch l.mode {
+	case accessLogRecord:
+		slog.InfoContext(r.Context(), "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", bytes,
+			"duration", duration,
+			"remote", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	case accessLogJSON:
+		line, err := json.Marshal(accessLogEntry{
+			Time:      start.UTC(),
+			RequestID: logging.RequestID(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			Bytes:     bytes,
+			Duration:  duration.Seconds(),
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		})
+		if err == nil {
+			l.write(string(line) + "\n")
+		}
+	case accessLogCommon:
+		l.write(commonLogLine(r, status, bytes, start))
+	}
+}
+
+func (l *accessLogger) write(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.out, logging.Redact(line))
+}
+
+// commonLogLine formats r in the Common Log Format of Apache and nginx:
+//
+//	host ident authuser [date] "request line" status bytes
+func commonLogLine(r *http.Request, status, bytes int, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s\n",
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto, status, size)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/config"
+)
+
+func TestAccessLogger(t *testing.T) {
+	request := func(path string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.3.7:51522"
+		req.Header.Set("User-Agent", "Prometheus/3.5.0")
+		return req
+	}
+	start := time.Date(2026, 10, 16, 9, 12, 4, 0, time.UTC)
+
+	t.Run("common", func(t *testing.T) {
+		l, err := newAccessLogger(config.Log{AccessLog: "common", AccessLogSampleRate: 1, AccessLogExclude: "/healthz"})
+		if err != nil {
+			t.Fatalf("new access logger: %v", err)
+		}
+		var out bytes.Buffer
+		l.out = &out
+		l.log(request("/metrics?x=1"), http.StatusOK, 48213, start)
+		l.log(request("/healthz"), http.StatusOK, 3, start)
+		want := `10.0.3.7 - - [16/Oct/2026:09:12:04 +0000] "GET /metrics?x=1 HTTP/1.1" 200 48213` + "\n"
+		if out.String() != want {
+			t.Fatalf("got %q, want %q", out.String(), want)
+		}
+	})
+
+	t.Run("json sampled", func(t *testing.T) {
+		l, err := newAccessLogger(config.Log{AccessLog: "json", AccessLogSampleRate: 0})
+		if err != nil {
+			t.Fatalf("new access logger: %v", err)
+		}
+		var out bytes.Buffer
+		l.out = &out
+		l.log(request("/metrics"), http.StatusOK, 10, start)
+		l.log(request("/metrics"), http.StatusServiceUnavailable, 10, start)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("expected only the failed request, got %q", out.String())
+		}
+		var entry accessLogEntry
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if entry.Status != http.StatusServiceUnavailable || entry.Path != "/metrics" || entry.UserAgent != "Prometheus/3.5.0" {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	})
+
+	for _, cfg := range []config.Log{{AccessLog: "apache", AccessLogSampleRate: 1}, {AccessLog: "log", AccessLogSampleRate: 2}} {
+		if _, err := newAccessLogger(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/snapshot"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nvidia-license-server-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireWebAuth puts next behind the web config authentication, except for
+// /healthz and the /-/ paths. Probes call /healthz, /-/healthy and /-/ready
+// without credentials, and the admin endpoints and cache bypass already
+// require the admin token in the same Authorization header.
+func requireWebAuth(cfg *web.Config, next http.Handler, metricsPath string, adminBypass bool) http.Handler {
+	protected := cfg.RequireAuth(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz",
+			strings.HasPrefix(r.URL.Path, "/-/"),
+			adminBypass && r.URL.Path == metricsPath && r.URL.Query().Get("cache") == "bypass":
+			next.ServeHTTP(w, r)
+		default:
+			protected.ServeHTTP(w, r)
+		}
+	})
+}
+
+// metricsHandler serves cached metrics, or a freshly fetched scrape when the
+// request carries ?cache=bypass. A nil bypass handler disables bypassing.
+func metricsHandler(cached, bypass http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cache") {
+		case "":
+			cached.ServeHTTP(w, r)
+		case "bypass":
+			if bypass == nil {
+				http.Error(w, "cache bypass is disabled", http.StatusForbidden)
+				return
+			}
+			bypass.ServeHTTP(w, r)
+		default:
+			http.Error(w, `unsupported cache parameter: use "bypass"`, http.StatusBadRequest)
+		}
+	})
+}
+
+type refreshResult struct {
+	Meta  snapshot.Meta `json:"meta"`
+	Error string        `json:"error,omitempty"`
+}
+
+func refreshHandler(manager *snapshot.Manager, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		for _, svc := range manager.Services() {
+			svc.Invalidate()
+		}
+
+		status := http.StatusOK
+		response := make(map[string]refreshResult, len(manager.Services()))
+		for _, result := range manager.ForceRefreshAll(ctx) {
+			org := result.Service.Target()
+			if result.Err != nil {
+				slog.ErrorContext(r.Context(), "manual refresh failed", "org", org, "error", result.Err)
+				status = http.StatusBadGateway
+				response[org] = refreshResult{Meta: result.Service.Meta(), Error: result.Err.Error()}
+				continue
+			}
+			response[org] = refreshResult{Meta: result.Meta}
+		}
+		writeJSON(w, status, response)
+	})
+}
+
+// flusher is implemented by the OTEL metrics pusher and the tracer and
+// logger providers.
+type flusher interface {
+	ForceFlush(ctx context.Context) error
+}
+
+type flushResult struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// otelFlushHandler exports pending OTEL data for every signal right away, to
+// check collector connectivity without waiting for the next interval.
+func otelFlushHandler(flushers map[string]flusher, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		status := http.StatusOK
+		response := make(map[string]flushResult, len(flushers))
+		for signal, f := range flushers {
+			start := time.Now()
+			err := f.ForceFlush(ctx)
+			result := flushResult{DurationSeconds: time.Since(start).Seconds()}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "otel flush failed", "signal", signal, "error", err)
+				status = http.StatusBadGateway
+				result.Error = logging.Redact(err.Error())
+			}
+			response[signal] = result
+		}
+		writeJSON(w, status, response)
+	})
+}
+
+type reloadResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// reloadHandler reloads the configuration like SIGHUP, without the forced
+// refresh, and reports whether the new configuration was applied.
+func reloadHandler(r interface{ Reload() error }) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, reloadResult{Status: "error", Error: logging.Redact(err.Error())})
+			return
+		}
+		writeJSON(w, http.StatusOK, reloadResult{Status: "success"})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("write json response failed", "error", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// allowlist holds the networks -allow-cidrs admits. A nil allowlist admits
+// every client.
+type allowlist []netip.Prefix
+
+// parseAllowlist parses a comma-separated list of CIDRs. A bare address is a
+// single host.
+func parseAllowlist(s string) (allowlist, error) {
+	list, err := parsePrefixes(s)
+	return allowlist(list), err
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs and addresses, with
+// IPv4-mapped IPv6 ones unmapped.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var list []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("%q is neither a CIDR nor an IP address", field)
+			}
+			list = append(list, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a CIDR nor an IP address", field)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		list = append(list, prefix.Masked())
+	}
+	return list, nil
+}
+
+// allows reports whether the client of r may be served. Health checks are
+// always served, as probes from kubelets and load balancers rarely come
+// from the networks that scrape.
+func (l allowlist) allows(r *http.Request) bool {
+	if l == nil || isHealthCheck(r.URL.Path) {
+		return true
+	}
+	addr, ok := remoteAddr(r)
+	return ok && containsAddr(l, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the client of r, with IPv4-mapped
+// IPv6 addresses unmapped. That is the TCP peer, unless realIPMiddleware
+// replaced it by the client named by a trusted proxy.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func isHealthCheck(path string) bool {
+	return path == "/healthz" || path == "/-/healthy" || path == "/-/ready"
+}
+
+func (l allowlist) forbid(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "request rejected by allowlist", "remote", r.RemoteAddr, "path", r.URL.Path)
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowlist(t *testing.T) {
+	list, err := parseAllowlist("10.0.0.0/8, 192.168.1.7,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, tt := range []struct {
+		remote, path string
+		want         bool
+	}{
+		{"10.1.2.3:5555", "/metrics", true},
+		{"[::ffff:10.1.2.3]:5555", "/metrics", true},
+		{"192.168.1.7:5555", "/api/v1/diff", true},
+		{"192.168.1.8:5555", "/metrics", false},
+		{"[2001:db8::1]:5555", "/metrics", true},
+		{"[fe80::1%eth0]:5555", "/metrics", false},
+		{"172.16.0.1:5555", "/-/reload", false},
+		{"172.16.0.1:5555", "/-/ready", true},
+		{"not-an-address", "/metrics", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remote
+		if got := list.allows(req); got != tt.want {
+			t.Errorf("%s %s: allows = %v, want %v", tt.remote, tt.path, got, tt.want)
+		}
+	}
+
+	if list, err := parseAllowlist(""); err != nil || list != nil {
+		t.Fatalf("empty allowlist = %v, %v; want nil, nil", list, err)
+	}
+	if _, err := parseAllowlist("10.0.0.0/33"); err == nil {
+		t.Fatal("expected an error for an invalid CIDR")
+	}
+}
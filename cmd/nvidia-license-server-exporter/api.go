@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/report"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type diffResult struct {
+	Diff  *snapshot.Diff `json:"diff,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// diffHandler compares each target's current snapshot to the newest retained
+// snapshot collected at or before ?since=. It responds 404 when no target
+// could be diffed.
+func diffHandler(manager *snapshot.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		since, err := parseSince(r.URL.Query().Get("since"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status := http.StatusNotFound
+		response := make(map[string]diffResult, len(manager.Services()))
+		for _, svc := range manager.Services() {
+			current, _, ok := svc.Latest()
+			if !ok {
+				response[svc.Target()] = diffResult{Error: "no current snapshot"}
+				continue
+			}
+			earlier, ok, err := svc.SnapshotAt(since)
+			switch {
+			case err != nil:
+				response[svc.Target()] = diffResult{Error: logging.Redact(err.Error())}
+			case !ok:
+				response[svc.Target()] = diffResult{Error: "no snapshot retained at or before since"}
+			default:
+				diff := snapshot.DiffSnapshots(earlier, current)
+				response[svc.Target()] = diffResult{Diff: &diff}
+				status = http.StatusOK
+			}
+		}
+		writeJSON(w, status, response)
+	})
+}
+
+type targetsResult struct {
+	Targets []targetStatus `json:"targets"`
+}
+
+type targetStatus struct {
+	OrgName       string   `json:"org_name"`
+	Endpoint      string   `json:"endpoint"`
+	VirtualGroups []string `json:"virtual_groups,omitempty"`
+	Up            bool     `json:"up"`
+	// LastSuccess is when the snapshot being served was collected.
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// targetsHandler lists the scrape targets of cfg in order, with the state
+// of their last refresh, so that fleet tooling can check every org is
+// scraped. It never refreshes.
+func targetsHandler(cfg *config.Config, manager *snapshot.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		response := targetsResult{Targets: []targetStatus{}}
+		for _, target := range cfg.ScrapeTargets() {
+			status := targetStatus{
+				OrgName:       target.OrgName,
+				Endpoint:      targetBaseURL(cfg, target),
+				VirtualGroups: target.VirtualGroups,
+			}
+			if svc, ok := manager.Service(target.OrgName); ok {
+				meta := svc.Meta()
+				status.Up = meta.Up == 1
+				status.LastSuccess = svc.LastSuccess()
+				status.LastError = logging.Redact(meta.LastError)
+				status.ConsecutiveFailures = svc.Backoff().ConsecutiveFailures
+			}
+			response.Targets = append(response.Targets, status)
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+type snapshotResult struct {
+	Snapshots []dumpDocument `json:"snapshots"`
+}
+
+// snapshotHandler serves the cached snapshot of every org, or of ?org=, in
+// the JSON of the dump command. It never refreshes. Its ETag changes with
+// the collection time of the snapshots and their leases, so that pollers
+// sending If-None-Match get 304 and no body until the next CLS fetch,
+// lease-only refreshes included. It responds
+// 404 when no org has a snapshot to serve.
+func snapshotHandler(manager *snapshot.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		services := manager.Services()
+		if org := r.URL.Query().Get("org"); org != "" {
+			svc, ok := manager.Service(org)
+			if !ok {
+				http.Error(w, "unknown org", http.StatusNotFound)
+				return
+			}
+			services = []*snapshot.Service{svc}
+		}
+
+		response := snapshotResult{Snapshots: []dumpDocument{}}
+		var snaps []*cls.Snapshot
+		for _, svc := range services {
+			if snap, _, ok := svc.Latest(); ok {
+				response.Snapshots = append(response.Snapshots, newDumpDocument(svc.Target(), snap))
+				snaps = append(snaps, snap)
+			}
+		}
+		if len(response.Snapshots) == 0 {
+			http.Error(w, "no current snapshot", http.StatusNotFound)
+			return
+		}
+		etag := snapshotETag(response.Snapshots, snaps)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+// snapshotETag covers the orgs served, their collection times and when
+// their leases were last refreshed, as lease-only refreshes keep the
+// collection time. docs and snaps are parallel. It is weak, as gzipHandler
+// may compress the body.
+func snapshotETag(docs []dumpDocument, snaps []*cls.Snapshot) string {
+	h := fnv.New64a()
+	for i, doc := range docs {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", doc.Org, doc.CollectedAt.UnixNano(), snaps[i].LeasesCollectedAt.UnixNano())
+	}
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// etagMatches applies the weak comparison of If-None-Match: any listed
+// tag, or "*", matches etag regardless of W/ prefixes.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	usageIntervalDay  = "day"
+	usageIntervalWeek = "week"
+
+	defaultUsageRange = 30 * 24 * time.Hour
+)
+
+type usageResult struct {
+	Series []usageSeries `json:"series"`
+}
+
+// usageSeries is the usage of an entitlement feature of a virtual group.
+type usageSeries struct {
+	OrgName          string       `json:"org_name"`
+	VirtualGroupID   int          `json:"virtual_group_id"`
+	VirtualGroupName string       `json:"virtual_group_name"`
+	FeatureName      string       `json:"feature_name"`
+	LicenseType      string       `json:"license_type"`
+	Points           []usagePoint `json:"points"`
+}
+
+type usagePoint struct {
+	// Start is the start of the UTC day or week.
+	Start               time.Time `json:"start"`
+	PeakActiveLeases    float64   `json:"peak_active_leases"`
+	AverageActiveLeases float64   `json:"average_active_leases"`
+	PeakInUse           float64   `json:"peak_in_use"`
+	// Entitled is the highest entitled quantity of the period.
+	Entitled float64 `json:"entitled"`
+	// Samples is the number of refreshes the point covers.
+	Samples int `json:"samples"`
+}
+
+// usageHandler serves the peak and average active leases per day or week of
+// every feature of the history database, for ?range= back from now, of
+// ?org= and ?feature= if given.
+func usageHandler(db *historydb.DB, manager *snapshot.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		lookback, err := parseRange("range", query.Get("range"), defaultUsageRange)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval := query.Get("interval")
+		switch interval {
+		case "":
+			interval = usageIntervalDay
+		case usageIntervalDay, usageIntervalWeek:
+		default:
+			http.Error(w, "interval must be day or week", http.StatusBadRequest)
+			return
+		}
+		services, ok := orgServices(manager, query.Get("org"))
+		if !ok {
+			http.Error(w, "unknown org", http.StatusNotFound)
+			return
+		}
+		feature := query.Get("feature")
+
+		now := time.Now()
+		response := usageResult{Series: []usageSeries{}}
+		for _, svc := range services {
+			periods, err := db.Daily(svc.Target(), now.Add(-lookback), now)
+			if err != nil {
+				http.Error(w, logging.Redact(err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if interval == usageIntervalWeek {
+				periods = historydb.Weekly(periods)
+			}
+			response.Series = append(response.Series, usageSeriesOf(svc.Target(), feature, periods)...)
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+// usageSeriesOf pivots the rollups of org into a series per feature, in
+// the order the features first appear, keeping only feature if set.
+func usageSeriesOf(org, feature string, periods []historydb.Rollup) []usageSeries {
+	var out []usageSeries
+	index := make(map[string]int)
+	for _, p := range periods {
+		for _, f := range p.Features {
+			if feature != "" && f.FeatureName != feature {
+				continue
+			}
+			key := fmt.Sprintf("%d\x00%s\x00%s", f.VirtualGroupID, f.FeatureName, f.LicenseType)
+			i, ok := index[key]
+			if !ok {
+				i = len(out)
+				index[key] = i
+				out = append(out, usageSeries{
+					OrgName:        org,
+					VirtualGroupID: f.VirtualGroupID,
+					FeatureName:    f.FeatureName,
+					LicenseType:    f.LicenseType,
+				})
+			}
+			out[i].VirtualGroupName = f.VirtualGroupName
+			out[i].Points = append(out[i].Points, usagePoint{
+				Start:               p.Start,
+				PeakActiveLeases:    f.ActiveLeases.Max,
+				AverageActiveLeases: f.ActiveLeases.Avg(),
+				PeakInUse:           f.InUse.Max,
+				Entitled:            f.Allocated.Max,
+				Samples:             f.InUse.Count,
+			})
+		}
+	}
+	return out
+}
+
+// orgServices returns the service of org, or every service if org is
+// empty. It returns false for an unknown org.
+func orgServices(manager *snapshot.Manager, org string) ([]*snapshot.Service, bool) {
+	if org == "" {
+		return manager.Services(), true
+	}
+	svc, ok := manager.Service(org)
+	if !ok {
+		return nil, false
+	}
+	return []*snapshot.Service{svc}, true
+}
+
+type forecastResult struct {
+	Forecasts []featureForecast `json:"forecasts"`
+}
+
+// featureForecast is when an entitlement feature of a virtual group runs
+// out of licenses at the trend of its daily peaks.
+type featureForecast struct {
+	OrgName          string  `json:"org_name"`
+	VirtualGroupID   int     `json:"virtual_group_id"`
+	VirtualGroupName string  `json:"virtual_group_name"`
+	FeatureName      string  `json:"feature_name"`
+	LicenseType      string  `json:"license_type"`
+	Entitled         float64 `json:"entitled"`
+	PeakInUse        float64 `json:"peak_in_use"`
+	TrendPerDay      float64 `json:"trend_per_day"`
+	// DaysUntilExhaustion and ExhaustsAt are null when the trend is flat or
+	// falling.
+	DaysUntilExhaustion *float64   `json:"days_until_exhaustion"`
+	ExhaustsAt          *time.Time `json:"exhausts_at"`
+	// Days is the number of days the trend is fitted to.
+	Days int `json:"days"`
+}
+
+// forecastHandler serves the exhaustion forecast of every feature of the
+// history database, fitted to ?window= or defaultWindow back from now, of
+// ?org= and ?feature= if given.
+func forecastHandler(db *historydb.DB, manager *snapshot.Manager, defaultWindow time.Duration) http.Handler {
+	minWindow := historydb.MinForecastDays * 24 * time.Hour
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		window, err := parseRange("window", query.Get("window"), defaultWindow)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if window < minWindow {
+			http.Error(w, fmt.Sprintf("window must be at least %dd", historydb.MinForecastDays), http.StatusBadRequest)
+			return
+		}
+		services, ok := orgServices(manager, query.Get("org"))
+		if !ok {
+			http.Error(w, "unknown org", http.StatusNotFound)
+			return
+		}
+		feature := query.Get("feature")
+
+		now := time.Now()
+		response := forecastResult{Forecasts: []featureForecast{}}
+		for _, svc := range services {
+			forecasts, err := db.Forecasts(svc.Target(), window, now)
+			if err != nil {
+				http.Error(w, logging.Redact(err.Error()), http.StatusInternalServerError)
+				return
+			}
+			for _, f := range forecasts {
+				if feature != "" && f.FeatureName != feature {
+					continue
+				}
+				out := featureForecast{
+					OrgName:          svc.Target(),
+					VirtualGroupID:   f.VirtualGroupID,
+					VirtualGroupName: f.VirtualGroupName,
+					FeatureName:      f.FeatureName,
+					LicenseType:      f.LicenseType,
+					Entitled:         f.Entitled,
+					PeakInUse:        f.PeakInUse,
+					TrendPerDay:      f.Slope,
+					Days:             f.Points,
+				}
+				if at := f.ExhaustsAt(now); !at.IsZero() {
+					days := f.Days
+					at = at.UTC().Truncate(time.Second)
+					out.DaysUntilExhaustion, out.ExhaustsAt = &days, &at
+				}
+				response.Forecasts = append(response.Forecasts, out)
+			}
+		}
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+const defaultReportRange = 30 * 24 * time.Hour
+
+// reportHandler serves the audit report of every org with a snapshot, or of
+// ?org=, as ?format=csv (default) or xlsx. Peaks cover ?range= back from
+// now from db, if set, and the current snapshots otherwise. CSV holds every
+// table, or only ?table=. It never refreshes, and responds 404 when no org
+// has a snapshot to report.
+func reportHandler(manager *snapshot.Manager, db *historydb.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		format := query.Get("format")
+		switch format {
+		case "":
+			format = report.FormatCSV
+		case report.FormatCSV, report.FormatXLSX:
+		default:
+			http.Error(w, "format must be csv or xlsx", http.StatusBadRequest)
+			return
+		}
+		tables := report.Tables
+		if table := query.Get("table"); table != "" {
+			if format != report.FormatCSV || !slices.Contains(report.Tables, table) {
+				http.Error(w, "table must be one of "+strings.Join(report.Tables, ", ")+", with format=csv", http.StatusBadRequest)
+				return
+			}
+			tables = []string{table}
+		}
+		lookback, err := parseRange("range", query.Get("range"), defaultReportRange)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		services, ok := orgServices(manager, query.Get("org"))
+		if !ok {
+			http.Error(w, "unknown org", http.StatusNotFound)
+			return
+		}
+
+		now := time.Now()
+		sources, err := report.Collect(services, db, now.Add(-lookback), now)
+		if err != nil {
+			http.Error(w, logging.Redact(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if len(sources) == 0 {
+			http.Error(w, "no current snapshot", http.StatusNotFound)
+			return
+		}
+		rep := report.Build(sources, now)
+		var buf bytes.Buffer
+		contentType := report.ContentTypeCSV
+		if format == report.FormatXLSX {
+			err = rep.WriteXLSX(&buf)
+			contentType = report.ContentTypeXLSX
+		} else {
+			err = rep.WriteCSV(&buf, tables)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="nvidia-cls-report-%s.%s"`, now.UTC().Format("20060102"), format))
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// parseRange accepts a positive Go duration or a number of days or weeks
+// such as 30d or 12w for the query parameter name. Empty means fallback.
+func parseRange(name, raw string, fallback time.Duration) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback, nil
+	}
+	var d time.Duration
+	var err error
+	switch unit := raw[len(raw)-1]; unit {
+	case 'd', 'w':
+		var n int
+		n, err = strconv.Atoi(raw[:len(raw)-1])
+		d = time.Duration(n) * 24 * time.Hour
+		if unit == 'w' {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(raw)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30d, 12w or 72h", name)
+	}
+	return d, nil
+}
+
+// parseSince accepts an RFC 3339 timestamp or Unix seconds.
+func parseSince(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, errors.New("since is required")
+	}
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	ts, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.New("since must be an RFC 3339 timestamp or Unix seconds")
+	}
+	return ts, nil
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"nvidia-license-server-exporter/internal/alerts"
+	"nvidia-license-server-exporter/internal/anomaly"
+	"nvidia-license-server-exporter/internal/cloudwatch"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/graphite"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/influx"
+	"nvidia-license-server-exporter/internal/leader"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/otel"
+	"nvidia-license-server-exporter/internal/redisstore"
+	"nvidia-license-server-exporter/internal/remotewrite"
+	"nvidia-license-server-exporter/internal/report"
+	"nvidia-license-server-exporter/internal/snapshot"
+	"nvidia-license-server-exporter/internal/statsd"
+	"nvidia-license-server-exporter/internal/version"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// app is everything built from one configuration: the CLS client, snapshot
+// cache, HTTP routes and push backends. A config reload builds a new app and
+// swaps it in while the HTTP listener keeps running.
+type app struct {
+	cfg     *config.Config
+	manager *snapshot.Manager
+	handler http.Handler
+	// webCfg is the loaded -web-config-file, nil without one.
+	webCfg *web.Config
+	// ready backs /-/ready and the systemd watchdog.
+	ready *readiness
+	// health serves -health-listen-address, nil without one.
+	health http.Handler
+	// allow restricts the clients of the main listener, nil for all.
+	allow allowlist
+	// proxies are the -trusted-proxies, nil for none.
+	proxies   trustedProxies
+	accessLog *accessLogger
+	// fingerprint is cfg.Fingerprint, reported by config_info.
+	fingerprint string
+
+	// start launches the refreshers and push backends, in order.
+	start []func()
+	// cancel stops the background refreshers.
+	cancel context.CancelFunc
+	// Components are shut down pushers first, then the OTEL providers,
+	// then the shared cache they all write to and the leader election.
+	pushers   []component
+	providers []component
+	stores    []component
+}
+
+type component struct {
+	name  string
+	close func(context.Context) error
+}
+
+// newApp builds an app from cfg. extra is registered with the app's metric
+// registry, for collectors that outlive reloads. Nothing is sent or fetched
+// until run; background work stops when ctx is done or the app is closed.
+func newApp(ctx context.Context, cfg *config.Config, extra ...prometheus.Collector) (_ *app, err error) {
+	if err := requireCredentials(cfg); err != nil {
+		return nil, err
+	}
+	allow, err := parseAllowlist(cfg.Server.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -allow-cidrs: %w", err)
+	}
+	proxies, err := parsePrefixes(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -trusted-proxies: %w", err)
+	}
+	accessLog, err := newAccessLogger(cfg.Log)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range cfg.Deprecations {
+		slog.Warn("deprecated setting in use", "name", d.Name, "replacement", d.Replacement)
+	}
+
+	var otelCfg otel.Config
+	if cfg.OTEL.Enabled {
+		if strings.TrimSpace(cfg.OTEL.Endpoint) == "" {
+			cfg.OTEL.Endpoint = otel.DefaultEndpoint(cfg.OTEL.Protocol)
+		}
+		headers, headersErr := otel.ParseHeaders(cfg.OTEL.Headers)
+		if headersErr != nil {
+			return nil, fmt.Errorf("invalid -otel-headers: %w", headersErr)
+		}
+		aggregations, aggregationsErr := otel.ParseAggregations(cfg.OTEL.Aggregation)
+		if aggregationsErr != nil {
+			return nil, fmt.Errorf("invalid -otel-aggregation: %w", aggregationsErr)
+		}
+		resourceAttrs, resourceAttrsErr := otel.ParseResourceAttributes(cfg.OTEL.ResourceAttributes)
+		if resourceAttrsErr != nil {
+			return nil, fmt.Errorf("invalid -otel-resource-attributes: %w", resourceAttrsErr)
+		}
+		var views []otel.ViewConfig
+		if strings.TrimSpace(cfg.OTEL.ViewsFile) != "" {
+			loaded, viewsErr := otel.LoadViews(cfg.OTEL.ViewsFile)
+			if viewsErr != nil {
+				return nil, fmt.Errorf("invalid -otel-views-file: %w", viewsErr)
+			}
+			views = loaded
+		}
+		endpoints := splitList(cfg.OTEL.Endpoint)
+		if len(endpoints) == 0 {
+			return nil, fmt.Errorf("invalid -otel-endpoint: no endpoint given")
+		}
+		otelCfg = otel.Config{
+			Enabled:            cfg.OTEL.Enabled,
+			Protocol:           cfg.OTEL.Protocol,
+			Endpoint:           endpoints[0],
+			Endpoints:          endpoints,
+			EndpointMode:       cfg.OTEL.EndpointMode,
+			Headers:            headers,
+			ServiceName:        cfg.OTEL.ServiceName,
+			ServiceInstanceID:  cfg.OTEL.ServiceInstanceID,
+			ServiceNamespace:   cfg.OTEL.ServiceNamespace,
+			DeploymentEnv:      cfg.OTEL.DeploymentEnvironment,
+			ResourceDetectors:  strings.Split(cfg.OTEL.ResourceDetectors, ","),
+			ResourceAttributes: resourceAttrs,
+			Insecure:           cfg.OTEL.Insecure,
+			CAFile:             cfg.OTEL.CAFile,
+			CertFile:           cfg.OTEL.CertFile,
+			KeyFile:            cfg.OTEL.KeyFile,
+			Temporality:        cfg.OTEL.Temporality,
+			Aggregations:       aggregations,
+			DropAttributes:     strings.Split(cfg.OTEL.DropAttributes, ","),
+			DurationHistogram:  cfg.OTEL.DurationHistogram,
+			LeaseInstrument:    cfg.OTEL.LeaseInstrument,
+			MetricNames:        cfg.OTEL.MetricNames,
+			Views:              views,
+			Retry: otel.RetryConfig{
+				Disabled:        !cfg.OTEL.Retry.Enabled,
+				InitialInterval: cfg.OTEL.Retry.InitialInterval,
+				MaxInterval:     cfg.OTEL.Retry.MaxInterval,
+				MaxElapsedTime:  cfg.OTEL.Retry.MaxElapsedTime,
+			},
+			PushInterval:       cfg.OTEL.PushInterval,
+			ExportTimeout:      cfg.OTEL.ExportTimeout,
+			MaxExportBatchSize: cfg.OTEL.MaxExportBatchSize,
+		}
+	} else if cfg.Server.HTTPDisabled && !cfg.CloudWatch.Enabled && strings.TrimSpace(cfg.RemoteWrite.URL+cfg.StatsD.Address+cfg.Influx.URL+cfg.Graphite.Address) == "" {
+		return nil, fmt.Errorf("-http-disabled requires -otel-enabled, -remote-write-url, -statsd-address, -influx-url, -graphite-address or -cloudwatch-enabled, otherwise nothing is exported")
+	} else if cfg.OTEL.Traces {
+		return nil, fmt.Errorf("-otel-traces requires -otel-enabled")
+	} else if cfg.OTEL.Logs {
+		return nil, fmt.Errorf("-otel-logs requires -otel-enabled")
+	}
+
+	switch snapshot.StalePolicy(cfg.Cache.StalePolicy) {
+	case snapshot.StaleServe, snapshot.StaleError:
+	default:
+		return nil, fmt.Errorf("unsupported stale policy %q: use serve or error", cfg.Cache.StalePolicy)
+	}
+
+	var webCfg *web.Config
+	if strings.TrimSpace(cfg.Server.WebConfigFile) != "" {
+		loaded, webErr := web.LoadConfig(cfg.Server.WebConfigFile)
+		if webErr != nil {
+			return nil, fmt.Errorf("invalid -web-config-file: %w", webErr)
+		}
+		logging.AddSecret(loaded.BearerToken())
+		webCfg = loaded
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	a := &app{cfg: cfg, webCfg: webCfg, allow: allow, proxies: proxies, accessLog: accessLog, cancel: cancel}
+	defer func() {
+		if err != nil {
+			// Release whatever was built before the failure.
+			a.close(context.Background())
+		}
+	}()
+
+	var tracerProvider *sdktrace.TracerProvider
+	if cfg.OTEL.Traces {
+		tp, tpErr := otel.NewTracerProvider(ctx, otelCfg)
+		if tpErr != nil {
+			return nil, fmt.Errorf("failed to initialize otel traces: %w", tpErr)
+		}
+		tracerProvider = tp
+		a.providers = append(a.providers, component{"otel trace", tp.Shutdown})
+	}
+
+	var store snapshot.Store
+	switch strings.ToLower(strings.TrimSpace(cfg.Cache.Backend)) {
+	case "", "memory":
+	case "redis":
+		storeCtx, storeCancel := context.WithTimeout(ctx, cfg.CLS.ScrapeTimeout)
+		redisStore, storeErr := redisstore.New(storeCtx, redisstore.Config{
+			Address:  cfg.Cache.Redis.Address,
+			Username: cfg.Cache.Redis.Username,
+			Password: cfg.Cache.Redis.Password,
+			DB:       cfg.Cache.Redis.DB,
+		})
+		storeCancel()
+		if storeErr != nil {
+			return nil, fmt.Errorf("failed to connect shared cache: %w", storeErr)
+		}
+		store = redisStore
+		a.stores = append(a.stores, component{"redis", func(context.Context) error { return redisStore.Close() }})
+	default:
+		return nil, fmt.Errorf("unsupported cache backend %q: use memory or redis", cfg.Cache.Backend)
+	}
+
+	var (
+		loggerProvider *sdklog.LoggerProvider
+		onError        func(context.Context, string, error)
+	)
+	if cfg.OTEL.Logs {
+		lp, lpErr := otel.NewLoggerProvider(ctx, otelCfg)
+		if lpErr != nil {
+			return nil, fmt.Errorf("failed to initialize otel logs: %w", lpErr)
+		}
+		loggerProvider = lp
+		onError = otel.NewErrorLogger(lp).RefreshFailed
+		a.providers = append(a.providers, component{"otel log", lp.Shutdown})
+	}
+
+	var (
+		standby       func() bool
+		keyCollectors []prometheus.Collector
+	)
+	if le := cfg.LeaderElection; le.Enabled {
+		identity := strings.TrimSpace(le.Identity)
+		if identity == "" {
+			// The pod name in Kubernetes.
+			identity, _ = os.Hostname()
+		}
+		leaseName := le.LeaseName
+		if shard := shardName(cfg); shard != "" {
+			leaseName += "-" + shard
+		}
+		elector, electorErr := leader.New(leader.Config{
+			Name:          leaseName,
+			Namespace:     le.Namespace,
+			Identity:      identity,
+			LeaseDuration: le.LeaseDuration,
+			RenewDeadline: le.RenewDeadline,
+			RetryPeriod:   le.RetryPeriod,
+		})
+		if electorErr != nil {
+			return nil, fmt.Errorf("failed to set up leader election: %w", electorErr)
+		}
+		// First, so that warm-up and the refreshers know whether to fetch.
+		a.start = append(a.start, elector.Start)
+		a.stores = append(a.stores, component{"leader election", elector.Shutdown})
+		keyCollectors = append(keyCollectors, elector.Collector())
+		standby = func() bool { return !elector.IsLeader() }
+		slog.Info("leader election enabled", "lease", leaseName, "identity", identity, "shared_cache", store != nil)
+	}
+
+	storePrefix := cfg.Cache.Redis.KeyPrefix
+	if shard := shardName(cfg); shard != "" {
+		storePrefix += shard + "/"
+	}
+	var services []*snapshot.Service
+	for _, target := range cfg.ScrapeTargets() {
+		clientCfg := clsClientConfig(cfg, target)
+		if tracerProvider != nil {
+			clientCfg.TracerProvider = tracerProvider
+		}
+		client, clientErr := cls.NewClient(clientCfg)
+		if clientErr != nil {
+			return nil, fmt.Errorf("failed to create CLS client for org %s: %w", target.OrgName, clientErr)
+		}
+		apiKey := newAPIKeyState(target, client)
+		watchers := []func(){watchAPIKeyFile(ctx, target, cfg.CLS.APIKeyFilePollInterval, apiKey)}
+		if target.OrgName == cfg.CLS.OrgName {
+			// The secrets manager is configured for the cls section only.
+			watchers = append(watchers, watchAPIKeySecret(ctx, cfg, apiKey))
+		}
+		for _, start := range watchers {
+			if start != nil {
+				a.start = append(a.start, start)
+			}
+		}
+		keyCollectors = append(keyCollectors, apiKey.collector())
+
+		cacheTTL := cfg.Cache.TTL
+		if target.CacheTTL > 0 {
+			cacheTTL = target.CacheTTL
+		}
+		services = append(services, snapshot.NewService(client, snapshot.Config{
+			Target:              target.OrgName,
+			CacheTTL:            cacheTTL,
+			MaxStale:            cfg.Cache.MaxStale,
+			ValidationTolerance: cfg.Cache.ValidationTolerance,
+			RejectInvalid:       cfg.Cache.RejectInvalid,
+			MaxBytes:            cfg.Cache.MaxSnapshotBytes,
+			Compress:            cfg.Cache.Compress,
+			CopyOnRead:          cfg.Cache.CopyOnRead,
+			HistorySize:         cfg.Cache.HistorySize,
+			HistoryInterval:     cfg.Cache.HistoryInterval,
+			StalePolicy:         snapshot.StalePolicy(cfg.Cache.StalePolicy),
+			Store:               store,
+			StoreKeyPrefix:      storePrefix,
+			BackoffInitial:      cfg.Cache.FailureBackoff,
+			BackoffMax:          cfg.Cache.FailureBackoffMax,
+			OnError:             onError,
+			Standby:             standby,
+		}))
+	}
+	manager, err := snapshot.NewManager(services...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot manager: %w", err)
+	}
+	a.manager = manager
+
+	if a.fingerprint, err = cfg.Fingerprint(); err != nil {
+		return nil, fmt.Errorf("failed to fingerprint the configuration: %w", err)
+	}
+	collector := exporter.NewCollector(manager, cfg.CLS.ScrapeTimeout)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		configInfo(a.fingerprint),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collector,
+		version.NewCollector(),
+	)
+	registry.MustRegister(keyCollectors...)
+	registry.MustRegister(cacheCollectors(manager)...)
+	registry.MustRegister(deprecationCollectors(cfg.Deprecations)...)
+	registry.MustRegister(extra...)
+
+	// Over the limit, scrapes fail fast with 503 instead of queueing up
+	// behind a slow CLS fetch.
+	metricsOpts := promhttp.HandlerOpts{MaxRequestsInFlight: cfg.Server.MetricsMaxInflight}
+	var bypassHandler http.Handler
+	if cfg.Server.AllowCacheBypass {
+		bypassRegistry := prometheus.NewRegistry()
+		bypassRegistry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+			exporter.NewCacheBypassCollector(manager, cfg.CLS.ScrapeTimeout),
+		)
+		bypassHandler = promhttp.HandlerFor(bypassRegistry, metricsOpts)
+		if strings.TrimSpace(cfg.Server.AdminToken) != "" {
+			bypassHandler = requireAdminToken(strings.TrimSpace(cfg.Server.AdminToken), bypassHandler)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Server.MetricsPath, metricsHandler(promhttp.HandlerFor(registry, metricsOpts), bypassHandler))
+	a.ready = &readiness{ctx: ctx, manager: manager, maxAge: cfg.Server.ReadyMaxAge, timeout: cfg.CLS.ScrapeTimeout}
+	if store == nil {
+		// Followers have nothing to serve.
+		a.ready.standby = standby
+	}
+	registerHealth(mux, a.ready)
+	if strings.TrimSpace(cfg.Server.AdminToken) != "" {
+		mux.Handle("/-/refresh", requireAdminToken(strings.TrimSpace(cfg.Server.AdminToken), refreshHandler(manager, cfg.CLS.ScrapeTimeout)))
+	}
+	mux.Handle("/api/v1/targets", gzipHandler(targetsHandler(cfg, manager)))
+	mux.Handle("/api/v1/snapshot", gzipHandler(snapshotHandler(manager)))
+	mux.Handle(dashboardPath, gzipHandler(dashboardHandler(collector)))
+	if cfg.Cache.HistorySize > 0 {
+		mux.Handle("/api/v1/diff", gzipHandler(diffHandler(manager)))
+	}
+	if cfg.Server.EnablePprof && pprofAddress(cfg.Server) == "" {
+		registerPprof(mux)
+	}
+	mux.Handle("/", landingHandler(a))
+	a.handler = mux
+	if healthAddr := strings.TrimSpace(cfg.Server.HealthListenAddress); healthAddr != "" {
+		healthMux := http.NewServeMux()
+		registerHealth(healthMux, a.ready)
+		if cfg.Server.EnablePprof && pprofAddress(cfg.Server) == healthAddr {
+			registerPprof(healthMux)
+		}
+		a.health = healthMux
+	}
+	if webCfg != nil && webCfg.AuthEnabled() {
+		a.handler = requireWebAuth(webCfg, mux, cfg.Server.MetricsPath, bypassHandler != nil && strings.TrimSpace(cfg.Server.AdminToken) != "")
+		slog.Info("web auth enabled", "file", cfg.Server.WebConfigFile, "basic_auth_users", len(webCfg.BasicAuthUsers), "bearer_token", strings.TrimSpace(webCfg.BearerTokenFile) != "")
+	}
+	if limiter := newRateLimiter(cfg.Server.RateLimit, cfg.Server.RateLimitGlobal, cfg.Server.RateLimitBurst); limiter != nil {
+		// Ahead of authentication, so that failed logins count as well.
+		a.handler = limiter.wrap(cfg.Server.MetricsPath, a.handler)
+		registry.MustRegister(limiter.collector())
+	}
+	if c := newCORS(cfg.Server.CORSAllowedOrigins, cfg.Server.CORSAllowedMethods); c != nil {
+		a.handler = c.wrap(a.handler)
+	}
+
+	if cfg.Cache.LeaseRefreshInterval > 0 {
+		a.start = append(a.start, func() {
+			go manager.RunLeaseRefresh(ctx, cfg.Cache.LeaseRefreshInterval, cfg.CLS.ScrapeTimeout)
+			slog.Info("lease-only refresh enabled", "interval", cfg.Cache.LeaseRefreshInterval)
+		})
+	}
+
+	pushing := false
+	if cfg.OTEL.Enabled {
+		otelPusher, initErr := otel.NewMetricsPusher(ctx, otelCfg, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize otel metrics: %w", initErr)
+		}
+		registry.MustRegister(otelPusher.Collector())
+		a.pushers = append(a.pushers, component{"otel", otelPusher.Shutdown})
+		a.start = append(a.start, otelPusher.Start)
+		pushing = true
+
+		if strings.TrimSpace(cfg.Server.AdminToken) != "" {
+			flushers := map[string]flusher{"metrics": otelPusher}
+			if tracerProvider != nil {
+				flushers["traces"] = tracerProvider
+			}
+			if loggerProvider != nil {
+				flushers["logs"] = loggerProvider
+			}
+			mux.Handle("/-/otel/flush", requireAdminToken(strings.TrimSpace(cfg.Server.AdminToken), otelFlushHandler(flushers, cfg.CLS.ScrapeTimeout)))
+		}
+		slog.Info("otel enabled", "protocol", cfg.OTEL.Protocol, "endpoint", cfg.OTEL.Endpoint, "endpoint_mode", otelCfg.EndpointMode, "headers", otel.RedactHeaders(otelCfg.Headers), "insecure", cfg.OTEL.Insecure, "interval", cfg.OTEL.PushInterval, "traces", cfg.OTEL.Traces, "logs", cfg.OTEL.Logs)
+	}
+
+	// Interval pushers start after warm-up, so the first push carries data.
+	var afterWarmup []func()
+	if strings.TrimSpace(cfg.StatsD.Address) != "" {
+		emitter, initErr := statsd.NewEmitter(statsd.Config{
+			Address:    cfg.StatsD.Address,
+			Prefix:     cfg.StatsD.Prefix,
+			Tags:       cfg.StatsD.Tags,
+			GlobalTags: splitList(cfg.StatsD.GlobalTags),
+		}, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize statsd: %w", initErr)
+		}
+		a.pushers = append(a.pushers, component{"statsd", emitter.Shutdown})
+		a.start = append(a.start, emitter.Start)
+		pushing = true
+		slog.Info("statsd enabled", "address", cfg.StatsD.Address, "tags", cfg.StatsD.Tags)
+	}
+
+	if strings.TrimSpace(cfg.Influx.URL) != "" {
+		writer, initErr := influx.NewWriter(influx.Config{
+			URL:     cfg.Influx.URL,
+			Org:     cfg.Influx.Org,
+			Bucket:  cfg.Influx.Bucket,
+			Token:   cfg.Influx.Token,
+			Timeout: cfg.Influx.Timeout,
+		}, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize influx: %w", initErr)
+		}
+		registry.MustRegister(writer.Collector())
+		a.pushers = append(a.pushers, component{"influx", writer.Shutdown})
+		a.start = append(a.start, writer.Start)
+		pushing = true
+		slog.Info("influx enabled", "endpoint", cfg.Influx.URL, "influx_org", cfg.Influx.Org, "bucket", cfg.Influx.Bucket)
+	}
+
+	if strings.TrimSpace(cfg.Graphite.Address) != "" {
+		graphitePusher, initErr := graphite.NewPusher(graphite.Config{
+			Address:  cfg.Graphite.Address,
+			Prefix:   cfg.Graphite.Prefix,
+			Interval: cfg.Graphite.Interval,
+		}, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize graphite: %w", initErr)
+		}
+		registry.MustRegister(graphitePusher.Collector())
+		a.pushers = append(a.pushers, component{"graphite", graphitePusher.Shutdown})
+		afterWarmup = append(afterWarmup, graphitePusher.Start)
+		pushing = true
+		slog.Info("graphite enabled", "address", cfg.Graphite.Address, "prefix", cfg.Graphite.Prefix, "interval", cfg.Graphite.Interval)
+	}
+
+	if cfg.CloudWatch.Enabled {
+		publisher, initErr := cloudwatch.NewPublisher(cloudwatch.Config{
+			Namespace: cfg.CloudWatch.Namespace,
+			Region:    cfg.CloudWatch.Region,
+			Interval:  cfg.CloudWatch.Interval,
+			Timeout:   cfg.CloudWatch.Timeout,
+		}, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize cloudwatch: %w", initErr)
+		}
+		registry.MustRegister(publisher.Collector())
+		a.pushers = append(a.pushers, component{"cloudwatch", publisher.Shutdown})
+		afterWarmup = append(afterWarmup, publisher.Start)
+		pushing = true
+		slog.Info("cloudwatch enabled", "namespace", cfg.CloudWatch.Namespace, "region", cfg.CloudWatch.Region, "interval", cfg.CloudWatch.Interval)
+	}
+
+	if strings.TrimSpace(cfg.RemoteWrite.URL) != "" {
+		headers, headersErr := otel.ParseHeaders(cfg.RemoteWrite.Headers)
+		if headersErr != nil {
+			return nil, fmt.Errorf("invalid -remote-write-headers: %w", headersErr)
+		}
+		rwPusher, initErr := remotewrite.NewPusher(remotewrite.Config{
+			URL:         cfg.RemoteWrite.URL,
+			BearerToken: strings.TrimSpace(cfg.RemoteWrite.BearerToken),
+			Username:    strings.TrimSpace(cfg.RemoteWrite.Username),
+			Password:    cfg.RemoteWrite.Password,
+			Headers:     headers,
+			Interval:    cfg.RemoteWrite.Interval,
+			Timeout:     cfg.RemoteWrite.Timeout,
+		}, manager)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize remote write: %w", initErr)
+		}
+		registry.MustRegister(rwPusher.Collector())
+		a.pushers = append(a.pushers, component{"remote write", rwPusher.Shutdown})
+		afterWarmup = append(afterWarmup, rwPusher.Start)
+		pushing = true
+		slog.Info("remote write enabled", "endpoint", cfg.RemoteWrite.URL, "interval", cfg.RemoteWrite.Interval)
+	}
+
+	var detector *anomaly.Detector
+	if cfg.Anomaly.Enabled {
+		detector = anomaly.NewDetector(anomaly.Config{
+			Alpha:        cfg.Anomaly.Alpha,
+			Threshold:    cfg.Anomaly.Threshold,
+			Warmup:       cfg.Anomaly.Warmup,
+			MinDeviation: cfg.Anomaly.MinDeviation,
+		}, manager)
+		registry.MustRegister(detector.Collector())
+		a.pushers = append(a.pushers, component{"anomaly detector", detector.Shutdown})
+		a.start = append(a.start, detector.Start)
+		pushing = true
+		slog.Info("lease anomaly detection enabled", "alpha", cfg.Anomaly.Alpha, "threshold", cfg.Anomaly.Threshold, "warmup", cfg.Anomaly.Warmup, "min_deviation", cfg.Anomaly.MinDeviation)
+	}
+
+	if strings.TrimSpace(cfg.Alerts.ConfigFile) != "" {
+		alertsCfg, loadErr := alerts.LoadConfig(cfg.Alerts.ConfigFile)
+		if loadErr != nil {
+			return nil, fmt.Errorf("invalid -alerts-config-file: %w", loadErr)
+		}
+		for _, secret := range alertsCfg.Secrets() {
+			logging.AddSecret(secret)
+		}
+		engine, initErr := alerts.NewEngine(alertsCfg, manager, standby, detector)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize alerts: %w", initErr)
+		}
+		registry.MustRegister(engine.Collector())
+		a.pushers = append(a.pushers, component{"alerts", engine.Shutdown})
+		a.start = append(a.start, engine.Start)
+		pushing = true
+		slog.Info("alerts enabled", "file", cfg.Alerts.ConfigFile, "rules", len(alertsCfg.Rules), "webhooks", len(alertsCfg.Webhooks), "slack", len(alertsCfg.Slack), "pagerduty", len(alertsCfg.PagerDuty), "email", len(alertsCfg.Email), "summaries", len(alertsCfg.Summaries))
+	}
+
+	var historyDB *historydb.DB
+	if strings.TrimSpace(cfg.HistoryDB.Path) != "" {
+		var openErr error
+		historyDB, openErr = historydb.Open(historydb.Config{
+			Path:            strings.TrimSpace(cfg.HistoryDB.Path),
+			Retention:       cfg.HistoryDB.Retention,
+			HourlyRetention: cfg.HistoryDB.HourlyRetention,
+			DailyRetention:  cfg.HistoryDB.DailyRetention,
+			ForecastWindow:  cfg.HistoryDB.ForecastWindow,
+		}, manager)
+		if openErr != nil {
+			return nil, openErr
+		}
+		registry.MustRegister(historyDB.Collector())
+		mux.Handle("/api/v1/usage", gzipHandler(usageHandler(historyDB, manager)))
+		mux.Handle("/api/v1/forecast", gzipHandler(forecastHandler(historyDB, manager, cfg.HistoryDB.ForecastWindow)))
+		a.pushers = append(a.pushers, component{"history db", historyDB.Shutdown})
+		a.start = append(a.start, historyDB.Start)
+		pushing = true
+		slog.Info("history db enabled", "path", cfg.HistoryDB.Path, "retention", cfg.HistoryDB.Retention, "hourly_retention", cfg.HistoryDB.HourlyRetention, "daily_retention", cfg.HistoryDB.DailyRetention, "forecast_window", cfg.HistoryDB.ForecastWindow)
+	}
+	mux.Handle("/api/v1/report", gzipHandler(reportHandler(manager, historyDB)))
+
+	if strings.TrimSpace(cfg.Report.Schedule) != "" {
+		scheduler, initErr := report.NewScheduler(report.ScheduleConfig{
+			Schedule:    cfg.Report.Schedule,
+			Format:      cfg.Report.Format,
+			Range:       cfg.Report.Range,
+			Destination: cfg.Report.Destination,
+		}, manager, historyDB, standby)
+		if initErr != nil {
+			return nil, fmt.Errorf("failed to initialize scheduled reports: %w", initErr)
+		}
+		registry.MustRegister(scheduler.Collector())
+		a.pushers = append(a.pushers, component{"scheduled reports", scheduler.Shutdown})
+		a.start = append(a.start, scheduler.Start)
+		pushing = true
+		slog.Info("scheduled reports enabled", "schedule", cfg.Report.Schedule, "format", cfg.Report.Format, "range", cfg.Report.Range, "destination", cfg.Report.Destination)
+	}
+
+	if pushing {
+		// Push backends only observe the cache, as do the anomaly
+		// detector, alerts, the history database and scheduled reports,
+		// so something has to keep it fresh when nobody scrapes.
+		a.start = append(a.start, func() { go manager.RunRefresh(ctx, cfg.CLS.ScrapeTimeout) })
+	}
+	if cfg.Server.Warmup {
+		a.start = append(a.start, func() { warmUp(ctx, manager, cfg.CLS.ScrapeTimeout) })
+	}
+	a.start = append(a.start, afterWarmup...)
+	return a, nil
+}
+
+// shardName names the shard of cfg, such as shard-0-of-3, or is empty
+// without sharding. Shards do not share snapshots in the shared cache or a
+// leader election Lease, as they fetch different virtual groups.
+func shardName(cfg *config.Config) string {
+	if cfg.CLS.TotalShards <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("shard-%d-of-%d", cfg.CLS.Shard, cfg.CLS.TotalShards)
+}
+
+// serverTLS returns the listener TLS config from the web config file, or nil
+// for plain HTTP.
+func (a *app) serverTLS() *tls.Config {
+	if a.webCfg == nil {
+		return nil
+	}
+	return a.webCfg.TLS()
+}
+
+// run starts the refreshers and push backends. With warm-up enabled it
+// returns once the initial snapshot is fetched.
+func (a *app) run() {
+	for _, start := range a.start {
+		start()
+	}
+}
+
+// close stops the app's background work and shuts its components down.
+// Errors are logged.
+func (a *app) close(ctx context.Context) {
+	a.cancel()
+	for _, c := range append(append(append([]component(nil), a.pushers...), a.providers...), a.stores...) {
+		if err := c.close(ctx); err != nil {
+			slog.Error("shutdown error", "component", c.name, "error", err)
+		}
+	}
+}
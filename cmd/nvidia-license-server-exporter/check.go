@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+)
+
+// check lists the virtual groups and the license servers of one of them,
+// reports the endpoints reached, whether the API key was accepted and what
+// was found, and exits non-zero on any failure. With several targets, it
+// checks each in turn. It is meant for CI jobs that validate rotated API
+// keys.
+func check(args []string, stdout io.Writer) int {
+	cfg, _, code, done := setup(args, stdout, nil)
+	if done {
+		return code
+	}
+	if err := requireCredentials(cfg); err != nil {
+		slog.Error("check failed", "error", err)
+		return 1
+	}
+	for i, target := range cfg.ScrapeTargets() {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		if err := checkTarget(cfg, target, stdout); err != nil {
+			code = 1
+		}
+	}
+	return code
+}
+
+func checkTarget(cfg *config.Config, target config.Target, stdout io.Writer) error {
+	client, err := cls.NewClient(clsClientConfig(cfg, target))
+	if err != nil {
+		err = fmt.Errorf("failed to create CLS client: %w", err)
+		slog.Error("check failed", "org", target.OrgName, "error", err)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.CLS.ScrapeTimeout)
+	defer cancel()
+
+	result, err := client.Check(ctx)
+	writeCheckReport(stdout, target.OrgName, result, err)
+	return err
+}
+
+func writeCheckReport(w io.Writer, org string, result *cls.CheckResult, err error) {
+	fmt.Fprintf(w, "org: %s\n", org)
+	for _, step := range result.Steps {
+		status := "ok"
+		if step.Err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%-4s %s %s (%.3fs)\n", status, step.Operation, step.Endpoint, step.Duration.Seconds())
+	}
+
+	switch {
+	case cls.IsAuthError(err):
+		fmt.Fprintln(w, "auth: rejected, the API key is invalid, expired or has no access to the org")
+	case len(result.Steps) > 0 && result.Steps[0].Err == nil:
+		fmt.Fprintln(w, "auth: valid")
+	default:
+		fmt.Fprintln(w, "auth: unknown, the CLS API was not reached")
+	}
+	if len(result.Steps) > 0 && result.Steps[0].Err == nil {
+		fmt.Fprintf(w, "virtual groups: %d\n", result.VirtualGroups)
+	}
+	if len(result.Steps) > 1 && result.Steps[1].Err == nil {
+		fmt.Fprintf(w, "license servers in %s: %d\n", result.VirtualGroup, result.LicenseServers)
+	}
+
+	if err != nil {
+		fmt.Fprintf(w, "check failed: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, "check passed")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/version"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// command is a subcommand. run receives the arguments after the command
+// name and returns the process exit code.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer) int
+}
+
+var commands = []command{
+	{"serve", "Run the exporter and serve /metrics (default).", serve},
+	{"check", "Validate credentials and connectivity to the CLS API, then exit non-zero on failure.", check},
+	{"dump", "Fetch one snapshot and write it as JSON or CSV, then exit.", dump},
+	{"generate", "Write a Prometheus scrape config or alerting rules for this exporter, then exit.", generate},
+	{"healthcheck", "Request /-/healthy of a running exporter and exit non-zero unless it is healthy.", healthcheck},
+	{"version", "Print version and build information, then exit.", printVersion},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run dispatches to the subcommand named by the first argument. Without one,
+// or when the first argument is a flag, it serves, as before subcommands.
+func run(args []string, stdout io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(stdout)
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args, stdout)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nEvery command accepts the configuration flags; run %s <command> -h to list them.\n", os.Args[0])
+}
+
+func printVersion(_ []string, stdout io.Writer) int {
+	fmt.Fprintln(stdout, version.Get())
+	return 0
+}
+
+// setup loads the configuration from args and installs the configured logger
+// as the slog default. register, if not nil, defines the command's own flags.
+// done reports that the command should exit with code right away: after -h,
+// -version or an invalid configuration.
+func setup(args []string, stdout io.Writer, register func(*flag.FlagSet)) (cfg *config.Config, logLevel *slog.LevelVar, code int, done bool) {
+	cfg, err := config.LoadWith(args, register)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, nil, 0, true
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return nil, nil, 1, true
+	}
+	if cfg.ShowVersion {
+		return nil, nil, printVersion(nil, stdout), true
+	}
+	if err := cfg.Validate(); err != nil {
+		writeValidationReport(os.Stderr, err)
+		return nil, nil, 1, true
+	}
+	level, err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return nil, nil, 1, true
+	}
+	logLevel = new(slog.LevelVar)
+	logLevel.Set(level)
+	logger, err := logging.New(cfg.Log.Format, logLevel, os.Stderr)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return nil, nil, 1, true
+	}
+	slog.SetDefault(logger)
+	for _, secret := range cfg.Secrets() {
+		logging.AddSecret(secret)
+	}
+	if err := resolveAPIKeySecret(context.Background(), cfg); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return nil, nil, 1, true
+	}
+	return cfg, logLevel, 0, false
+}
+
+// writeValidationReport lists every problem found by config.Validate,
+// followed by a minimal working configuration. It writes plain text rather
+// than a log record so that the list stays readable in a terminal.
+func writeValidationReport(w io.Writer, err error) {
+	problems := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		problems = joined.Unwrap()
+	}
+	fmt.Fprintf(w, "invalid configuration, %d problem(s):\n\n", len(problems))
+	for _, problem := range problems {
+		fmt.Fprintf(w, "  - %v\n", problem)
+	}
+	fmt.Fprintf(w, "\n%s", config.MinimalExample)
+}
+
+// requireCredentials checks the settings every command needs to reach CLS.
+func requireCredentials(cfg *config.Config) error {
+	if strings.TrimSpace(cfg.CLS.OrgName) == "" && len(cfg.Targets) == 0 {
+		return fmt.Errorf("missing required org name: set NVIDIA_ORG_NAME, pass -nvidia-org-name or -target or set cls.org_name in the config file")
+	}
+	if strings.TrimSpace(cfg.CLS.OrgName) != "" && strings.TrimSpace(cfg.CLS.APIKey) == "" {
+		return fmt.Errorf("missing required API key: set NVIDIA_API_KEY, NVIDIA_API_KEY_FILE or NVIDIA_API_KEY_SECRET, or the matching flag or cls setting in the config file")
+	}
+	for _, target := range cfg.Targets {
+		if strings.TrimSpace(target.APIKey) == "" {
+			return fmt.Errorf("missing required API key for target %s", target)
+		}
+	}
+	return nil
+}
+
+// clsClientConfig returns the client settings for target, one of
+// cfg.ScrapeTargets.
+func clsClientConfig(cfg *config.Config, target config.Target) cls.Config {
+	parallelFetches := int(cfg.CLS.Parallelism)
+	if cfg.CLS.Parallelism == config.ParallelismAuto {
+		parallelFetches = cls.AutoParallelFetches
+	}
+	// Both were checked by Validate.
+	tlsMinVersion, _ := web.ParseTLSVersion(cfg.CLS.TLSMinVersion)
+	tlsCipherSuites, _ := web.ParseCipherSuites(strings.Split(cfg.CLS.TLSCipherSuites, ","))
+	return cls.Config{
+		BaseURL:           targetBaseURL(cfg, target),
+		APIKey:            target.APIKey,
+		OrgName:           target.OrgName,
+		ServiceInstanceID: target.ServiceInstanceID,
+		VirtualGroups:     target.VirtualGroups,
+		Shard:             cfg.CLS.Shard,
+		TotalShards:       cfg.CLS.TotalShards,
+		ParallelFetches:   parallelFetches,
+		MaxResponseBytes:  int64(cfg.CLS.MaxResponseBytes),
+		TLSMinVersion:     tlsMinVersion,
+		TLSCipherSuites:   tlsCipherSuites,
+	}
+}
+
+// targetBaseURL returns the CLS API base URL of target.
+func targetBaseURL(cfg *config.Config, target config.Target) string {
+	if strings.TrimSpace(target.BaseURL) != "" {
+		return target.BaseURL
+	}
+	return cfg.CLS.BaseURL
+}
+
+// fetchOnce builds a CLS client for the first target of cfg and fetches a
+// single snapshot, bypassing the cache and push backends.
+func fetchOnce(cfg *config.Config) (config.Target, *cls.Snapshot, error) {
+	if err := requireCredentials(cfg); err != nil {
+		return config.Target{}, nil, err
+	}
+	target := cfg.ScrapeTargets()[0]
+	client, err := cls.NewClient(clsClientConfig(cfg, target))
+	if err != nil {
+		return target, nil, fmt.Errorf("failed to create CLS client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.CLS.ScrapeTimeout)
+	defer cancel()
+	snap, err := client.FetchSnapshot(ctx)
+	return target, snap, err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+func TestRunDispatchesCommands(t *testing.T) {
+	var out bytes.Buffer
+	if code := run([]string{"version"}, &out); code != 0 || !strings.HasPrefix(out.String(), "nvidia-license-server-exporter, version ") {
+		t.Fatalf("version: code %d, output %q", code, out.String())
+	}
+
+	out.Reset()
+	if code := run([]string{"help"}, &out); code != 0 {
+		t.Fatalf("help: code %d", code)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(out.String(), cmd.name) {
+			t.Fatalf("usage does not list %s: %q", cmd.name, out.String())
+		}
+	}
+
+	if code := run([]string{"frobnicate"}, &out); code != 2 {
+		t.Fatalf("unknown command: expected exit code 2, got %d", code)
+	}
+
+	t.Setenv("NVIDIA_ORG_NAME", "")
+	t.Setenv("NVIDIA_API_KEY", "")
+	t.Setenv("CONFIG_FILE", "")
+	for _, name := range []string{"check", "dump"} {
+		if code := run([]string{name}, &out); code != 1 {
+			t.Fatalf("%s without credentials: expected exit code 1, got %d", name, code)
+		}
+	}
+}
+
+func TestWriteDumpJSON(t *testing.T) {
+	snap := &cls.Snapshot{
+		CollectedAt:      time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC),
+		ActiveLeaseTotal: 3,
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "vg", FeatureName: "vWS", TotalQuantity: 10, InUseQuantity: 3, Unassigned: 2},
+		},
+		PoolUsage: []cls.PoolUsageSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", PoolID: "pool-1", Allocated: 8, InUse: 3, Available: 5},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeDumpJSON(&buf, "org-1", snap); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if doc["org"] != "org-1" || doc["collected_at"] != "2026-10-16T08:00:00Z" || doc["active_lease_total"] != 3.0 {
+		t.Fatalf("unexpected document: %v", doc)
+	}
+	entitlements := doc["entitlements"].([]any)
+	if len(entitlements) != 1 || entitlements[0].(map[string]any)["in_use"] != 3.0 {
+		t.Fatalf("unexpected entitlements: %v", entitlements)
+	}
+	pools := doc["pool_usage"].([]any)
+	if len(pools) != 1 || pools[0].(map[string]any)["pool_id"] != "pool-1" {
+		t.Fatalf("unexpected pool usage: %v", pools)
+	}
+	if servers := doc["server_usage"].([]any); len(servers) != 0 {
+		t.Fatalf("expected empty server usage, got %v", servers)
+	}
+}
+
+// newFakeCLS serves org-1 with one virtual group, two license servers and
+// a pool on the first, and rejects the API key for org "expired".
+func newFakeCLS(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/org/org-1/virtual-groups", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"virtualGroups":[{"id":101,"name":"VG"}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/license-servers", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"licenseServers":[{"id":"srv-1","name":"server-1",
+			"licenseServerFeatures":[{"id":"f-1","featureName":"Feature A","productName":"Product","licenseType":"TYPE","totalQuantity":10}]},
+			{"id":"srv-2","name":"server-2"}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/license-servers/{server}/license-pools", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("server") != "srv-1" {
+			_, _ = w.Write([]byte(`{"licensePools":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"licensePools":[{"id":"pool-1","name":"pool, main",
+			"licensePoolFeatures":[{"licenseServerFeatureId":"f-1","totalAllotment":10,"inUse":2}]}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/leases", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"clients":[]}`))
+	})
+	mux.HandleFunc("/v1/org/expired/", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckCommand(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	srv := newFakeCLS(t)
+
+	var out bytes.Buffer
+	if code := run([]string{"check", "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "org-1", "-nvidia-api-key", "key"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, out.String())
+	}
+	for _, want := range []string{"ok   list virtual-groups " + srv.URL, "auth: valid", "virtual groups: 1", "license servers in VG: 2", "check passed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if code := run([]string{"check", "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "expired", "-nvidia-api-key", "key"}, &out); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, out.String())
+	}
+	for _, want := range []string{"FAIL list virtual-groups", "auth: rejected", "check failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDumpCommandWritesCSVFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	srv := newFakeCLS(t)
+	path := filepath.Join(t.TempDir(), "pools.csv")
+
+	var out bytes.Buffer
+	code := run([]string{"dump", "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "org-1", "-nvidia-api-key", "key",
+		"-format", "csv", "-table", "pool_usage", "-output", path}, &out)
+	if code != 0 || out.Len() != 0 {
+		t.Fatalf("expected exit code 0 and nothing on stdout, got %d: %q", code, out.String())
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	want := "org,virtual_group_id,virtual_group_name,server_id,server_name,pool_id,pool_name,feature_name,product_name,license_type,allocated,in_use,available\n" +
+		"org-1,101,VG,srv-1,server-1,pool-1,\"pool, main\",Feature A,Product,TYPE,10,2,8\n"
+	if string(raw) != want {
+		t.Fatalf("unexpected CSV:\n%s\nwant:\n%s", raw, want)
+	}
+
+	if code := run([]string{"dump", "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "org-1", "-nvidia-api-key", "key", "-format", "xml"}, &out); code != 1 {
+		t.Fatalf("unknown format: expected exit code 1, got %d", code)
+	}
+}
+
+func TestWriteDumpCSVSeparatesTables(t *testing.T) {
+	doc := newDumpDocument("org-1", &cls.Snapshot{
+		ServerUsage: []cls.ServerUsageSnapshot{{VirtualGroupID: 1, ServerID: "srv-1", Allocated: 10, InUse: 2.5, Available: 7.5}},
+	})
+	var buf bytes.Buffer
+	if err := writeDumpCSV(&buf, doc, dumpTables); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tables := strings.Split(buf.String(), "\n\n")
+	if len(tables) != 3 {
+		t.Fatalf("expected 3 tables, got %d:\n%s", len(tables), buf.String())
+	}
+	if !strings.HasSuffix(tables[1], "\norg-1,1,,srv-1,,,,,10,2.5,7.5") {
+		t.Fatalf("unexpected server usage table:\n%s", tables[1])
+	}
+}
+
+func TestCheckWithAPIKeySecret(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("NVIDIA_API_KEY", "")
+	t.Setenv("NLS_API_KEY", "")
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/nvidia" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"api_key":"key-from-vault"},"metadata":{"version":1}}}`))
+	}))
+	t.Cleanup(vault.Close)
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	cls := newFakeCLS(t)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key-from-vault" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		cls.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+
+	var out bytes.Buffer
+	code := run([]string{"check", "-nvidia-api-base-url", api.URL, "-nvidia-org-name", "org-1", "-nvidia-api-key-secret", "vault://secret/nvidia#api_key"}, &out)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, out.String())
+	}
+}
+
+func TestWriteValidationReport(t *testing.T) {
+	var buf bytes.Buffer
+	writeValidationReport(&buf, errors.Join(errors.New("cls.org_name: not set"), errors.New("cache.ttl: 0s is not positive")))
+	out := buf.String()
+	for _, want := range []string{"2 problem(s)", "  - cls.org_name: not set\n", "  - cache.ttl: 0s is not positive\n", "NVIDIA_ORG_NAME="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in report:\n%s", want, out)
+		}
+	}
+}
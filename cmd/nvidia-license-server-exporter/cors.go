@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight result.
+const corsMaxAge = "600"
+
+// cors adds CORS headers to the JSON API under /api/, so that web
+// dashboards on the allowed origins can query it from the browser.
+type cors struct {
+	origins []string
+	methods string
+}
+
+// newCORS returns nil when origins, a comma-separated list, is empty. "*"
+// allows every origin, but without credentials, as browsers require.
+func newCORS(origins, methods string) *cors {
+	c := &cors{origins: splitList(origins), methods: strings.ToUpper(strings.Join(splitList(methods), ", "))}
+	if len(c.origins) == 0 {
+		return nil
+	}
+	if c.methods == "" {
+		c.methods = http.MethodGet
+	}
+	return c
+}
+
+func (c *cors) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		switch {
+		case slices.Contains(c.origins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// Lets dashboards send web config credentials.
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case slices.Contains(c.origins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		// A preflight carries no credentials, so it is answered here,
+		// before authentication.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
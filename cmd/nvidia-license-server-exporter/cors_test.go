@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := newCORS("https://dash.example.com", "get").wrap(ok)
+	do := func(method, path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodOptions, "/api/v1/diff", "https://dash.example.com")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != "GET" {
+		t.Fatalf("unexpected preflight response %d %v", rec.Code, rec.Header())
+	}
+	rec = do(http.MethodGet, "/api/v1/diff", "https://dash.example.com")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("missing CORS headers: %v", rec.Header())
+	}
+	if rec := do(http.MethodGet, "/api/v1/diff", "https://evil.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("CORS headers for a foreign origin: %v", rec.Header())
+	}
+	if rec := do(http.MethodGet, "/metrics", "https://dash.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("CORS headers outside /api/: %v", rec.Header())
+	}
+
+	handler = newCORS("*", "").wrap(ok)
+	if rec := do(http.MethodGet, "/api/v1/diff", "https://any.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("unexpected wildcard headers: %v", rec.Header())
+	}
+	if newCORS(" ", "GET") != nil {
+		t.Fatal("expected CORS to be disabled without origins")
+	}
+}
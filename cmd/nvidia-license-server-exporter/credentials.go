@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/secrets"
+)
+
+// resolveAPIKeySecret fetches -nvidia-api-key-secret, if set, into
+// cfg.CLS.APIKey.
+func resolveAPIKeySecret(ctx context.Context, cfg *config.Config) error {
+	if strings.TrimSpace(cfg.CLS.APIKeySecret) == "" {
+		return nil
+	}
+	ref, err := secrets.Parse(cfg.CLS.APIKeySecret)
+	if err != nil {
+		return fmt.Errorf("invalid -nvidia-api-key-secret: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.CLS.ScrapeTimeout)
+	defer cancel()
+	key, err := new(secrets.Fetcher).Fetch(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the API key: %w", err)
+	}
+	cfg.CLS.APIKey = key
+	logging.AddSecret(key)
+	slog.Info("API key fetched", "secret", ref.String())
+	return nil
+}
+
+// apiKeyState swaps rotated API keys into the live client and tracks when
+// the key in use was issued, for nvidia_cls_exporter_api_key_age_seconds.
+type apiKeyState struct {
+	org    string
+	client *cls.Client
+	// issued is the Unix time in nanoseconds the key was written to its
+	// file or fetched.
+	issued atomic.Int64
+}
+
+func newAPIKeyState(target config.Target, client *cls.Client) *apiKeyState {
+	s := &apiKeyState{org: target.OrgName, client: client}
+	issued := time.Now()
+	if path := strings.TrimSpace(target.APIKeyFile); path != "" {
+		if info, err := os.Stat(path); err == nil {
+			issued = info.ModTime()
+		}
+	}
+	s.issued.Store(issued.UnixNano())
+	return s
+}
+
+func (s *apiKeyState) rotate(key string, issued time.Time) {
+	logging.AddSecret(key)
+	s.client.SetAPIKey(key)
+	s.issued.Store(issued.UnixNano())
+}
+
+func (s *apiKeyState) collector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "nvidia_cls_exporter_api_key_age_seconds",
+		Help:        "Seconds since the API key in use was written to its file or fetched.",
+		ConstLabels: prometheus.Labels{"org_name": s.org},
+	}, func() float64 {
+		return time.Since(time.Unix(0, s.issued.Load())).Seconds()
+	})
+}
+
+// watchAPIKeyFile returns a start function that polls the API key file of
+// target every interval and swaps a changed key into the client, or nil
+// when there is nothing to watch. Reading the file each time follows the
+// symlink swaps of Kubernetes Secret volumes.
+func watchAPIKeyFile(ctx context.Context, target config.Target, interval time.Duration, state *apiKeyState) func() {
+	path := strings.TrimSpace(target.APIKeyFile)
+	if path == "" || interval <= 0 {
+		return nil
+	}
+	return func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			current := target.APIKey
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				key, err := config.ReadSecretFile(path)
+				if err != nil {
+					slog.Warn("API key file unreadable, keeping the current key", "org", target.OrgName, "file", path, "error", err)
+					continue
+				}
+				if key == current {
+					continue
+				}
+				current = key
+				issued := time.Now()
+				if info, err := os.Stat(path); err == nil {
+					issued = info.ModTime()
+				}
+				state.rotate(key, issued)
+				slog.Info("API key rotated", "org", target.OrgName, "file", path)
+			}
+		}()
+		slog.Info("watching API key file", "org", target.OrgName, "file", path, "interval", interval)
+	}
+}
+
+// watchAPIKeySecret returns a start function that re-fetches
+// -nvidia-api-key-secret every refresh interval and swaps rotated keys into
+// the client, or nil when there is nothing to watch.
+func watchAPIKeySecret(ctx context.Context, cfg *config.Config, state *apiKeyState) func() {
+	if strings.TrimSpace(cfg.CLS.APIKeySecret) == "" || cfg.CLS.APIKeySecretRefresh <= 0 {
+		return nil
+	}
+	ref, err := secrets.Parse(cfg.CLS.APIKeySecret)
+	if err != nil {
+		// Validated by config.Load.
+		return nil
+	}
+	return func() {
+		go new(secrets.Fetcher).Watch(ctx, ref, cfg.CLS.APIKeySecretRefresh, cfg.CLS.APIKey, func(key string) {
+			state.rotate(key, time.Now())
+		})
+		slog.Info("API key refresh enabled", "secret", ref.String(), "interval", cfg.CLS.APIKeySecretRefresh)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+)
+
+// writeSecretVolume lays out key like the kubelet does in a Secret volume:
+// key -> ..data/key, ..data -> a timestamped directory, the ..data link
+// being replaced atomically on update.
+func writeSecretVolume(t *testing.T, dir, generation, key string) {
+	t.Helper()
+	target := filepath.Join(dir, "..2026_10_16_"+generation)
+	if err := os.Mkdir(target, 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "api-key"), []byte(key), 0o400); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(target), tmp); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("swap ..data: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "api-key")); os.IsNotExist(err) {
+		if err := os.Symlink("..data/api-key", filepath.Join(dir, "api-key")); err != nil {
+			t.Fatalf("symlink key: %v", err)
+		}
+	}
+}
+
+func TestWatchAPIKeyFileFollowsSecretVolume(t *testing.T) {
+	var seen atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("x-api-key"))
+		_, _ = w.Write([]byte(`{"virtualGroups":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	writeSecretVolume(t, dir, "1", "key-1\n")
+	cfg := config.Default()
+	cfg.CLS.OrgName = "org-1"
+	cfg.CLS.APIKeyFile = filepath.Join(dir, "api-key")
+	cfg.CLS.APIKeyFilePollInterval = 10 * time.Millisecond
+	cfg.CLS.APIKey = "key-1"
+	client, err := cls.NewClient(cls.Config{BaseURL: srv.URL, APIKey: cfg.CLS.APIKey, OrgName: "org-1"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	target := cfg.ScrapeTargets()[0]
+	state := newAPIKeyState(target, client)
+	state.issued.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchAPIKeyFile(ctx, target, cfg.CLS.APIKeyFilePollInterval, state)()
+
+	writeSecretVolume(t, dir, "2", "key-2\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := client.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "no virtual groups") {
+			t.Fatalf("unexpected check result: %v", err)
+		}
+		if seen.Load() == "key-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated key not used, last x-api-key %v", seen.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if age := testutil.ToFloat64(state.collector()); age < 0 || age > 60 {
+		t.Fatalf("expected the key age to reset on rotation, got %v", age)
+	}
+}
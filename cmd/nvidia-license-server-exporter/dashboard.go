@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/grafana"
+)
+
+// dashboardPath serves the Grafana dashboard for provisioning, e.g. with
+// curl from an init container or Grafana's dashboard import by URL.
+const dashboardPath = "/dashboards/nvidia-cls.json"
+
+// dashboardHandler serves the Grafana dashboard for the metrics of
+// collector. It is rendered once, as the metrics only change between
+// builds.
+func dashboardHandler(collector *exporter.Collector) http.Handler {
+	raw, err := grafana.Dashboard(collector.Metrics())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			slog.Error("rendering the Grafana dashboard failed", "error", err)
+			http.Error(w, "rendering the dashboard failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(raw)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
+)
+
+// registerPprof mounts the net/http/pprof handlers under /debug/pprof/.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", redactedCmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// redactedCmdline is pprof.Cmdline with secrets, such as an API key passed
+// as a flag, redacted.
+func redactedCmdline(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, logging.Redact(strings.Join(os.Args, "\x00")))
+}
+
+// pprofAddress returns the listener that serves pprof when it is enabled:
+// the debug listener, else the health listener, else "" for the main one.
+func pprofAddress(s config.Server) string {
+	if addr := strings.TrimSpace(s.DebugListenAddress); addr != "" {
+		return addr
+	}
+	return strings.TrimSpace(s.HealthListenAddress)
+}
+
+// newDebugServer returns the debug listener, which serves pprof apart from
+// the metrics port, so that profiles are neither exposed to scrapers nor
+// subject to its authentication.
+func newDebugServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	registerPprof(mux)
+	return &http.Server{
+		Addr:    addr,
+		Handler: recoverMiddleware(mux),
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// dumpTables are the tables written by -format=csv, in order.
+var dumpTables = []string{"entitlements", "server_usage", "pool_usage"}
+
+// dump fetches a snapshot of the first target and writes it as JSON or CSV
+// to stdout or, with -output, atomically to a file.
+func dump(args []string, stdout io.Writer) int {
+	var format, output, table string
+	cfg, _, code, done := setup(args, stdout, func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "json", "dump: output format, json or csv.")
+		fs.StringVar(&output, "output", "", "dump: file to write instead of stdout.")
+		fs.StringVar(&table, "table", "", "dump: with -format=csv, write only this table: entitlements, server_usage or pool_usage.")
+	})
+	if done {
+		return code
+	}
+
+	var write func(io.Writer, string, *cls.Snapshot) error
+	switch format {
+	case "json":
+		if table != "" {
+			slog.Error("invalid configuration", "error", fmt.Errorf("-table requires -format=csv"))
+			return 1
+		}
+		write = writeDumpJSON
+	case "csv":
+		tables := dumpTables
+		if table != "" {
+			if !slices.Contains(dumpTables, table) {
+				slog.Error("invalid configuration", "error", fmt.Errorf("unsupported -table %q: use entitlements, server_usage or pool_usage", table))
+				return 1
+			}
+			tables = []string{table}
+		}
+		write = func(w io.Writer, org string, snap *cls.Snapshot) error {
+			return writeDumpCSV(w, newDumpDocument(org, snap), tables)
+		}
+	default:
+		slog.Error("invalid configuration", "error", fmt.Errorf("unsupported -format %q: use json or csv", format))
+		return 1
+	}
+
+	target, snap, err := fetchOnce(cfg)
+	org := target.OrgName
+	if err != nil {
+		slog.Error("dump failed", "org", org, "endpoint", targetBaseURL(cfg, target), "error", err)
+		return 1
+	}
+	if output == "" || output == "-" {
+		err = write(stdout, org, snap)
+	} else {
+		err = writeFileAtomic(output, func(w io.Writer) error { return write(w, org, snap) })
+	}
+	if err != nil {
+		slog.Error("dump failed", "org", org, "error", err)
+		return 1
+	}
+	if output != "" && output != "-" {
+		slog.Info("snapshot written", "org", org, "file", output, "format", format)
+	}
+	return 0
+}
+
+// dumpDocument is the JSON written by the dump command. Field names are
+// stable, unlike the cls types, which carry no JSON tags.
+type dumpDocument struct {
+	Org              string             `json:"org"`
+	CollectedAt      time.Time          `json:"collected_at"`
+	ActiveLeaseTotal float64            `json:"active_lease_total"`
+	Entitlements     []dumpEntitlement  `json:"entitlements"`
+	ServerUsage      []dumpServerUsage  `json:"server_usage"`
+	PoolUsage        []dumpPoolUsage    `json:"pool_usage"`
+	ServerLeases     []dumpServerLeases `json:"server_leases"`
+}
+
+type dumpEntitlement struct {
+	VirtualGroupID   int     `json:"virtual_group_id"`
+	VirtualGroupName string  `json:"virtual_group_name"`
+	FeatureName      string  `json:"feature_name"`
+	FeatureVersion   string  `json:"feature_version"`
+	ProductName      string  `json:"product_name"`
+	LicenseType      string  `json:"license_type"`
+	Total            float64 `json:"total"`
+	InUse            float64 `json:"in_use"`
+	Unassigned       float64 `json:"unassigned"`
+}
+
+type dumpServerUsage struct {
+	VirtualGroupID   int     `json:"virtual_group_id"`
+	VirtualGroupName string  `json:"virtual_group_name"`
+	ServerID         string  `json:"server_id"`
+	ServerName       string  `json:"server_name"`
+	Status           string  `json:"status"`
+	DeployedOn       string  `json:"deployed_on"`
+	LeasingMode      string  `json:"leasing_mode"`
+	Allocated        float64 `json:"allocated"`
+	InUse            float64 `json:"in_use"`
+	Available        float64 `json:"available"`
+}
+
+type dumpPoolUsage struct {
+	VirtualGroupID   int     `json:"virtual_group_id"`
+	VirtualGroupName string  `json:"virtual_group_name"`
+	ServerID         string  `json:"server_id"`
+	ServerName       string  `json:"server_name"`
+	PoolID           string  `json:"pool_id"`
+	PoolName         string  `json:"pool_name"`
+	FeatureName      string  `json:"feature_name"`
+	ProductName      string  `json:"product_name"`
+	LicenseType      string  `json:"license_type"`
+	Allocated        float64 `json:"allocated"`
+	InUse            float64 `json:"in_use"`
+	Available        float64 `json:"available"`
+}
+
+type dumpServerLeases struct {
+	VirtualGroupID   int     `json:"virtual_group_id"`
+	VirtualGroupName string  `json:"virtual_group_name"`
+	ServerID         string  `json:"server_id"`
+	ServerName       string  `json:"server_name"`
+	ActiveLeases     float64 `json:"active_leases"`
+}
+
+func newDumpDocument(org string, snap *cls.Snapshot) dumpDocument {
+	doc := dumpDocument{
+		Org:              org,
+		CollectedAt:      snap.CollectedAt,
+		ActiveLeaseTotal: snap.ActiveLeaseTotal,
+		Entitlements:     make([]dumpEntitlement, 0, len(snap.EntitlementFeatures)),
+		ServerUsage:      make([]dumpServerUsage, 0, len(snap.ServerUsage)),
+		PoolUsage:        make([]dumpPoolUsage, 0, len(snap.PoolUsage)),
+		ServerLeases:     make([]dumpServerLeases, 0, len(snap.ServerActiveLeases)),
+	}
+	for _, f := range snap.EntitlementFeatures {
+		doc.Entitlements = append(doc.Entitlements, dumpEntitlement{
+			VirtualGroupID:   f.VirtualGroupID,
+			VirtualGroupName: f.VirtualGroupName,
+			FeatureName:      f.FeatureName,
+			FeatureVersion:   f.FeatureVersion,
+			ProductName:      f.ProductName,
+			LicenseType:      f.LicenseType,
+			Total:            f.TotalQuantity,
+			InUse:            f.InUseQuantity,
+			Unassigned:       f.Unassigned,
+		})
+	}
+	for _, s := range snap.ServerUsage {
+		doc.ServerUsage = append(doc.ServerUsage, dumpServerUsage{
+			VirtualGroupID:   s.VirtualGroupID,
+			VirtualGroupName: s.VirtualGroupName,
+			ServerID:         s.ServerID,
+			ServerName:       s.ServerName,
+			Status:           s.ServerStatus,
+			DeployedOn:       s.DeployedOn,
+			LeasingMode:      s.LeasingMode,
+			Allocated:        s.Allocated,
+			InUse:            s.InUse,
+			Available:        s.Available,
+		})
+	}
+	for _, p := range snap.PoolUsage {
+		doc.PoolUsage = append(doc.PoolUsage, dumpPoolUsage{
+			VirtualGroupID:   p.VirtualGroupID,
+			VirtualGroupName: p.VirtualGroupName,
+			ServerID:         p.ServerID,
+			ServerName:       p.ServerName,
+			PoolID:           p.PoolID,
+			PoolName:         p.PoolName,
+			FeatureName:      p.FeatureName,
+			ProductName:      p.ProductName,
+			LicenseType:      p.LicenseType,
+			Allocated:        p.Allocated,
+			InUse:            p.InUse,
+			Available:        p.Available,
+		})
+	}
+	for _, l := range snap.ServerActiveLeases {
+		doc.ServerLeases = append(doc.ServerLeases, dumpServerLeases{
+			VirtualGroupID:   l.VirtualGroupID,
+			VirtualGroupName: l.VirtualGroupName,
+			ServerID:         l.ServerID,
+			ServerName:       l.ServerName,
+			ActiveLeases:     l.ActiveLeases,
+		})
+	}
+	return doc
+}
+
+func writeDumpJSON(w io.Writer, org string, snap *cls.Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newDumpDocument(org, snap))
+}
+
+// writeDumpCSV writes tables of doc, each with a header row, separated by an
+// empty line. A single table is plain CSV.
+func writeDumpCSV(w io.Writer, doc dumpDocument, tables []string) error {
+	cw := csv.NewWriter(w)
+	for i, table := range tables {
+		if i > 0 {
+			cw.Flush()
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		var rows [][]string
+		switch table {
+		case "entitlements":
+			rows = append(rows, []string{"org", "virtual_group_id", "virtual_group_name", "feature_name", "feature_version", "product_name", "license_type", "total", "in_use", "unassigned"})
+			for _, e := range doc.Entitlements {
+				rows = append(rows, []string{doc.Org, strconv.Itoa(e.VirtualGroupID), e.VirtualGroupName, e.FeatureName, e.FeatureVersion, e.ProductName, e.LicenseType,
+					formatQuantity(e.Total), formatQuantity(e.InUse), formatQuantity(e.Unassigned)})
+			}
+		case "server_usage":
+			rows = append(rows, []string{"org", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "status", "deployed_on", "leasing_mode", "allocated", "in_use", "available"})
+			for _, s := range doc.ServerUsage {
+				rows = append(rows, []string{doc.Org, strconv.Itoa(s.VirtualGroupID), s.VirtualGroupName, s.ServerID, s.ServerName, s.Status, s.DeployedOn, s.LeasingMode,
+					formatQuantity(s.Allocated), formatQuantity(s.InUse), formatQuantity(s.Available)})
+			}
+		case "pool_usage":
+			rows = append(rows, []string{"org", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "pool_id", "pool_name", "feature_name", "product_name", "license_type", "allocated", "in_use", "available"})
+			for _, p := range doc.PoolUsage {
+				rows = append(rows, []string{doc.Org, strconv.Itoa(p.VirtualGroupID), p.VirtualGroupName, p.ServerID, p.ServerName, p.PoolID, p.PoolName, p.FeatureName, p.ProductName, p.LicenseType,
+					formatQuantity(p.Allocated), formatQuantity(p.InUse), formatQuantity(p.Available)})
+			}
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatQuantity(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v2"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/version"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// generateOptions are the flags of the generate command besides the
+// configuration.
+type generateOptions struct {
+	job          string
+	address      string
+	usageWarning float64
+	usageCrit    float64
+	staleAfter   time.Duration
+	forDuration  time.Duration
+}
+
+func (o *generateOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.job, "job", "nvidia_cls", "generate: Prometheus job name of the exporter.")
+	fs.StringVar(&o.address, "address", "", "generate: host:port Prometheus scrapes; empty uses the listen address, with localhost for an unspecified host.")
+	fs.Float64Var(&o.usageWarning, "usage-warning", 0.9, "generate: fraction of an entitlement in use that raises a warning.")
+	fs.Float64Var(&o.usageCrit, "usage-critical", 1, "generate: fraction of an entitlement in use that is critical.")
+	fs.DurationVar(&o.staleAfter, "stale-after", 15*time.Minute, "generate: age of the last CLS snapshot that raises an alert.")
+	fs.DurationVar(&o.forDuration, "for", 10*time.Minute, "generate: how long a condition must hold before alerts fire.")
+}
+
+// generate writes a Prometheus scrape config or rule file for the exporter
+// as configured, so that both follow the listener and metric names of this
+// build instead of being copied from the README. It reads the same
+// configuration as serve but needs neither credentials nor CLS.
+func generate(args []string, stdout io.Writer) int {
+	kinds := map[string]func(*config.Config, generateOptions) (any, error){
+		"scrape-config": scrapeConfigFor,
+		"rules":         alertingRules,
+	}
+	if len(args) == 0 || kinds[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: %s generate scrape-config|rules [flags]\n", os.Args[0])
+		return 2
+	}
+	kind, build := args[0], kinds[args[0]]
+
+	var opts generateOptions
+	cfg, err := config.LoadWith(args[1:], opts.register)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+	if cfg.ShowVersion {
+		return printVersion(nil, stdout)
+	}
+	out, err := build(cfg, opts)
+	if err != nil {
+		slog.Error("generate failed", "kind", kind, "error", err)
+		return 1
+	}
+	raw, err := yaml.Marshal(out)
+	if err != nil {
+		slog.Error("generate failed", "kind", kind, "error", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "# Generated by nvidia-license-server-exporter %s: generate %s.\n", version.Get().Version, kind)
+	_, _ = stdout.Write(raw)
+	return 0
+}
+
+type scrapeConfigs struct {
+	ScrapeConfigs []scrapeConfig `yaml:"scrape_configs"`
+}
+
+type scrapeConfig struct {
+	JobName        string            `yaml:"job_name"`
+	ScrapeInterval string            `yaml:"scrape_interval"`
+	ScrapeTimeout  string            `yaml:"scrape_timeout"`
+	MetricsPath    string            `yaml:"metrics_path"`
+	Scheme         string            `yaml:"scheme"`
+	Authorization  map[string]string `yaml:"authorization,omitempty"`
+	BasicAuth      map[string]string `yaml:"basic_auth,omitempty"`
+	StaticConfigs  []staticConfig    `yaml:"static_configs"`
+}
+
+type staticConfig struct {
+	Targets []string `yaml:"targets"`
+}
+
+// scrapeConfigFor scrapes every cache TTL, as more frequent scrapes only
+// return the cached snapshot, and allows a scrape that misses the cache the
+// CLS scrape timeout plus some slack.
+func scrapeConfigFor(cfg *config.Config, opts generateOptions) (any, error) {
+	if cfg.Server.HTTPDisabled {
+		return nil, errors.New("the HTTP listener is disabled, there is nothing to scrape")
+	}
+	address := strings.TrimSpace(opts.address)
+	if address == "" {
+		host, port, err := net.SplitHostPort(cfg.Server.ListenAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", cfg.Server.ListenAddress, err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "localhost"
+		}
+		address = net.JoinHostPort(host, port)
+	}
+	interval := max(cfg.Cache.TTL, 15*time.Second)
+	sc := scrapeConfig{
+		JobName:        opts.job,
+		ScrapeInterval: model.Duration(interval).String(),
+		ScrapeTimeout:  model.Duration(min(cfg.CLS.ScrapeTimeout+5*time.Second, interval)).String(),
+		MetricsPath:    cfg.Server.MetricsPath,
+		Scheme:         "http",
+		StaticConfigs:  []staticConfig{{Targets: []string{address}}},
+	}
+	if strings.TrimSpace(cfg.Server.WebConfigFile) != "" {
+		webCfg, err := web.LoadConfig(cfg.Server.WebConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if webCfg.TLS() != nil {
+			sc.Scheme = "https"
+		}
+		// Placeholders: the files are those of the Prometheus server.
+		switch {
+		case webCfg.BearerToken() != "":
+			sc.Authorization = map[string]string{"credentials_file": "/etc/prometheus/secrets/nvidia-cls-token"}
+		case len(webCfg.BasicAuthUsers) > 0:
+			sc.BasicAuth = map[string]string{"username": firstKey(webCfg.BasicAuthUsers), "password_file": "/etc/prometheus/secrets/nvidia-cls-password"}
+		}
+	}
+	return scrapeConfigs{ScrapeConfigs: []scrapeConfig{sc}}, nil
+}
+
+func firstKey(m map[string]string) string {
+	first := ""
+	for k := range m {
+		if first == "" || k < first {
+			first = k
+		}
+	}
+	return first
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// alertingRules covers the exporter being down, failing CLS fetches, a
+// stale snapshot and entitlements running out. Every metric it uses is one
+// the exporter exposes; TestAlertingRulesUseExportedMetrics keeps it so.
+func alertingRules(_ *config.Config, opts generateOptions) (any, error) {
+	switch {
+	case opts.usageWarning <= 0 || opts.usageCrit <= 0:
+		return nil, errors.New("-usage-warning and -usage-critical must be positive")
+	case opts.usageWarning > opts.usageCrit:
+		return nil, errors.New("-usage-warning must not be above -usage-critical")
+	case opts.staleAfter <= 0:
+		return nil, errors.New("-stale-after must be positive")
+	}
+	job := fmt.Sprintf("{job=%q}", opts.job)
+	holdFor := model.Duration(opts.forDuration).String()
+	usage := "sum by (org_name, virtual_group_name, feature_name) (nvidia_cls_license_server_feature_active_leases" + job + ")\n" +
+		"  / sum by (org_name, virtual_group_name, feature_name) (nvidia_cls_entitlement_total_quantity" + job + ")"
+	usageRule := func(name, severity string, threshold float64) alertRule {
+		return alertRule{
+			Alert:  name,
+			Expr:   usage + " >= " + strconv.FormatFloat(threshold, 'g', -1, 64),
+			For:    holdFor,
+			Labels: map[string]string{"severity": severity},
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.feature_name }} licenses of {{ $labels.virtual_group_name }} are {{ $value | humanizePercentage }} in use",
+				"description": "Active leases of {{ $labels.feature_name }} in virtual group {{ $labels.virtual_group_name }} of org {{ $labels.org_name }} reached " + strconv.FormatFloat(threshold*100, 'g', -1, 64) + "% of the entitlement.",
+			},
+		}
+	}
+	return ruleFile{Groups: []ruleGroup{{
+		Name: "nvidia-cls",
+		Rules: []alertRule{
+			{
+				Alert:       "NvidiaCLSExporterDown",
+				Expr:        "up" + job + " == 0",
+				For:         "5m",
+				Labels:      map[string]string{"severity": "critical"},
+				Annotations: map[string]string{"summary": "NVIDIA CLS exporter {{ $labels.instance }} is down"},
+			},
+			{
+				Alert:       "NvidiaCLSScrapeFailing",
+				Expr:        "nvidia_cls_up" + job + " == 0",
+				For:         holdFor,
+				Labels:      map[string]string{"severity": "warning"},
+				Annotations: map[string]string{"summary": "Fetching org {{ $labels.org_name }} from NVIDIA CLS fails", "description": "See nvidia_cls_last_error_info or /api/v1/targets for the error."},
+			},
+			{
+				Alert:       "NvidiaCLSSnapshotStale",
+				Expr:        "time() - nvidia_cls_scrape_timestamp_seconds" + job + " > " + strconv.FormatFloat(opts.staleAfter.Seconds(), 'g', -1, 64),
+				For:         "5m",
+				Labels:      map[string]string{"severity": "warning"},
+				Annotations: map[string]string{"summary": "The license data of org {{ $labels.org_name }} is {{ $value | humanizeDuration }} old"},
+			},
+			usageRule("NvidiaCLSLicensesNearlyExhausted", "warning", opts.usageWarning),
+			usageRule("NvidiaCLSLicensesExhausted", "critical", opts.usageCrit),
+		},
+	}}}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+func TestGenerateScrapeConfig(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	var out bytes.Buffer
+	if code := run([]string{"generate", "scrape-config", "-listen-address", "0.0.0.0:9900", "-metrics-path", "/m", "-cache-ttl", "5m"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	var got scrapeConfigs
+	if err := yaml.UnmarshalStrict(out.Bytes(), &got); err != nil {
+		t.Fatalf("parse output: %v\n%s", err, out.String())
+	}
+	sc := got.ScrapeConfigs[0]
+	if sc.MetricsPath != "/m" || sc.ScrapeInterval != "5m" || sc.ScrapeTimeout != "25s" || sc.StaticConfigs[0].Targets[0] != "localhost:9900" {
+		t.Fatalf("unexpected scrape config:\n%s", out.String())
+	}
+
+	if code := run([]string{"generate", "dashboards"}, &out); code != 2 {
+		t.Fatalf("unknown kind: expected exit code 2, got %d", code)
+	}
+}
+
+func TestAlertingRulesUseExportedMetrics(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	var out bytes.Buffer
+	if code := run([]string{"generate", "rules", "-usage-warning", "0.8", "-stale-after", "30m"}, &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	var rules ruleFile
+	if err := yaml.UnmarshalStrict(out.Bytes(), &rules); err != nil {
+		t.Fatalf("parse output: %v\n%s", err, out.String())
+	}
+
+	manager, err := snapshot.NewManager(snapshot.NewService(stubFetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := map[string]bool{}
+	for _, m := range exporter.NewCollector(manager, time.Second).Metrics() {
+		exported[m.Name] = true
+	}
+
+	var exprs []string
+	for _, rule := range rules.Groups[0].Rules {
+		exprs = append(exprs, rule.Expr)
+		for _, name := range regexp.MustCompile(`nvidia_cls_[a-z_]+`).FindAllString(rule.Expr, -1) {
+			if !exported[name] {
+				t.Errorf("%s uses %s, which the exporter does not expose", rule.Alert, name)
+			}
+		}
+	}
+	for _, want := range []string{">= 0.8", "> 1800", `up{job="nvidia_cls"} == 0`} {
+		if !strings.Contains(strings.Join(exprs, "\n"), want) {
+			t.Errorf("rules lack %q:\n%s", want, out.String())
+		}
+	}
+
+	if code := run([]string{"generate", "rules", "-usage-warning", "1.2"}, &out); code != 1 {
+		t.Fatalf("warning above critical: expected exit code 1, got %d", code)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	body := strings.Repeat(`{"org":"org-1"}`, 1000)
+	handler := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, body)
+	}))
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/api/v1/diff", "br;q=1.0, gzip;q=0.8")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzip response, got headers %v", rec.Header())
+	}
+	if rec.Body.Len() >= len(body) {
+		t.Fatalf("expected a compressed body, got %d bytes", rec.Body.Len())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil || !strings.Contains(string(raw), `\"org\":\"org-1\"`) {
+		t.Fatalf("decompress: %v\n%.100s", err, raw)
+	}
+
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0"} {
+		if rec := serve("/api/v1/diff", acceptEncoding); rec.Header().Get("Content-Encoding") != "" || !strings.Contains(rec.Body.String(), "org-1") {
+			t.Fatalf("Accept-Encoding %q: expected a plain response, got headers %v", acceptEncoding, rec.Header())
+		}
+	}
+	if rec := serve("/empty", "gzip"); rec.Code != http.StatusNoContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Fatalf("expected an unencoded 204, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
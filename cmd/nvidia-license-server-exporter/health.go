@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type healthResult struct {
+	Status  string                  `json:"status"`
+	Targets map[string]targetHealth `json:"targets,omitempty"`
+}
+
+type targetHealth struct {
+	Ready       bool      `json:"ready"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// registerHealth mounts the health check endpoints.
+func registerHealth(mux *http.ServeMux, ready *readiness) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/-/healthy", healthyHandler())
+	mux.Handle("/-/ready", ready)
+}
+
+// newHealthServer returns the health listener, which serves the health
+// checks of the current app apart from the metrics port, without
+// authentication, so that load balancers can probe it while the metrics
+// port stays firewalled to Prometheus.
+func newHealthServer(addr string, reloads *reloader) *http.Server {
+	return &http.Server{
+		Addr: addr,
+		Handler: recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if reloads.serveDraining(w, req) {
+				return
+			}
+			reloads.current().health.ServeHTTP(w, req)
+		})),
+	}
+}
+
+// healthyHandler is the liveness check. It only fails when the process can
+// no longer serve requests: CLS outages and bad credentials are readiness
+// problems, and restarting would not fix them.
+func healthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, healthResult{Status: "healthy"})
+	})
+}
+
+// readiness reports ready when every target has a snapshot collected within
+// maxAge, or at all when maxAge is zero. A failed check starts a refresh in
+// the background, so that an exporter which receives no scrapes while it is
+// unready can recover.
+type readiness struct {
+	ctx     context.Context
+	manager *snapshot.Manager
+	maxAge  time.Duration
+	timeout time.Duration
+	// standby, if set, reports whether another replica leads the leader
+	// election while there is no shared cache to serve its snapshots
+	// from. The replica is then not ready, so that it receives no scrapes.
+	standby func() bool
+
+	refreshing atomic.Bool
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if rd.standby != nil && rd.standby() {
+		writeJSON(w, http.StatusServiceUnavailable, healthResult{Status: "standby"})
+		return
+	}
+	ready, targets := rd.check()
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, healthResult{Status: "not ready", Targets: targets})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResult{Status: "ready", Targets: targets})
+}
+
+// check reports whether every target is ready, starting a background
+// refresh when one is not.
+func (rd *readiness) check() (bool, map[string]targetHealth) {
+	now := time.Now()
+	ready := true
+	targets := make(map[string]targetHealth, len(rd.manager.Services()))
+	for _, svc := range rd.manager.Services() {
+		last := svc.LastSuccess()
+		health := targetHealth{
+			Ready:       !last.IsZero() && (rd.maxAge <= 0 || now.Sub(last) <= rd.maxAge),
+			LastSuccess: last,
+			LastError:   logging.Redact(svc.Meta().LastError),
+		}
+		ready = ready && health.Ready
+		targets[svc.Target()] = health
+	}
+	if !ready {
+		rd.refreshInBackground()
+	}
+	return ready, targets
+}
+
+func (rd *readiness) refreshInBackground() {
+	if !rd.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer rd.refreshing.Store(false)
+		ctx, cancel := context.WithTimeout(rd.ctx, rd.timeout)
+		defer cancel()
+		for _, result := range rd.manager.RefreshAll(ctx) {
+			if result.Err != nil {
+				slog.Error("readiness refresh failed", "org", result.Service.Target(), "error", result.Err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type agedFetcher struct{ age time.Duration }
+
+func (f agedFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return &cls.Snapshot{CollectedAt: time.Now().Add(-f.age).UTC()}, nil
+}
+
+func TestReadiness(t *testing.T) {
+	ready := func(rd *readiness) (int, healthResult) {
+		rec := httptest.NewRecorder()
+		rd.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+		var result healthResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return rec.Code, result
+	}
+
+	t.Run("becomes ready after the background refresh", func(t *testing.T) {
+		manager, err := snapshot.NewManager(snapshot.NewService(stubFetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute}))
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		rd := &readiness{ctx: context.Background(), manager: manager, maxAge: time.Minute, timeout: time.Second}
+
+		code, result := ready(rd)
+		if code != http.StatusServiceUnavailable || result.Targets["org-1"].Ready {
+			t.Fatalf("expected not ready before the first snapshot, got %d %+v", code, result)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for code != http.StatusOK {
+			if time.Now().After(deadline) {
+				t.Fatalf("not ready after background refresh: %+v", result)
+			}
+			time.Sleep(10 * time.Millisecond)
+			code, result = ready(rd)
+		}
+		if result.Status != "ready" || result.Targets["org-1"].LastSuccess.IsZero() {
+			t.Fatalf("unexpected response: %+v", result)
+		}
+	})
+
+	t.Run("fails on an old snapshot", func(t *testing.T) {
+		svc := snapshot.NewService(agedFetcher{age: time.Hour}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+		manager, err := snapshot.NewManager(svc)
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		if _, _, err := svc.Refresh(context.Background()); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+
+		if code, _ := ready(&readiness{ctx: context.Background(), manager: manager, maxAge: 10 * time.Minute, timeout: time.Second}); code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for a snapshot older than max age, got %d", code)
+		}
+		if code, _ := ready(&readiness{ctx: context.Background(), manager: manager, timeout: time.Second}); code != http.StatusOK {
+			t.Fatalf("expected 200 without max age, got %d", code)
+		}
+	})
+
+	t.Run("fails on standby", func(t *testing.T) {
+		svc := snapshot.NewService(agedFetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+		manager, err := snapshot.NewManager(svc)
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		if _, _, err := svc.Refresh(context.Background()); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+		standby := true
+		rd := &readiness{ctx: context.Background(), manager: manager, timeout: time.Second, standby: func() bool { return standby }}
+		if code, result := ready(rd); code != http.StatusServiceUnavailable || result.Status != "standby" {
+			t.Fatalf("expected 503 standby for a follower, got %d %+v", code, result)
+		}
+		standby = false
+		if code, _ := ready(rd); code != http.StatusOK {
+			t.Fatalf("expected 200 for the leader, got %d", code)
+		}
+	})
+}
+
+func TestHealthListenerServesOnlyHealthChecks(t *testing.T) {
+	cfg := config.Default()
+	cfg.CLS.BaseURL = "http://127.0.0.1:1"
+	cfg.CLS.OrgName = "org-1"
+	cfg.CLS.APIKey = "test-key"
+	cfg.Server.HealthListenAddress = "127.0.0.1:9845"
+	cfg.Server.EnablePprof = true
+	cfg.Server.DebugListenAddress = ""
+	a, err := newApp(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	defer a.close(context.Background())
+	reloads := newReloader(context.Background(), nil, nil)
+	reloads.init(a)
+	health := newHealthServer(cfg.Server.HealthListenAddress, reloads).Handler
+
+	for path, want := range map[string]int{
+		"/healthz":      http.StatusOK,
+		"/-/healthy":    http.StatusOK,
+		"/-/ready":      http.StatusServiceUnavailable,
+		"/debug/pprof/": http.StatusOK,
+		"/metrics":      http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("health listener %s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+	// pprof moved to the health listener.
+	if _, pattern := a.handler.(*http.ServeMux).Handler(httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)); pattern != "/" {
+		t.Fatalf("pprof still served on the metrics listener, by %q", pattern)
+	}
+}
+
+func TestDrainFailsReadiness(t *testing.T) {
+	cfg := config.Default()
+	cfg.CLS.BaseURL = "http://127.0.0.1:1"
+	cfg.CLS.OrgName = "org-1"
+	cfg.CLS.APIKey = "test-key"
+	cfg.Server.HealthListenAddress = "127.0.0.1:9845"
+	a, err := newApp(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	defer a.close(context.Background())
+	reloads := newReloader(context.Background(), nil, nil)
+	reloads.init(a)
+	reloads.drain()
+
+	for name, handler := range map[string]http.Handler{
+		"main":   reloads,
+		"health": newHealthServer(cfg.Server.HealthListenAddress, reloads).Handler,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+		var result healthResult
+		_ = json.Unmarshal(rec.Body.Bytes(), &result)
+		if rec.Code != http.StatusServiceUnavailable || result.Status != "shutting down" {
+			t.Errorf("%s listener: expected 503 shutting down while draining, got %d %+v", name, rec.Code, result)
+		}
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/healthy", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s listener: expected liveness to pass while draining, got %d", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// healthcheck requests the liveness check of a running exporter and exits 0
+// if it answers 200, and 1 otherwise. It is meant for Docker HEALTHCHECK and
+// Kubernetes exec probes in images without a shell or curl, so it reads the
+// same configuration as serve but needs neither credentials nor CLS.
+func healthcheck(args []string, stdout io.Writer) int {
+	var target string
+	var timeout time.Duration
+	cfg, err := config.LoadWith(args, func(fs *flag.FlagSet) {
+		fs.StringVar(&target, "url", "", "healthcheck: URL to request instead of /-/healthy on the configured listener.")
+		fs.DurationVar(&timeout, "timeout", 5*time.Second, "healthcheck: time to wait for the answer.")
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+	if cfg.ShowVersion {
+		return printVersion(nil, stdout)
+	}
+	if target == "" {
+		if target, err = healthcheckURL(cfg.Server); err != nil {
+			slog.Error("healthcheck failed", "error", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := probe(ctx, target); err != nil {
+		slog.Error("healthcheck failed", "url", target, "error", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "healthy: %s\n", target)
+	return 0
+}
+
+// healthcheckURL returns the liveness check URL of an exporter running with
+// s: on the health listener if there is one, which always serves plain HTTP,
+// and otherwise on the main listener, over HTTPS if the web config enables
+// TLS. Unspecified listen hosts are reached on localhost.
+func healthcheckURL(s config.Server) (string, error) {
+	addr, scheme := strings.TrimSpace(s.HealthListenAddress), "http"
+	if addr == "" {
+		if s.HTTPDisabled {
+			return "", fmt.Errorf("the HTTP listener is disabled and no -health-listen-address is set")
+		}
+		addr = s.ListenAddress
+		if strings.TrimSpace(s.WebConfigFile) != "" {
+			webCfg, err := web.LoadConfig(s.WebConfigFile)
+			if err != nil {
+				return "", err
+			}
+			if webCfg.TLS() != nil {
+				scheme = "https"
+			}
+		}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return (&url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: "/-/healthy"}).String(), nil
+}
+
+// probe requests target and fails unless it answers 200. The certificate is
+// not verified: the exporter is reached by a local name its certificate
+// usually does not cover, and the check is about liveness, not identity.
+func probe(ctx context.Context, target string) error {
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nvidia-license-server-exporter/internal/config"
+)
+
+func TestHealthcheckURL(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		edit func(*config.Server)
+		want string
+	}{
+		{"default listener", func(*config.Server) {}, "http://localhost:9844/-/healthy"},
+		{"bound host", func(s *config.Server) { s.ListenAddress = "10.0.0.5:9000" }, "http://10.0.0.5:9000/-/healthy"},
+		{"unspecified ipv6", func(s *config.Server) { s.ListenAddress = "[::]:9000" }, "http://localhost:9000/-/healthy"},
+		{"health listener", func(s *config.Server) { s.HealthListenAddress = "0.0.0.0:9845" }, "http://localhost:9845/-/healthy"},
+		{"push only with health listener", func(s *config.Server) { s.HTTPDisabled, s.HealthListenAddress = true, ":9845" }, "http://localhost:9845/-/healthy"},
+	} {
+		s := config.Default().Server
+		s.ListenAddress = ":9844"
+		tt.edit(&s)
+		if got, err := healthcheckURL(s); err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	s := config.Default().Server
+	s.HTTPDisabled = true
+	if _, err := healthcheckURL(s); err == nil {
+		t.Fatal("expected an error without any listener")
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/healthy" || !healthy {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("NVIDIA_ORG_NAME", "")
+	t.Setenv("NVIDIA_API_KEY", "")
+	t.Setenv("CONFIG_FILE", "")
+
+	var out bytes.Buffer
+	if code := run([]string{"healthcheck", "-url", server.URL + "/-/healthy"}, &out); code != 0 {
+		t.Fatalf("healthy exporter: expected exit code 0, got %d", code)
+	}
+	healthy = false
+	if code := run([]string{"healthcheck", "-url", server.URL + "/-/healthy"}, &out); code != 1 {
+		t.Fatalf("unhealthy exporter: expected exit code 1, got %d", code)
+	}
+	server.Close()
+	if code := run([]string{"healthcheck", "-url", server.URL + "/-/healthy", "-timeout", "1s"}, &out); code != 1 {
+		t.Fatalf("unreachable exporter: expected exit code 1, got %d", code)
+	}
+}
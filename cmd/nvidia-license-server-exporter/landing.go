@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/version"
+)
+
+// landingPage is what / shows: where things are and how the exporter is
+// configured, for operators opening it in a browser or with curl.
+type landingPage struct {
+	Version      string
+	Orgs         []string
+	CacheBackend string
+	CacheTTL     time.Duration
+	Pushers      []string
+	Links        []landingLink
+	// Config is the effective configuration as YAML, secrets redacted.
+	Config string
+}
+
+type landingLink struct {
+	Path        string
+	Description string
+	// Method is set for endpoints that are not plain GETs, which are
+	// listed without a link.
+	Method string
+}
+
+func newLandingPage(a *app) landingPage {
+	cfg := a.cfg
+	page := landingPage{
+		Version:      version.Get().Version,
+		CacheBackend: cfg.Cache.Backend,
+		CacheTTL:     cfg.Cache.TTL,
+	}
+	for _, svc := range a.manager.Services() {
+		page.Orgs = append(page.Orgs, svc.Target())
+	}
+	for _, p := range a.pushers {
+		page.Pushers = append(page.Pushers, p.name)
+	}
+
+	admin := strings.TrimSpace(cfg.Server.AdminToken) != ""
+	page.Links = append(page.Links, landingLink{Path: cfg.Server.MetricsPath, Description: "Prometheus metrics"})
+	if cfg.Server.AllowCacheBypass {
+		page.Links = append(page.Links, landingLink{Path: cfg.Server.MetricsPath + "?cache=bypass", Description: "Metrics from a fresh CLS fetch"})
+	}
+	page.Links = append(page.Links,
+		landingLink{Path: "/-/healthy", Description: "Liveness check"},
+		landingLink{Path: "/-/ready", Description: "Readiness check, with the last success and error per org"},
+		landingLink{Path: "/healthz", Description: "Legacy health check"},
+		landingLink{Path: "/api/v1/targets", Description: "JSON status of every org"},
+		landingLink{Path: "/api/v1/snapshot", Description: "Cached license data of every org as JSON"},
+		landingLink{Path: "/api/v1/report?format=xlsx", Description: "Audit report of entitlements, deployment, peak usage and license servers"},
+		landingLink{Path: dashboardPath, Description: "Grafana dashboard for these metrics"},
+	)
+	if cfg.Cache.HistorySize > 0 {
+		page.Links = append(page.Links, landingLink{Path: "/api/v1/diff", Description: "JSON diff between the last two snapshots"})
+	}
+	if strings.TrimSpace(cfg.HistoryDB.Path) != "" {
+		page.Links = append(page.Links,
+			landingLink{Path: "/api/v1/usage", Description: "Daily peak and average active leases of the last 30 days"},
+			landingLink{Path: "/api/v1/forecast", Description: "Days until each feature's licenses run out at the current trend"},
+		)
+	}
+	if admin {
+		page.Links = append(page.Links,
+			landingLink{Path: "/-/refresh", Method: http.MethodPost, Description: "Force a snapshot refresh (admin token)"},
+			landingLink{Path: "/-/reload", Method: http.MethodPost, Description: "Reload the configuration (admin token)"},
+		)
+		if cfg.OTEL.Enabled {
+			page.Links = append(page.Links, landingLink{Path: "/-/otel/flush", Method: http.MethodPost, Description: "Flush OTEL exports (admin token)"})
+		}
+	}
+	if cfg.Server.EnablePprof && pprofAddress(cfg.Server) == "" {
+		page.Links = append(page.Links, landingLink{Path: "/debug/pprof/", Description: "Go profiles"})
+	}
+
+	if raw, err := cfg.RedactedYAML(); err != nil {
+		page.Config = "unavailable: " + err.Error()
+	} else {
+		page.Config = string(raw)
+	}
+	return page
+}
+
+// landingHandler serves the landing page as HTML to browsers and as plain
+// text otherwise. Every other unknown path is a 404.
+func landingHandler(a *app) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := newLandingPage(a)
+		var err error
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			err = landingTemplate.Execute(w, page)
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			err = writeLandingText(w, page)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "write landing page failed", "error", err)
+		}
+	})
+}
+
+func writeLandingText(w io.Writer, page landingPage) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nvidia-license-server-exporter %s\n\n", page.Version)
+	fmt.Fprintf(&b, "orgs: %s\n", strings.Join(page.Orgs, ", "))
+	fmt.Fprintf(&b, "cache: %s, ttl %s\n", page.CacheBackend, page.CacheTTL)
+	if len(page.Pushers) > 0 {
+		fmt.Fprintf(&b, "push: %s\n", strings.Join(page.Pushers, ", "))
+	}
+	b.WriteString("\nendpoints:\n")
+	for _, link := range page.Links {
+		method := link.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		fmt.Fprintf(&b, "  %-4s %-26s %s\n", method, link.Path, link.Description)
+	}
+	b.WriteString("\nconfiguration (secrets redacted):\n\n")
+	b.WriteString(page.Config)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>NVIDIA License System exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+td { padding: 0.2em 1em 0.2em 0; }
+pre { background: #f4f4f4; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>NVIDIA License System exporter</h1>
+<p>Version {{.Version}}</p>
+<table>
+<tr><td>Orgs</td><td>{{range $i, $org := .Orgs}}{{if $i}}, {{end}}{{$org}}{{end}}</td></tr>
+<tr><td>Cache</td><td>{{.CacheBackend}}, TTL {{.CacheTTL}}</td></tr>
+{{- if .Pushers}}
+<tr><td>Push</td><td>{{range $i, $p := .Pushers}}{{if $i}}, {{end}}{{$p}}{{end}}</td></tr>
+{{- end}}
+</table>
+<h2>Endpoints</h2>
+<table>
+{{- range .Links}}
+<tr><td>{{if .Method}}{{.Method}} <code>{{.Path}}</code>{{else}}<a href="{{.Path}}">{{.Path}}</a>{{end}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+<h2>Configuration</h2>
+<p>Secrets are redacted.</p>
+<pre>{{.Config}}</pre>
+</body>
+</html>
+`))
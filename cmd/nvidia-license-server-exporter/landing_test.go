@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nvidia-license-server-exporter/internal/config"
+)
+
+func TestLandingPage(t *testing.T) {
+	cfg := config.Default()
+	cfg.CLS.BaseURL = "http://127.0.0.1:1"
+	cfg.CLS.OrgName = "org-1"
+	cfg.CLS.APIKey = "nvapi-landing-key"
+	cfg.Server.AdminToken = "admin-landing-token"
+	cfg.Cache.HistorySize = 2
+	a, err := newApp(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	defer a.close(context.Background())
+
+	for accept, contentType := range map[string]string{
+		"":                                "text/plain",
+		"text/html,application/xhtml+xml": "text/html",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		a.handler.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), contentType) {
+			t.Fatalf("Accept %q: got %d %s", accept, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		for _, want := range []string{"/metrics", "/-/ready", "/api/v1/diff", "/-/refresh", "org-1", "ttl: 1m0s"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Accept %q: page lacks %q:\n%s", accept, want, body)
+			}
+		}
+		for _, secret := range []string{cfg.CLS.APIKey, cfg.Server.AdminToken} {
+			if strings.Contains(body, secret) {
+				t.Errorf("Accept %q: page leaks %q", accept, secret)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no-such-page", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown paths, got %d", rec.Code)
+	}
+}
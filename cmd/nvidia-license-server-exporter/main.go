@@ -2,142 +2,329 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"flag"
-	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"nvidia-license-server-exporter/internal/cls"
-	"nvidia-license-server-exporter/internal/exporter"
-	"nvidia-license-server-exporter/internal/otel"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
 	"nvidia-license-server-exporter/internal/snapshot"
+	"nvidia-license-server-exporter/internal/systemd"
+	"nvidia-license-server-exporter/internal/version"
 )
 
-func main() {
-	var (
-		listenAddress = flag.String("listen-address", defaultListenAddress(), "Address to listen on for HTTP requests.")
-		metricsPath   = flag.String("metrics-path", getenv("METRICS_PATH", "/metrics"), "Path where metrics are exposed.")
-		baseURL       = flag.String("nvidia-api-base-url", getenv("NVIDIA_API_BASE_URL", "https://api.licensing.nvidia.com"), "NVIDIA CLS API base URL.")
-		orgName       = flag.String("nvidia-org-name", firstNonEmpty(getenv("NVIDIA_ORG_NAME", ""), getenv("NLS_ORG_NAME", "")), "NVIDIA org name / ID (e.g. lic-...).")
-		apiKey        = flag.String("nvidia-api-key", firstNonEmpty(getenv("NVIDIA_API_KEY", ""), getenv("NLS_API_KEY", "")), "NVIDIA Licensing State API key.")
-		serviceID     = flag.String("nvidia-service-instance-id", getenv("NVIDIA_SERVICE_INSTANCE_ID", ""), "Optional service instance ID sent as x-nv-service-instance-id.")
-		scrapeTimeout = flag.Duration("scrape-timeout", durationFromEnv("SCRAPE_TIMEOUT", 20*time.Second), "Timeout for each CLS scrape.")
-		cacheTTL      = flag.Duration("cache-ttl", durationFromEnv("CACHE_TTL", 60*time.Second), "In-memory cache TTL for CLS snapshots.")
-		parallelism   = flag.Int("parallelism", intFromEnv("PARALLELISM", 8), "Max concurrent CLS API calls during scrape.")
-		otelEnabled   = flag.Bool("otel-enabled", boolFromEnv("OTEL_ENABLED", false), "Enable OTEL metrics export.")
-		otelEndpoint  = flag.String("otel-endpoint", getenv("OTEL_ENDPOINT", "127.0.0.1:4317"), "OTLP gRPC endpoint.")
-		otelSvcName   = flag.String("otel-service-name", getenv("OTEL_SERVICE_NAME", "nvidia-license-server-exporter"), "OTEL service.name.")
-		otelSvcID     = flag.String("otel-service-instance-id", getenv("OTEL_SERVICE_INSTANCE_ID", hostnameOrUnknown()), "OTEL service.instance.id.")
-		otelInsecure  = flag.Bool("otel-insecure", boolFromEnv("OTEL_INSECURE", true), "Disable TLS for OTLP.")
-		otelInterval  = flag.Duration("otel-push-interval", durationFromEnv("OTEL_PUSH_INTERVAL", 60*time.Second), "OTEL periodic push interval.")
-	)
-	flag.Parse()
-
-	if strings.TrimSpace(*orgName) == "" {
-		log.Fatal("missing required org name: set NVIDIA_ORG_NAME or pass -nvidia-org-name")
+// serveOptions are the flags of the serve command besides the
+// configuration.
+type serveOptions struct {
+	once         bool
+	output       string
+	validate     bool
+	validateAuth bool
+}
+
+func (o *serveOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.once, "once", false, "serve: scrape once, write the metrics to -output and exit, for cron and node_exporter's textfile collector.")
+	fs.StringVar(&o.output, "output", "", "serve: with -once, the .prom file to write atomically.")
+	fs.BoolVar(&o.validate, "validate", false, "serve: check the configuration and resolve secrets without starting, print the effective configuration with secrets redacted and exit.")
+	fs.BoolVar(&o.validateAuth, "validate-auth", false, "serve: with -validate, also check every API key against the CLS API, as the check command does.")
+}
+
+// serve runs the exporter until it receives SIGINT or SIGTERM, scrapes once
+// with -once or only checks the configuration with -validate.
+func serve(args []string, stdout io.Writer) int {
+	var opts serveOptions
+	cfg, logLevel, code, done := setup(args, stdout, opts.register)
+	if done {
+		return code
 	}
-	if strings.TrimSpace(*apiKey) == "" {
-		log.Fatal("missing required API key: set NVIDIA_API_KEY or pass -nvidia-api-key")
+	if opts.validate || opts.validateAuth {
+		return validateOnly(cfg, opts, stdout)
 	}
-
-	client, err := cls.NewClient(cls.Config{
-		BaseURL:           *baseURL,
-		APIKey:            *apiKey,
-		OrgName:           *orgName,
-		ServiceInstanceID: *serviceID,
-		ParallelFetches:   *parallelism,
-	})
-	if err != nil {
-		log.Fatalf("failed to create CLS client: %v", err)
+	if opts.once || opts.output != "" {
+		return scrapeOnce(cfg, opts)
 	}
 
-	snapshotSvc := snapshot.NewService(client, *cacheTTL)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(
-		collectors.NewGoCollector(),
-		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
-		exporter.NewCollector(snapshotSvc, *orgName, *scrapeTimeout),
-	)
+	listener, err := activationListener(cfg.Server.HTTPDisabled)
+	if err != nil {
+		slog.Error("startup failed", "error", err)
+		return 1
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok\n"))
-	})
-	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, "nvidia-license-server-exporter\nscrape metrics at %s\n", *metricsPath)
-	})
-	handler := loggingMiddleware(recoverMiddleware(mux))
+	reloads := newReloader(ctx, args, logLevel)
+	current, err := newApp(ctx, cfg, reloads.collectors()...)
+	if err != nil {
+		slog.Error("startup failed", "error", err)
+		return 1
+	}
+	reloads.init(current)
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("SIGHUP received, reloading configuration")
+				_ = reloads.Reload()
+				forceRefresh(reloads.current().manager, reloads.current().cfg.CLS.ScrapeTimeout)
+			}
+		}
+	}()
 
-	var otelPusher *otel.MetricsPusher
-	if *otelEnabled {
-		pusher, initErr := otel.NewMetricsPusher(ctx, otel.Config{
-			Enabled:           *otelEnabled,
-			Endpoint:          *otelEndpoint,
-			ServiceName:       *otelSvcName,
-			ServiceInstanceID: *otelSvcID,
-			Insecure:          *otelInsecure,
-			PushInterval:      *otelInterval,
-			RefreshTimeout:    *scrapeTimeout,
-		}, *orgName, snapshotSvc)
-		if initErr != nil {
-			log.Fatalf("failed to initialize otel metrics: %v", initErr)
+	var handler http.Handler = reloads
+	if cfg.Server.RequestTimeout > 0 {
+		handler = http.TimeoutHandler(handler, cfg.Server.RequestTimeout, "request timed out\n")
+		if cfg.Server.RequestTimeout <= cfg.CLS.ScrapeTimeout {
+			slog.Warn("request timeout is not above the scrape timeout, scrapes that miss the cache may fail", "request_timeout", cfg.Server.RequestTimeout, "scrape_timeout", cfg.CLS.ScrapeTimeout)
 		}
-		otelPusher = pusher
-		otelPusher.Start()
-		log.Printf("otel enabled endpoint=%s insecure=%t interval=%s", *otelEndpoint, *otelInsecure, otelInterval.String())
 	}
-
+	handler = reloads.telemetry.instrument(recoverMiddleware(handler), func() config.Server { return reloads.current().cfg.Server })
+	handler = loggingMiddleware(handler, func() *accessLogger { return reloads.current().accessLog })
+	// Outermost, so that the access log records the forwarded client.
+	handler = realIPMiddleware(handler, func() trustedProxies { return reloads.current().proxies })
 	server := &http.Server{
-		Addr:    *listenAddress,
-		Handler: handler,
-		ErrorLog: log.New(os.Stderr, "http-server ", log.LstdFlags|log.LUTC),
+		Addr:         cfg.Server.ListenAddress,
+		Handler:      handler,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ErrorLog:     slog.NewLogLogger(slog.Default().With("component", "http-server").Handler(), slog.LevelError),
+	}
+	hardenServer(server, cfg.Server)
+	if current.serverTLS() != nil {
+		// Per connection, so that certificates follow config reloads.
+		server.TLSConfig = &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return reloads.current().serverTLS(), nil
+			},
+		}
 	}
 
-	log.Printf("starting nvidia-license-server-exporter on %s", *listenAddress)
-	log.Printf("scraping org=%s base_url=%s", *orgName, *baseURL)
-	log.Printf("cache_ttl=%s", cacheTTL.String())
+	current.run()
 
-	serverErr := make(chan error, 1)
-	go func() {
-		serverErr <- server.ListenAndServe()
-	}()
+	switch {
+	case cfg.Server.HTTPDisabled:
+		slog.Info("starting nvidia-license-server-exporter without HTTP listener, push only")
+	case listener != nil:
+		slog.Info("starting nvidia-license-server-exporter on systemd socket", "address", listener.Addr().String(), "tls", server.TLSConfig != nil)
+	default:
+		slog.Info("starting nvidia-license-server-exporter", "address", cfg.Server.ListenAddress, "tls", server.TLSConfig != nil)
+	}
+	build := version.Get()
+	slog.Info("build info", "version", build.Version, "commit", build.Commit, "build_date", build.BuildDate, "go_version", build.GoVersion)
+	slog.Info("configuration loaded", "file", cfg.File, "fingerprint", current.fingerprint)
+	for _, target := range cfg.ScrapeTargets() {
+		slog.Info("scraping", "org", target.OrgName, "endpoint", targetBaseURL(cfg, target), "virtual_groups", target.VirtualGroups)
+	}
+	if cfg.CLS.TotalShards > 1 {
+		slog.Info("sharding virtual groups", "shard", cfg.CLS.Shard, "total_shards", cfg.CLS.TotalShards)
+	}
+	slog.Info("cache configured", "cache_ttl", cfg.Cache.TTL, "max_stale", cfg.Cache.MaxStale, "cache_backend", cfg.Cache.Backend)
+
+	serverErr := make(chan error, 3)
+	var debugServer, healthServer *http.Server
+	healthAddr := strings.TrimSpace(cfg.Server.HealthListenAddress)
+	if healthAddr != "" {
+		healthServer = newHealthServer(healthAddr, reloads)
+		hardenServer(healthServer, cfg.Server)
+		slog.Info("health checks enabled on health listener", "address", healthAddr, "pprof", cfg.Server.EnablePprof && pprofAddress(cfg.Server) == healthAddr)
+		go func() {
+			serverErr <- healthServer.ListenAndServe()
+		}()
+	}
+	if cfg.Server.EnablePprof && pprofAddress(cfg.Server) != "" && pprofAddress(cfg.Server) != healthAddr {
+		debugServer = newDebugServer(cfg.Server.DebugListenAddress)
+		hardenServer(debugServer, cfg.Server)
+		slog.Info("pprof enabled on debug listener", "address", cfg.Server.DebugListenAddress)
+		go func() {
+			serverErr <- debugServer.ListenAndServe()
+		}()
+	}
+	if !cfg.Server.HTTPDisabled {
+		go func() {
+			switch {
+			case listener != nil && server.TLSConfig != nil:
+				serverErr <- server.ServeTLS(listener, "", "")
+			case listener != nil:
+				serverErr <- server.Serve(listener)
+			case server.TLSConfig != nil:
+				serverErr <- server.ListenAndServeTLS("", "")
+			default:
+				serverErr <- server.ListenAndServe()
+			}
+		}()
+	}
+	notifySystemd(systemd.Ready)
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go watchdog(ctx, reloads, interval)
+		slog.Info("systemd watchdog enabled", "interval", interval)
+	}
 
+	code = 0
 	select {
 	case err := <-serverErr:
 		if !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("server failed: %v", err)
+			slog.Error("server failed", "error", err)
+			code = 1
 		}
+		notifySystemd(systemd.Stopping)
 	case <-ctx.Done():
-		log.Printf("shutdown signal received")
+		slog.Info("shutdown signal received")
+		notifySystemd(systemd.Stopping)
+		if delay := cfg.Server.ShutdownDelay; delay > 0 && (!cfg.Server.HTTPDisabled || healthServer != nil) {
+			reloads.drain()
+			slog.Info("draining before shutdown, failing readiness", "duration", delay)
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			select {
+			case <-time.After(delay):
+			case <-sigs:
+				slog.Info("second shutdown signal received, skipping the drain delay")
+			}
+			signal.Stop(sigs)
+		}
 	}
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if otelPusher != nil {
-		if err := otelPusher.Shutdown(shutdownCtx); err != nil {
-			log.Printf("otel shutdown error: %v", err)
+	reloads.close(shutdownCtx)
+	if !cfg.Server.HTTPDisabled {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("http shutdown error", "error", err)
 		}
 	}
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("http shutdown error: %v", err)
+	if debugServer != nil {
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("debug http shutdown error", "error", err)
+		}
+	}
+	if healthServer != nil {
+		if err := healthServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("health http shutdown error", "error", err)
+		}
+	}
+	return code
+}
+
+// activationListener returns the socket passed by systemd socket
+// activation, or nil to listen on -listen-address.
+func activationListener(httpDisabled bool) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+	if httpDisabled {
+		slog.Warn("ignoring systemd sockets, the HTTP listener is disabled", "sockets", len(listeners))
+		closeListeners(listeners)
+		return nil, nil
+	}
+	if len(listeners) > 1 {
+		slog.Warn("more than one systemd socket passed, serving on the first only", "sockets", len(listeners))
+		closeListeners(listeners[1:])
+	}
+	return listeners[0], nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// hardenServer applies the limits that guard every listener against slow or
+// oversized requests. The read and write timeouts only apply to the main
+// listener, as pprof profiles on the debug listener run for as long as asked.
+func hardenServer(server *http.Server, cfg config.Server) {
+	server.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+	server.IdleTimeout = cfg.IdleTimeout
+	server.MaxHeaderBytes = cfg.MaxHeaderBytes
+}
+
+func notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		slog.Warn("systemd notification failed", "state", state, "error", err)
+	}
+}
+
+// watchdog pings the systemd watchdog at half its interval, but only while
+// every target is ready, so that systemd restarts an exporter whose
+// refreshes keep failing. A missed check starts a refresh like /-/ready.
+func watchdog(ctx context.Context, reloads *reloader, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ready, _ := reloads.current().ready.check()
+		if !ready {
+			if healthy {
+				slog.Warn("withholding systemd watchdog pings until every target refreshes successfully")
+			}
+			healthy = false
+			continue
+		}
+		if !healthy {
+			slog.Info("targets ready again, resuming systemd watchdog pings")
+		}
+		healthy = true
+		notifySystemd(systemd.Watchdog)
+	}
+}
+
+// warmUp fetches an initial snapshot per target so the first scrapes after a
+// deploy are served from cache. Failures are logged and do not block startup.
+func warmUp(ctx context.Context, manager *snapshot.Manager, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	for _, result := range manager.RefreshAll(ctx) {
+		org := result.Service.Target()
+		if result.Err != nil {
+			slog.Error("warm-up refresh failed", "org", org, "error", result.Err)
+			continue
+		}
+		slog.Info("warm-up refresh completed", "org", org, "up", result.Meta.Up)
+	}
+	slog.Info("warm-up finished", "duration", time.Since(start))
+}
+
+func forceRefresh(manager *snapshot.Manager, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	slog.Info("forcing snapshot refresh")
+	for _, result := range manager.ForceRefreshAll(ctx) {
+		org := result.Service.Target()
+		if result.Err != nil {
+			slog.Error("forced refresh failed", "org", org, "error", result.Err)
+			continue
+		}
+		slog.Info("forced refresh completed", "org", org, "up", result.Meta.Up, "duration", time.Duration(result.Meta.DurationSeconds*float64(time.Second)))
 	}
 }
 
@@ -165,7 +352,7 @@ func recoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
-				log.Printf("panic recovered method=%s path=%s err=%v", r.Method, r.URL.Path, rec)
+				slog.ErrorContext(r.Context(), "panic recovered", "method", r.Method, "path", r.URL.Path, "error", rec)
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
 		}()
@@ -173,9 +360,19 @@ func recoverMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
+// loggingMiddleware tags every request with a request ID, taken from an
+// incoming X-Request-Id header or generated, which is echoed in the response
+// and added to every log record of the request. The request is then logged
+// by the access logger current when it completes.
+func loggingMiddleware(next http.Handler, accessLog func() *accessLogger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" || len(requestID) > 128 {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", requestID)
+		r = r.WithContext(logging.WithRequestID(r.Context(), requestID))
 		lw := &loggingResponseWriter{
 			ResponseWriter: w,
 			statusCode:     http.StatusOK,
@@ -183,93 +380,23 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(lw, r)
 
-		log.Printf(
-			"http request method=%s path=%s status=%d bytes=%d duration=%s remote=%s user_agent=%q",
-			r.Method,
-			r.URL.Path,
-			lw.statusCode,
-			lw.bytes,
-			time.Since(start).String(),
-			r.RemoteAddr,
-			r.UserAgent(),
-		)
+		accessLog().log(r, lw.statusCode, lw.bytes, start)
 	})
 }
 
-func getenv(key, fallback string) string {
-	value := strings.TrimSpace(os.Getenv(key))
-	if value == "" {
-		return fallback
-	}
-	return value
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
-func intFromEnv(key string, fallback int) int {
-	raw := strings.TrimSpace(os.Getenv(key))
-	if raw == "" {
-		return fallback
-	}
-	var value int
-	_, err := fmt.Sscanf(raw, "%d", &value)
-	if err != nil {
-		return fallback
-	}
-	return value
-}
-
-func boolFromEnv(key string, fallback bool) bool {
-	raw := strings.TrimSpace(os.Getenv(key))
-	if raw == "" {
-		return fallback
-	}
-	value, err := strconv.ParseBool(raw)
-	if err != nil {
-		return fallback
-	}
-	return value
-}
-
-func durationFromEnv(key string, fallback time.Duration) time.Duration {
-	raw := strings.TrimSpace(os.Getenv(key))
-	if raw == "" {
-		return fallback
-	}
-	value, err := time.ParseDuration(raw)
-	if err != nil {
-		return fallback
-	}
-	return value
-}
-
-func hostnameOrUnknown() string {
-	host, err := os.Hostname()
-	if err != nil || strings.TrimSpace(host) == "" {
-		return "unknown"
-	}
-	return host
-}
-
-func firstNonEmpty(values ...string) string {
-	for _, value := range values {
-		if strings.TrimSpace(value) != "" {
-			return value
-		}
-	}
-	return ""
-}
-
-func defaultListenAddress() string {
-	if v := strings.TrimSpace(os.Getenv("LISTEN_ADDRESS")); v != "" {
-		return v
-	}
-
-	port := strings.TrimSpace(os.Getenv("PORT"))
-	if port != "" {
-		if strings.HasPrefix(port, ":") {
-			return port
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(raw string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
 		}
-		return ":" + port
 	}
-
-	return ":9844"
+	return out
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/version"
+)
+
+// scrapeOnce refreshes every target, writes the CLS metrics to opts.output
+// in the Prometheus text format and returns 0 only if every target was
+// scraped. The file is written also after a failed scrape, with
+// nvidia_cls_up 0, so that the failure shows up in Prometheus.
+func scrapeOnce(cfg *config.Config, opts serveOptions) int {
+	switch {
+	case !opts.once:
+		slog.Error("invalid configuration", "error", errors.New("-output requires -once"))
+		return 1
+	case strings.TrimSpace(opts.output) == "":
+		slog.Error("invalid configuration", "error", errors.New("-once requires -output"))
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := newApp(ctx, cfg)
+	if err != nil {
+		slog.Error("startup failed", "error", err)
+		return 1
+	}
+	defer a.close(context.Background())
+
+	code := 0
+	refreshCtx, refreshCancel := context.WithTimeout(ctx, cfg.CLS.ScrapeTimeout)
+	for _, result := range a.manager.RefreshAll(refreshCtx) {
+		if result.Err != nil {
+			slog.Error("scrape failed", "org", result.Service.Target(), "error", result.Err)
+			code = 1
+		}
+	}
+	refreshCancel()
+
+	// Only the CLS and build metrics: the Go and process metrics of a
+	// short-lived process mean nothing and would clash with
+	// node_exporter's own.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.NewCollector(a.manager, cfg.CLS.ScrapeTimeout), version.NewCollector())
+	if err := writeFileAtomic(opts.output, func(w io.Writer) error { return writeTextfile(w, registry) }); err != nil {
+		slog.Error("writing metrics failed", "file", opts.output, "error", err)
+		return 1
+	}
+	slog.Info("metrics written", "file", opts.output, "success", code == 0)
+	return code
+}
+
+func writeTextfile(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
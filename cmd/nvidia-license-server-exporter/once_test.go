@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeOnceWritesTextfile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	srv := newFakeCLS(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nvidia_cls.prom")
+
+	var out bytes.Buffer
+	code := run([]string{"-once", "-output", path, "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "org-1", "-nvidia-api-key", "key"}, &out)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read textfile: %v", err)
+	}
+	for _, want := range []string{`nvidia_cls_up{org_name="org-1"} 1`, "nvidia_cls_exporter_build_info{"} {
+		if !strings.Contains(string(raw), want) {
+			t.Fatalf("textfile lacks %q:\n%s", want, raw)
+		}
+	}
+	if strings.Contains(string(raw), "go_goroutines") {
+		t.Fatalf("textfile must not carry Go runtime metrics:\n%s", raw)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected only the textfile in %s, got %v", dir, entries)
+	}
+
+	code = run([]string{"serve", "-once", "-output", path, "-nvidia-api-base-url", srv.URL, "-nvidia-org-name", "expired", "-nvidia-api-key", "key"}, &out)
+	if code != 1 {
+		t.Fatalf("expected exit code 1 for a failed scrape, got %d", code)
+	}
+	if raw, _ := os.ReadFile(path); !strings.Contains(string(raw), `nvidia_cls_up{org_name="expired"} 0`) {
+		t.Fatalf("expected the failed scrape in the textfile:\n%s", raw)
+	}
+
+	if code := run([]string{"-output", path, "-nvidia-org-name", "org-1", "-nvidia-api-key", "key"}, &out); code != 1 {
+		t.Fatalf("-output without -once: expected exit code 1, got %d", code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies holds the networks of -trusted-proxies. A nil list trusts
+// no proxy.
+type trustedProxies []netip.Prefix
+
+// clientAddr returns the client of r: the TCP peer or, when the peer is a
+// trusted proxy, the nearest hop of its Forwarded or X-Forwarded-For header
+// that is not a trusted proxy itself. Hops are read from the right, as only
+// the entries appended by trusted proxies can be believed; clients may send
+// whatever headers they like.
+func (t trustedProxies) clientAddr(r *http.Request) (netip.Addr, bool) {
+	addr, ok := remoteAddr(r)
+	if !ok || !containsAddr(t, addr) {
+		return addr, ok
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			// "unknown" or an obfuscated identifier: the client is
+			// not known beyond the last hop.
+			break
+		}
+		addr = hop
+		if !containsAddr(t, hop) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// forwardedFor returns the hops named by the RFC 7239 Forwarded header or,
+// without one, by X-Forwarded-For, from the client to the last proxy.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+		return hops
+	}
+	for _, hop := range strings.Split(strings.Join(h.Values("X-Forwarded-For"), ","), ",") {
+		if hop = strings.TrimSpace(hop); hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop parses a hop such as 192.0.2.1, 192.0.2.1:4711, 2001:db8::1 or
+// "[2001:db8::1]:4711", ignoring the port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// realIPMiddleware replaces the RemoteAddr of requests from trusted proxies
+// by the client they forward for, without a port, so that the access log,
+// the allowlist and the rate limiter see the client rather than the proxy.
+// proxies returns the list of the current configuration.
+func realIPMiddleware(next http.Handler, proxies func() trustedProxies) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if trusted := proxies(); len(trusted) > 0 {
+			peer, _ := remoteAddr(r)
+			if addr, ok := trusted.clientAddr(r); ok && addr != peer {
+				// Handlers must not modify their request, so change a
+				// shallow copy, as WithContext does.
+				forwarded := *r
+				forwarded.RemoteAddr = addr.String()
+				r = &forwarded
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientAddr(t *testing.T) {
+	prefixes, err := parsePrefixes("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	proxies := trustedProxies(prefixes)
+	for _, tt := range []struct {
+		name, remote string
+		header       http.Header
+		want         string
+	}{
+		{"untrusted peer", "203.0.113.9:5555", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.9"},
+		{"no header", "10.0.0.1:5555", nil, "10.0.0.1"},
+		{"x-forwarded-for", "10.0.0.1:5555", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed leftmost hop", "10.0.0.1:5555", http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"several header lines", "10.0.0.1:5555", http.Header{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}}, "198.51.100.1"},
+		{"forwarded", "10.0.0.1:5555", http.Header{"Forwarded": {`for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`}}, "2001:db8::1"},
+		{"forwarded wins", "10.0.0.1:5555", http.Header{"Forwarded": {"For=198.51.100.1:80"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.1"},
+		{"unknown hop", "10.0.0.1:5555", http.Header{"Forwarded": {"for=198.51.100.1, for=unknown"}}, "10.0.0.1"},
+		{"only proxies", "10.0.0.1:5555", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tt.remote
+		for key, values := range tt.header {
+			req.Header[key] = values
+		}
+		if got, ok := proxies.clientAddr(req); !ok || got.String() != tt.want {
+			t.Errorf("%s: clientAddr = %s, %v; want %s", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	prefixes, err := parsePrefixes("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	allow, err := parseAllowlist("198.51.100.0/24")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var seen string
+	handler := realIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.RemoteAddr
+		if !allow.allows(r) {
+			allow.forbid(w, r)
+		}
+	}), func() trustedProxies { return prefixes })
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "198.51.100.7" || rec.Code != http.StatusOK || req.RemoteAddr != "10.0.0.1:5555" {
+		t.Fatalf("forwarded client: saw %q, status %d, original %q", seen, rec.Code, req.RemoteAddr)
+	}
+
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "203.0.113.1" || rec.Code != http.StatusForbidden {
+		t.Fatalf("forwarded client outside the allowlist: saw %q, status %d", seen, rec.Code)
+	}
+}
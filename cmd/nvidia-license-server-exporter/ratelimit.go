@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// clientIdleTimeout is how long a client's bucket is kept after its last
+// request. A full bucket is the same as a new one, so evicting it is safe.
+const clientIdleTimeout = 10 * time.Minute
+
+// rateLimiter is a token bucket per client address plus one shared by all
+// clients, applied to the metrics path and the JSON API. Either limit may
+// be zero to disable it.
+type rateLimiter struct {
+	perClient rate.Limit
+	burst     int
+	global    *rate.Limiter
+
+	mu        sync.Mutex
+	clients   map[netip.Addr]*clientBucket
+	lastSweep time.Time
+
+	rejected *prometheus.CounterVec
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns nil when both limits are zero. burst applies to
+// both buckets and is raised to at least 1.
+func newRateLimiter(perClient, global float64, burst int) *rateLimiter {
+	if perClient <= 0 && global <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	l := &rateLimiter{
+		burst:   burst,
+		clients: make(map[netip.Addr]*clientBucket),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_http_requests_rate_limited_total",
+			Help: "HTTP requests rejected with 429 by the rate limiter, by the limit that was exceeded.",
+		}, []string{"limit"}),
+	}
+	if perClient > 0 {
+		l.perClient = rate.Limit(perClient)
+	}
+	if global > 0 {
+		l.global = rate.NewLimiter(rate.Limit(global), burst)
+	}
+	return l
+}
+
+func (l *rateLimiter) collector() prometheus.Collector {
+	return l.rejected
+}
+
+// wrap limits requests for metricsPath and /api/ and passes everything
+// else, such as health checks, straight to next.
+func (l *rateLimiter) wrap(metricsPath string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != metricsPath && !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limit, delay := l.reserve(r, time.Now()); limit != "" {
+			l.rejected.WithLabelValues(limit).Inc()
+			slog.DebugContext(r.Context(), "request rate limited", "limit", limit, "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reserve takes a token for r and returns "" if it may be served, or else
+// the exceeded limit, "client" or "global", and when to retry. The client
+// bucket is checked first, so one noisy client does not drain the global
+// one for everybody else.
+func (l *rateLimiter) reserve(r *http.Request, now time.Time) (string, time.Duration) {
+	if l.perClient > 0 {
+		if ok, delay := take(l.client(r, now), now); !ok {
+			return "client", delay
+		}
+	}
+	if l.global != nil {
+		if ok, delay := take(l.global, now); !ok {
+			return "global", delay
+		}
+	}
+	return "", 0
+}
+
+func take(limiter *rate.Limiter, now time.Time) (bool, time.Duration) {
+	reservation := limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+func (l *rateLimiter) client(r *http.Request, now time.Time) *rate.Limiter {
+	// Unparsable addresses share the bucket of the zero Addr.
+	addr, _ := remoteAddr(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > clientIdleTimeout {
+		for a, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > clientIdleTimeout {
+				delete(l.clients, a)
+			}
+		}
+		l.lastSweep = now
+	}
+	bucket, ok := l.clients[addr]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(l.perClient, l.burst)}
+		l.clients[addr] = bucket
+	}
+	bucket.lastSeen = now
+	return bucket.limiter
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(0.001, 0.001, 2)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := limiter.wrap("/metrics", ok)
+	get := func(remote, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		if rec := get("10.0.0.1:1000", "/metrics"); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 within the burst, got %d", rec.Code)
+		}
+	}
+	rec := get("10.0.0.1:1001", "/api/v1/diff")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After over the client limit, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := get("10.0.0.1:1002", "/-/ready"); rec.Code != http.StatusOK {
+		t.Fatalf("health checks must not be limited, got %d", rec.Code)
+	}
+	// Another client has its own bucket, but the global one is spent.
+	if rec := get("10.0.0.2:1000", "/metrics"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the global limit, got %d", rec.Code)
+	}
+
+	if newRateLimiter(0, 0, 10) != nil {
+		t.Fatal("expected no limiter without limits")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/logging"
+)
+
+// reloader rebuilds the app from the configuration on SIGHUP or
+// POST /-/reload and swaps it in without closing the HTTP listener. It serves
+// HTTP requests with whichever app is current.
+type reloader struct {
+	ctx      context.Context
+	args     []string
+	logLevel *slog.LevelVar
+
+	mu  sync.Mutex
+	app atomic.Pointer[app]
+	// draining fails readiness during the shutdown delay.
+	draining atomic.Bool
+
+	telemetry *telemetry
+}
+
+// newReloader returns a reloader that also applies log level changes to
+// logLevel. The log format is fixed at startup.
+func newReloader(ctx context.Context, args []string, logLevel *slog.LevelVar) *reloader {
+	return &reloader{
+		ctx:       ctx,
+		args:      args,
+		logLevel:  logLevel,
+		telemetry: newTelemetry(),
+	}
+}
+
+// collectors returns the self-telemetry metrics, which every app registers
+// so they survive reloads.
+func (r *reloader) collectors() []prometheus.Collector {
+	return r.telemetry.collectors()
+}
+
+// init installs the app built at startup, which counts as a successful load.
+func (r *reloader) init(a *app) {
+	r.app.Store(a)
+	r.telemetry.reloadSuccessful.Set(1)
+	r.telemetry.reloadSuccess.SetToCurrentTime()
+}
+
+func (r *reloader) current() *app {
+	return r.app.Load()
+}
+
+// Reload loads the configuration again from the same flags, environment and
+// file, builds a new app and replaces the current one. On failure the
+// current app keeps running unchanged.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.app.Load()
+	next, err := r.build(old)
+	if err != nil {
+		r.telemetry.reloadSuccessful.Set(0)
+		slog.Error("config reload failed", "error", err)
+		return err
+	}
+	next.run()
+	r.app.Store(next)
+	level, _ := logging.ParseLevel(next.cfg.Log.Level) // validated by build
+	r.logLevel.Set(level)
+	r.telemetry.reloadSuccessful.Set(1)
+	r.telemetry.reloadSuccess.SetToCurrentTime()
+	slog.Info("config reload completed", "file", next.cfg.File, "fingerprint", next.fingerprint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	old.close(ctx)
+	return nil
+}
+
+func (r *reloader) build(old *app) (*app, error) {
+	cfg, err := config.LoadWith(r.args, new(serveOptions).register)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range cfg.Secrets() {
+		logging.AddSecret(secret)
+	}
+	if err := resolveAPIKeySecret(r.ctx, cfg); err != nil {
+		return nil, err
+	}
+	// The listener is not rebuilt. Its TLS certificates are looked up per
+	// connection, so they do follow reloads.
+	if cfg.Server.ListenAddress != old.cfg.Server.ListenAddress {
+		return nil, fmt.Errorf("changing the listen address requires a restart")
+	}
+	if cfg.Server.HTTPDisabled != old.cfg.Server.HTTPDisabled {
+		return nil, fmt.Errorf("changing http_disabled requires a restart")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(cfg.Log.Format), strings.TrimSpace(old.cfg.Log.Format)) {
+		return nil, fmt.Errorf("changing the log format requires a restart")
+	}
+	if cfg.Server.EnablePprof != old.cfg.Server.EnablePprof || cfg.Server.DebugListenAddress != old.cfg.Server.DebugListenAddress {
+		return nil, fmt.Errorf("changing enable_pprof or debug_listen_address requires a restart")
+	}
+	if cfg.Server.HealthListenAddress != old.cfg.Server.HealthListenAddress {
+		return nil, fmt.Errorf("changing health_listen_address requires a restart")
+	}
+	if cfg.Server.ReadTimeout != old.cfg.Server.ReadTimeout || cfg.Server.WriteTimeout != old.cfg.Server.WriteTimeout || cfg.Server.RequestTimeout != old.cfg.Server.RequestTimeout {
+		return nil, fmt.Errorf("changing read_timeout, write_timeout or request_timeout requires a restart")
+	}
+	if cfg.Server.ReadHeaderTimeout != old.cfg.Server.ReadHeaderTimeout || cfg.Server.IdleTimeout != old.cfg.Server.IdleTimeout || cfg.Server.MaxHeaderBytes != old.cfg.Server.MaxHeaderBytes {
+		return nil, fmt.Errorf("changing read_header_timeout, idle_timeout or max_header_bytes requires a restart")
+	}
+	next, err := newApp(r.ctx, cfg, r.collectors()...)
+	if err != nil {
+		return nil, err
+	}
+	if (next.serverTLS() == nil) != (old.serverTLS() == nil) {
+		next.close(context.Background())
+		return nil, fmt.Errorf("switching between HTTP and HTTPS requires a restart")
+	}
+	return next, nil
+}
+
+func (r *reloader) close(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.app.Load().close(ctx)
+}
+
+// drain makes /-/ready fail from now on, so that load balancers and
+// Kubernetes stop sending requests before the listener closes.
+func (r *reloader) drain() {
+	r.draining.Store(true)
+}
+
+// serveDraining answers /-/ready while draining and reports whether it did.
+func (r *reloader) serveDraining(w http.ResponseWriter, req *http.Request) bool {
+	if req.URL.Path != "/-/ready" || !r.draining.Load() {
+		return false
+	}
+	writeJSON(w, http.StatusServiceUnavailable, healthResult{Status: "shutting down"})
+	return true
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.serveDraining(w, req) {
+		return
+	}
+	current := r.app.Load()
+	if !current.allow.allows(req) {
+		current.allow.forbid(w, req)
+		return
+	}
+	if req.URL.Path == "/-/reload" {
+		if token := strings.TrimSpace(current.cfg.Server.AdminToken); token != "" {
+			requireAdminToken(token, reloadHandler(r)).ServeHTTP(w, req)
+			return
+		}
+	}
+	current.handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nvidia-license-server-exporter/internal/config"
+)
+
+func TestReloaderSwapsApp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	write("server:\n  admin_token: secret\ncls:\n  base_url: http://127.0.0.1:1\n  org_name: org-1\n  api_key: key\n")
+	args := []string{"-config", path}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	reloads := newReloader(context.Background(), args, new(slog.LevelVar))
+	initial, err := newApp(context.Background(), cfg, reloads.collectors()...)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	reloads.init(initial)
+	defer reloads.close(context.Background())
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		reloads.ServeHTTP(rec, req)
+		return rec
+	}
+
+	write("server:\n  admin_token: secret\n  metrics_path: /m\ncls:\n  base_url: http://127.0.0.1:1\n  org_name: org-1\n  api_key: key\n")
+	if rec := serve(http.MethodPost, "/-/reload"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if reloads.current() == initial || reloads.current().cfg.Server.MetricsPath != "/m" {
+		t.Fatalf("expected reloaded app")
+	}
+
+	write("server:\n  listen_address: :1\ncls:\n  base_url: http://127.0.0.1:1\n  org_name: org-1\n  api_key: key\n")
+	if rec := serve(http.MethodPost, "/-/reload"); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "restart") {
+		t.Fatalf("expected failed reload, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := serve(http.MethodGet, "/m")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "nvidia_cls_exporter_config_last_reload_successful 0") {
+		t.Fatalf("expected previous app to keep serving with failed reload metric, got %d:\n%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// telemetry holds the metrics the exporter keeps about itself rather than
+// about licenses: the HTTP server and configuration reloads here, cache
+// lookups per app, and the push backends' own counters. All are named
+// nvidia_cls_exporter_*, so that self-health dashboards and alerts select
+// them apart from the license data. serve creates it once and every app
+// registers it, so the counters survive reloads.
+type telemetry struct {
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	httpInflight prometheus.Gauge
+
+	reloadSuccessful prometheus.Gauge
+	reloadSuccess    prometheus.Gauge
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_http_requests_total",
+			Help: "HTTP requests served on the main listener, by handler, method and status code.",
+		}, []string{"handler", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nvidia_cls_exporter_http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests on the main listener, by handler.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"handler"}),
+		httpInflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_http_requests_in_flight",
+			Help: "HTTP requests being served on the main listener.",
+		}),
+		reloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		}),
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload.",
+		}),
+	}
+}
+
+func (t *telemetry) collectors() []prometheus.Collector {
+	return []prometheus.Collector{t.httpRequests, t.httpDuration, t.httpInflight, t.reloadSuccessful, t.reloadSuccess}
+}
+
+// instrument records the requests served by next. server returns the
+// server settings of the current configuration, which decide the handler
+// label.
+func (t *telemetry) instrument(next http.Handler, server func() config.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		t.httpInflight.Inc()
+		defer t.httpInflight.Dec()
+		lw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(lw, r)
+
+		handler := handlerLabel(server(), r.URL.Path)
+		t.httpRequests.WithLabelValues(handler, methodLabel(r.Method), strconv.Itoa(lw.statusCode)).Inc()
+		t.httpDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+	})
+}
+
+// handlerLabel maps path to the endpoint serving it, and every unknown path
+// to "other", so that scanners cannot create series at will.
+func handlerLabel(s config.Server, path string) string {
+	switch {
+	case path == s.MetricsPath, isHealthCheck(path):
+		return path
+	case path == "/", path == "/-/refresh", path == "/-/reload", path == "/-/otel/flush", path == "/api/v1/diff", path == "/api/v1/targets", path == "/api/v1/snapshot", path == "/api/v1/usage", path == "/api/v1/forecast", path == "/api/v1/report", path == dashboardPath:
+		return path
+	case strings.HasPrefix(path, "/debug/pprof/"):
+		return "/debug/pprof/"
+	}
+	return "other"
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// cacheCollectors reports the cache lookups of every target of manager.
+func cacheCollectors(manager *snapshot.Manager) []prometheus.Collector {
+	var out []prometheus.Collector
+	for _, svc := range manager.Services() {
+		for _, result := range []string{"hit", "miss"} {
+			out = append(out, prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "nvidia_cls_exporter_cache_requests_total",
+				Help:        "Snapshot lookups by scrapes and pushes, by whether the cache answered them (hit) or they refreshed (miss). Reset by reloads.",
+				ConstLabels: prometheus.Labels{"org_name": svc.Target(), "result": result},
+			}, func() float64 {
+				hits, misses := svc.CacheLookups()
+				if result == "hit" {
+					return float64(hits)
+				}
+				return float64(misses)
+			}))
+		}
+	}
+	return out
+}
+
+// deprecationCollectors reports every deprecated flag and environment
+// variable set in the configuration, so that exporters still relying on
+// them can be found before the old names are removed.
+func deprecationCollectors(deprecations []config.Deprecation) []prometheus.Collector {
+	out := make([]prometheus.Collector, 0, len(deprecations))
+	for _, d := range deprecations {
+		out = append(out, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "nvidia_cls_exporter_deprecated_settings",
+			Help:        "Deprecated flags and environment variables set in the configuration, always 1, by name and the name replacing it.",
+			ConstLabels: prometheus.Labels{"name": d.Name, "replacement": d.Replacement},
+		}, func() float64 { return 1 }))
+	}
+	return out
+}
+
+// configInfo reports the fingerprint of the configuration, so that
+// replicas running divergent configurations stand out, e.g. with
+// count by (fingerprint) (nvidia_cls_exporter_config_info).
+func configInfo(fingerprint string) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "nvidia_cls_exporter_config_info",
+		Help:        "Fingerprint of the effective configuration, always 1; see config.Config.Fingerprint.",
+		ConstLabels: prometheus.Labels{"fingerprint": fingerprint},
+	}, func() float64 { return 1 })
+}
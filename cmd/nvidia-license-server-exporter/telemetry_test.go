@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"nvidia-license-server-exporter/internal/config"
+)
+
+func TestTelemetryInstrument(t *testing.T) {
+	tel := newTelemetry()
+	server := config.Default().Server
+	handler := tel.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+		}
+	}), func() config.Server { return server })
+
+	for _, path := range []string{"/metrics", "/metrics", "/wp-login.php", "/.env"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/metrics", nil))
+
+	for _, tt := range []struct {
+		labels []string
+		want   float64
+	}{
+		{[]string{"/metrics", "GET", "200"}, 2},
+		{[]string{"other", "GET", "404"}, 2},
+		{[]string{"/metrics", "other", "200"}, 1},
+	} {
+		if got := testutil.ToFloat64(tel.httpRequests.WithLabelValues(tt.labels...)); got != tt.want {
+			t.Errorf("requests%v = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+	if got := testutil.CollectAndCount(tel.httpDuration); got != 2 {
+		t.Errorf("expected duration histograms for 2 handlers, got %d", got)
+	}
+	if got := testutil.ToFloat64(tel.httpInflight); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+}
+
+func TestSelfTelemetryPrefix(t *testing.T) {
+	cfg := config.Default()
+	cfg.CLS.BaseURL = "http://127.0.0.1:1"
+	cfg.CLS.OrgName = "org-1"
+	cfg.CLS.APIKey = "test-key"
+	cfg.Deprecations = []config.Deprecation{{Name: "NLS_API_KEY", Replacement: "NVIDIA_API_KEY"}}
+	reloads := newReloader(context.Background(), nil, nil)
+	a, err := newApp(context.Background(), cfg, reloads.collectors()...)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	defer a.close(context.Background())
+
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, name := range []string{
+		"nvidia_cls_exporter_cache_requests_total{org_name=\"org-1\",result=\"miss\"} 1",
+		"nvidia_cls_exporter_config_last_reload_successful",
+		"nvidia_cls_exporter_http_requests_in_flight",
+		"nvidia_cls_exporter_build_info",
+		"nvidia_cls_exporter_config_info{fingerprint=\"" + a.fingerprint + "\"} 1",
+		"nvidia_cls_exporter_deprecated_settings{name=\"NLS_API_KEY\",replacement=\"NVIDIA_API_KEY\"} 1",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("metrics lack %s", name)
+		}
+	}
+}
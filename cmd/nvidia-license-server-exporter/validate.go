@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/alerts"
+	"nvidia-license-server-exporter/internal/config"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// validateOnly is serve -validate: a dry run for CI jobs that gate
+// configuration changes. setup has already parsed the file, flags and
+// environment, validated the result and resolved the secrets; this also
+// loads the web and alerts config files and, with -validate-auth, checks every API key
+// against CLS. It then writes the effective configuration, with secrets
+// redacted, to stdout and exits 0 only if nothing failed. Nothing is
+// started, and neither Redis nor Kubernetes is contacted.
+func validateOnly(cfg *config.Config, opts serveOptions, stdout io.Writer) int {
+	switch {
+	case opts.validateAuth && !opts.validate:
+		slog.Error("invalid configuration", "error", errors.New("-validate-auth requires -validate"))
+		return 1
+	case opts.once:
+		slog.Error("invalid configuration", "error", errors.New("-validate and -once are mutually exclusive"))
+		return 1
+	}
+	for _, d := range cfg.Deprecations {
+		slog.Warn("deprecated setting in use", "name", d.Name, "replacement", d.Replacement)
+	}
+	if err := requireCredentials(cfg); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+	if strings.TrimSpace(cfg.Server.WebConfigFile) != "" {
+		if _, err := web.LoadConfig(cfg.Server.WebConfigFile); err != nil {
+			slog.Error("invalid configuration", "error", err)
+			return 1
+		}
+	}
+	if strings.TrimSpace(cfg.Alerts.ConfigFile) != "" {
+		if _, err := alerts.LoadConfig(cfg.Alerts.ConfigFile); err != nil {
+			slog.Error("invalid configuration", "error", err)
+			return 1
+		}
+	}
+
+	code := 0
+	if opts.validateAuth {
+		// The report goes to stderr, keeping stdout to the configuration.
+		for i, target := range cfg.ScrapeTargets() {
+			if i > 0 {
+				fmt.Fprintln(os.Stderr)
+			}
+			if err := checkTarget(cfg, target, os.Stderr); err != nil {
+				code = 1
+			}
+		}
+	}
+
+	raw, err := cfg.RedactedYAML()
+	if err != nil {
+		slog.Error("rendering the configuration failed", "error", err)
+		return 1
+	}
+	if _, err := stdout.Write(raw); err != nil {
+		slog.Error("writing the configuration failed", "error", err)
+		return 1
+	}
+	return code
+}
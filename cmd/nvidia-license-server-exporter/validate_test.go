@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestServeValidate(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	srv := newFakeCLS(t)
+	args := func(org string, flags ...string) []string {
+		return append([]string{"-nvidia-api-base-url", srv.URL, "-nvidia-org-name", org, "-nvidia-api-key", "very-secret-key"}, flags...)
+	}
+
+	var out bytes.Buffer
+	if code := run(args("org-1", "-validate"), &out); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(out.String(), "org_name: org-1") || !strings.Contains(out.String(), "api_key: '[REDACTED]'") {
+		t.Fatalf("expected the redacted configuration, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "very-secret-key") {
+		t.Fatalf("configuration leaks the API key:\n%s", out.String())
+	}
+
+	out.Reset()
+	if code := run(args("org-1", "-validate", "-validate-auth"), &out); code != 0 {
+		t.Fatalf("valid key: expected exit code 0, got %d", code)
+	}
+	if code := run(args("expired", "-validate", "-validate-auth"), &out); code != 1 {
+		t.Fatalf("rejected key: expected exit code 1, got %d", code)
+	}
+	if code := run(args("org-1", "-validate-auth"), &out); code != 1 {
+		t.Fatalf("-validate-auth without -validate: expected exit code 1, got %d", code)
+	}
+	if code := run(args("org-1", "-validate", "-cache-ttl", "-1m"), &out); code != 1 {
+		t.Fatalf("invalid configuration: expected exit code 1, got %d", code)
+	}
+}
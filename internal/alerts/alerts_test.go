@@ -0,0 +1,679 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/anomaly"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "alerts.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+rules:
+  - name: FeatureNearlyExhausted
+    type: feature_utilization
+    threshold: 0.9
+  - name: ServerNotEnabled
+    type: server_status
+    severity: critical
+webhooks:
+  - name: ops
+    url: https://hooks.example.com/cls?token=hook-secret
+    headers:
+      Authorization: Bearer header-secret
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.Rules[0].Severity; got != SeverityWarning {
+		t.Errorf("default severity %q, want %q", got, SeverityWarning)
+	}
+	if got := cfg.Rules[1].Status; got != "ENABLED" {
+		t.Errorf("default status %q, want ENABLED", got)
+	}
+	if got := cfg.Webhooks[0].Timeout; got != defaultTimeout {
+		t.Errorf("default timeout %s, want %s", got, defaultTimeout)
+	}
+	secrets := strings.Join(cfg.Secrets(), " ")
+	for _, want := range []string{"hook-secret", "header-secret"} {
+		if !strings.Contains(secrets, want) {
+			t.Errorf("secrets %q miss %q", secrets, want)
+		}
+	}
+
+	for name, tc := range map[string]struct{ config, want string }{
+		"expiry": {
+			"rules: [{name: a, type: entitlement_expiry}]\nwebhooks: [{name: w, url: http://localhost}]",
+			"entitlement end dates are not fetched",
+		},
+		"threshold": {
+			"rules: [{name: a, type: feature_utilization}]\nwebhooks: [{name: w, url: http://localhost}]",
+			"threshold must be positive",
+		},
+		"duplicate": {
+			"rules: [{name: a, type: server_status}, {name: a, type: server_status}]\nwebhooks: [{name: w, url: http://localhost}]",
+			"name is used twice",
+		},
+		"url": {
+			"rules: [{name: a, type: server_status}]\nwebhooks: [{name: w, url: localhost}]",
+			"url must be an http:// or https:// URL",
+		},
+		"template": {
+			"rules: [{name: a, type: server_status}]\nwebhooks: [{name: w, url: http://localhost, body: '{{ .Rule'}]",
+			"invalid body template",
+		},
+		"no webhooks": {
+			"rules: [{name: a, type: server_status}]",
+			"no webhooks",
+		},
+		"direction": {
+			"rules: [{name: a, type: lease_anomaly, direction: up}]\nwebhooks: [{name: w, url: http://localhost}]",
+			"unsupported direction",
+		},
+		"unknown key": {
+			"rules: [{name: a, type: server_status, treshold: 1}]\nwebhooks: [{name: w, url: http://localhost}]",
+			"treshold",
+		},
+	} {
+		if _, err := LoadConfig(writeConfig(t, tc.config)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error with %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func testSnapshot(inUse float64, status string) *cls.Snapshot {
+	return &cls.Snapshot{
+		CollectedAt: time.Now(),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 10, InUseQuantity: inUse},
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "vPC", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 0},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-1", ServerName: "dls-1", ServerStatus: status, Allocated: 10, InUse: inUse},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Name: "FeatureNearlyExhausted", Type: TypeFeatureUtilization, Threshold: 0.9},
+			{Name: "ServerBusy", Type: TypeServerUtilization, Threshold: 1},
+			{Name: "ServerNotEnabled", Type: TypeServerStatus, Severity: SeverityCritical},
+		},
+		Webhooks: []Webhook{{Name: "ops", URL: "http://localhost"}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	manager, err := snapshot.NewManager(snapshot.NewService(nil, snapshot.Config{Target: "org-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(cfg, manager, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	changes := e.Evaluate("org-1", testSnapshot(9, "DISABLED"), start)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 alerts to start firing, got %+v", changes)
+	}
+	feature := changes[0]
+	if feature.Rule != "FeatureNearlyExhausted" || feature.Status != StatusFiring || feature.Value != 0.9 ||
+		feature.Labels["feature_name"] != "vWS" || !feature.StartsAt.Equal(start) ||
+		feature.Summary != "vWS licenses of virtual group VG in org org-1 are 90% in use (9 of 10)" {
+		t.Errorf("unexpected feature alert: %+v", feature)
+	}
+	if server := changes[1]; server.Rule != "ServerNotEnabled" || server.Severity != SeverityCritical || server.Labels["server_id"] != "srv-1" {
+		t.Errorf("unexpected server alert: %+v", server)
+	}
+
+	// Alerts that keep firing are not sent again, and other orgs are
+	// evaluated on their own.
+	if changes := e.Evaluate("org-1", testSnapshot(10, "DISABLED"), start.Add(time.Minute)); len(changes) != 1 || changes[0].Rule != "ServerBusy" {
+		t.Fatalf("expected only ServerBusy to start firing, got %+v", changes)
+	}
+	if changes := e.Evaluate("org-2", testSnapshot(0, "ENABLED"), start.Add(time.Minute)); len(changes) != 0 {
+		t.Fatalf("another org resolved alerts: %+v", changes)
+	}
+
+	end := start.Add(2 * time.Minute)
+	changes = e.Evaluate("org-1", testSnapshot(1, "ENABLED"), end)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 alerts to resolve, got %+v", changes)
+	}
+	for _, a := range changes {
+		if a.Status != StatusResolved || !a.EndsAt.Equal(end) || a.StartsAt.IsZero() {
+			t.Errorf("unexpected resolved alert: %+v", a)
+		}
+	}
+	if changes[0].Rule != "FeatureNearlyExhausted" || changes[0].Value != 1 || !changes[0].StartsAt.Equal(start) {
+		t.Errorf("resolved alert lost its last value or start: %+v", changes[0])
+	}
+}
+
+func TestEvaluateLeaseAnomaly(t *testing.T) {
+	cfg := &Config{
+		Rules:    []Rule{{Name: "LeasesDropped", Type: TypeLeaseAnomaly, Direction: anomaly.DirectionDrop, Severity: SeverityCritical}},
+		Webhooks: []Webhook{{Name: "ops", URL: "http://localhost"}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	manager, err := snapshot.NewManager(snapshot.NewService(nil, snapshot.Config{Target: "org-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEngine(cfg, manager, nil, nil); err == nil || !strings.Contains(err.Error(), "requires the anomaly detector") {
+		t.Fatalf("expected an error without a detector, got %v", err)
+	}
+	detector := anomaly.NewDetector(anomaly.Config{Alpha: 0.1, Threshold: 3, Warmup: 5, MinDeviation: 5}, manager)
+	e, err := NewEngine(cfg, manager, nil, detector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	evaluate := func(i int, leases float64) []Alert {
+		at := start.Add(time.Duration(i) * time.Minute)
+		return e.Evaluate("org-1", &cls.Snapshot{CollectedAt: at, ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-1", ServerName: "dls-1", ActiveLeases: leases},
+		}}, at)
+	}
+	for i := range 10 {
+		if changes := evaluate(i, 40); len(changes) != 0 {
+			t.Fatalf("refresh %d: unexpected alerts %+v", i, changes)
+		}
+	}
+	// A spike does not match the rule.
+	if changes := evaluate(10, 80); len(changes) != 0 {
+		t.Fatalf("a spike fired the drop rule: %+v", changes)
+	}
+	changes := evaluate(11, 0)
+	if len(changes) != 1 {
+		t.Fatalf("expected the drop to fire, got %+v", changes)
+	}
+	if a := changes[0]; a.Rule != "LeasesDropped" || a.Value != 0 || a.Labels["server_id"] != "srv-1" || a.Labels["direction"] != anomaly.DirectionDrop ||
+		!strings.HasPrefix(a.Summary, "Active leases of license server dls-1 in org org-1 dropped to 0, from about") {
+		t.Errorf("unexpected alert %+v", a)
+	}
+}
+
+type sequenceFetcher struct {
+	mu    sync.Mutex
+	snaps []*cls.Snapshot
+}
+
+func (f *sequenceFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snap := f.snaps[0]
+	if len(f.snaps) > 1 {
+		f.snaps = f.snaps[1:]
+	}
+	return snap, nil
+}
+
+func TestEngineSendsWebhooks(t *testing.T) {
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hook-token" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	first, second := testSnapshot(10, "ENABLED"), testSnapshot(1, "ENABLED")
+	second.CollectedAt = first.CollectedAt.Add(time.Second)
+	svc := snapshot.NewService(&sequenceFetcher{snaps: []*cls.Snapshot{first, second}}, snapshot.Config{Target: "org-1", CacheTTL: time.Hour})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Rules: []Rule{{Name: "FeatureExhausted", Type: TypeFeatureUtilization, Threshold: 1}},
+		Webhooks: []Webhook{{
+			Name:    "chat",
+			URL:     srv.URL,
+			Headers: map[string]string{"Authorization": "Bearer hook-token"},
+			Body:    `{"text": {{ json .Summary }}, "status": {{ json .Status }}}`,
+		}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(cfg, manager, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Start()
+	t.Cleanup(func() { _ = e.Shutdown(context.Background()) })
+
+	next := func() map[string]string {
+		t.Helper()
+		select {
+		case body := <-received:
+			var payload map[string]string
+			if err := json.Unmarshal([]byte(body), &payload); err != nil {
+				t.Fatalf("decode %s: %v", body, err)
+			}
+			return payload
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook received")
+			return nil
+		}
+	}
+
+	if _, _, err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got["status"] != StatusFiring || got["text"] != "vWS licenses of virtual group VG in org org-1 are 100% in use (10 of 10)" {
+		t.Errorf("unexpected firing payload: %v", got)
+	}
+	if _, _, err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got["status"] != StatusResolved {
+		t.Errorf("unexpected resolved payload: %v", got)
+	}
+}
+
+func TestWebhookRejectsInvalidJSON(t *testing.T) {
+	w, err := newWebhook(Webhook{Name: "chat", URL: "http://localhost", Body: `{"text": {{ .Summary }}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.render(Alert{Summary: "not quoted"}); err == nil || !strings.Contains(err.Error(), "not valid JSON") {
+		t.Fatalf("expected invalid JSON to be rejected, got %v", err)
+	}
+}
+
+func TestRouteDelivery(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	firing := Alert{Rule: "FeatureExhausted", OrgName: "org-1", Status: StatusFiring, StartsAt: start}
+	resolved := firing
+	resolved.Status = StatusResolved
+
+	sendResolved := false
+	r := newRoute(nil, Delivery{RepeatInterval: time.Hour, SendResolved: &sendResolved})
+	if due := r.due([]Alert{firing}, []Alert{firing}, start); len(due) != 1 {
+		t.Fatalf("new alert: expected it to be sent, got %+v", due)
+	}
+	if due := r.due(nil, []Alert{firing}, start.Add(59*time.Minute)); len(due) != 0 {
+		t.Fatalf("before the repeat interval: expected nothing, got %+v", due)
+	}
+	if due := r.due(nil, []Alert{firing}, start.Add(time.Hour)); len(due) != 1 {
+		t.Fatalf("after the repeat interval: expected a repeat, got %+v", due)
+	}
+	if due := r.due(nil, []Alert{firing}, start.Add(90*time.Minute)); len(due) != 0 {
+		t.Fatalf("repeats are counted from the last one, got %+v", due)
+	}
+	if due := r.due([]Alert{resolved}, nil, start.Add(2*time.Hour)); len(due) != 0 {
+		t.Fatalf("without send_resolved: expected nothing, got %+v", due)
+	}
+
+	// Alerts that started firing on standby count as sent when they
+	// started, and resolved alerts are sent by default.
+	r = newRoute(nil, Delivery{RepeatInterval: time.Hour})
+	if due := r.due(nil, []Alert{firing}, start.Add(30*time.Minute)); len(due) != 0 {
+		t.Fatalf("taken over alert before the repeat interval: expected nothing, got %+v", due)
+	}
+	if due := r.due([]Alert{resolved}, nil, start.Add(40*time.Minute)); len(due) != 1 || due[0].Status != StatusResolved {
+		t.Fatalf("expected the resolved alert, got %+v", due)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+rules:
+  - name: FeatureExhausted
+    type: feature_utilization
+    threshold: 1
+slack:
+  - name: licensing
+    webhook_url: https://hooks.slack.com/services/T0/B0/slack-secret
+    channel: "#licensing"
+    repeat_interval: 4h
+    send_resolved: false
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if s := cfg.Slack[0]; s.RepeatInterval != 4*time.Hour || s.SendResolved == nil || *s.SendResolved || s.Timeout != defaultTimeout {
+		t.Errorf("unexpected slack config: %+v", s)
+	}
+	if !strings.Contains(strings.Join(cfg.Secrets(), " "), "slack-secret") {
+		t.Errorf("secrets miss the slack webhook URL: %q", cfg.Secrets())
+	}
+
+	s, err := newSlack(cfg.Slack[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := s.render(Alert{Rule: "FeatureExhausted", Severity: SeverityCritical, Status: StatusFiring, Summary: "vWS licenses are 100% in use"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg slackMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	if want := ":rotating_light: *[FIRING] FeatureExhausted* (critical)\nvWS licenses are 100% in use"; msg.Channel != "#licensing" || msg.Text != want {
+		t.Errorf("unexpected message %+v, want text %q", msg, want)
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []pagerDutyEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	cfg, err := LoadConfig(writeConfig(t, `
+rules:
+  - name: FeatureExhausted
+    type: feature_utilization
+    threshold: 1
+    severity: critical
+pagerduty:
+  - name: default
+    routing_key: pd-secret
+  - name: test
+    routing_key: pd-secret
+    url: `+srv.URL+`
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.PagerDuty[0].URL; got != defaultPagerDutyURL {
+		t.Errorf("default url %q, want %q", got, defaultPagerDutyURL)
+	}
+	if !strings.Contains(strings.Join(cfg.Secrets(), " "), "pd-secret") {
+		t.Errorf("secrets miss the routing key: %q", cfg.Secrets())
+	}
+	if _, err := LoadConfig(writeConfig(t, "rules: [{name: a, type: server_status}]\npagerduty: [{name: p}]")); err == nil || !strings.Contains(err.Error(), "routing_key is required") {
+		t.Errorf("expected a missing routing_key to be rejected, got %v", err)
+	}
+
+	p := newPagerDuty(cfg.PagerDuty[1])
+	firing := Alert{
+		Rule: "FeatureExhausted", Severity: SeverityCritical, Status: StatusFiring, OrgName: "org-1",
+		Labels:  map[string]string{"virtual_group_name": "VG", "feature_name": "vWS"},
+		Summary: "vWS licenses are 100% in use", StartsAt: time.Now(),
+	}
+	resolved := firing
+	resolved.Status = StatusResolved
+	for _, a := range []Alert{firing, resolved} {
+		if err := p.Notify(context.Background(), a); err != nil {
+			t.Fatalf("notify %s: %v", a.Status, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	trigger, resolve := events[0], events[1]
+	if want := "FeatureExhausted/org-1/feature_name=vWS/virtual_group_name=VG"; trigger.DedupKey != want || resolve.DedupKey != want {
+		t.Errorf("dedup keys %q and %q, want %q", trigger.DedupKey, resolve.DedupKey, want)
+	}
+	if trigger.EventAction != "trigger" || trigger.RoutingKey != "pd-secret" || trigger.Payload == nil ||
+		trigger.Payload.Severity != SeverityCritical || trigger.Payload.Component != "vWS" || trigger.Payload.Source != "org-1" {
+		t.Errorf("unexpected trigger event: %+v", trigger)
+	}
+	if resolve.EventAction != "resolve" || resolve.Payload != nil {
+		t.Errorf("unexpected resolve event: %+v", resolve)
+	}
+}
+
+// smtpServer accepts unencrypted SMTP sessions and delivers the DATA of
+// each message to its channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tp := textproto.NewConn(conn)
+				_ = tp.PrintfLine("220 localhost ESMTP")
+				for {
+					line, err := tp.ReadLine()
+					if err != nil {
+						return
+					}
+					switch verb, _, _ := strings.Cut(strings.ToUpper(line), " "); verb {
+					case "EHLO", "HELO":
+						_ = tp.PrintfLine("250 localhost")
+					case "DATA":
+						_ = tp.PrintfLine("354 go ahead")
+						data, err := tp.ReadDotBytes()
+						if err != nil {
+							return
+						}
+						messages <- string(data)
+						_ = tp.PrintfLine("250 queued")
+					case "QUIT":
+						_ = tp.PrintfLine("221 bye")
+						return
+					default:
+						_ = tp.PrintfLine("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func receive(t *testing.T, messages <-chan string) *mail.Message {
+	t.Helper()
+	select {
+	case data := <-messages:
+		msg, err := mail.ReadMessage(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("parse %q: %v", data, err)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestEmail(t *testing.T) {
+	addr, messages := smtpServer(t)
+	cfg, err := LoadConfig(writeConfig(t, `
+rules:
+  - name: FeatureExhausted
+    type: feature_utilization
+    threshold: 1
+smtp:
+  address: `+addr+`
+  from: NVIDIA licensing <nvidia-cls@example.com>
+  tls: none
+  username: exporter
+  password: smtp-secret
+email:
+  - name: ops
+    to: [ops@example.com]
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !strings.Contains(strings.Join(cfg.Secrets(), " "), "smtp-secret") {
+		t.Errorf("secrets miss the smtp password: %q", cfg.Secrets())
+	}
+	// The test server has no AUTH, and PlainAuth only sends the password
+	// over TLS or to localhost.
+	cfg.SMTP.Username, cfg.SMTP.Password = "", ""
+
+	n := &email{cfg: cfg.Email[0], mailer: &mailer{cfg: cfg.SMTP}}
+	err = n.Notify(context.Background(), Alert{
+		Rule: "FeatureExhausted", Severity: SeverityWarning, Status: StatusFiring, OrgName: "org-1",
+		Labels:  map[string]string{"feature_name": "vWS"},
+		Summary: "vWS licenses are 100% in use", StartsAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	msg := receive(t, messages)
+	if got := msg.Header.Get("Subject"); got != "[FIRING] FeatureExhausted (warning)" {
+		t.Errorf("subject %q", got)
+	}
+	if got := msg.Header.Get("To"); got != "ops@example.com" {
+		t.Errorf("to %q", got)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if !strings.Contains(string(body), "vWS licenses are 100% in use") || !strings.Contains(string(body), "feature_name: vWS") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	for name, tc := range map[string]struct{ config, want string }{
+		"no smtp": {
+			"rules: [{name: a, type: server_status}]\nemail: [{name: e, to: [a@example.com]}]",
+			"smtp: address",
+		},
+		"recipient": {
+			"rules: [{name: a, type: server_status}]\nsmtp: {address: 'localhost:25', from: a@example.com}\nemail: [{name: e, to: [not an address]}]",
+			"invalid recipient",
+		},
+		"schedule": {
+			"smtp: {address: 'localhost:25', from: a@example.com}\nsummaries: [{name: s, schedule: 'every monday', to: [a@example.com]}]",
+			"invalid schedule",
+		},
+	} {
+		if _, err := LoadConfig(writeConfig(t, tc.config)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error with %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	addr, messages := smtpServer(t)
+	// Summaries need no rules.
+	cfg, err := LoadConfig(writeConfig(t, `
+smtp:
+  address: `+addr+`
+  from: nvidia-cls@example.com
+  tls: none
+summaries:
+  - name: weekly
+    schedule: CRON_TZ=UTC 0 8 * * MON
+    to: [license-managers@example.com]
+`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	start := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+	s, err := newSummary(cfg.Summaries[0], &mailer{cfg: cfg.SMTP}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.schedule.Next(start); !next.Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("next run %s, want a week later", next)
+	}
+
+	s.observe("org-1", testSnapshot(8, "ENABLED"))
+	s.observe("org-1", testSnapshot(4, "ENABLED"))
+	now := start.AddDate(0, 0, 7)
+	snaps := []orgSnapshot{{org: "org-1", snap: testSnapshot(3, "ENABLED")}, {org: "org-2"}}
+	if err := s.send(context.Background(), snaps, now); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msg := receive(t, messages)
+	if got := msg.Header.Get("Subject"); got != defaultSummarySubject {
+		t.Errorf("subject %q", got)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var text, html string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			html = string(body)
+		} else {
+			text = string(body)
+		}
+	}
+	// In use 3 of 10 now, with a peak of 8 during the week.
+	if !regexp.MustCompile(`VG +vWS +CONCURRENT_COUNTED_SINGLE +10 +3 +30% +8 +80%`).MatchString(text) {
+		t.Errorf("text misses the vWS row:\n%s", text)
+	}
+	if !strings.Contains(text, "since 2024-05-06 08:00 UTC") || !strings.Contains(text, "org-2\nNo current snapshot.") {
+		t.Errorf("unexpected text:\n%s", text)
+	}
+	if !strings.Contains(html, "<td>vWS</td>") || !strings.Contains(html, `<td align="right">80%</td>`) {
+		t.Errorf("html misses the vWS row:\n%s", html)
+	}
+
+	// Peaks start anew after each summary.
+	if data := s.collect(snaps, now.AddDate(0, 0, 7)); data.Orgs[0].Features[1].Peak != 3 || !data.Since.Equal(now) {
+		t.Errorf("expected the peaks to be reset, got %+v", data)
+	}
+}
@@ -0,0 +1,463 @@
+// Package alerts evaluates threshold rules against every new CLS snapshot
+// and sends notifications when an alert starts firing or resolves, for sites
+// that have no Prometheus Alertmanager.
+package alerts
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.yaml.in/yaml/v2"
+	"nvidia-license-server-exporter/internal/anomaly"
+)
+
+// Rule types.
+const (
+	// TypeFeatureUtilization fires when the in-use fraction of an
+	// entitlement feature in a virtual group reaches Threshold.
+	TypeFeatureUtilization = "feature_utilization"
+	// TypeServerUtilization fires when the in-use fraction of the licenses
+	// allocated to a license server reaches Threshold.
+	TypeServerUtilization = "server_utilization"
+	// TypeServerStatus fires when a license server's status is not Status.
+	TypeServerStatus = "server_status"
+	// TypeLeaseAnomaly fires when the anomaly detector flags the active
+	// leases of a license server, in Direction if set.
+	TypeLeaseAnomaly = "lease_anomaly"
+)
+
+// Severities.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Config is the alerts config file:
+//
+//	rules:
+//	  - name: FeatureNearlyExhausted
+//	    type: feature_utilization
+//	    threshold: 0.9
+//	  - name: LicenseServerNotEnabled
+//	    type: server_status
+//	    severity: critical
+//	  - name: LicenseServerLeasesDropped
+//	    type: lease_anomaly
+//	    direction: drop
+//	webhooks:
+//	  - name: ops
+//	    url: https://hooks.example.com/nvidia-cls
+//	slack:
+//	  - name: licensing
+//	    webhook_url: https://hooks.slack.com/services/...
+//	    repeat_interval: 4h
+//	pagerduty:
+//	  - name: licensing
+//	    routing_key: <integration key>
+//	smtp:
+//	  address: smtp.example.com:587
+//	  from: nvidia-cls@example.com
+//	email:
+//	  - name: ops
+//	    to: [ops@example.com]
+//	summaries:
+//	  - name: weekly
+//	    schedule: 0 8 * * MON
+//	    to: [license-managers@example.com]
+type Config struct {
+	Rules     []Rule      `yaml:"rules"`
+	Webhooks  []Webhook   `yaml:"webhooks"`
+	Slack     []Slack     `yaml:"slack"`
+	PagerDuty []PagerDuty `yaml:"pagerduty"`
+	SMTP      SMTP        `yaml:"smtp"`
+	Email     []Email     `yaml:"email"`
+	Summaries []Summary   `yaml:"summaries"`
+}
+
+type Rule struct {
+	// Name identifies the rule in notifications and metrics.
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Threshold is the in-use fraction at or above which utilization rules
+	// fire, e.g. 0.9.
+	Threshold float64 `yaml:"threshold"`
+	// Status is the expected license server status of server_status
+	// rules; empty means ENABLED.
+	Status string `yaml:"status"`
+	// Direction limits lease_anomaly rules to drops or spikes; empty means
+	// both.
+	Direction string `yaml:"direction"`
+	// Severity is info, warning or critical; empty means warning.
+	Severity string `yaml:"severity"`
+}
+
+// Delivery decides which alerts a notifier receives besides those that
+// start firing.
+type Delivery struct {
+	// RepeatInterval is how often an alert that keeps firing is sent
+	// again; zero sends it once.
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+	// SendResolved sends alerts that no longer hold; nil means true.
+	SendResolved *bool `yaml:"send_resolved"`
+}
+
+func (d *Delivery) validate() error {
+	if d.RepeatInterval < 0 {
+		return fmt.Errorf("repeat_interval %s is negative", d.RepeatInterval)
+	}
+	if d.SendResolved == nil {
+		d.SendResolved = new(bool)
+		*d.SendResolved = true
+	}
+	return nil
+}
+
+// Webhook posts alerts as JSON.
+type Webhook struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Body is a text/template rendering the JSON payload from an Alert,
+	// with a json function that encodes a value, e.g.
+	// {"text": {{ json .Summary }}}. Empty posts the Alert itself.
+	Body     string        `yaml:"body"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Delivery `yaml:",inline"`
+}
+
+// Slack posts alerts to a Slack incoming webhook.
+type Slack struct {
+	Name       string `yaml:"name"`
+	WebhookURL string `yaml:"webhook_url"`
+	// Channel and Username override those of the incoming webhook, where
+	// Slack allows it.
+	Channel  string `yaml:"channel"`
+	Username string `yaml:"username"`
+	// Text is a text/template rendering the message from an Alert. Empty
+	// uses defaultSlackText.
+	Text     string        `yaml:"text"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Delivery `yaml:",inline"`
+}
+
+// PagerDuty triggers and resolves incidents through the PagerDuty Events
+// API v2.
+type PagerDuty struct {
+	Name string `yaml:"name"`
+	// RoutingKey is the integration key of an Events API v2 integration.
+	RoutingKey string `yaml:"routing_key"`
+	// URL is the Events API endpoint; empty means defaultPagerDutyURL.
+	URL      string        `yaml:"url"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Delivery `yaml:",inline"`
+}
+
+// SMTP connection modes.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+// SMTP is the mail server of the email notifiers and summaries.
+type SMTP struct {
+	// Address is the host:port of the server, e.g. smtp.example.com:587.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender, e.g. NVIDIA licensing <nvidia-cls@example.com>.
+	From string `yaml:"from"`
+	// TLS is starttls, which requires the server to support STARTTLS, tls
+	// for implicit TLS, as on port 465, or none; empty means starttls.
+	TLS     string        `yaml:"tls"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Email mails alerts through SMTP.
+type Email struct {
+	Name     string   `yaml:"name"`
+	To       []string `yaml:"to"`
+	Delivery `yaml:",inline"`
+}
+
+// Summary mails the utilization of every entitlement feature on a schedule.
+type Summary struct {
+	Name string `yaml:"name"`
+	// Schedule is a cron expression with five fields, such as 0 8 * * MON,
+	// or a descriptor such as @weekly. It is in local time unless prefixed
+	// with CRON_TZ=<zone>.
+	Schedule string   `yaml:"schedule"`
+	To       []string `yaml:"to"`
+	// Subject is the subject line; empty means defaultSummarySubject.
+	Subject string `yaml:"subject"`
+}
+
+// LoadConfig reads and validates the alerts config file at path.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read alerts config file: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse alerts config file %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("alerts config: %w", err)
+	}
+	return cfg, nil
+}
+
+// validate checks cfg and fills in defaults.
+func (c *Config) validate() error {
+	if len(c.Rules)+len(c.Summaries) == 0 {
+		return fmt.Errorf("no rules or summaries")
+	}
+	names := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		r.Name = strings.TrimSpace(r.Name)
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("rule %d: name is required", i)
+		case names[r.Name]:
+			return fmt.Errorf("rule %s: name is used twice", r.Name)
+		}
+		names[r.Name] = true
+		if r.Severity == "" {
+			r.Severity = SeverityWarning
+		}
+		switch r.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return fmt.Errorf("rule %s: unsupported severity %q: use %s, %s or %s", r.Name, r.Severity, SeverityInfo, SeverityWarning, SeverityCritical)
+		}
+		switch r.Type {
+		case TypeFeatureUtilization, TypeServerUtilization:
+			if r.Threshold <= 0 {
+				return fmt.Errorf("rule %s: threshold must be positive, e.g. 0.9 for 90%% in use", r.Name)
+			}
+		case TypeServerStatus:
+			if strings.TrimSpace(r.Status) == "" {
+				r.Status = "ENABLED"
+			}
+		case TypeLeaseAnomaly:
+			switch r.Direction {
+			case "", anomaly.DirectionDrop, anomaly.DirectionSpike:
+			default:
+				return fmt.Errorf("rule %s: unsupported direction %q: use %s, %s or leave it empty for both", r.Name, r.Direction, anomaly.DirectionDrop, anomaly.DirectionSpike)
+			}
+		case "entitlement_expiry":
+			return fmt.Errorf("rule %s: %s is not supported, as CLS entitlement end dates are not fetched", r.Name, r.Type)
+		default:
+			return fmt.Errorf("rule %s: unsupported type %q: use %s, %s, %s or %s", r.Name, r.Type, TypeFeatureUtilization, TypeServerUtilization, TypeServerStatus, TypeLeaseAnomaly)
+		}
+	}
+
+	if len(c.Rules) > 0 && len(c.Webhooks)+len(c.Slack)+len(c.PagerDuty)+len(c.Email) == 0 {
+		return fmt.Errorf("no webhooks, slack, pagerduty or email notifiers: alerts would go nowhere")
+	}
+	names = make(map[string]bool, len(c.Webhooks))
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		if err := validateNotifier("webhook", i, &w.Name, names, &w.Delivery); err != nil {
+			return err
+		}
+		if err := validateEndpoint("webhook", w.Name, w.URL, &w.Timeout); err != nil {
+			return err
+		}
+		if w.Body != "" {
+			if _, err := parseBody(w.Body); err != nil {
+				return fmt.Errorf("webhook %s: invalid body template: %w", w.Name, err)
+			}
+		}
+	}
+	names = make(map[string]bool, len(c.Slack))
+	for i := range c.Slack {
+		s := &c.Slack[i]
+		if err := validateNotifier("slack", i, &s.Name, names, &s.Delivery); err != nil {
+			return err
+		}
+		if err := validateEndpoint("slack", s.Name, s.WebhookURL, &s.Timeout); err != nil {
+			return err
+		}
+		if s.Text != "" {
+			if _, err := parseText(s.Text); err != nil {
+				return fmt.Errorf("slack %s: invalid text template: %w", s.Name, err)
+			}
+		}
+	}
+	names = make(map[string]bool, len(c.PagerDuty))
+	for i := range c.PagerDuty {
+		p := &c.PagerDuty[i]
+		if strings.TrimSpace(p.URL) == "" {
+			p.URL = defaultPagerDutyURL
+		}
+		if err := validateNotifier("pagerduty", i, &p.Name, names, &p.Delivery); err != nil {
+			return err
+		}
+		if err := validateEndpoint("pagerduty", p.Name, p.URL, &p.Timeout); err != nil {
+			return err
+		}
+		if strings.TrimSpace(p.RoutingKey) == "" {
+			return fmt.Errorf("pagerduty %s: routing_key is required", p.Name)
+		}
+	}
+
+	if len(c.Email)+len(c.Summaries) > 0 {
+		if err := c.SMTP.validate(); err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+	}
+	names = make(map[string]bool, len(c.Email))
+	for i := range c.Email {
+		m := &c.Email[i]
+		if err := validateNotifier("email", i, &m.Name, names, &m.Delivery); err != nil {
+			return err
+		}
+		if err := validateRecipients(m.To); err != nil {
+			return fmt.Errorf("email %s: %w", m.Name, err)
+		}
+	}
+	names = make(map[string]bool, len(c.Summaries))
+	for i := range c.Summaries {
+		s := &c.Summaries[i]
+		s.Name = strings.TrimSpace(s.Name)
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("summary %d: name is required", i)
+		case names[s.Name]:
+			return fmt.Errorf("summary %s: name is used twice", s.Name)
+		}
+		names[s.Name] = true
+		if _, err := cron.ParseStandard(strings.TrimSpace(s.Schedule)); err != nil {
+			return fmt.Errorf("summary %s: invalid schedule %q: %w", s.Name, s.Schedule, err)
+		}
+		if err := validateRecipients(s.To); err != nil {
+			return fmt.Errorf("summary %s: %w", s.Name, err)
+		}
+		if s.Subject == "" {
+			s.Subject = defaultSummarySubject
+		}
+	}
+	return nil
+}
+
+// validateNotifier checks the settings every notifier has and fills in
+// their defaults. names holds the names of the same kind seen so far.
+func validateNotifier(kind string, i int, name *string, names map[string]bool, delivery *Delivery) error {
+	*name = strings.TrimSpace(*name)
+	switch {
+	case *name == "":
+		return fmt.Errorf("%s %d: name is required", kind, i)
+	case names[*name]:
+		return fmt.Errorf("%s %s: name is used twice", kind, *name)
+	}
+	names[*name] = true
+	if err := delivery.validate(); err != nil {
+		return fmt.Errorf("%s %s: %w", kind, *name, err)
+	}
+	return nil
+}
+
+// validateEndpoint checks the URL and timeout of an HTTP notifier and
+// fills in the default timeout.
+func validateEndpoint(kind, name, rawURL string, timeout *time.Duration) error {
+	if u, err := url.Parse(strings.TrimSpace(rawURL)); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %s: url must be an http:// or https:// URL", kind, name)
+	}
+	if *timeout < 0 {
+		return fmt.Errorf("%s %s: timeout %s is negative", kind, name, *timeout)
+	}
+	if *timeout == 0 {
+		*timeout = defaultTimeout
+	}
+	return nil
+}
+
+func (s *SMTP) validate() error {
+	s.Address = strings.TrimSpace(s.Address)
+	if _, port, err := net.SplitHostPort(s.Address); err != nil || port == "" {
+		return fmt.Errorf("address %q must be host:port, e.g. smtp.example.com:587", s.Address)
+	}
+	if _, err := mail.ParseAddress(s.From); err != nil {
+		return fmt.Errorf("invalid from %q: %w", s.From, err)
+	}
+	switch s.TLS {
+	case "":
+		s.TLS = SMTPStartTLS
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+	default:
+		return fmt.Errorf("unsupported tls %q: use %s, %s or %s", s.TLS, SMTPStartTLS, SMTPTLS, SMTPNone)
+	}
+	if s.Password != "" && s.Username == "" {
+		return fmt.Errorf("password is set without username")
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout %s is negative", s.Timeout)
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+func validateRecipients(to []string) error {
+	if len(to) == 0 {
+		return fmt.Errorf("to is required")
+	}
+	for _, addr := range to {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// Secrets returns the values in c that must not be logged: webhook URLs,
+// which often embed a token, header values, PagerDuty routing keys and the
+// SMTP password.
+func (c *Config) Secrets() []string {
+	var out []string
+	if c.SMTP.Password != "" {
+		out = append(out, c.SMTP.Password)
+	}
+	for _, p := range c.PagerDuty {
+		out = append(out, strings.TrimSpace(p.RoutingKey))
+	}
+	for _, s := range c.Slack {
+		out = append(out, strings.TrimSpace(s.WebhookURL))
+	}
+	for _, w := range c.Webhooks {
+		out = append(out, strings.TrimSpace(w.URL))
+		for _, value := range w.Headers {
+			if value = strings.TrimSpace(value); value != "" {
+				out = append(out, value)
+				// The credentials of "Bearer <token>" or "Basic <base64>".
+				if _, credentials, ok := strings.Cut(value, " "); ok && strings.TrimSpace(credentials) != "" {
+					out = append(out, strings.TrimSpace(credentials))
+				}
+			}
+		}
+	}
+	return out
+}
+
+func parseBody(body string) (*template.Template, error) {
+	return template.New("body").Funcs(template.FuncMap{"json": jsonString}).Option("missingkey=error").Parse(body)
+}
+
+func parseText(text string) (*template.Template, error) {
+	return template.New("text").Funcs(template.FuncMap{"upper": strings.ToUpper}).Option("missingkey=error").Parse(text)
+}
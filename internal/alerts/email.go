@@ -0,0 +1,183 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mailer sends messages through the SMTP server of the alerts config.
+type mailer struct {
+	cfg SMTP
+}
+
+// send delivers msg to every address in to. The connection is closed when
+// ctx is done or the SMTP timeout passes, whichever comes first.
+func (m *mailer) send(ctx context.Context, to []string, msg []byte) error {
+	host, _, _ := net.SplitHostPort(m.cfg.Address)
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if m.cfg.TLS == SMTPTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", m.cfg.Address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", m.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.cfg.TLS == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS; set tls to none to send unencrypted")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to
+		// localhost.
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	from, _ := mail.ParseAddress(m.cfg.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		rcpt, _ := mail.ParseAddress(addr)
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", rcpt.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds a MIME message with a plain text part and, if html is not
+// empty, an HTML alternative.
+func (m *mailer) message(to []string, subject, text, html string, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) { fmt.Fprintf(&buf, "%s: %s\r\n", name, value) }
+	header("From", m.cfg.From)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if html == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", text},
+		{"text/html", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	// Line breaks become CRLF.
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// email mails alerts as plain text.
+type email struct {
+	cfg    Email
+	mailer *mailer
+}
+
+func (e *email) Name() string {
+	return "email/" + e.cfg.Name
+}
+
+func (e *email) Notify(ctx context.Context, alert Alert) error {
+	subject := fmt.Sprintf("[%s] %s (%s)", strings.ToUpper(alert.Status), alert.Rule, alert.Severity)
+	msg, err := e.mailer.message(e.cfg.To, subject, alertText(alert), "", time.Now())
+	if err != nil {
+		return err
+	}
+	return e.mailer.send(ctx, e.cfg.To, msg)
+}
+
+// alertText renders alert as the body of an email.
+func alertText(alert Alert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", alert.Summary)
+	fmt.Fprintf(&b, "Rule:     %s\n", alert.Rule)
+	fmt.Fprintf(&b, "Severity: %s\n", alert.Severity)
+	fmt.Fprintf(&b, "Status:   %s\n", alert.Status)
+	fmt.Fprintf(&b, "Org:      %s\n", alert.OrgName)
+	for _, name := range slices.Sorted(maps.Keys(alert.Labels)) {
+		fmt.Fprintf(&b, "%s: %s\n", name, alert.Labels[name])
+	}
+	fmt.Fprintf(&b, "Started:  %s\n", alert.StartsAt.Format(time.RFC3339))
+	if !alert.EndsAt.IsZero() {
+		fmt.Fprintf(&b, "Resolved: %s\n", alert.EndsAt.Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,381 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/anomaly"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// Notifier sends an alert that started firing or resolved.
+type Notifier interface {
+	// Name identifies the notifier in logs and metrics, e.g. webhook/ops.
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Engine evaluates the rules against each new snapshot of every target and
+// notifies on changes: an alert that starts firing, and one that no longer
+// holds. Alerts that keep firing are only sent again after the repeat
+// interval of a notifier.
+type Engine struct {
+	rules     []Rule
+	routes    []*route
+	summaries []*summary
+	manager   *snapshot.Manager
+	standby   func() bool
+	detector  *anomaly.Detector
+
+	mu sync.Mutex
+	// active holds the firing alerts by Key.
+	active map[string]Alert
+	// collected is when the last evaluated snapshot of each target was
+	// collected, so that refreshes returning the same snapshot are skipped.
+	collected map[string]time.Time
+
+	alerts        *prometheus.GaugeVec
+	notifications *prometheus.CounterVec
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	pending     chan *snapshot.Service
+	unsubscribe []func()
+	wg          sync.WaitGroup
+	done        chan struct{}
+}
+
+// NewEngine builds the notifiers and summaries of cfg. standby, if set,
+// reports whether another replica sends the notifications, such as the
+// leader of a leader election; while it does, alerts are evaluated but not
+// sent, and summaries are skipped. detector is required by lease_anomaly
+// rules only.
+func NewEngine(cfg *Config, manager *snapshot.Manager, standby func() bool, detector *anomaly.Detector) (*Engine, error) {
+	for _, r := range cfg.Rules {
+		if r.Type == TypeLeaseAnomaly && detector == nil {
+			return nil, fmt.Errorf("rule %s: %s requires the anomaly detector", r.Name, r.Type)
+		}
+	}
+	var routes []*route
+	for _, w := range cfg.Webhooks {
+		n, err := newWebhook(w)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, newRoute(n, w.Delivery))
+	}
+	for _, s := range cfg.Slack {
+		n, err := newSlack(s)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, newRoute(n, s.Delivery))
+	}
+	for _, p := range cfg.PagerDuty {
+		routes = append(routes, newRoute(newPagerDuty(p), p.Delivery))
+	}
+	m := &mailer{cfg: cfg.SMTP}
+	for _, c := range cfg.Email {
+		routes = append(routes, newRoute(&email{cfg: c, mailer: m}, c.Delivery))
+	}
+	var summaries []*summary
+	for _, c := range cfg.Summaries {
+		s, err := newSummary(c, m, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Engine{
+		rules:     cfg.Rules,
+		routes:    routes,
+		summaries: summaries,
+		manager:   manager,
+		standby:   standby,
+		detector:  detector,
+		active:    make(map[string]Alert),
+		collected: make(map[string]time.Time),
+		alerts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_alerts_firing",
+			Help: "Alerts currently firing by rule and severity.",
+		}, []string{"rule", "severity"}),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_alert_notifications_total",
+			Help: "Alert notifications and summaries sent by notifier and result.",
+		}, []string{"notifier", "result"}),
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(chan *snapshot.Service, len(manager.Services())),
+		done:    make(chan struct{}),
+	}
+	for _, r := range e.rules {
+		e.alerts.WithLabelValues(r.Name, r.Severity)
+	}
+	for _, r := range e.routes {
+		e.notifications.WithLabelValues(r.notifier.Name(), "success")
+		e.notifications.WithLabelValues(r.notifier.Name(), "failure")
+	}
+	for _, s := range e.summaries {
+		e.notifications.WithLabelValues(s.Name(), "success")
+		e.notifications.WithLabelValues(s.Name(), "failure")
+	}
+	return e, nil
+}
+
+// Collector exposes the firing alerts and notification counters for a
+// Prometheus registry.
+func (e *Engine) Collector() prometheus.Collector {
+	return collectors{e.alerts, e.notifications}
+}
+
+type collectors []prometheus.Collector
+
+func (c collectors) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c {
+		collector.Describe(ch)
+	}
+}
+
+func (c collectors) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c {
+		collector.Collect(ch)
+	}
+}
+
+// Start subscribes to every target's refreshes. Evaluation and sending
+// happen on a separate goroutine so a slow notifier never delays a refresh;
+// when it falls behind, a target's pending update is coalesced with the
+// next one. Each summary waits for its schedule on a goroutine of its own.
+func (e *Engine) Start() {
+	for _, svc := range e.manager.Services() {
+		e.unsubscribe = append(e.unsubscribe, svc.Subscribe(func(snapshot.RefreshEvent) {
+			select {
+			case e.pending <- svc:
+			default:
+			}
+		}))
+	}
+
+	e.wg.Go(func() {
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case svc := <-e.pending:
+				e.process(svc)
+			}
+		}
+	})
+	for _, s := range e.summaries {
+		e.wg.Go(func() {
+			s.run(e.ctx, e.latest, func(err error) {
+				if err != nil {
+					e.notifications.WithLabelValues(s.Name(), "failure").Inc()
+					slog.Error("summary failed", "summary", s.cfg.Name, "error", err)
+					return
+				}
+				e.notifications.WithLabelValues(s.Name(), "success").Inc()
+			})
+		})
+	}
+	go func() {
+		e.wg.Wait()
+		close(e.done)
+	}()
+}
+
+func (e *Engine) Shutdown(ctx context.Context) error {
+	for _, cancel := range e.unsubscribe {
+		cancel()
+	}
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// process evaluates the current snapshot of svc, unless it was evaluated
+// before, and sends the changes.
+func (e *Engine) process(svc *snapshot.Service) {
+	snap, _, ok := svc.Latest()
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	seen := e.collected[svc.Target()].Equal(snap.CollectedAt)
+	e.collected[svc.Target()] = snap.CollectedAt
+	e.mu.Unlock()
+	if seen {
+		return
+	}
+	for _, s := range e.summaries {
+		s.observe(svc.Target(), snap)
+	}
+	now := time.Now()
+	changes := e.Evaluate(svc.Target(), snap, now)
+	if e.standby != nil && e.standby() {
+		return
+	}
+	e.send(changes, e.firing(svc.Target()), now)
+}
+
+// Evaluate updates the alerts of org from snap and returns those that
+// started firing or resolved, ordered by Key.
+func (e *Engine) Evaluate(org string, snap *cls.Snapshot, now time.Time) []Alert {
+	var anomalies []anomaly.Anomaly
+	if e.detector != nil {
+		anomalies = e.detector.Observe(org, snap)
+	}
+	current := make(map[string]Alert)
+	for _, r := range e.rules {
+		for _, a := range r.evaluate(org, snap, anomalies) {
+			current[a.Key()] = a
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var changes []Alert
+	for key, a := range current {
+		if previous, ok := e.active[key]; ok {
+			a.StartsAt = previous.StartsAt
+			e.active[key] = a
+			continue
+		}
+		a.StartsAt = now
+		e.active[key] = a
+		changes = append(changes, a)
+	}
+	for key, a := range e.active {
+		if _, ok := current[key]; ok || a.OrgName != org {
+			continue
+		}
+		delete(e.active, key)
+		a.Status = StatusResolved
+		a.EndsAt = now
+		changes = append(changes, a)
+	}
+	slices.SortFunc(changes, func(a, b Alert) int { return strings.Compare(a.Key(), b.Key()) })
+
+	e.alerts.Reset()
+	for _, r := range e.rules {
+		e.alerts.WithLabelValues(r.Name, r.Severity)
+	}
+	for _, a := range e.active {
+		e.alerts.WithLabelValues(a.Rule, a.Severity).Inc()
+	}
+	return changes
+}
+
+// latest returns the latest snapshot of every target for summaries, or
+// false on standby.
+func (e *Engine) latest() ([]orgSnapshot, bool) {
+	if e.standby != nil && e.standby() {
+		return nil, false
+	}
+	var out []orgSnapshot
+	for _, svc := range e.manager.Services() {
+		snap, _, _ := svc.Latest()
+		out = append(out, orgSnapshot{org: svc.Target(), snap: snap})
+	}
+	return out, true
+}
+
+// firing returns the alerts of org that are firing, ordered by Key.
+func (e *Engine) firing(org string) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []Alert
+	for _, a := range e.active {
+		if a.OrgName == org {
+			out = append(out, a)
+		}
+	}
+	slices.SortFunc(out, func(a, b Alert) int { return strings.Compare(a.Key(), b.Key()) })
+	return out
+}
+
+// send hands changes, and the firing alerts that are due again, to every
+// notifier. Failures are logged and counted; the alert is not retried.
+func (e *Engine) send(changes, firing []Alert, now time.Time) {
+	for _, r := range e.routes {
+		for _, a := range r.due(changes, firing, now) {
+			if err := r.notifier.Notify(e.ctx, a); err != nil {
+				e.notifications.WithLabelValues(r.notifier.Name(), "failure").Inc()
+				slog.Error("alert notification failed", "notifier", r.notifier.Name(), "rule", a.Rule, "org", a.OrgName, "status", a.Status, "error", err)
+				continue
+			}
+			e.notifications.WithLabelValues(r.notifier.Name(), "success").Inc()
+		}
+	}
+}
+
+// route applies the Delivery of a notifier.
+type route struct {
+	notifier       Notifier
+	repeatInterval time.Duration
+	sendResolved   bool
+	// sent is when each firing alert was last handed to the notifier, by
+	// Key.
+	sent map[string]time.Time
+}
+
+func newRoute(n Notifier, d Delivery) *route {
+	return &route{
+		notifier:       n,
+		repeatInterval: d.RepeatInterval,
+		sendResolved:   d.SendResolved == nil || *d.SendResolved,
+		sent:           make(map[string]time.Time),
+	}
+}
+
+// due returns the alerts to send: every change, except resolved ones
+// without SendResolved, and the firing alerts last sent at least the repeat
+// interval ago. Alerts that started firing while on standby count as sent
+// when they started.
+func (r *route) due(changes, firing []Alert, now time.Time) []Alert {
+	var out []Alert
+	changed := make(map[string]bool, len(changes))
+	for _, a := range changes {
+		key := a.Key()
+		changed[key] = true
+		if a.Status == StatusResolved {
+			delete(r.sent, key)
+			if r.sendResolved {
+				out = append(out, a)
+			}
+			continue
+		}
+		r.sent[key] = now
+		out = append(out, a)
+	}
+	if r.repeatInterval <= 0 {
+		return out
+	}
+	for _, a := range firing {
+		key := a.Key()
+		if changed[key] {
+			continue
+		}
+		last, ok := r.sent[key]
+		if !ok {
+			last = a.StartsAt
+		}
+		if now.Sub(last) >= r.repeatInterval {
+			r.sent[key] = now
+			out = append(out, a)
+		}
+	}
+	return out
+}
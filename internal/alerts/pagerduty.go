@@ -0,0 +1,112 @@
+package alerts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// maxDedupKey is the longest dedup_key the Events API accepts.
+	maxDedupKey = 255
+)
+
+type pagerDuty struct {
+	cfg    PagerDuty
+	client *http.Client
+}
+
+func newPagerDuty(cfg PagerDuty) *pagerDuty {
+	return &pagerDuty{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (p *pagerDuty) Name() string {
+	return "pagerduty/" + p.cfg.Name
+}
+
+// pagerDutyEvent is an Events API v2 event. Resolve events carry no
+// payload.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Client      string            `json:"client,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string         `json:"summary"`
+	Source        string         `json:"source"`
+	Severity      string         `json:"severity"`
+	Timestamp     time.Time      `json:"timestamp,omitzero"`
+	Component     string         `json:"component,omitempty"`
+	Group         string         `json:"group,omitempty"`
+	Class         string         `json:"class,omitempty"`
+	CustomDetails map[string]any `json:"custom_details,omitempty"`
+}
+
+func (p *pagerDuty) Notify(ctx context.Context, alert Alert) error {
+	payload, err := json.Marshal(p.event(alert))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, p.client, p.cfg.URL, nil, payload)
+}
+
+// event triggers an incident for a firing alert and resolves it once the
+// alert resolves. Both share the dedup key, so PagerDuty folds repeats of a
+// firing alert into the open incident.
+func (p *pagerDuty) event(alert Alert) pagerDutyEvent {
+	event := pagerDutyEvent{
+		RoutingKey:  strings.TrimSpace(p.cfg.RoutingKey),
+		EventAction: "trigger",
+		DedupKey:    dedupKey(alert),
+	}
+	if alert.Status == StatusResolved {
+		event.EventAction = "resolve"
+		return event
+	}
+	source := alert.Labels["server_name"]
+	if source == "" {
+		source = alert.OrgName
+	}
+	component := alert.Labels["feature_name"]
+	if component == "" {
+		component = alert.Labels["server_name"]
+	}
+	event.Client = userAgent
+	event.Payload = &pagerDutyPayload{
+		Summary: alert.Summary,
+		Source:  source,
+		// PagerDuty severities include info, warning and critical.
+		Severity:  alert.Severity,
+		Timestamp: alert.StartsAt,
+		Component: component,
+		Group:     alert.Labels["virtual_group_name"],
+		Class:     alert.Rule,
+		CustomDetails: map[string]any{
+			"org_name":  alert.OrgName,
+			"labels":    alert.Labels,
+			"value":     alert.Value,
+			"threshold": alert.Threshold,
+		},
+	}
+	return event
+}
+
+// dedupKey identifies an alert's incident: its rule, org and labels, such
+// as the feature or license server. Keys too long for PagerDuty are hashed.
+func dedupKey(alert Alert) string {
+	key := strings.ReplaceAll(alert.Key(), "\x00", "/")
+	if len(key) > maxDedupKey {
+		sum := sha256.Sum256([]byte(alert.Key()))
+		key = hex.EncodeToString(sum[:])
+	}
+	return key
+}
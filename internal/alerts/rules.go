@@ -0,0 +1,130 @@
+package alerts
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/anomaly"
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// Alert statuses.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is one rule matching one entitlement feature or license server of
+// an org. Notifiers receive it when it starts firing and when it resolves.
+type Alert struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Status   string `json:"status"`
+	OrgName  string `json:"org_name"`
+	// Labels identify what the alert is about, such as virtual_group_name
+	// and feature_name or server_id and server_name.
+	Labels map[string]string `json:"labels"`
+	// Value is the in-use fraction for utilization rules, the active leases
+	// for lease_anomaly rules, and 0 for server_status rules. A resolved
+	// alert carries the last firing value.
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold,omitempty"`
+	Summary   string    `json:"summary"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at,omitzero"`
+}
+
+// Key identifies the alert across evaluations: its rule, org and labels.
+func (a Alert) Key() string {
+	parts := []string{a.Rule, a.OrgName}
+	for _, name := range slices.Sorted(maps.Keys(a.Labels)) {
+		parts = append(parts, name+"="+a.Labels[name])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// evaluate returns the alerts of r that hold for snap, as firing and
+// without StartsAt. anomalies are those the detector found in snap.
+func (r Rule) evaluate(org string, snap *cls.Snapshot, anomalies []anomaly.Anomaly) []Alert {
+	var out []Alert
+	alert := func(labels map[string]string, value float64, summary string) {
+		out = append(out, Alert{
+			Rule:      r.Name,
+			Severity:  r.Severity,
+			Status:    StatusFiring,
+			OrgName:   org,
+			Labels:    labels,
+			Value:     value,
+			Threshold: r.Threshold,
+			Summary:   summary,
+		})
+	}
+	switch r.Type {
+	case TypeFeatureUtilization:
+		for _, f := range snap.EntitlementFeatures {
+			if f.TotalQuantity <= 0 {
+				continue
+			}
+			if used := f.InUseQuantity / f.TotalQuantity; used >= r.Threshold {
+				alert(map[string]string{
+					"virtual_group_id":   strconv.Itoa(f.VirtualGroupID),
+					"virtual_group_name": f.VirtualGroupName,
+					"feature_name":       f.FeatureName,
+					"license_type":       f.LicenseType,
+				}, used, fmt.Sprintf("%s licenses of virtual group %s in org %s are %s in use (%g of %g)",
+					f.FeatureName, f.VirtualGroupName, org, percent(used), f.InUseQuantity, f.TotalQuantity))
+			}
+		}
+	case TypeServerUtilization:
+		for _, s := range snap.ServerUsage {
+			if s.Allocated <= 0 {
+				continue
+			}
+			if used := s.InUse / s.Allocated; used >= r.Threshold {
+				alert(map[string]string{
+					"virtual_group_name": s.VirtualGroupName,
+					"server_id":          s.ServerID,
+					"server_name":        s.ServerName,
+				}, used, fmt.Sprintf("License server %s in org %s has %s of its licenses in use (%g of %g)",
+					s.ServerName, org, percent(used), s.InUse, s.Allocated))
+			}
+		}
+	case TypeServerStatus:
+		for _, s := range snap.ServerUsage {
+			if !strings.EqualFold(s.ServerStatus, r.Status) {
+				alert(map[string]string{
+					"virtual_group_name": s.VirtualGroupName,
+					"server_id":          s.ServerID,
+					"server_name":        s.ServerName,
+				}, 0, fmt.Sprintf("License server %s in org %s is %s, not %s",
+					s.ServerName, org, s.ServerStatus, r.Status))
+			}
+		}
+	case TypeLeaseAnomaly:
+		for _, a := range anomalies {
+			if r.Direction != "" && a.Direction != r.Direction {
+				continue
+			}
+			verb := "spiked"
+			if a.Direction == anomaly.DirectionDrop {
+				verb = "dropped"
+			}
+			alert(map[string]string{
+				"virtual_group_name": a.VirtualGroupName,
+				"server_id":          a.ServerID,
+				"server_name":        a.ServerName,
+				"direction":          a.Direction,
+			}, a.ActiveLeases, fmt.Sprintf("Active leases of license server %s in org %s %s to %g, from about %.1f (standard deviation %.1f)",
+				a.ServerName, org, verb, a.ActiveLeases, a.Mean, a.StdDev))
+		}
+	}
+	return out
+}
+
+func percent(fraction float64) string {
+	return strconv.FormatFloat(fraction*100, 'f', 0, 64) + "%"
+}
@@ -0,0 +1,58 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// defaultSlackText renders an alert as a Slack message in mrkdwn.
+const defaultSlackText = `{{ if eq .Status "firing" }}:rotating_light:{{ else }}:white_check_mark:{{ end }} *[{{ upper .Status }}] {{ .Rule }}* ({{ .Severity }})
+{{ .Summary }}`
+
+type slack struct {
+	cfg    Slack
+	text   *template.Template
+	client *http.Client
+}
+
+func newSlack(cfg Slack) (*slack, error) {
+	text := cfg.Text
+	if text == "" {
+		text = defaultSlackText
+	}
+	tmpl, err := parseText(text)
+	if err != nil {
+		return nil, fmt.Errorf("slack %s: invalid text template: %w", cfg.Name, err)
+	}
+	return &slack{cfg: cfg, text: tmpl, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (s *slack) Name() string {
+	return "slack/" + s.cfg.Name
+}
+
+type slackMessage struct {
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+	Text     string `json:"text"`
+}
+
+func (s *slack) Notify(ctx context.Context, alert Alert) error {
+	payload, err := s.render(alert)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.client, s.cfg.WebhookURL, nil, payload)
+}
+
+func (s *slack) render(alert Alert) ([]byte, error) {
+	var text bytes.Buffer
+	if err := s.text.Execute(&text, alert); err != nil {
+		return nil, fmt.Errorf("render text: %w", err)
+	}
+	return json.Marshal(slackMessage{Channel: s.cfg.Channel, Username: s.cfg.Username, Text: text.String()})
+}
@@ -0,0 +1,224 @@
+package alerts
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+const defaultSummarySubject = "NVIDIA license utilization summary"
+
+// summary mails a table of every entitlement feature on a schedule, with
+// the peak in-use quantity seen since the previous one.
+type summary struct {
+	cfg      Summary
+	schedule cron.Schedule
+	mailer   *mailer
+
+	mu sync.Mutex
+	// since is when the peaks started being recorded.
+	since time.Time
+	// peaks holds the highest in-use quantity per target and feature, by
+	// featureKey.
+	peaks map[string]float64
+}
+
+func newSummary(cfg Summary, m *mailer, now time.Time) (*summary, error) {
+	schedule, err := cron.ParseStandard(strings.TrimSpace(cfg.Schedule))
+	if err != nil {
+		return nil, fmt.Errorf("summary %s: invalid schedule: %w", cfg.Name, err)
+	}
+	return &summary{cfg: cfg, schedule: schedule, mailer: m, since: now, peaks: make(map[string]float64)}, nil
+}
+
+func (s *summary) Name() string {
+	return "summary/" + s.cfg.Name
+}
+
+func featureKey(org string, f cls.EntitlementFeatureSnapshot) string {
+	return strings.Join([]string{org, strconv.Itoa(f.VirtualGroupID), f.FeatureName, f.LicenseType}, "\x00")
+}
+
+// observe records the in-use quantities of snap.
+func (s *summary) observe(org string, snap *cls.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range snap.EntitlementFeatures {
+		key := featureKey(org, f)
+		s.peaks[key] = max(s.peaks[key], f.InUseQuantity)
+	}
+}
+
+type summaryData struct {
+	Name        string
+	GeneratedAt time.Time
+	Since       time.Time
+	Orgs        []summaryOrg
+}
+
+type summaryOrg struct {
+	Name string
+	// CollectedAt is zero when the org has no snapshot yet.
+	CollectedAt time.Time
+	Features    []summaryFeature
+}
+
+type summaryFeature struct {
+	VirtualGroup string
+	Feature      string
+	LicenseType  string
+	Entitled     float64
+	InUse        float64
+	Peak         float64
+}
+
+// Utilization is the in-use fraction, or 0 without entitled licenses.
+func (f summaryFeature) Utilization() float64 {
+	if f.Entitled <= 0 {
+		return 0
+	}
+	return f.InUse / f.Entitled
+}
+
+func (f summaryFeature) PeakUtilization() float64 {
+	if f.Entitled <= 0 {
+		return 0
+	}
+	return f.Peak / f.Entitled
+}
+
+// orgSnapshot is the latest snapshot of a target, nil when there is none.
+type orgSnapshot struct {
+	org  string
+	snap *cls.Snapshot
+}
+
+// collect builds the summary of snaps and starts recording peaks anew.
+func (s *summary) collect(snaps []orgSnapshot, now time.Time) summaryData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := summaryData{Name: s.cfg.Name, GeneratedAt: now, Since: s.since}
+	for _, t := range snaps {
+		org := summaryOrg{Name: t.org}
+		if t.snap != nil {
+			org.CollectedAt = t.snap.CollectedAt
+			for _, f := range t.snap.EntitlementFeatures {
+				org.Features = append(org.Features, summaryFeature{
+					VirtualGroup: f.VirtualGroupName,
+					Feature:      f.FeatureName,
+					LicenseType:  f.LicenseType,
+					Entitled:     f.TotalQuantity,
+					InUse:        f.InUseQuantity,
+					Peak:         max(s.peaks[featureKey(t.org, f)], f.InUseQuantity),
+				})
+			}
+			slices.SortFunc(org.Features, func(a, b summaryFeature) int {
+				return cmp.Or(cmp.Compare(a.VirtualGroup, b.VirtualGroup), cmp.Compare(a.Feature, b.Feature), cmp.Compare(a.LicenseType, b.LicenseType))
+			})
+		}
+		data.Orgs = append(data.Orgs, org)
+	}
+	s.since = now
+	s.peaks = make(map[string]float64)
+	return data
+}
+
+// send mails the summary of snaps.
+func (s *summary) send(ctx context.Context, snaps []orgSnapshot, now time.Time) error {
+	data := s.collect(snaps, now)
+	text := summaryText(data)
+	var html bytes.Buffer
+	if err := summaryHTML.Execute(&html, data); err != nil {
+		return fmt.Errorf("render summary: %w", err)
+	}
+	msg, err := s.mailer.message(s.cfg.To, s.cfg.Subject, text, html.String(), now)
+	if err != nil {
+		return err
+	}
+	return s.mailer.send(ctx, s.cfg.To, msg)
+}
+
+// run sends the summary at every scheduled time until ctx is done. latest
+// returns the snapshots to summarize, or false on standby, when the summary
+// is skipped but the peaks still start anew.
+func (s *summary) run(ctx context.Context, latest func() ([]orgSnapshot, bool), sent func(error)) {
+	for {
+		next := s.schedule.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		snaps, active := latest()
+		if !active {
+			s.collect(nil, next)
+			slog.Debug("summary skipped on standby", "summary", s.cfg.Name)
+			continue
+		}
+		sent(s.send(ctx, snaps, next))
+	}
+}
+
+func summaryText(data summaryData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "NVIDIA license utilization, %s\n", data.GeneratedAt.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&b, "Peaks are the highest in-use quantities seen since %s.\n", data.Since.Format("2006-01-02 15:04 MST"))
+	for _, org := range data.Orgs {
+		fmt.Fprintf(&b, "\n%s\n", org.Name)
+		if org.CollectedAt.IsZero() {
+			b.WriteString("No current snapshot.\n")
+			continue
+		}
+		if len(org.Features) == 0 {
+			b.WriteString("No entitlement features.\n")
+			continue
+		}
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Virtual group\tFeature\tLicense type\tEntitled\tIn use\tUtilization\tPeak\tPeak utilization")
+		for _, f := range org.Features {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%g\t%g\t%s\t%g\t%s\n",
+				f.VirtualGroup, f.Feature, f.LicenseType, f.Entitled, f.InUse, percent(f.Utilization()), f.Peak, percent(f.PeakUtilization()))
+		}
+		w.Flush()
+	}
+	return b.String()
+}
+
+var summaryHTML = htmltemplate.Must(htmltemplate.New("summary").Funcs(htmltemplate.FuncMap{"percent": percent}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>NVIDIA license utilization</title></head>
+<body style="font-family: sans-serif">
+<h2>NVIDIA license utilization, {{ .GeneratedAt.Format "2006-01-02 15:04 MST" }}</h2>
+<p>Peaks are the highest in-use quantities seen since {{ .Since.Format "2006-01-02 15:04 MST" }}.</p>
+{{- range .Orgs }}
+<h3>{{ .Name }}</h3>
+{{- if .CollectedAt.IsZero }}
+<p>No current snapshot.</p>
+{{- else if not .Features }}
+<p>No entitlement features.</p>
+{{- else }}
+<table style="border-collapse: collapse" border="1" cellpadding="4">
+<tr><th>Virtual group</th><th>Feature</th><th>License type</th><th>Entitled</th><th>In use</th><th>Utilization</th><th>Peak</th><th>Peak utilization</th></tr>
+{{- range .Features }}
+<tr><td>{{ .VirtualGroup }}</td><td>{{ .Feature }}</td><td>{{ .LicenseType }}</td><td align="right">{{ .Entitled }}</td><td align="right">{{ .InUse }}</td><td align="right">{{ percent .Utilization }}</td><td align="right">{{ .Peak }}</td><td align="right">{{ percent .PeakUtilization }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
@@ -0,0 +1,96 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const (
+	userAgent = "nvidia-license-server-exporter/0.1"
+
+	// maxErrorBody bounds how much of a rejected request's response is
+	// kept in the error.
+	maxErrorBody = 512
+)
+
+type webhook struct {
+	cfg    Webhook
+	body   *template.Template
+	client *http.Client
+}
+
+func newWebhook(cfg Webhook) (*webhook, error) {
+	w := &webhook{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	if cfg.Body != "" {
+		body, err := parseBody(cfg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid body template: %w", cfg.Name, err)
+		}
+		w.body = body
+	}
+	return w, nil
+}
+
+func (w *webhook) Name() string {
+	return "webhook/" + w.cfg.Name
+}
+
+func (w *webhook) Notify(ctx context.Context, alert Alert) error {
+	payload, err := w.render(alert)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.client, w.cfg.URL, w.cfg.Headers, payload)
+}
+
+// render returns the JSON payload for alert.
+func (w *webhook) render(alert Alert) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(alert)
+	}
+	var buf bytes.Buffer
+	if err := w.body.Execute(&buf, alert); err != nil {
+		return nil, fmt.Errorf("render body: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("rendered body is not valid JSON; quote values with the json function")
+	}
+	return buf.Bytes(), nil
+}
+
+// postJSON posts payload to url and fails on any status but 2xx.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSpace(url), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// jsonString encodes v for body templates.
+func jsonString(v any) (string, error) {
+	raw, err := json.Marshal(v)
+	return string(raw), err
+}
@@ -0,0 +1,257 @@
+// Package anomaly flags sudden drops and spikes in the active leases of
+// each license server, such as those of a DLS appliance that silently
+// stopped serving, against an exponentially weighted moving mean and
+// standard deviation of its recent refreshes.
+package anomaly
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// Directions of an anomaly.
+const (
+	DirectionDrop  = "drop"
+	DirectionSpike = "spike"
+)
+
+type Config struct {
+	// Alpha is the weight of the latest refresh in the moving mean and
+	// variance, from 0 to 1; lower values remember longer.
+	Alpha float64
+	// Threshold is how many standard deviations from the mean the active
+	// leases have to be to count as an anomaly.
+	Threshold float64
+	// Warmup is how many refreshes of a server are observed before any is
+	// flagged.
+	Warmup int
+	// MinDeviation is the fewest leases from the mean that count as an
+	// anomaly, so that servers with a steady lease count are not flagged
+	// for a lease or two.
+	MinDeviation float64
+}
+
+// Anomaly is a license server whose active leases are far from its moving
+// mean.
+type Anomaly struct {
+	VirtualGroupID   int
+	VirtualGroupName string
+	ServerID         string
+	ServerName       string
+	// Direction is DirectionDrop or DirectionSpike.
+	Direction    string
+	ActiveLeases float64
+	// Mean and StdDev are the moving mean and standard deviation before
+	// the refresh.
+	Mean   float64
+	StdDev float64
+}
+
+// Detector keeps a baseline of the active leases of every license server of
+// every target. Each new snapshot is flagged against the baseline and then
+// added to it, so a lasting change becomes the new normal after a while.
+type Detector struct {
+	cfg     Config
+	manager *snapshot.Manager
+
+	mu sync.Mutex
+	// baselines and observed are by target; observed is when the last
+	// observed snapshot was collected, with the anomalies found in it.
+	baselines map[string]map[serverKey]*baseline
+	observed  map[string]observation
+
+	anomalies *prometheus.GaugeVec
+
+	pending     chan *snapshot.Service
+	unsubscribe []func()
+	started     bool
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+type serverKey struct {
+	virtualGroupID int
+	id             string
+}
+
+// baseline is the exponentially weighted moving mean and variance of a
+// server's active leases, with the label values of its gauge.
+type baseline struct {
+	mean, variance float64
+	samples        int
+	labels         []string
+}
+
+type observation struct {
+	at        time.Time
+	anomalies []Anomaly
+}
+
+func NewDetector(cfg Config, manager *snapshot.Manager) *Detector {
+	return &Detector{
+		cfg:       cfg,
+		manager:   manager,
+		baselines: make(map[string]map[serverKey]*baseline),
+		observed:  make(map[string]observation),
+		anomalies: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nvidia_cls_lease_anomaly",
+			Help: "Whether the active leases of a license server dropped (-1) or spiked (1) against their moving mean in the latest snapshot, or not (0).",
+		}, []string{"org_name", "virtual_group_id", "virtual_group_name", "server_id", "server_name"}),
+		pending: make(chan *snapshot.Service, len(manager.Services())),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Collector exposes the anomaly flag of every license server for a
+// Prometheus registry.
+func (d *Detector) Collector() prometheus.Collector {
+	return d.anomalies
+}
+
+// Start subscribes to every target's refreshes, which are observed on a
+// separate goroutine; when it falls behind, a target's pending update is
+// coalesced with the next one.
+func (d *Detector) Start() {
+	d.started = true
+	for _, svc := range d.manager.Services() {
+		d.unsubscribe = append(d.unsubscribe, svc.Subscribe(func(snapshot.RefreshEvent) {
+			select {
+			case d.pending <- svc:
+			default:
+			}
+		}))
+	}
+
+	go func() {
+		defer close(d.done)
+		for {
+			select {
+			case <-d.stop:
+				return
+			case svc := <-d.pending:
+				if snap, _, ok := svc.Latest(); ok {
+					d.Observe(svc.Target(), snap)
+				}
+			}
+		}
+	}()
+}
+
+func (d *Detector) Shutdown(ctx context.Context) error {
+	for _, cancel := range d.unsubscribe {
+		cancel()
+	}
+	close(d.stop)
+	if !d.started {
+		return nil
+	}
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Observe flags the license servers of org whose active leases in snap are
+// anomalous and adds snap to their baselines. A snapshot observed before,
+// such as by the alert engine and the detector's own subscription, is only
+// added once and returns the same anomalies. Servers no longer in snap are
+// forgotten.
+func (d *Detector) Observe(org string, snap *cls.Snapshot) []Anomaly {
+	at := snap.CollectedAt
+	if snap.LeasesCollectedAt.After(at) {
+		at = snap.LeasesCollectedAt
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if o, ok := d.observed[org]; ok && o.at.Equal(at) {
+		return o.anomalies
+	}
+
+	previous := d.baselines[org]
+	current := make(map[serverKey]*baseline, len(snap.ServerActiveLeases))
+	var anomalies []Anomaly
+	for _, s := range snap.ServerActiveLeases {
+		key := serverKey{s.VirtualGroupID, s.ServerID}
+		b, ok := previous[key]
+		if !ok {
+			b = &baseline{}
+		}
+		current[key] = b
+		labels := []string{org, strconv.Itoa(s.VirtualGroupID), s.VirtualGroupName, s.ServerID, s.ServerName}
+		if b.labels != nil && !slices.Equal(b.labels, labels) {
+			d.anomalies.DeleteLabelValues(b.labels...)
+		}
+		b.labels = labels
+
+		a, ok := d.check(b, s)
+		b.add(s.ActiveLeases, d.cfg.Alpha)
+		flag := 0.0
+		if ok {
+			anomalies = append(anomalies, a)
+			flag = 1
+			if a.Direction == DirectionDrop {
+				flag = -1
+			}
+		}
+		d.anomalies.WithLabelValues(labels...).Set(flag)
+	}
+	for key, b := range previous {
+		if _, ok := current[key]; !ok {
+			d.anomalies.DeleteLabelValues(b.labels...)
+		}
+	}
+	d.baselines[org] = current
+	d.observed[org] = observation{at: at, anomalies: anomalies}
+	return anomalies
+}
+
+// check returns the anomaly of s against b, if any.
+func (d *Detector) check(b *baseline, s cls.ServerActiveLeaseSnapshot) (Anomaly, bool) {
+	if b.samples < d.cfg.Warmup {
+		return Anomaly{}, false
+	}
+	stdDev := math.Sqrt(b.variance)
+	deviation := s.ActiveLeases - b.mean
+	if math.Abs(deviation) < max(d.cfg.Threshold*stdDev, d.cfg.MinDeviation) {
+		return Anomaly{}, false
+	}
+	a := Anomaly{
+		VirtualGroupID:   s.VirtualGroupID,
+		VirtualGroupName: s.VirtualGroupName,
+		ServerID:         s.ServerID,
+		ServerName:       s.ServerName,
+		Direction:        DirectionSpike,
+		ActiveLeases:     s.ActiveLeases,
+		Mean:             b.mean,
+		StdDev:           stdDev,
+	}
+	if deviation < 0 {
+		a.Direction = DirectionDrop
+	}
+	return a, true
+}
+
+// add adds v to the moving mean and variance with weight alpha. The first
+// value starts the mean with no variance.
+func (b *baseline) add(v, alpha float64) {
+	b.samples++
+	if b.samples == 1 {
+		b.mean = v
+		return
+	}
+	diff := v - b.mean
+	increment := alpha * diff
+	b.mean += increment
+	b.variance = (1 - alpha) * (b.variance + diff*increment)
+}
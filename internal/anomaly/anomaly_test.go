@@ -0,0 +1,82 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+func testDetector(t *testing.T) *Detector {
+	t.Helper()
+	manager, err := snapshot.NewManager(snapshot.NewService(nil, snapshot.Config{Target: "org-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDetector(Config{Alpha: 0.1, Threshold: 3, Warmup: 10, MinDeviation: 5}, manager)
+}
+
+func leases(at time.Time, values ...float64) *cls.Snapshot {
+	snap := &cls.Snapshot{CollectedAt: at}
+	for i, v := range values {
+		id := string(rune('1' + i))
+		snap.ServerActiveLeases = append(snap.ServerActiveLeases, cls.ServerActiveLeaseSnapshot{
+			VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-" + id, ServerName: "dls-" + id, ActiveLeases: v,
+		})
+	}
+	return snap
+}
+
+func TestObserve(t *testing.T) {
+	d := testDetector(t)
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+
+	// A large change during the warmup is not flagged.
+	d.Observe("org-2", leases(at(0), 10))
+	if got := d.Observe("org-2", leases(at(1), 100)); len(got) != 0 {
+		t.Fatalf("flagged during warmup: %+v", got)
+	}
+	// srv-1 moves between 48 and 52, srv-2 stays at 10.
+	for i := range 20 {
+		if got := d.Observe("org-1", leases(at(i), float64(48+4*(i%2)), 10)); len(got) != 0 {
+			t.Fatalf("refresh %d flagged normal leases: %+v", i, got)
+		}
+	}
+	// A move of 3 leases on srv-2 is below MinDeviation.
+	if got := d.Observe("org-1", leases(at(20), 50, 13)); len(got) != 0 {
+		t.Fatalf("flagged a small change: %+v", got)
+	}
+
+	snap := leases(at(21), 0, 30)
+	got := d.Observe("org-1", snap)
+	if len(got) != 2 {
+		t.Fatalf("expected a drop and a spike, got %+v", got)
+	}
+	if a := got[0]; a.ServerID != "srv-1" || a.Direction != DirectionDrop || a.ActiveLeases != 0 || a.Mean < 45 || a.StdDev <= 0 {
+		t.Errorf("unexpected drop %+v", a)
+	}
+	if a := got[1]; a.ServerID != "srv-2" || a.Direction != DirectionSpike {
+		t.Errorf("unexpected spike %+v", a)
+	}
+	if v := testutil.ToFloat64(d.anomalies.WithLabelValues("org-1", "1", "VG", "srv-1", "dls-1")); v != -1 {
+		t.Errorf("expected -1 for the drop, got %v", v)
+	}
+	if v := testutil.ToFloat64(d.anomalies.WithLabelValues("org-1", "1", "VG", "srv-2", "dls-2")); v != 1 {
+		t.Errorf("expected 1 for the spike, got %v", v)
+	}
+
+	// The same snapshot is not added twice.
+	mean := d.baselines["org-1"][serverKey{1, "srv-1"}].mean
+	if again := d.Observe("org-1", snap); len(again) != 2 || d.baselines["org-1"][serverKey{1, "srv-1"}].mean != mean {
+		t.Errorf("observing a snapshot again changed the baseline or anomalies: %+v", again)
+	}
+
+	// A removed server loses its gauge.
+	d.Observe("org-1", leases(at(22), 50))
+	if n := testutil.CollectAndCount(d.anomalies); n != 2 {
+		t.Errorf("expected the gauges of srv-1 of both orgs only, got %d", n)
+	}
+}
@@ -0,0 +1,180 @@
+// Package cloud authenticates requests to AWS and Google Cloud APIs with
+// the credentials their own tooling finds in the environment, such as
+// AWS_ACCESS_KEY_ID or the GCE metadata server.
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds the responses of credential endpoints.
+const maxResponseBytes = 1 << 20
+
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSRegion returns AWS_REGION, or else AWS_DEFAULT_REGION.
+func AWSRegion() string {
+	if region := strings.TrimSpace(os.Getenv("AWS_REGION")); region != "" {
+		return region
+	}
+	return strings.TrimSpace(os.Getenv("AWS_DEFAULT_REGION"))
+}
+
+// AWSEndpoint returns the first of the environment variables keys that is
+// set, such as AWS_ENDPOINT_URL_S3 then AWS_ENDPOINT_URL, or else fallback.
+func AWSEndpoint(fallback string, keys ...string) string {
+	for _, key := range keys {
+		if override := strings.TrimSpace(os.Getenv(key)); override != "" {
+			return strings.TrimSuffix(override, "/")
+		}
+	}
+	return fallback
+}
+
+// LoadAWSCredentials returns the credentials in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or else those of a web
+// identity (EKS IAM roles for service accounts) through
+// AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, exchanged with STS in region.
+func LoadAWSCredentials(ctx context.Context, client *http.Client, region string) (AWSCredentials, error) {
+	creds := AWSCredentials{
+		AccessKeyID:     strings.TrimSpace(os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: strings.TrimSpace(os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    strings.TrimSpace(os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+	tokenFile := strings.TrimSpace(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	roleARN := strings.TrimSpace(os.Getenv("AWS_ROLE_ARN"))
+	if tokenFile == "" || roleARN == "" {
+		return creds, errors.New("no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN")
+	}
+	return assumeRoleWithWebIdentity(ctx, client, region, roleARN, tokenFile)
+}
+
+// assumeRoleWithWebIdentity exchanges the projected service account token
+// for temporary credentials. The call is not signed.
+func assumeRoleWithWebIdentity(ctx context.Context, client *http.Client, region, roleARN, tokenFile string) (AWSCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("read AWS_WEB_IDENTITY_TOKEN_FILE: %w", err)
+	}
+	sessionName := strings.TrimSpace(os.Getenv("AWS_ROLE_SESSION_NAME"))
+	if sessionName == "" {
+		sessionName = "nvidia-license-server-exporter"
+	}
+	endpoint := AWSEndpoint("https://sts."+region+".amazonaws.com", "AWS_ENDPOINT_URL_STS")
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("assume role: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("assume role: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return AWSCredentials{}, fmt.Errorf("assume role %s: status %d: %s", roleARN, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return AWSCredentials{}, fmt.Errorf("assume role: decode response: %w", err)
+	}
+	return AWSCredentials(result.Credentials), nil
+}
+
+// SignAWSv4 adds AWS Signature Version 4 headers to req, signing every
+// header already set plus Host and X-Amz-Date.
+func SignAWSv4(req *http.Request, body []byte, creds AWSCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		// Encode sorts by key, as SigV4 requires.
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
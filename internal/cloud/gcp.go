@@ -0,0 +1,60 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// GCPToken returns the access token in GOOGLE_OAUTH_ACCESS_TOKEN, or else
+// one of the default service account from the metadata server, as on GCE
+// and GKE with Workload Identity. GCE_METADATA_HOST overrides the metadata
+// server, as with the Google Cloud client libraries.
+func GCPToken(ctx context.Context, client *http.Client) (string, error) {
+	if token := strings.TrimSpace(os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")); token != "" {
+		return token, nil
+	}
+	host := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST"))
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	token, err := metadataToken(ctx, client, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("no GCP access token: set GOOGLE_OAUTH_ACCESS_TOKEN or run with a metadata server: %w", err)
+	}
+	return token, nil
+}
+
+func metadataToken(ctx context.Context, client *http.Client, tokenURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: status %d: %s", tokenURL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("decode token: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("GET %s: no access_token", tokenURL)
+	}
+	return result.AccessToken, nil
+}
@@ -0,0 +1,241 @@
+// Package cloudwatch publishes the core gauges of every target to Amazon
+// CloudWatch with PutMetricData, so license exhaustion can be alarmed on
+// with CloudWatch alarms.
+package cloudwatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/cloud"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	DefaultNamespace = "NVIDIA/CLS"
+
+	defaultInterval = 60 * time.Second
+	defaultTimeout  = 10 * time.Second
+	// maxDatums is the most metric data sent per PutMetricData request, well
+	// within the API's limits of 1000 data and 1 MB.
+	maxDatums = 500
+	// maxErrorBytes bounds the error responses read.
+	maxErrorBytes = 64 << 10
+)
+
+type Config struct {
+	// Namespace holds the metrics; DefaultNamespace when empty.
+	Namespace string
+	// Region is the CloudWatch region; AWS_REGION or AWS_DEFAULT_REGION
+	// when empty.
+	Region   string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Publisher periodically sends the core gauges of every target to
+// CloudWatch. The metric name is the Prometheus name without nvidia_cls_,
+// and every non-empty label becomes a dimension, so
+// nvidia_cls_license_server_feature_active_leases becomes
+// license_server_feature_active_leases with the dimensions org_name,
+// virtual_group_id, ..., license_type.
+type Publisher struct {
+	cfg      Config
+	manager  *snapshot.Manager
+	endpoint string
+	client   *http.Client
+
+	pushes *prometheus.CounterVec
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPublisher resolves the region and endpoint of cfg. Credentials come
+// from the environment at every push, as for the aws-sm secrets.
+// AWS_ENDPOINT_URL_CLOUDWATCH or AWS_ENDPOINT_URL override the endpoint,
+// e.g. for VPC endpoints.
+func NewPublisher(cfg Config, manager *snapshot.Manager) (*Publisher, error) {
+	cfg.Namespace = strings.TrimSpace(cfg.Namespace)
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultNamespace
+	}
+	if strings.HasPrefix(cfg.Namespace, "AWS/") {
+		return nil, fmt.Errorf("cloudwatch namespace %q is reserved for AWS services", cfg.Namespace)
+	}
+	cfg.Region = strings.TrimSpace(cfg.Region)
+	if cfg.Region == "" {
+		cfg.Region = cloud.AWSRegion()
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("cloudwatch region is required: set it or AWS_REGION")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	p := &Publisher{
+		cfg:      cfg,
+		manager:  manager,
+		endpoint: cloud.AWSEndpoint("https://monitoring."+cfg.Region+".amazonaws.com", "AWS_ENDPOINT_URL_CLOUDWATCH", "AWS_ENDPOINT_URL"),
+		client:   &http.Client{Timeout: cfg.Timeout},
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_cloudwatch_pushes_total",
+			Help: "CloudWatch pushes by result.",
+		}, []string{"result"}),
+		done: make(chan struct{}),
+	}
+	p.pushes.WithLabelValues("success")
+	p.pushes.WithLabelValues("failure")
+	return p, nil
+}
+
+// Collector exposes the push counters for a Prometheus registry.
+func (p *Publisher) Collector() prometheus.Collector {
+	return p.pushes
+}
+
+func (p *Publisher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go func() {
+		defer close(p.done)
+
+		p.pushOnce(ctx)
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.pushOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) pushOnce(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	n, err := p.Push(ctx)
+	if err != nil {
+		p.pushes.WithLabelValues("failure").Inc()
+		slog.ErrorContext(ctx, "cloudwatch push failed", "region", p.cfg.Region, "namespace", p.cfg.Namespace, "error", err)
+		return
+	}
+	p.pushes.WithLabelValues("success").Inc()
+	slog.DebugContext(ctx, "cloudwatch push succeeded", "region", p.cfg.Region, "namespace", p.cfg.Namespace, "metrics", n)
+}
+
+// Push sends the cached gauges of every target in as few requests as the
+// API allows and returns the number of metrics sent. It never triggers a
+// refresh.
+func (p *Publisher) Push(ctx context.Context) (int, error) {
+	var samples []exporter.Sample
+	for _, svc := range p.manager.Services() {
+		samples = append(samples, exporter.CoreSamples(svc)...)
+	}
+	creds, err := cloud.LoadAWSCredentials(ctx, p.client, p.cfg.Region)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for start := 0; start < len(samples); start += maxDatums {
+		end := min(start+maxDatums, len(samples))
+		if err := p.put(ctx, creds, encodeForm(p.cfg.Namespace, samples[start:end], now)); err != nil {
+			return start, err
+		}
+	}
+	return len(samples), nil
+}
+
+func (p *Publisher) put(ctx context.Context, creds cloud.AWSCredentials, form url.Values) error {
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	cloud.SignAWSv4(req, body, creds, p.cfg.Region, "monitoring", time.Now())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The error response names the reason, such as AccessDenied or
+		// InvalidParameterValue.
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+		return fmt.Errorf("put metric data: status %d: %s", resp.StatusCode, strings.TrimSpace(string(reason)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// encodeForm renders samples as a PutMetricData request of the query API.
+// Labels with an empty value are left out, as CloudWatch rejects empty
+// dimension values.
+func encodeForm(namespace string, samples []exporter.Sample, now time.Time) url.Values {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {namespace},
+	}
+	ts := now.UTC().Format(time.RFC3339)
+	for i, sample := range samples {
+		member := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(member+"MetricName", strings.TrimPrefix(sample.Name, "nvidia_cls_"))
+		form.Set(member+"Value", strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		form.Set(member+"Timestamp", ts)
+		form.Set(member+"Unit", unit(sample.Name))
+		n := 0
+		for _, label := range sample.Labels {
+			if label.Value == "" {
+				continue
+			}
+			n++
+			dimension := member + "Dimensions.member." + strconv.Itoa(n) + "."
+			form.Set(dimension+"Name", label.Name)
+			form.Set(dimension+"Value", label.Value)
+		}
+	}
+	return form
+}
+
+func unit(name string) string {
+	if strings.HasSuffix(name, "_seconds") {
+		return "Seconds"
+	}
+	return "None"
+}
@@ -0,0 +1,145 @@
+package cloudwatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// fetcher returns the active leases of servers license servers.
+type fetcher struct{ servers int }
+
+func (f fetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	snap := &cls.Snapshot{CollectedAt: time.Now().UTC()}
+	for i := range f.servers {
+		snap.ServerFeatureActiveLeases = append(snap.ServerFeatureActiveLeases, cls.ServerFeatureActiveLeaseSnapshot{
+			VirtualGroupID: 1, ServerID: "srv-" + strconv.Itoa(i), ServerName: "dls", FeatureName: "vGPU", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 7,
+		})
+	}
+	return snap, nil
+}
+
+func TestPublisherPutsMetricData(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		forms []url.Values
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/monitoring/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		forms = append(forms, r.PostForm)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_CLOUDWATCH", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	svc := snapshot.NewService(fetcher{servers: 600}, snapshot.Config{Target: "lic-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	publisher, err := NewPublisher(Config{Region: "eu-west-1"}, manager)
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	n, err := publisher.Push(context.Background())
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	// up, scrape duration and consecutive failures, then the leases.
+	if n != 603 || len(forms) != 2 {
+		t.Fatalf("expected 603 metrics in 2 requests, got %d in %d", n, len(forms))
+	}
+	form := forms[0]
+	for key, want := range map[string]string{
+		"Action":                         "PutMetricData",
+		"Namespace":                      "NVIDIA/CLS",
+		"MetricData.member.1.MetricName": "exporter_refresh_consecutive_failures",
+		"MetricData.member.1.Dimensions.member.1.Name":  "org_name",
+		"MetricData.member.1.Dimensions.member.1.Value": "lic-1",
+		"MetricData.member.3.MetricName":                "scrape_duration_seconds",
+		"MetricData.member.3.Unit":                      "Seconds",
+		"MetricData.member.4.MetricName":                "license_server_feature_active_leases",
+		"MetricData.member.4.Value":                     "7",
+		"MetricData.member.4.Unit":                      "None",
+		"MetricData.member.4.Dimensions.member.3.Name":  "virtual_group_name",
+		"MetricData.member.4.Dimensions.member.3.Value": "unknown",
+		"MetricData.member.4.Dimensions.member.4.Value": "srv-0",
+		"MetricData.member.4.Dimensions.member.8.Name":  "license_type",
+	} {
+		if got := form.Get(key); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+	if form.Has("MetricData.member.4.Dimensions.member.9.Name") || form.Has("MetricData.member.501.MetricName") {
+		t.Error("unexpected data in the first request")
+	}
+	if got := forms[1].Get("MetricData.member.103.MetricName"); got != "license_server_feature_active_leases" {
+		t.Errorf("expected the rest in the second request, got %q", got)
+	}
+}
+
+func TestPublisherReportsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("<ErrorResponse><Error><Code>InvalidParameterValue</Code></Error></ErrorResponse>"))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_CLOUDWATCH", srv.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	manager, err := snapshot.NewManager(snapshot.NewService(fetcher{}, snapshot.Config{Target: "lic-1"}))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	publisher, err := NewPublisher(Config{}, manager)
+	if err != nil {
+		t.Fatalf("new publisher: %v", err)
+	}
+	if _, err := publisher.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "InvalidParameterValue") {
+		t.Fatalf("expected the 400 to be reported, got %v", err)
+	}
+
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	if _, err := NewPublisher(Config{}, manager); err == nil {
+		t.Error("expected a missing region to be rejected")
+	}
+	if _, err := NewPublisher(Config{Region: "us-east-1", Namespace: "AWS/EC2"}, manager); err == nil {
+		t.Error("expected an AWS namespace to be rejected")
+	}
+}
+
+func TestEncodeFormSkipsEmptyDimensions(t *testing.T) {
+	form := encodeForm("Licensing", []exporter.Sample{
+		{Name: "nvidia_cls_up", Value: 1, Labels: []exporter.Label{{Name: "org_name", Value: ""}}},
+	}, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if form.Get("MetricData.member.1.Timestamp") != "2024-06-01T00:00:00Z" || form.Has("MetricData.member.1.Dimensions.member.1.Name") {
+		t.Fatalf("unexpected form %v", form)
+	}
+}
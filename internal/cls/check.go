@@ -0,0 +1,79 @@
+package cls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CheckStep is one API call made by Check.
+type CheckStep struct {
+	Operation string
+	Endpoint  string
+	Duration  time.Duration
+	// Items is the number of virtual groups or license servers returned.
+	Items int
+	Err   error
+}
+
+// CheckResult is the outcome of Check.
+type CheckResult struct {
+	Steps []CheckStep
+	// VirtualGroups is the number of virtual groups visible to the API key.
+	VirtualGroups int
+	// VirtualGroup is the virtual group whose license servers were listed,
+	// and LicenseServers their number.
+	VirtualGroup   string
+	LicenseServers int
+}
+
+// Check validates the credentials and connectivity with the two cheapest
+// calls a snapshot fetch starts with: listing the virtual groups and the
+// license servers of the first one. The returned result describes every
+// call made, also when err is non-nil.
+func (c *Client) Check(ctx context.Context) (*CheckResult, error) {
+	result := &CheckResult{}
+
+	start := time.Now()
+	virtualGroups, err := c.listVirtualGroups(ctx)
+	result.Steps = append(result.Steps, CheckStep{
+		Operation: "list virtual-groups",
+		Endpoint:  c.virtualGroupsEndpoint(),
+		Duration:  time.Since(start),
+		Items:     len(virtualGroups),
+		Err:       err,
+	})
+	if err != nil {
+		return result, err
+	}
+	result.VirtualGroups = len(virtualGroups)
+	if len(virtualGroups) == 0 {
+		return result, fmt.Errorf("no virtual groups visible in org %s", c.orgName)
+	}
+
+	vg := virtualGroups[0]
+	result.VirtualGroup = vg.Name
+	start = time.Now()
+	servers, err := c.listLicenseServers(ctx, vg.ID)
+	result.Steps = append(result.Steps, CheckStep{
+		Operation: "list license-servers",
+		Endpoint:  c.licenseServersEndpoint(vg.ID),
+		Duration:  time.Since(start),
+		Items:     len(servers),
+		Err:       err,
+	})
+	if err != nil {
+		return result, err
+	}
+	result.LicenseServers = len(servers)
+	return result, nil
+}
+
+// IsAuthError reports whether err is a 401 or 403 response, i.e. the API key
+// is invalid, expired or lacks access to the org.
+func IsAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
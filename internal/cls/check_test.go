@@ -0,0 +1,47 @@
+package cls
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	fake := &fakeCLS{}
+	client := newTestClient(t, fake)
+
+	result, err := client.Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if result.VirtualGroups != 1 || result.VirtualGroup != "VG" || result.LicenseServers != 1 || len(result.Steps) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if !strings.HasSuffix(result.Steps[1].Endpoint, "/v1/org/org-1/virtual-groups/101/license-servers") || result.Steps[1].Items != 1 {
+		t.Fatalf("unexpected license-servers step: %+v", result.Steps[1])
+	}
+	if fake.serverLists.Load() != 1 {
+		t.Fatalf("expected one license-servers call, got %d", fake.serverLists.Load())
+	}
+}
+
+func TestCheckReportsAuthFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "rotated", OrgName: "org-1"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	result, err := client.Check(context.Background())
+	if !IsAuthError(err) {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Err == nil {
+		t.Fatalf("expected one failed step, got %+v", result.Steps)
+	}
+}
@@ -2,15 +2,26 @@ package cls
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -18,26 +29,76 @@ const (
 	defaultBaseURL           = "https://api.licensing.nvidia.com"
 	defaultRequestTimeout    = 15 * time.Second
 	defaultParallelFetches   = 8
+	maxAutoParallelFetches   = 64
+	defaultMaxResponseBytes  = 64 << 20
 	defaultUserAgent         = "nvidia-license-server-exporter/0.1"
 	defaultContentTypeHeader = "application/json"
+	tracerName               = "nvidia-license-server-exporter/internal/cls"
 )
 
+// AutoParallelFetches selects automatic sizing in Config.ParallelFetches.
+const AutoParallelFetches = -1
+
 type Config struct {
 	BaseURL           string
 	APIKey            string
 	OrgName           string
 	ServiceInstanceID string
-	HTTPClient        *http.Client
-	ParallelFetches   int
+	// VirtualGroups limits fetches to the virtual groups with these names
+	// or IDs. Empty fetches every virtual group of the org.
+	VirtualGroups []string
+	// Shard and TotalShards split the virtual groups of the org between
+	// replicas: with TotalShards above 1, only those whose ID hashes to
+	// Shard are fetched. See ShardOf.
+	Shard       int
+	TotalShards int
+	HTTPClient  *http.Client
+	// TLSMinVersion and TLSCipherSuites restrict the TLS connections to the
+	// API when HTTPClient is nil. Zero values leave the Go defaults.
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	// ParallelFetches bounds the concurrent API calls of a fetch. Zero uses
+	// the default, and AutoParallelFetches sizes each phase of a fetch by
+	// its number of calls, up to a bound derived from GOMAXPROCS.
+	ParallelFetches int
+	// MaxResponseBytes bounds the size of a single decoded API response so a
+	// pathological payload cannot exhaust memory. Zero uses the default.
+	MaxResponseBytes int64
+	// TracerProvider records a span per snapshot fetch and per API call.
+	// Nil disables tracing.
+	TracerProvider trace.TracerProvider
 }
 
 type Client struct {
-	baseURL           string
-	apiKey            string
+	baseURL string
+	// apiKey is swapped by SetAPIKey while requests are in flight.
+	apiKey            atomic.Pointer[string]
 	orgName           string
 	serviceInstanceID string
+	virtualGroups     map[string]bool
+	shard             int
+	totalShards       int
 	httpClient        *http.Client
 	parallelFetches   int
+	maxResponseBytes  int64
+	tracer            trace.Tracer
+
+	requestsMu sync.Mutex
+	requests   map[apiRequestKey]float64
+}
+
+// APIRequestCount is the number of CLS API requests made for an operation
+// that ended with Code: the HTTP status code, or "error" when no response
+// was received.
+type APIRequestCount struct {
+	Operation string
+	Code      string
+	Count     float64
+}
+
+type apiRequestKey struct {
+	operation string
+	code      string
 }
 
 func NewClient(cfg Config) (*Client, error) {
@@ -47,6 +108,9 @@ func NewClient(cfg Config) (*Client, error) {
 	if strings.TrimSpace(cfg.OrgName) == "" {
 		return nil, errors.New("org name is required")
 	}
+	if cfg.TotalShards > 1 && (cfg.Shard < 0 || cfg.Shard >= cfg.TotalShards) {
+		return nil, fmt.Errorf("shard %d is not between 0 and %d", cfg.Shard, cfg.TotalShards-1)
+	}
 
 	baseURL := strings.TrimSpace(cfg.BaseURL)
 	if baseURL == "" {
@@ -57,23 +121,67 @@ func NewClient(cfg Config) (*Client, error) {
 	httpClient := cfg.HTTPClient
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: defaultRequestTimeout}
+		if cfg.TLSMinVersion != 0 || len(cfg.TLSCipherSuites) > 0 {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = &tls.Config{MinVersion: cfg.TLSMinVersion, CipherSuites: cfg.TLSCipherSuites}
+			httpClient.Transport = transport
+		}
 	}
 
 	parallelFetches := cfg.ParallelFetches
-	if parallelFetches <= 0 {
+	if parallelFetches <= 0 && parallelFetches != AutoParallelFetches {
 		parallelFetches = defaultParallelFetches
 	}
 
-	return &Client{
+	maxResponseBytes := cfg.MaxResponseBytes
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = defaultMaxResponseBytes
+	}
+
+	tracerProvider := cfg.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
+	}
+
+	var virtualGroups map[string]bool
+	for _, vg := range cfg.VirtualGroups {
+		if vg = strings.TrimSpace(vg); vg != "" {
+			if virtualGroups == nil {
+				virtualGroups = make(map[string]bool)
+			}
+			virtualGroups[vg] = true
+		}
+	}
+
+	c := &Client{
 		baseURL:           baseURL,
-		apiKey:            strings.TrimSpace(cfg.APIKey),
 		orgName:           strings.TrimSpace(cfg.OrgName),
 		serviceInstanceID: strings.TrimSpace(cfg.ServiceInstanceID),
+		virtualGroups:     virtualGroups,
+		shard:             cfg.Shard,
+		totalShards:       cfg.TotalShards,
 		httpClient:        httpClient,
 		parallelFetches:   parallelFetches,
-	}, nil
+		maxResponseBytes:  maxResponseBytes,
+		tracer:            tracerProvider.Tracer(tracerName),
+		requests:          make(map[apiRequestKey]float64),
+	}
+	c.SetAPIKey(cfg.APIKey)
+	return c, nil
 }
 
+// SetAPIKey replaces the API key used by subsequent requests, for credential
+// rotation without rebuilding the client. Empty keys are ignored.
+func (c *Client) SetAPIKey(key string) {
+	if key = strings.TrimSpace(key); key != "" {
+		c.apiKey.Store(&key)
+	}
+}
+
+// Snapshot is immutable once returned by FetchSnapshot or RefreshLeases:
+// it is shared between concurrent scrapes and pushes, so callers must not
+// modify it in place. RefreshLeases and other derivations build a new
+// Snapshot instead. Use Clone to obtain a private, mutable copy.
 type Snapshot struct {
 	CollectedAt               time.Time
 	EntitlementFeatures       []EntitlementFeatureSnapshot
@@ -83,6 +191,67 @@ type Snapshot struct {
 	ServerFeatureActiveLeases []ServerFeatureActiveLeaseSnapshot
 	ActiveLeaseTotal          float64
 	PoolUsage                 []PoolUsageSnapshot
+	// LeasesCollectedAt is when the active-lease sections were last
+	// fetched; it moves ahead of CollectedAt after a lease-only refresh.
+	LeasesCollectedAt time.Time
+	// Phases records how long each group of API calls took and how many
+	// items it returned.
+	Phases []Phase
+
+	// topology keeps the license servers per virtual group so that active
+	// leases can be re-fetched without listing servers again.
+	topology map[int][]licenseServer
+}
+
+// Clone returns a deep copy of s. The server topology is shared since it is
+// never modified after FetchSnapshot returns.
+func (s *Snapshot) Clone() *Snapshot {
+	if s == nil {
+		return nil
+	}
+	clone := *s
+	clone.EntitlementFeatures = slices.Clone(s.EntitlementFeatures)
+	clone.ServerFeatureCapacity = slices.Clone(s.ServerFeatureCapacity)
+	clone.ServerUsage = slices.Clone(s.ServerUsage)
+	clone.ServerActiveLeases = slices.Clone(s.ServerActiveLeases)
+	clone.ServerFeatureActiveLeases = slices.Clone(s.ServerFeatureActiveLeases)
+	clone.PoolUsage = slices.Clone(s.PoolUsage)
+	clone.Phases = slices.Clone(s.Phases)
+	return &clone
+}
+
+const (
+	PhaseVirtualGroups  = "virtual_groups"
+	PhaseLicenseServers = "license_servers"
+	PhaseActiveLeases   = "active_leases"
+	PhaseLicensePools   = "license_pools"
+)
+
+type Phase struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Items           int     `json:"items"`
+}
+
+type encodedSnapshot struct {
+	Snapshot
+	Topology map[int][]licenseServer `json:"topology,omitempty"`
+}
+
+// EncodeSnapshot serializes snap, including the server topology needed for
+// lease-only refreshes, so it can be shared through an external cache.
+func EncodeSnapshot(snap *Snapshot) ([]byte, error) {
+	return json.Marshal(encodedSnapshot{Snapshot: *snap, Topology: snap.topology})
+}
+
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var decoded encodedSnapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	snap := decoded.Snapshot
+	snap.topology = decoded.Topology
+	return &snap, nil
 }
 
 type EntitlementFeatureSnapshot struct {
@@ -159,19 +328,31 @@ type PoolUsageSnapshot struct {
 }
 
 func (c *Client) FetchSnapshot(ctx context.Context) (*Snapshot, error) {
+	ctx, span := c.tracer.Start(ctx, "cls.FetchSnapshot", trace.WithAttributes(attribute.String("org_name", c.orgName)))
+	snapshot, err := c.fetchSnapshot(ctx)
+	endSpan(span, err)
+	return snapshot, err
+}
+
+func (c *Client) fetchSnapshot(ctx context.Context) (*Snapshot, error) {
+	phaseStart := time.Now()
 	virtualGroups, err := c.listVirtualGroups(ctx)
 	if err != nil {
 		return nil, err
 	}
+	phases := []Phase{newPhase(PhaseVirtualGroups, phaseStart, len(virtualGroups))}
 
+	collectedAt := time.Now().UTC()
 	snapshot := &Snapshot{
-		CollectedAt:         time.Now().UTC(),
+		CollectedAt:         collectedAt,
+		LeasesCollectedAt:   collectedAt,
 		EntitlementFeatures: extractEntitlementFeatureMetrics(virtualGroups),
 	}
 
+	phaseStart = time.Now()
 	serversByVG := make(map[int][]licenseServer, len(virtualGroups))
 	serverGroup, groupCtx := errgroup.WithContext(ctx)
-	serverGroup.SetLimit(c.parallelFetches)
+	serverGroup.SetLimit(c.fetchLimit(len(virtualGroups)))
 
 	var serverMu sync.Mutex
 	for _, vg := range virtualGroups {
@@ -198,19 +379,29 @@ func (c *Client) FetchSnapshot(ctx context.Context) (*Snapshot, error) {
 	if err := serverGroup.Wait(); err != nil {
 		return nil, err
 	}
+	snapshot.topology = serversByVG
+	serverCount := 0
+	for _, servers := range serversByVG {
+		serverCount += len(servers)
+	}
+	phases = append(phases, newPhase(PhaseLicenseServers, phaseStart, serverCount))
 
+	phaseStart = time.Now()
 	activeByServer, serverActiveLeases, serverFeatureActiveLeases, activeLeaseTotal, err := c.fetchActiveLeaseUsage(ctx, serversByVG)
 	if err != nil {
 		return nil, err
 	}
+	phases = append(phases, newPhase(PhaseActiveLeases, phaseStart, int(activeLeaseTotal)))
 	snapshot.ActiveLeaseTotal = activeLeaseTotal
 	snapshot.ServerActiveLeases = serverActiveLeases
 	snapshot.ServerFeatureActiveLeases = serverFeatureActiveLeases
 
+	phaseStart = time.Now()
 	poolGroup, poolCtx := errgroup.WithContext(ctx)
-	poolGroup.SetLimit(c.parallelFetches)
+	poolGroup.SetLimit(c.fetchLimit(serverCount))
 
 	var snapshotMu sync.Mutex
+	poolCount := 0
 	for _, vg := range virtualGroups {
 		vg := vg
 		servers := serversByVG[vg.ID]
@@ -292,6 +483,7 @@ func (c *Client) FetchSnapshot(ctx context.Context) (*Snapshot, error) {
 				}
 
 				snapshotMu.Lock()
+				poolCount += len(pools)
 				snapshot.PoolUsage = append(snapshot.PoolUsage, poolUsage...)
 				snapshot.ServerUsage = append(snapshot.ServerUsage, serverUsage)
 				snapshot.ServerFeatureCapacity = append(snapshot.ServerFeatureCapacity, serverFeatureCapacity...)
@@ -303,10 +495,80 @@ func (c *Client) FetchSnapshot(ctx context.Context) (*Snapshot, error) {
 	if err := poolGroup.Wait(); err != nil {
 		return nil, err
 	}
+	snapshot.Phases = append(phases, newPhase(PhaseLicensePools, phaseStart, poolCount))
 
 	return snapshot, nil
 }
 
+// RefreshLeases re-fetches only the active-lease data for the servers known
+// to base and returns a new snapshot with the lease sections and server
+// usage replaced. base is not modified.
+func (c *Client) RefreshLeases(ctx context.Context, base *Snapshot) (*Snapshot, error) {
+	ctx, span := c.tracer.Start(ctx, "cls.RefreshLeases", trace.WithAttributes(attribute.String("org_name", c.orgName)))
+	merged, err := c.refreshLeases(ctx, base)
+	endSpan(span, err)
+	return merged, err
+}
+
+func (c *Client) refreshLeases(ctx context.Context, base *Snapshot) (*Snapshot, error) {
+	if base == nil || base.topology == nil {
+		return nil, errors.New("snapshot has no server topology to refresh leases for")
+	}
+
+	phaseStart := time.Now()
+	activeByServer, serverActiveLeases, serverFeatureActiveLeases, activeLeaseTotal, err := c.fetchActiveLeaseUsage(ctx, base.topology)
+	if err != nil {
+		return nil, err
+	}
+	leasePhase := newPhase(PhaseActiveLeases, phaseStart, int(activeLeaseTotal))
+
+	poolInUse := make(map[string]float64, len(base.ServerUsage))
+	for _, pool := range base.PoolUsage {
+		poolInUse[pool.ServerID] += pool.InUse
+	}
+
+	merged := *base
+	merged.LeasesCollectedAt = time.Now().UTC()
+	merged.ActiveLeaseTotal = activeLeaseTotal
+	merged.ServerActiveLeases = serverActiveLeases
+	merged.ServerFeatureActiveLeases = serverFeatureActiveLeases
+	merged.Phases = make([]Phase, 0, len(base.Phases))
+	for _, phase := range base.Phases {
+		if phase.Name == PhaseActiveLeases {
+			phase = leasePhase
+		}
+		merged.Phases = append(merged.Phases, phase)
+	}
+	merged.ServerUsage = make([]ServerUsageSnapshot, len(base.ServerUsage))
+	for i, usage := range base.ServerUsage {
+		inUse := poolInUse[usage.ServerID]
+		if activeLeaseCount, ok := activeByServer[usage.ServerID]; ok {
+			inUse = activeLeaseCount
+		}
+		usage.InUse = inUse
+		usage.Available = maxFloat64(0, usage.Allocated-inUse)
+		merged.ServerUsage[i] = usage
+	}
+	return &merged, nil
+}
+
+// fetchLimit returns the number of concurrent calls for a phase of a fetch
+// that makes calls API calls. With AutoParallelFetches, small orgs open no more connections
+// than they have calls, and large ones get up to four per available CPU,
+// since the calls mostly wait on the network, but no more than
+// maxAutoParallelFetches, to stay polite towards CLS.
+func (c *Client) fetchLimit(calls int) int {
+	if c.parallelFetches != AutoParallelFetches {
+		return c.parallelFetches
+	}
+	return autoParallelFetches(calls, runtime.GOMAXPROCS(0))
+}
+
+func autoParallelFetches(calls, procs int) int {
+	limit := min(max(4*procs, defaultParallelFetches), maxAutoParallelFetches)
+	return max(min(calls, limit), 1)
+}
+
 type activeFeatureKey struct {
 	virtualGroupID   int
 	virtualGroupName string
@@ -322,8 +584,14 @@ func (c *Client) fetchActiveLeaseUsage(ctx context.Context, serversByVG map[int]
 	featureTotals := make(map[activeFeatureKey]float64)
 	seenLeaseIDs := make(map[string]struct{})
 
+	serverCount := 0
+	for _, servers := range serversByVG {
+		serverCount += len(servers)
+	}
 	activeGroup, activeCtx := errgroup.WithContext(ctx)
-	activeGroup.SetLimit(c.parallelFetches)
+	// Servers of a virtual group mostly share a service instance, so this
+	// overestimates the calls; the surplus goroutines are never started.
+	activeGroup.SetLimit(c.fetchLimit(serverCount))
 
 	var total float64
 	var mu sync.Mutex
@@ -478,24 +746,55 @@ func extractEntitlementFeatureMetrics(virtualGroups []virtualGroup) []Entitlemen
 	return metrics
 }
 
-func (c *Client) listVirtualGroups(ctx context.Context) ([]virtualGroup, error) {
-	endpoint := fmt.Sprintf("%s/v1/org/%s/virtual-groups", c.baseURL, url.PathEscape(c.orgName))
-	var resp virtualGroupsResponse
-	if err := c.doJSON(ctx, http.MethodGet, endpoint, &resp, ""); err != nil {
-		return nil, err
-	}
-	return resp.VirtualGroups, nil
+func (c *Client) virtualGroupsEndpoint() string {
+	return fmt.Sprintf("%s/v1/org/%s/virtual-groups", c.baseURL, url.PathEscape(c.orgName))
 }
 
-func (c *Client) listLicenseServers(ctx context.Context, virtualGroupID int) ([]licenseServer, error) {
-	endpoint := fmt.Sprintf(
+func (c *Client) licenseServersEndpoint(virtualGroupID int) string {
+	return fmt.Sprintf(
 		"%s/v1/org/%s/virtual-groups/%d/license-servers",
 		c.baseURL,
 		url.PathEscape(c.orgName),
 		virtualGroupID,
 	)
+}
+
+func (c *Client) listVirtualGroups(ctx context.Context) ([]virtualGroup, error) {
+	var resp virtualGroupsResponse
+	if err := c.doJSON(ctx, "list virtual-groups", http.MethodGet, c.virtualGroupsEndpoint(), &resp, ""); err != nil {
+		return nil, err
+	}
+	if c.virtualGroups == nil && c.totalShards <= 1 {
+		return resp.VirtualGroups, nil
+	}
+	selected := resp.VirtualGroups[:0]
+	for _, vg := range resp.VirtualGroups {
+		if c.virtualGroups != nil && !c.virtualGroups[vg.Name] && !c.virtualGroups[strconv.Itoa(vg.ID)] {
+			continue
+		}
+		if c.totalShards > 1 && ShardOf(vg.ID, c.totalShards) != c.shard {
+			continue
+		}
+		selected = append(selected, vg)
+	}
+	return selected, nil
+}
+
+// ShardOf returns the shard, from 0 to totalShards-1, that fetches the
+// virtual group with the given ID. It hashes the ID rather than the name,
+// which can be changed, so that a virtual group stays on its shard.
+func ShardOf(virtualGroupID, totalShards int) int {
+	if totalShards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strconv.Itoa(virtualGroupID)))
+	return int(h.Sum32() % uint32(totalShards))
+}
+
+func (c *Client) listLicenseServers(ctx context.Context, virtualGroupID int) ([]licenseServer, error) {
 	var resp licenseServersResponse
-	if err := c.doJSON(ctx, http.MethodGet, endpoint, &resp, ""); err != nil {
+	if err := c.doJSON(ctx, "list license-servers", http.MethodGet, c.licenseServersEndpoint(virtualGroupID), &resp, ""); err != nil {
 		return nil, err
 	}
 	return resp.LicenseServers, nil
@@ -510,7 +809,7 @@ func (c *Client) listLicensePools(ctx context.Context, virtualGroupID int, serve
 		url.PathEscape(serverID),
 	)
 	var resp licensePoolsResponse
-	if err := c.doJSON(ctx, http.MethodGet, endpoint, &resp, ""); err != nil {
+	if err := c.doJSON(ctx, "list license-pools", http.MethodGet, endpoint, &resp, ""); err != nil {
 		return nil, err
 	}
 	return resp.LicensePools, nil
@@ -524,18 +823,44 @@ func (c *Client) listActiveLeases(ctx context.Context, virtualGroupID int, servi
 		virtualGroupID,
 	)
 	var resp activeLeasesResponse
-	if err := c.doJSON(ctx, http.MethodGet, endpoint, &resp, serviceInstanceID); err != nil {
+	if err := c.doJSON(ctx, "list leases", http.MethodGet, endpoint, &resp, serviceInstanceID); err != nil {
 		return nil, err
 	}
 	return resp.Clients, nil
 }
 
-func (c *Client) doJSON(ctx context.Context, method, endpoint string, out any, serviceInstanceID string) error {
+// doJSON performs one API call inside a span named after operation.
+func (c *Client) doJSON(ctx context.Context, operation, method, endpoint string, out any, serviceInstanceID string) (err error) {
+	ctx, span := c.tracer.Start(ctx, "cls "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.full", endpoint),
+			// The client does not retry; a failed call is repeated by
+			// the next refresh, under a span of its own.
+			attribute.Int("cls.retry_count", 0),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	status, requestID := "error", ""
+	defer func() {
+		attrs := []any{"org", c.orgName, "operation", operation, "endpoint", endpoint, "status", status, "duration", time.Since(start)}
+		if requestID != "" {
+			attrs = append(attrs, "cls_request_id", requestID)
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		slog.DebugContext(ctx, "cls api call", attrs...)
+	}()
+
 	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("x-api-key", *c.apiKey.Load())
 	req.Header.Set("accept", defaultContentTypeHeader)
 	req.Header.Set("user-agent", defaultUserAgent)
 	headerServiceInstanceID := strings.TrimSpace(serviceInstanceID)
@@ -548,24 +873,93 @@ func (c *Client) doJSON(ctx context.Context, method, endpoint string, out any, s
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		c.countRequest(operation, "error")
 		return err
 	}
 	defer resp.Body.Close()
+	status = strconv.Itoa(resp.StatusCode)
+	c.countRequest(operation, status)
+	requestID = resp.Header.Get("x-request-id")
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if requestID != "" {
+		span.SetAttributes(attribute.String("cls.request_id", requestID))
+	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("request %s failed with status %d", endpoint, resp.StatusCode)
+		return &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, RequestID: requestID}
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+	body := http.MaxBytesReader(nil, resp.Body, c.maxResponseBytes)
+	if err := json.NewDecoder(body).Decode(out); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return fmt.Errorf("response from %s exceeds %d bytes", endpoint, maxErr.Limit)
+		}
 		return err
 	}
 	return nil
 }
 
+func (c *Client) countRequest(operation, code string) {
+	c.requestsMu.Lock()
+	c.requests[apiRequestKey{operation: operation, code: code}]++
+	c.requestsMu.Unlock()
+}
+
+// APIRequests returns the number of API requests made since the client was
+// created, sorted by operation and code.
+func (c *Client) APIRequests() []APIRequestCount {
+	c.requestsMu.Lock()
+	counts := make([]APIRequestCount, 0, len(c.requests))
+	for key, count := range c.requests {
+		counts = append(counts, APIRequestCount{Operation: key.operation, Code: key.code, Count: count})
+	}
+	c.requestsMu.Unlock()
+
+	slices.SortFunc(counts, func(a, b APIRequestCount) int {
+		if a.Operation != b.Operation {
+			return strings.Compare(a.Operation, b.Operation)
+		}
+		return strings.Compare(a.Code, b.Code)
+	})
+	return counts
+}
+
+// APIError reports a non-2xx response from the CLS API.
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	// RequestID is the x-request-id response header, if NVIDIA sent one.
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("request %s failed with status %d request_id=%s", e.Endpoint, e.StatusCode, e.RequestID)
+	}
+	return fmt.Sprintf("request %s failed with status %d", e.Endpoint, e.StatusCode)
+}
+
 func (c *Client) OrgName() string {
 	return c.orgName
 }
 
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func newPhase(name string, start time.Time, items int) Phase {
+	return Phase{
+		Name:            name,
+		DurationSeconds: time.Since(start).Seconds(),
+		Items:           items,
+	}
+}
+
 func maxFloat64(a, b float64) float64 {
 	if a > b {
 		return a
@@ -0,0 +1,394 @@
+package cls
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fakeCLS struct {
+	leaseCount  atomic.Int64
+	serverLists atomic.Int64
+}
+
+func (f *fakeCLS) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/org/org-1/virtual-groups", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"virtualGroups":[{"id":101,"name":"VG"}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/license-servers", func(w http.ResponseWriter, _ *http.Request) {
+		f.serverLists.Add(1)
+		_, _ = w.Write([]byte(`{"licenseServers":[{"id":"srv-1","name":"server-1","serviceInstanceId":"si-1",
+			"licenseServerFeatures":[{"id":"f-1","featureName":"Feature A","productName":"Product","licenseType":"TYPE","totalQuantity":10}]}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/license-servers/srv-1/license-pools", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"licensePools":[{"id":"pool-1","name":"pool",
+			"licensePoolFeatures":[{"licenseServerFeatureId":"f-1","totalAllotment":10,"inUse":2}]}]}`))
+	})
+	mux.HandleFunc("/v1/org/org-1/virtual-groups/101/leases", func(w http.ResponseWriter, _ *http.Request) {
+		if f.leaseCount.Load() == 0 {
+			_, _ = w.Write([]byte(`{"clients":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"clients":[{"additionalProperties":{"license_server_id":"srv-1"},
+			"leases":[{"leaseId":"l-1","featureName":"Feature A","leaseCount":` + strconv.FormatInt(f.leaseCount.Load(), 10) + `,"licenseAllotmentFeatureId":"f-1"}]}]}`))
+	})
+	return mux
+}
+
+func newTestClient(t *testing.T, fake *fakeCLS) *Client {
+	t.Helper()
+	srv := httptest.NewServer(fake.handler())
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return client
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	fake := &fakeCLS{}
+	fake.leaseCount.Store(3)
+	client := newTestClient(t, fake)
+
+	snap, err := client.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	if len(snap.ServerUsage) != 1 {
+		t.Fatalf("expected 1 server usage, got %d", len(snap.ServerUsage))
+	}
+	usage := snap.ServerUsage[0]
+	if usage.Allocated != 10 || usage.InUse != 3 || usage.Available != 7 {
+		t.Fatalf("unexpected server usage: %+v", usage)
+	}
+	if snap.ActiveLeaseTotal != 3 {
+		t.Fatalf("expected active lease total 3, got %v", snap.ActiveLeaseTotal)
+	}
+}
+
+func TestClientCountsAPIRequests(t *testing.T) {
+	fake := &fakeCLS{}
+	client := newTestClient(t, fake)
+
+	if _, err := client.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+
+	want := []APIRequestCount{
+		{Operation: "list leases", Code: "200", Count: 1},
+		{Operation: "list license-pools", Code: "200", Count: 1},
+		{Operation: "list license-servers", Code: "200", Count: 1},
+		{Operation: "list virtual-groups", Code: "200", Count: 1},
+	}
+	if got := client.APIRequests(); !slices.Equal(got, want) {
+		t.Fatalf("unexpected api request counts: %+v", got)
+	}
+}
+
+func TestFetchSnapshotRecordsPhases(t *testing.T) {
+	fake := &fakeCLS{}
+	fake.leaseCount.Store(3)
+	client := newTestClient(t, fake)
+
+	snap, err := client.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+
+	want := map[string]int{
+		PhaseVirtualGroups:  1,
+		PhaseLicenseServers: 1,
+		PhaseActiveLeases:   3,
+		PhaseLicensePools:   1,
+	}
+	if len(snap.Phases) != len(want) {
+		t.Fatalf("expected %d phases, got %+v", len(want), snap.Phases)
+	}
+	for _, phase := range snap.Phases {
+		if items, ok := want[phase.Name]; !ok || phase.Items != items {
+			t.Fatalf("unexpected phase %+v", phase)
+		}
+		if phase.DurationSeconds < 0 {
+			t.Fatalf("negative duration for phase %+v", phase)
+		}
+	}
+}
+
+func TestFetchSnapshotRecordsSpans(t *testing.T) {
+	srv := httptest.NewServer((&fakeCLS{}).handler())
+	t.Cleanup(srv.Close)
+	recorder := tracetest.NewSpanRecorder()
+	client, err := NewClient(Config{
+		BaseURL:        srv.URL,
+		APIKey:         "key",
+		OrgName:        "org-1",
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	if _, err := client.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+
+	spans := recorder.Ended()
+	var root sdktrace.ReadOnlySpan
+	calls := make(map[string]int)
+	for _, span := range spans {
+		if span.Name() == "cls.FetchSnapshot" {
+			root = span
+			continue
+		}
+		calls[span.Name()]++
+		attrs := attribute.NewSet(span.Attributes()...)
+		if status, ok := attrs.Value("http.response.status_code"); !ok || status.AsInt64() != http.StatusOK {
+			t.Fatalf("span %s missing status code: %v", span.Name(), span.Attributes())
+		}
+		if endpoint, ok := attrs.Value("url.full"); !ok || !strings.HasPrefix(endpoint.AsString(), srv.URL) {
+			t.Fatalf("span %s missing url: %v", span.Name(), span.Attributes())
+		}
+		if retries, ok := attrs.Value("cls.retry_count"); !ok || retries.AsInt64() != 0 {
+			t.Fatalf("span %s missing retry count: %v", span.Name(), span.Attributes())
+		}
+	}
+	if root == nil {
+		t.Fatalf("expected cls.FetchSnapshot span, got %d spans", len(spans))
+	}
+	for _, span := range spans {
+		if span != root && span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatalf("span %s is not a child of cls.FetchSnapshot", span.Name())
+		}
+	}
+	for _, name := range []string{"cls list virtual-groups", "cls list license-servers", "cls list leases", "cls list license-pools"} {
+		if calls[name] != 1 {
+			t.Fatalf("expected one %q span, got %v", name, calls)
+		}
+	}
+}
+
+func TestRefreshLeasesReusesTopology(t *testing.T) {
+	fake := &fakeCLS{}
+	client := newTestClient(t, fake)
+
+	base, err := client.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	if base.ServerUsage[0].InUse != 2 {
+		t.Fatalf("expected pool in-use without active leases, got %v", base.ServerUsage[0].InUse)
+	}
+
+	fake.leaseCount.Store(6)
+	merged, err := client.RefreshLeases(context.Background(), base)
+	if err != nil {
+		t.Fatalf("refresh leases: %v", err)
+	}
+	if fake.serverLists.Load() != 1 {
+		t.Fatalf("expected license servers to be listed once, got %d", fake.serverLists.Load())
+	}
+	if merged.ActiveLeaseTotal != 6 || merged.ServerUsage[0].InUse != 6 || merged.ServerUsage[0].Available != 4 {
+		t.Fatalf("unexpected merged snapshot: total=%v usage=%+v", merged.ActiveLeaseTotal, merged.ServerUsage[0])
+	}
+	if base.ServerUsage[0].InUse != 2 || base.ActiveLeaseTotal != 0 {
+		t.Fatalf("expected base snapshot to be left untouched")
+	}
+	if !merged.CollectedAt.Equal(base.CollectedAt) || merged.LeasesCollectedAt.Before(base.LeasesCollectedAt) {
+		t.Fatalf("unexpected timestamps: base=%v merged=%v/%v", base.CollectedAt, merged.CollectedAt, merged.LeasesCollectedAt)
+	}
+
+	if _, err := client.RefreshLeases(context.Background(), &Snapshot{}); err == nil {
+		t.Fatalf("expected error for snapshot without topology")
+	}
+}
+
+func TestDoJSONRejectsOversizedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"virtualGroups":[{"id":1,"name":"` + strings.Repeat("x", 512) + `"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1", MaxResponseBytes: 128})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, err = client.listVirtualGroups(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exceeds 128 bytes") {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1", TLSMinVersion: tls.VersionTLS13})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, err = client.listVirtualGroups(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Fatalf("expected a protocol version error, got %v", err)
+	}
+}
+
+func TestEncodeDecodeSnapshotKeepsTopology(t *testing.T) {
+	fake := &fakeCLS{}
+	client := newTestClient(t, fake)
+
+	snap, err := client.FetchSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+	data, err := EncodeSnapshot(snap)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := DecodeSnapshot(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !decoded.CollectedAt.Equal(snap.CollectedAt) || len(decoded.ServerUsage) != 1 {
+		t.Fatalf("unexpected decoded snapshot: %+v", decoded)
+	}
+
+	fake.leaseCount.Store(1)
+	if _, err := client.RefreshLeases(context.Background(), decoded); err != nil {
+		t.Fatalf("refresh leases on decoded snapshot: %v", err)
+	}
+}
+
+func TestSetAPIKeyRotatesKey(t *testing.T) {
+	var seen atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("x-api-key"))
+		_, _ = w.Write([]byte(`{"virtualGroups":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "old", OrgName: "org-1"})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	for _, key := range []string{"old", "new"} {
+		client.SetAPIKey(key)
+		if _, err := client.listVirtualGroups(context.Background()); err != nil {
+			t.Fatalf("list virtual groups: %v", err)
+		}
+		if got := seen.Load(); got != key {
+			t.Fatalf("expected x-api-key %q, got %q", key, got)
+		}
+	}
+	client.SetAPIKey("  ")
+	if got := *client.apiKey.Load(); got != "new" {
+		t.Fatalf("empty key must be ignored, got %q", got)
+	}
+}
+
+func TestAutoParallelFetches(t *testing.T) {
+	for _, tt := range []struct {
+		calls, procs, want int
+	}{
+		{0, 4, 1},
+		{3, 4, 3},
+		{100, 1, 8},
+		{100, 4, 16},
+		{1000, 64, 64},
+	} {
+		if got := autoParallelFetches(tt.calls, tt.procs); got != tt.want {
+			t.Errorf("autoParallelFetches(%d, %d) = %d, want %d", tt.calls, tt.procs, got, tt.want)
+		}
+	}
+
+	fake := &fakeCLS{}
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+	client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1", ParallelFetches: AutoParallelFetches})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.FetchSnapshot(context.Background()); err != nil {
+		t.Fatalf("fetch snapshot: %v", err)
+	}
+}
+
+func TestVirtualGroupsFilter(t *testing.T) {
+	fake := &fakeCLS{}
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		filter []string
+		want   int
+	}{
+		{nil, 1},
+		{[]string{"VG"}, 1},
+		{[]string{"101"}, 1},
+		{[]string{"Other", "7"}, 0},
+	} {
+		client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1", VirtualGroups: tt.filter})
+		if err != nil {
+			t.Fatalf("new client: %v", err)
+		}
+		snap, err := client.FetchSnapshot(context.Background())
+		if err != nil {
+			t.Fatalf("fetch snapshot: %v", err)
+		}
+		if len(snap.ServerUsage) != tt.want {
+			t.Errorf("filter %v: expected %d servers, got %d", tt.filter, tt.want, len(snap.ServerUsage))
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	fake := &fakeCLS{}
+	srv := httptest.NewServer(fake.handler())
+	defer srv.Close()
+
+	const total = 3
+	for shard := range total {
+		client, err := NewClient(Config{BaseURL: srv.URL, APIKey: "key", OrgName: "org-1", Shard: shard, TotalShards: total})
+		if err != nil {
+			t.Fatalf("new client: %v", err)
+		}
+		snap, err := client.FetchSnapshot(context.Background())
+		if err != nil {
+			t.Fatalf("fetch snapshot: %v", err)
+		}
+		want := 0
+		if ShardOf(101, total) == shard {
+			want = 1
+		}
+		if len(snap.ServerUsage) != want {
+			t.Errorf("shard %d: expected %d servers, got %d", shard, want, len(snap.ServerUsage))
+		}
+	}
+
+	counts := make([]int, 4)
+	for id := range 1000 {
+		counts[ShardOf(id, len(counts))]++
+	}
+	for shard, n := range counts {
+		if n < 200 || n > 300 {
+			t.Errorf("shard %d of %d got %d of 1000 virtual groups", shard, len(counts), n)
+		}
+	}
+	if _, err := NewClient(Config{APIKey: "key", OrgName: "org-1", Shard: 3, TotalShards: 3}); err == nil {
+		t.Error("expected an error for a shard out of range")
+	}
+}
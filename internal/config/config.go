@@ -0,0 +1,580 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+	"nvidia-license-server-exporter/internal/cloudwatch"
+	"nvidia-license-server-exporter/internal/graphite"
+	"nvidia-license-server-exporter/internal/leader"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/otel"
+	"nvidia-license-server-exporter/internal/report"
+	"nvidia-license-server-exporter/internal/snapshot"
+	"nvidia-license-server-exporter/internal/statsd"
+)
+
+// Config holds every exporter setting. Values are resolved with the
+// precedence flags > environment > config file > defaults; see Load.
+type Config struct {
+	// File is the config file the values were read from, if any. It is set
+	// by -config or CONFIG_FILE and cannot itself appear in the file.
+	File string `yaml:"-"`
+	// ShowVersion is set by -version. Load then skips the config file and
+	// environment.
+	ShowVersion bool `yaml:"-"`
+	// Targets are the orgs scraped after the org of the cls section; see
+	// ScrapeTargets. -target flags replace those of the config file.
+	Targets []Target `yaml:"targets"`
+	// Deprecations are the deprecated flags and environment variables Load
+	// found set, to be reported once logging is set up.
+	Deprecations []Deprecation `yaml:"-"`
+
+	Server         Server         `yaml:"server"`
+	Log            Log            `yaml:"log"`
+	CLS            CLS            `yaml:"cls"`
+	Cache          Cache          `yaml:"cache"`
+	LeaderElection LeaderElection `yaml:"leader_election"`
+	OTEL           OTEL           `yaml:"otel"`
+	RemoteWrite    RemoteWrite    `yaml:"remote_write"`
+	StatsD         StatsD         `yaml:"statsd"`
+	Influx         Influx         `yaml:"influx"`
+	Graphite       Graphite       `yaml:"graphite"`
+	CloudWatch     CloudWatch     `yaml:"cloudwatch"`
+	Alerts         Alerts         `yaml:"alerts"`
+	Anomaly        Anomaly        `yaml:"anomaly"`
+	HistoryDB      HistoryDB      `yaml:"history_db"`
+	Report         Report         `yaml:"report"`
+}
+
+type Server struct {
+	ListenAddress       string        `yaml:"listen_address"`
+	MetricsPath         string        `yaml:"metrics_path"`
+	HTTPDisabled        bool          `yaml:"http_disabled"`
+	AdminToken          string        `yaml:"admin_token"`
+	WebConfigFile       string        `yaml:"web_config_file"`
+	ReadyMaxAge         time.Duration `yaml:"ready_max_age"`
+	ShutdownDelay       time.Duration `yaml:"shutdown_delay"`
+	ReadHeaderTimeout   time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout         time.Duration `yaml:"read_timeout"`
+	WriteTimeout        time.Duration `yaml:"write_timeout"`
+	RequestTimeout      time.Duration `yaml:"request_timeout"`
+	IdleTimeout         time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes      int           `yaml:"max_header_bytes"`
+	MetricsMaxInflight  int           `yaml:"metrics_max_inflight"`
+	EnablePprof         bool          `yaml:"enable_pprof"`
+	DebugListenAddress  string        `yaml:"debug_listen_address"`
+	HealthListenAddress string        `yaml:"health_listen_address"`
+	AllowCIDRs          string        `yaml:"allow_cidrs"`
+	TrustedProxies      string        `yaml:"trusted_proxies"`
+	RateLimit           float64       `yaml:"rate_limit"`
+	RateLimitGlobal     float64       `yaml:"rate_limit_global"`
+	RateLimitBurst      int           `yaml:"rate_limit_burst"`
+	CORSAllowedOrigins  string        `yaml:"cors_allowed_origins"`
+	CORSAllowedMethods  string        `yaml:"cors_allowed_methods"`
+	AllowCacheBypass    bool          `yaml:"allow_cache_bypass"`
+	Warmup              bool          `yaml:"warmup"`
+}
+
+type Log struct {
+	Level               string  `yaml:"level"`
+	Format              string  `yaml:"format"`
+	AccessLog           string  `yaml:"access_log"`
+	AccessLogSampleRate float64 `yaml:"access_log_sample_rate"`
+	AccessLogExclude    string  `yaml:"access_log_exclude"`
+}
+
+type CLS struct {
+	BaseURL string `yaml:"base_url"`
+	OrgName string `yaml:"org_name"`
+	APIKey  string `yaml:"api_key"`
+	// APIKeyFile names a file holding the API key, read by Load into
+	// APIKey. APIKeySecret is a secrets manager URI (see package secrets)
+	// that the caller fetches, every APIKeySecretRefresh. At most one of
+	// APIKey, APIKeyFile and APIKeySecret may be set.
+	APIKeyFile string `yaml:"api_key_file"`
+	// APIKeyFilePollInterval is how often the caller re-reads APIKeyFile.
+	APIKeyFilePollInterval time.Duration `yaml:"api_key_file_poll_interval"`
+	APIKeySecret           string        `yaml:"api_key_secret"`
+	APIKeySecretRefresh    time.Duration `yaml:"api_key_secret_refresh"`
+	ServiceInstanceID      string        `yaml:"service_instance_id"`
+	ScrapeTimeout          time.Duration `yaml:"scrape_timeout"`
+	Parallelism            Parallelism   `yaml:"parallelism"`
+	MaxResponseBytes       int           `yaml:"max_response_bytes"`
+	// Shard and TotalShards split the virtual groups of every org between
+	// replicas by a hash of their ID; see cls.ShardOf.
+	Shard       int `yaml:"shard"`
+	TotalShards int `yaml:"total_shards"`
+	// TLSMinVersion and TLSCipherSuites, a comma-separated list, restrict
+	// the connections to the CLS API; see web.ParseTLSVersion and
+	// web.ParseCipherSuites.
+	TLSMinVersion   string `yaml:"tls_min_version"`
+	TLSCipherSuites string `yaml:"tls_cipher_suites"`
+}
+
+// Parallelism is the number of concurrent CLS API calls of a fetch, or
+// ParallelismAuto to size it per fetch from GOMAXPROCS and the number of
+// virtual groups and license servers found. It is written as a number or
+// "auto" in flags, environment variables and the config file.
+type Parallelism int
+
+const ParallelismAuto Parallelism = -1
+
+func (p Parallelism) String() string {
+	if p == ParallelismAuto {
+		return "auto"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// Set implements flag.Value.
+func (p *Parallelism) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "auto") {
+		*p = ParallelismAuto
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid parallelism %q: use a non-negative number or auto", s)
+	}
+	*p = Parallelism(n)
+	return nil
+}
+
+func (p Parallelism) MarshalYAML() (any, error) {
+	if p == ParallelismAuto {
+		return "auto", nil
+	}
+	return int(p), nil
+}
+
+func (p *Parallelism) UnmarshalYAML(unmarshal func(any) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	return p.Set(raw)
+}
+
+type Cache struct {
+	TTL                  time.Duration `yaml:"ttl"`
+	MaxStale             time.Duration `yaml:"max_stale"`
+	FailureBackoff       time.Duration `yaml:"failure_backoff"`
+	FailureBackoffMax    time.Duration `yaml:"failure_backoff_max"`
+	Backend              string        `yaml:"backend"`
+	Redis                Redis         `yaml:"redis"`
+	LeaseRefreshInterval time.Duration `yaml:"lease_refresh_interval"`
+	ValidationTolerance  float64       `yaml:"validation_tolerance"`
+	RejectInvalid        bool          `yaml:"reject_invalid_snapshots"`
+	MaxSnapshotBytes     int           `yaml:"max_snapshot_bytes"`
+	Compress             bool          `yaml:"compress_snapshots"`
+	CopyOnRead           bool          `yaml:"copy_on_read_snapshots"`
+	StalePolicy          string        `yaml:"stale_policy"`
+	HistorySize          int           `yaml:"history_size"`
+	HistoryInterval      time.Duration `yaml:"history_interval"`
+}
+
+type Redis struct {
+	Address   string `yaml:"address"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+// LeaderElection configures the Kubernetes Lease that elects the replica
+// which fetches from CLS; see package leader. Empty Namespace and Identity
+// mean the pod's namespace and the hostname, which is the pod name.
+type LeaderElection struct {
+	Enabled       bool          `yaml:"enabled"`
+	LeaseName     string        `yaml:"lease_name"`
+	Namespace     string        `yaml:"namespace"`
+	Identity      string        `yaml:"identity"`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewDeadline time.Duration `yaml:"renew_deadline"`
+	RetryPeriod   time.Duration `yaml:"retry_period"`
+}
+
+// OTEL mirrors the -otel-* flags. Lists and key=value pairs use the same
+// comma-separated strings as the flags.
+type OTEL struct {
+	Enabled               bool          `yaml:"enabled"`
+	Protocol              string        `yaml:"protocol"`
+	Endpoint              string        `yaml:"endpoint"`
+	EndpointMode          string        `yaml:"endpoint_mode"`
+	Headers               string        `yaml:"headers"`
+	ServiceName           string        `yaml:"service_name"`
+	ServiceInstanceID     string        `yaml:"service_instance_id"`
+	ServiceNamespace      string        `yaml:"service_namespace"`
+	DeploymentEnvironment string        `yaml:"deployment_environment"`
+	ResourceDetectors     string        `yaml:"resource_detectors"`
+	ResourceAttributes    string        `yaml:"resource_attributes"`
+	Insecure              bool          `yaml:"insecure"`
+	CAFile                string        `yaml:"ca_file"`
+	CertFile              string        `yaml:"cert_file"`
+	KeyFile               string        `yaml:"key_file"`
+	Temporality           string        `yaml:"temporality"`
+	Aggregation           string        `yaml:"aggregation"`
+	DropAttributes        string        `yaml:"drop_attributes"`
+	DurationHistogram     string        `yaml:"duration_histogram"`
+	ViewsFile             string        `yaml:"views_file"`
+	Retry                 OTELRetry     `yaml:"retry"`
+	PushInterval          time.Duration `yaml:"push_interval"`
+	MetricNames           string        `yaml:"metric_names"`
+	LeaseInstrument       string        `yaml:"lease_instrument"`
+	ExportTimeout         time.Duration `yaml:"export_timeout"`
+	MaxExportBatchSize    int           `yaml:"max_export_batch_size"`
+	Traces                bool          `yaml:"traces"`
+	Logs                  bool          `yaml:"logs"`
+}
+
+type OTELRetry struct {
+	Enabled         bool          `yaml:"enabled"`
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	MaxElapsedTime  time.Duration `yaml:"max_elapsed_time"`
+}
+
+type RemoteWrite struct {
+	URL         string        `yaml:"url"`
+	Interval    time.Duration `yaml:"interval"`
+	Timeout     time.Duration `yaml:"timeout"`
+	BearerToken string        `yaml:"bearer_token"`
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+	Headers     string        `yaml:"headers"`
+}
+
+type StatsD struct {
+	Address    string `yaml:"address"`
+	Prefix     string `yaml:"prefix"`
+	Tags       string `yaml:"tags"`
+	GlobalTags string `yaml:"global_tags"`
+}
+
+type Influx struct {
+	URL     string        `yaml:"url"`
+	Org     string        `yaml:"org"`
+	Bucket  string        `yaml:"bucket"`
+	Token   string        `yaml:"token"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type Graphite struct {
+	Address  string        `yaml:"address"`
+	Prefix   string        `yaml:"prefix"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CloudWatch publishes metrics with PutMetricData; see cloudwatch.Config.
+type CloudWatch struct {
+	Enabled   bool          `yaml:"enabled"`
+	Namespace string        `yaml:"namespace"`
+	Region    string        `yaml:"region"`
+	Interval  time.Duration `yaml:"interval"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+type Alerts struct {
+	// ConfigFile holds the alerting rules and notifiers; see
+	// alerts.LoadConfig.
+	ConfigFile string `yaml:"config_file"`
+}
+
+type Anomaly struct {
+	// Enabled turns on the lease anomaly detector; see anomaly.Config for
+	// the other fields.
+	Enabled      bool    `yaml:"enabled"`
+	Alpha        float64 `yaml:"alpha"`
+	Threshold    float64 `yaml:"threshold"`
+	Warmup       int     `yaml:"warmup"`
+	MinDeviation float64 `yaml:"min_deviation"`
+}
+
+type HistoryDB struct {
+	// Path is the bbolt file the refreshes are recorded in; empty disables
+	// the history database.
+	Path string `yaml:"path"`
+	// Retention applies to the records of every refresh, HourlyRetention
+	// and DailyRetention to their hourly and daily rollups.
+	Retention       time.Duration `yaml:"retention"`
+	HourlyRetention time.Duration `yaml:"hourly_retention"`
+	DailyRetention  time.Duration `yaml:"daily_retention"`
+	// ForecastWindow is how many days back the exhaustion forecasts are
+	// fitted to.
+	ForecastWindow time.Duration `yaml:"forecast_window"`
+}
+
+type Report struct {
+	// Schedule is a cron expression at which the audit report is written to
+	// Destination; empty disables scheduled reports.
+	Schedule string `yaml:"schedule"`
+	// Format is csv or xlsx.
+	Format string `yaml:"format"`
+	// Range is the reporting period before each scheduled time.
+	Range time.Duration `yaml:"range"`
+	// Destination is a directory, s3://<bucket>/<prefix> or
+	// gs://<bucket>/<prefix>; see report.ParseDestination.
+	Destination string `yaml:"destination"`
+}
+
+// Default returns the built-in defaults.
+func Default() *Config {
+	return &Config{
+		Targets: []Target{},
+		Server: Server{
+			ListenAddress:      ":9844",
+			MetricsPath:        "/metrics",
+			ReadyMaxAge:        10 * time.Minute,
+			ReadHeaderTimeout:  10 * time.Second,
+			ReadTimeout:        30 * time.Second,
+			WriteTimeout:       60 * time.Second,
+			RequestTimeout:     45 * time.Second,
+			IdleTimeout:        2 * time.Minute,
+			MaxHeaderBytes:     64 << 10,
+			MetricsMaxInflight: 10,
+			CORSAllowedMethods: "GET",
+			DebugListenAddress: "127.0.0.1:6060",
+			RateLimitBurst:     10,
+		},
+		Log: Log{
+			Level:               "info",
+			Format:              logging.FormatText,
+			AccessLog:           "log",
+			AccessLogSampleRate: 1,
+		},
+		CLS: CLS{
+			BaseURL:                "https://api.licensing.nvidia.com",
+			APIKeyFilePollInterval: 10 * time.Second,
+			APIKeySecretRefresh:    5 * time.Minute,
+			ScrapeTimeout:          20 * time.Second,
+			Parallelism:            8,
+			MaxResponseBytes:       64 << 20,
+			TotalShards:            1,
+			TLSMinVersion:          "TLS12",
+		},
+		Cache: Cache{
+			TTL:                 60 * time.Second,
+			FailureBackoff:      30 * time.Second,
+			FailureBackoffMax:   10 * time.Minute,
+			Backend:             "memory",
+			Redis:               Redis{Address: "127.0.0.1:6379", KeyPrefix: "nvidia-license-server-exporter/"},
+			ValidationTolerance: 0.05,
+			StalePolicy:         string(snapshot.StaleServe),
+			HistoryInterval:     time.Hour,
+		},
+		LeaderElection: LeaderElection{
+			LeaseName:     "nvidia-license-server-exporter",
+			LeaseDuration: leader.DefaultLeaseDuration,
+			RenewDeadline: leader.DefaultRenewDeadline,
+			RetryPeriod:   leader.DefaultRetryPeriod,
+		},
+		OTEL: OTEL{
+			Protocol:          otel.ProtocolGRPC,
+			EndpointMode:      otel.EndpointModeFanout,
+			ServiceName:       "nvidia-license-server-exporter",
+			ServiceInstanceID: hostnameOrUnknown(),
+			ResourceDetectors: strings.Join(otel.DefaultResourceDetectors, ","),
+			Insecure:          true,
+			Temporality:       otel.TemporalityCumulative,
+			DurationHistogram: otel.DurationHistogramExponential,
+			Retry: OTELRetry{
+				Enabled:         true,
+				InitialInterval: 5 * time.Second,
+				MaxInterval:     30 * time.Second,
+				MaxElapsedTime:  time.Minute,
+			},
+			PushInterval:    60 * time.Second,
+			MetricNames:     otel.MetricNamesPrometheus,
+			LeaseInstrument: otel.LeaseInstrumentGauge,
+			ExportTimeout:   30 * time.Second,
+		},
+		RemoteWrite: RemoteWrite{
+			Interval: 60 * time.Second,
+			Timeout:  30 * time.Second,
+		},
+		StatsD: StatsD{
+			Tags: statsd.TagsDogStatsD,
+		},
+		Influx: Influx{
+			Timeout: 10 * time.Second,
+		},
+		Graphite: Graphite{
+			Prefix:   graphite.DefaultPrefix,
+			Interval: 60 * time.Second,
+		},
+		CloudWatch: CloudWatch{
+			Namespace: cloudwatch.DefaultNamespace,
+			Interval:  60 * time.Second,
+			Timeout:   10 * time.Second,
+		},
+		Anomaly: Anomaly{
+			Alpha:        0.1,
+			Threshold:    3,
+			Warmup:       20,
+			MinDeviation: 5,
+		},
+		HistoryDB: HistoryDB{
+			Retention:       7 * 24 * time.Hour,
+			HourlyRetention: 90 * 24 * time.Hour,
+			ForecastWindow:  30 * 24 * time.Hour,
+		},
+		Report: Report{
+			Format: report.FormatCSV,
+			Range:  30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Load resolves the configuration from command-line args (without the
+// program name), the environment and the config file named by -config or
+// CONFIG_FILE. Flags win over environment variables, which win over the
+// file, which wins over Default. An invalid environment value is ignored
+// and the next source applies; invalid flags or file contents are errors.
+// flag.ErrHelp is returned for -h.
+func Load(args []string) (*Config, error) {
+	return LoadWith(args, nil)
+}
+
+// LoadWith is Load for commands with flags of their own: register, if not
+// nil, defines them on the flag set before args are parsed. They are set
+// from args only and are listed by -h with the configuration flags.
+func LoadWith(args []string, register func(*flag.FlagSet)) (*Config, error) {
+	// Parse once against the defaults to learn the config file and which
+	// flags were given, then rebuild in precedence order.
+	cfg := Default()
+	fs := newFlagSet(cfg)
+	if register != nil {
+		register(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if cfg.ShowVersion {
+		return cfg, nil
+	}
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	resolved := Default()
+	resolved.File = cfg.File
+	if resolved.File == "" {
+		resolved.File = strings.TrimSpace(os.Getenv("CONFIG_FILE"))
+	}
+	if resolved.File != "" {
+		if err := resolved.loadFile(resolved.File); err != nil {
+			return nil, err
+		}
+	}
+	resolvedFlags := newFlagSet(resolved)
+	resolved.applyEnv(resolvedFlags)
+	if strings.TrimSpace(resolved.OTEL.ServiceInstanceID) == "" {
+		// Also when the file sets it to "", as the example does.
+		resolved.OTEL.ServiceInstanceID = hostnameOrUnknown()
+	}
+	for name, value := range explicit {
+		if name == "target" {
+			// Repeated; the first parse collected every value.
+			resolved.Targets = cfg.Targets
+			continue
+		}
+		if resolvedFlags.Lookup(name) == nil {
+			// Registered by the caller and already set by the first parse.
+			continue
+		}
+		target := name
+		if replacement, ok := renamedFlag(name); ok {
+			resolved.Deprecations = append(resolved.Deprecations, Deprecation{Name: "-" + name, Replacement: "-" + replacement})
+			if _, set := explicit[replacement]; set {
+				continue
+			}
+			target = replacement
+		}
+		if err := resolvedFlags.Set(target, value); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %w", name, err)
+		}
+	}
+	slices.SortFunc(resolved.Deprecations, func(a, b Deprecation) int { return strings.Compare(a.Name, b.Name) })
+	if err := resolved.CLS.resolveAPIKey(); err != nil {
+		return nil, err
+	}
+	for i := range resolved.Targets {
+		if err := resolved.Targets[i].resolveAPIKey(); err != nil {
+			return nil, fmt.Errorf("invalid target %s: %w", resolved.Targets[i], err)
+		}
+	}
+	return resolved, nil
+}
+
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", "", "YAML config file; flags and environment variables take precedence over it.")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "Print version and build information, then exit.")
+	fs.Var(targetFlag{&cfg.Targets}, "target", "Another org to scrape, as org=<org>,api-key-file=<path>[,service-instance-id=<id>]; repeat for more.")
+	for _, s := range cfg.settings() {
+		s.register(fs)
+	}
+	registerRenamed(fs)
+	return fs
+}
+
+// loadFile overlays the YAML file at path. Unknown keys are rejected so that
+// typos do not silently fall back to defaults.
+func (c *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(raw, c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overlays the environment. For settings with several variables,
+// the first non-empty one wins. PORT is honoured as ":<port>" when
+// LISTEN_ADDRESS is unset, for platforms that inject it.
+func (c *Config) applyEnv(fs *flag.FlagSet) {
+	for _, s := range c.settings() {
+		for _, key := range s.env {
+			raw := strings.TrimSpace(os.Getenv(key))
+			if raw == "" {
+				continue
+			}
+			previous := fs.Lookup(s.flag).Value.String()
+			if err := fs.Set(s.flag, raw); err != nil {
+				// flag values are zeroed on a parse error.
+				_ = fs.Set(s.flag, previous)
+			}
+			if replacement, ok := renamedEnv(key); ok {
+				c.Deprecations = append(c.Deprecations, Deprecation{Name: key, Replacement: replacement})
+			}
+			break
+		}
+	}
+	if strings.TrimSpace(os.Getenv("LISTEN_ADDRESS")) == "" {
+		if port := strings.TrimSpace(os.Getenv("PORT")); port != "" {
+			c.Server.ListenAddress = ":" + strings.TrimPrefix(port, ":")
+		}
+	}
+}
+
+func hostnameOrUnknown() string {
+	host, err := os.Hostname()
+	if err != nil || strings.TrimSpace(host) == "" {
+		return "unknown"
+	}
+	return host
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+)
+
+// renamed lists flags and environment variables that were renamed. The old
+// names keep working, with the lowest precedence among the names of their
+// setting, and every use is recorded in Config.Deprecations. Old
+// environment variables stay in the env list of their setting, after the
+// new one; old flags are registered as aliases forwarding to the new flag.
+var renamed = []rename{
+	{old: "NLS_ORG_NAME", new: "NVIDIA_ORG_NAME", env: true},
+	{old: "NLS_API_KEY", new: "NVIDIA_API_KEY", env: true},
+}
+
+type rename struct {
+	old, new string
+	env      bool
+}
+
+// Deprecation is a deprecated flag or environment variable set in the
+// configuration, and the name replacing it.
+type Deprecation struct {
+	Name        string
+	Replacement string
+}
+
+func (d Deprecation) String() string {
+	return fmt.Sprintf("%s is deprecated, use %s", d.Name, d.Replacement)
+}
+
+// renamedFlag returns the flag replacing the deprecated flag name, if it is
+// one.
+func renamedFlag(name string) (string, bool) {
+	for _, r := range renamed {
+		if !r.env && r.old == name {
+			return r.new, true
+		}
+	}
+	return "", false
+}
+
+// renamedEnv returns the variable replacing the deprecated environment
+// variable key, if it is one.
+func renamedEnv(key string) (string, bool) {
+	for _, r := range renamed {
+		if r.env && r.old == key {
+			return r.new, true
+		}
+	}
+	return "", false
+}
+
+// registerRenamed registers the deprecated flags of fs, next to the flags
+// replacing them, which must already be registered. A deprecated flag only
+// holds its value; LoadWith sets it on the replacement unless that is set
+// too.
+func registerRenamed(fs *flag.FlagSet) {
+	for _, r := range renamed {
+		if r.env {
+			continue
+		}
+		target := fs.Lookup(r.new)
+		if target == nil {
+			panic(fmt.Sprintf("config: deprecated flag -%s renamed to unknown flag -%s", r.old, r.new))
+		}
+		b, ok := target.Value.(interface{ IsBoolFlag() bool })
+		fs.Var(&aliasFlag{isBool: ok && b.IsBoolFlag()}, r.old, fmt.Sprintf("Deprecated: use -%s.", r.new))
+	}
+}
+
+// aliasFlag holds the value of a deprecated flag.
+type aliasFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *aliasFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *aliasFlag) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+func (f *aliasFlag) IsBoolFlag() bool { return f.isBool }
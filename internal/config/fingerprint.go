@@ -0,0 +1,25 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Fingerprint returns a short, stable hash of the effective configuration,
+// so that replicas meant to run the same configuration can be compared. It
+// hashes RedactedYAML, so secrets do not count, and leaves out the settings
+// that differ between replicas by design: the OTEL service instance ID,
+// which defaults to the hostname, the leader election identity and the
+// shard.
+func (c *Config) Fingerprint() (string, error) {
+	shared := *c
+	shared.OTEL.ServiceInstanceID = ""
+	shared.LeaderElection.Identity = ""
+	shared.CLS.Shard = 0
+	raw, err := shared.RedactedYAML()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+	"nvidia-license-server-exporter/internal/logging"
+)
+
+// Redacted returns a copy of c that is safe to show: API keys, tokens,
+// passwords, header values and URL passwords are replaced by
+// logging.Redacted. Empty secrets stay empty, so that it remains visible
+// which ones are set.
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, secret := range r.secretFields() {
+		if *secret != "" {
+			*secret = logging.Redacted
+		}
+	}
+	r.Targets = make([]Target, len(c.Targets))
+	for i, t := range c.Targets {
+		if t.APIKey != "" {
+			t.APIKey = logging.Redacted
+		}
+		r.Targets[i] = t
+	}
+	r.OTEL.Headers = redactPairs(r.OTEL.Headers)
+	r.RemoteWrite.Headers = redactPairs(r.RemoteWrite.Headers)
+	r.OTEL.Endpoint = redactURL(r.OTEL.Endpoint)
+	r.RemoteWrite.URL = redactURL(r.RemoteWrite.URL)
+	r.Influx.URL = redactURL(r.Influx.URL)
+	return &r
+}
+
+// secretFields returns the settings that hold a secret as a whole.
+func (c *Config) secretFields() []*string {
+	return []*string{
+		&c.CLS.APIKey,
+		&c.Server.AdminToken,
+		&c.Cache.Redis.Password,
+		&c.RemoteWrite.BearerToken,
+		&c.RemoteWrite.Password,
+		&c.Influx.Token,
+	}
+}
+
+// credentialHeader matches header names that carry credentials, such as
+// Authorization or X-Api-Key.
+var credentialHeader = regexp.MustCompile(`(?i)auth|token|key|secret|password|cookie`)
+
+// Secrets returns every secret value in c, for logging.AddSecret: the
+// settings Redacted replaces, the API keys of the targets, the values of credential headers and URL
+// passwords. Other header values, such as a tenant ID, are left out, as
+// every occurrence of a registered value is redacted.
+func (c *Config) Secrets() []string {
+	var out []string
+	for _, secret := range c.secretFields() {
+		if strings.TrimSpace(*secret) != "" {
+			out = append(out, *secret)
+		}
+	}
+	for _, t := range c.Targets {
+		if strings.TrimSpace(t.APIKey) != "" {
+			out = append(out, t.APIKey)
+		}
+	}
+	for _, headers := range []string{c.OTEL.Headers, c.RemoteWrite.Headers} {
+		for _, pair := range strings.Split(headers, ",") {
+			name, value, _ := strings.Cut(pair, "=")
+			value = strings.TrimSpace(value)
+			if value == "" || !credentialHeader.MatchString(name) {
+				continue
+			}
+			out = append(out, value)
+			// The credentials of "Bearer <token>" or "Basic <base64>".
+			if _, credentials, ok := strings.Cut(value, " "); ok && strings.TrimSpace(credentials) != "" {
+				out = append(out, strings.TrimSpace(credentials))
+			}
+		}
+	}
+	for _, raw := range []string{c.OTEL.Endpoint, c.RemoteWrite.URL, c.Influx.URL} {
+		for _, endpoint := range strings.Split(raw, ",") {
+			if u, err := url.Parse(strings.TrimSpace(endpoint)); err == nil && u.User != nil {
+				if password, ok := u.User.Password(); ok && password != "" {
+					out = append(out, password)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// RedactedYAML renders Redacted as YAML in the config file format, with
+// durations such as 1m0s rather than nanoseconds.
+func (c *Config) RedactedYAML() ([]byte, error) {
+	return yaml.Marshal(yamlValue(reflect.ValueOf(*c.Redacted())))
+}
+
+func yamlValue(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = yamlValue(v.Index(i))
+		}
+		return items
+	}
+	if v.Kind() != reflect.Struct {
+		return v.Interface()
+	}
+	var fields yaml.MapSlice
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, yaml.MapItem{Key: name, Value: yamlValue(v.Field(i))})
+	}
+	return fields
+}
+
+// redactPairs redacts the values of comma-separated key=value pairs.
+func redactPairs(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+	pairs := strings.Split(s, ",")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		pairs[i] = strings.TrimSpace(key) + "=" + logging.Redacted
+	}
+	return strings.Join(pairs, ",")
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), logging.Redacted)
+	}
+	return u.String()
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/secrets"
+)
+
+// resolveAPIKey checks that at most one API key source is set and reads
+// APIKeyFile into APIKey.
+func (c *CLS) resolveAPIKey() error {
+	sources := 0
+	for _, source := range []string{c.APIKey, c.APIKeyFile, c.APIKeySecret} {
+		if strings.TrimSpace(source) != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("set only one of the API key, the API key file and the API key secret")
+	}
+	if strings.TrimSpace(c.APIKeySecret) != "" {
+		if _, err := secrets.Parse(c.APIKeySecret); err != nil {
+			return fmt.Errorf("invalid -nvidia-api-key-secret: %w", err)
+		}
+	}
+	path := strings.TrimSpace(c.APIKeyFile)
+	if path == "" {
+		return nil
+	}
+	key, err := ReadSecretFile(path)
+	if err != nil {
+		return fmt.Errorf("invalid -nvidia-api-key-file: %w", err)
+	}
+	c.APIKey = key
+	return nil
+}
+
+// ReadSecretFile returns the trimmed contents of the file at path. It
+// refuses files that every user may read or write, and empty files.
+// Symlinks, as in Kubernetes Secret volumes, are checked at their target.
+func ReadSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	// Windows has no POSIX permission bits to check.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o006 != 0 {
+		return "", fmt.Errorf("%s is accessible by all users (mode %04o): restrict it, for example with chmod 0400", path, info.Mode().Perm())
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(raw))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// setting binds one config field to its flag and environment variables.
+type setting struct {
+	flag  string
+	env   []string
+	usage string
+	// ptr is a *string, *bool, *int, *float64, *time.Duration or another
+	// flag.Value.
+	ptr any
+}
+
+// register defines the flag for s, with the field's current value as the
+// default.
+func (s setting) register(fs *flag.FlagSet) {
+	switch p := s.ptr.(type) {
+	case *string:
+		fs.StringVar(p, s.flag, *p, s.usage)
+	case *bool:
+		fs.BoolVar(p, s.flag, *p, s.usage)
+	case *int:
+		fs.IntVar(p, s.flag, *p, s.usage)
+	case *float64:
+		fs.Float64Var(p, s.flag, *p, s.usage)
+	case *time.Duration:
+		fs.DurationVar(p, s.flag, *p, s.usage)
+	case flag.Value:
+		fs.Var(p, s.flag, s.usage)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for -%s", s.ptr, s.flag))
+	}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"listen-address", []string{"LISTEN_ADDRESS"}, "Address to listen on for HTTP requests.", &c.Server.ListenAddress},
+		{"metrics-path", []string{"METRICS_PATH"}, "Path where metrics are exposed.", &c.Server.MetricsPath},
+		{"log-level", []string{"LOG_LEVEL"}, "Minimum log level: debug, info, warn or error.", &c.Log.Level},
+		{"log-format", []string{"LOG_FORMAT"}, "Log format: text (logfmt) or json.", &c.Log.Format},
+		{"access-log", []string{"ACCESS_LOG"}, "Access log: log (a record in -log-format), json or common (lines on stdout), or off.", &c.Log.AccessLog},
+		{"access-log-sample-rate", []string{"ACCESS_LOG_SAMPLE_RATE"}, "Fraction of successful requests to log, from 0 to 1; failed requests are always logged.", &c.Log.AccessLogSampleRate},
+		{"access-log-exclude", []string{"ACCESS_LOG_EXCLUDE"}, "Comma-separated paths never logged, e.g. /healthz,/-/ready.", &c.Log.AccessLogExclude},
+		{"nvidia-api-base-url", []string{"NVIDIA_API_BASE_URL"}, "NVIDIA CLS API base URL.", &c.CLS.BaseURL},
+		{"nvidia-org-name", []string{"NVIDIA_ORG_NAME", "NLS_ORG_NAME"}, "NVIDIA org name / ID (e.g. lic-...).", &c.CLS.OrgName},
+		{"nvidia-api-key", []string{"NVIDIA_API_KEY", "NLS_API_KEY"}, "NVIDIA Licensing State API key.", &c.CLS.APIKey},
+		{"nvidia-api-key-file", []string{"NVIDIA_API_KEY_FILE"}, "File holding the NVIDIA Licensing State API key; must not be readable by all users.", &c.CLS.APIKeyFile},
+		{"nvidia-api-key-file-poll-interval", []string{"NVIDIA_API_KEY_FILE_POLL_INTERVAL"}, "How often to re-read -nvidia-api-key-file to pick up rotated keys (0 = never).", &c.CLS.APIKeyFilePollInterval},
+		{"nvidia-api-key-secret", []string{"NVIDIA_API_KEY_SECRET"}, "Secrets manager URI of the API key: vault://<path>#<field>, aws-sm://<name or ARN>[#<field>] or gcp-sm://projects/<p>/secrets/<s>[#<field>].", &c.CLS.APIKeySecret},
+		{"nvidia-api-key-secret-refresh", []string{"NVIDIA_API_KEY_SECRET_REFRESH"}, "How often to re-fetch -nvidia-api-key-secret to pick up rotations (0 = never).", &c.CLS.APIKeySecretRefresh},
+		{"nvidia-service-instance-id", []string{"NVIDIA_SERVICE_INSTANCE_ID"}, "Optional service instance ID sent as x-nv-service-instance-id.", &c.CLS.ServiceInstanceID},
+		{"scrape-timeout", []string{"SCRAPE_TIMEOUT"}, "Timeout for each CLS scrape.", &c.CLS.ScrapeTimeout},
+		{"cache-ttl", []string{"CACHE_TTL"}, "In-memory cache TTL for CLS snapshots.", &c.Cache.TTL},
+		{"max-stale", []string{"MAX_STALE"}, "Stop serving cached snapshot series once the snapshot is older than this (0 disables).", &c.Cache.MaxStale},
+		{"failure-backoff", []string{"FAILURE_BACKOFF"}, "Skip CLS fetches for this long after a failure, doubling per consecutive failure (0 disables).", &c.Cache.FailureBackoff},
+		{"failure-backoff-max", []string{"FAILURE_BACKOFF_MAX"}, "Upper bound for the failure backoff window.", &c.Cache.FailureBackoffMax},
+		{"cache-backend", []string{"CACHE_BACKEND"}, "Snapshot cache backend shared between replicas: memory or redis.", &c.Cache.Backend},
+		{"redis-address", []string{"REDIS_ADDRESS"}, "Redis address for -cache-backend=redis.", &c.Cache.Redis.Address},
+		{"redis-username", []string{"REDIS_USERNAME"}, "Redis ACL username.", &c.Cache.Redis.Username},
+		{"redis-password", []string{"REDIS_PASSWORD"}, "Redis password.", &c.Cache.Redis.Password},
+		{"redis-db", []string{"REDIS_DB"}, "Redis logical database.", &c.Cache.Redis.DB},
+		{"redis-key-prefix", []string{"REDIS_KEY_PREFIX"}, "Prefix for snapshot keys stored in Redis.", &c.Cache.Redis.KeyPrefix},
+		{"leader-election", []string{"LEADER_ELECTION"}, "Elect one replica through a Kubernetes Lease to fetch from CLS; the others serve what it stores in the shared cache, or report not ready.", &c.LeaderElection.Enabled},
+		{"leader-election-lease-name", []string{"LEADER_ELECTION_LEASE_NAME"}, "Name of the leader election Lease.", &c.LeaderElection.LeaseName},
+		{"leader-election-namespace", []string{"LEADER_ELECTION_NAMESPACE"}, "Namespace of the leader election Lease; empty uses the pod's namespace.", &c.LeaderElection.Namespace},
+		{"leader-election-identity", []string{"LEADER_ELECTION_IDENTITY"}, "Name of this replica in the Lease; empty uses the hostname, which is the pod name.", &c.LeaderElection.Identity},
+		{"leader-election-lease-duration", []string{"LEADER_ELECTION_LEASE_DURATION"}, "How long followers wait after the leader's last renewal before taking over.", &c.LeaderElection.LeaseDuration},
+		{"leader-election-renew-deadline", []string{"LEADER_ELECTION_RENEW_DEADLINE"}, "How long the leader keeps leading while renewals fail.", &c.LeaderElection.RenewDeadline},
+		{"leader-election-retry-period", []string{"LEADER_ELECTION_RETRY_PERIOD"}, "How often to renew or try to acquire the Lease.", &c.LeaderElection.RetryPeriod},
+		{"lease-refresh-interval", []string{"LEASE_REFRESH_INTERVAL"}, "Refresh only active leases at this interval between full refreshes (0 disables).", &c.Cache.LeaseRefreshInterval},
+		{"validation-tolerance", []string{"VALIDATION_TOLERANCE"}, "Fraction by which in-use may exceed allocated before a snapshot is flagged.", &c.Cache.ValidationTolerance},
+		{"reject-invalid-snapshots", []string{"REJECT_INVALID_SNAPSHOTS"}, "Keep serving the previous snapshot when a fetched one fails validation.", &c.Cache.RejectInvalid},
+		{"max-snapshot-bytes", []string{"MAX_SNAPSHOT_BYTES"}, "Approximate cap on cached snapshot size; the most granular sections are dropped first (0 disables).", &c.Cache.MaxSnapshotBytes},
+		{"compress-snapshots", []string{"COMPRESS_SNAPSHOTS"}, "Keep cached snapshots gzip-packed in memory and materialize them per scrape.", &c.Cache.Compress},
+		{"copy-on-read-snapshots", []string{"COPY_ON_READ_SNAPSHOTS"}, "Hand every scrape and push a private deep copy of the cached snapshot.", &c.Cache.CopyOnRead},
+		{"max-response-bytes", []string{"MAX_RESPONSE_BYTES"}, "Maximum size of a single CLS API response body.", &c.CLS.MaxResponseBytes},
+		{"parallelism", []string{"PARALLELISM"}, "Max concurrent CLS API calls during scrape, or auto to size them from GOMAXPROCS and the virtual groups and license servers found.", &c.CLS.Parallelism},
+		{"shard", []string{"SHARD"}, "Shard of this replica, from 0 to -total-shards minus 1; it scrapes only the virtual groups whose ID hashes to it.", &c.CLS.Shard},
+		{"total-shards", []string{"TOTAL_SHARDS"}, "Number of replicas the virtual groups of every org are split between (1 = no sharding).", &c.CLS.TotalShards},
+		{"nvidia-api-tls-min-version", []string{"NVIDIA_API_TLS_MIN_VERSION"}, "Lowest TLS version used with the CLS API: TLS10, TLS11, TLS12 or TLS13.", &c.CLS.TLSMinVersion},
+		{"nvidia-api-tls-cipher-suites", []string{"NVIDIA_API_TLS_CIPHER_SUITES"}, "Comma-separated crypto/tls names of the cipher suites offered to the CLS API for TLS 1.2; empty uses the Go defaults.", &c.CLS.TLSCipherSuites},
+		{"otel-enabled", []string{"OTEL_ENABLED"}, "Enable OTEL metrics export.", &c.OTEL.Enabled},
+		{"otel-protocol", []string{"OTEL_PROTOCOL"}, "OTLP transport: grpc or http (HTTP/protobuf).", &c.OTEL.Protocol},
+		{"otel-endpoint", []string{"OTEL_ENDPOINT"}, "OTLP collector host:port, or a comma-separated list for metrics (default 127.0.0.1:4317 for grpc, 127.0.0.1:4318 for http).", &c.OTEL.Endpoint},
+		{"otel-endpoint-mode", []string{"OTEL_ENDPOINT_MODE"}, "With several -otel-endpoint entries: fanout (push to all) or failover (first that accepts).", &c.OTEL.EndpointMode},
+		{"otel-headers", []string{"OTEL_HEADERS"}, "Comma-separated key=value headers sent with OTLP exports, e.g. for authentication.", &c.OTEL.Headers},
+		{"otel-service-name", []string{"OTEL_SERVICE_NAME"}, "OTEL service.name.", &c.OTEL.ServiceName},
+		{"otel-service-instance-id", []string{"OTEL_SERVICE_INSTANCE_ID"}, "OTEL service.instance.id.", &c.OTEL.ServiceInstanceID},
+		{"otel-service-namespace", []string{"OTEL_SERVICE_NAMESPACE"}, "OTEL service.namespace; omitted when empty.", &c.OTEL.ServiceNamespace},
+		{"otel-deployment-environment", []string{"OTEL_DEPLOYMENT_ENVIRONMENT"}, "OTEL deployment.environment, e.g. production; omitted when empty.", &c.OTEL.DeploymentEnvironment},
+		{"otel-resource-detectors", []string{"OTEL_RESOURCE_DETECTORS"}, "Comma-separated OTEL resource detectors: env, host, os, process, container, k8s.", &c.OTEL.ResourceDetectors},
+		{"otel-resource-attributes", []string{"OTEL_EXTRA_RESOURCE_ATTRIBUTES"}, "Comma-separated key=value resource attributes added to all OTEL data, e.g. team=infra,env=prod.", &c.OTEL.ResourceAttributes},
+		{"otel-insecure", []string{"OTEL_INSECURE"}, "Disable TLS for OTLP.", &c.OTEL.Insecure},
+		{"otel-ca-file", []string{"OTEL_CA_FILE"}, "PEM CA bundle used to verify the OTLP collector certificate.", &c.OTEL.CAFile},
+		{"otel-cert-file", []string{"OTEL_CERT_FILE"}, "PEM client certificate for mutual TLS with the OTLP collector.", &c.OTEL.CertFile},
+		{"otel-key-file", []string{"OTEL_KEY_FILE"}, "PEM private key for -otel-cert-file.", &c.OTEL.KeyFile},
+		{"otel-temporality", []string{"OTEL_TEMPORALITY"}, "OTLP temporality preference: cumulative, delta or lowmemory.", &c.OTEL.Temporality},
+		{"otel-aggregation", []string{"OTEL_AGGREGATION"}, "Comma-separated kind=aggregation overrides, e.g. histogram=exponential.", &c.OTEL.Aggregation},
+		{"otel-drop-attributes", []string{"OTEL_DROP_ATTRIBUTES"}, "Comma-separated attributes removed from pushed OTEL metrics, e.g. virtual_group_name,product_name; series that collapse are summed.", &c.OTEL.DropAttributes},
+		{"otel-duration-histogram", []string{"OTEL_DURATION_HISTOGRAM"}, "Aggregation of OTEL duration histograms: exponential or explicit.", &c.OTEL.DurationHistogram},
+		{"otel-views-file", []string{"OTEL_VIEWS_FILE"}, "JSON file of OTEL views that rename metrics, drop attributes or change aggregations before export.", &c.OTEL.ViewsFile},
+		{"otel-retry", []string{"OTEL_RETRY_ENABLED"}, "Retry OTLP exports that fail with a transient error.", &c.OTEL.Retry.Enabled},
+		{"otel-retry-initial-interval", []string{"OTEL_RETRY_INITIAL_INTERVAL"}, "Wait before the first OTLP export retry; doubles per retry.", &c.OTEL.Retry.InitialInterval},
+		{"otel-retry-max-interval", []string{"OTEL_RETRY_MAX_INTERVAL"}, "Upper bound on the wait between OTLP export retries.", &c.OTEL.Retry.MaxInterval},
+		{"otel-retry-max-elapsed-time", []string{"OTEL_RETRY_MAX_ELAPSED_TIME"}, "Give up on an OTLP export and drop its data after this long.", &c.OTEL.Retry.MaxElapsedTime},
+		{"otel-push-interval", []string{"OTEL_PUSH_INTERVAL"}, "OTEL periodic push interval.", &c.OTEL.PushInterval},
+		{"otel-metric-names", []string{"OTEL_METRIC_NAMES"}, "OTEL metric naming: prometheus (nvidia_cls_*) or otel (dot-separated nvidia.cls.*).", &c.OTEL.MetricNames},
+		{"otel-lease-instrument", []string{"OTEL_LEASE_INSTRUMENT"}, "OTEL instrument for active lease metrics: gauge or updowncounter.", &c.OTEL.LeaseInstrument},
+		{"otel-export-timeout", []string{"OTEL_EXPORT_TIMEOUT"}, "Timeout for each OTEL metrics push, including collection and every export request.", &c.OTEL.ExportTimeout},
+		{"otel-max-export-batch-size", []string{"OTEL_MAX_EXPORT_BATCH_SIZE"}, "Split OTEL metrics pushes into requests of at most this many data points (0 = single request).", &c.OTEL.MaxExportBatchSize},
+		{"otel-traces", []string{"OTEL_TRACES_ENABLED"}, "Export a trace per snapshot fetch, with a span per CLS API call, to the OTLP endpoint; requires -otel-enabled.", &c.OTEL.Traces},
+		{"otel-logs", []string{"OTEL_LOGS_ENABLED"}, "Export refresh and CLS API failures as OTEL log records to the OTLP endpoint; requires -otel-enabled.", &c.OTEL.Logs},
+		{"warmup", []string{"WARMUP"}, "Fetch an initial snapshot before the HTTP listener starts accepting requests.", &c.Server.Warmup},
+		{"cors-allowed-origins", []string{"CORS_ALLOWED_ORIGINS"}, "Comma-separated origins allowed to call /api/ from a browser, or * for any; empty disables CORS.", &c.Server.CORSAllowedOrigins},
+		{"cors-allowed-methods", []string{"CORS_ALLOWED_METHODS"}, "Comma-separated methods allowed in CORS requests to /api/.", &c.Server.CORSAllowedMethods},
+		{"allow-cache-bypass", []string{"ALLOW_CACHE_BYPASS"}, "Serve a freshly fetched scrape for /metrics?cache=bypass; requires the admin token when one is set.", &c.Server.AllowCacheBypass},
+		{"stale-policy", []string{"STALE_POLICY"}, "On a failed refresh with a stale snapshot available: serve (hide the error) or error (return both).", &c.Cache.StalePolicy},
+		{"history-size", []string{"HISTORY_SIZE"}, "Number of earlier snapshots retained for /api/v1/diff (0 disables the endpoint).", &c.Cache.HistorySize},
+		{"history-interval", []string{"HISTORY_INTERVAL"}, "Minimum spacing between retained snapshots.", &c.Cache.HistoryInterval},
+		{"remote-write-url", []string{"REMOTE_WRITE_URL"}, "Prometheus remote-write endpoint to push metrics to, e.g. https://mimir.example.com/api/v1/push; disabled when empty.", &c.RemoteWrite.URL},
+		{"remote-write-interval", []string{"REMOTE_WRITE_INTERVAL"}, "Remote-write push interval.", &c.RemoteWrite.Interval},
+		{"remote-write-timeout", []string{"REMOTE_WRITE_TIMEOUT"}, "Timeout for each remote-write push.", &c.RemoteWrite.Timeout},
+		{"remote-write-bearer-token", []string{"REMOTE_WRITE_BEARER_TOKEN"}, "Bearer token for remote-write pushes.", &c.RemoteWrite.BearerToken},
+		{"remote-write-username", []string{"REMOTE_WRITE_USERNAME"}, "Basic auth username for remote-write pushes.", &c.RemoteWrite.Username},
+		{"remote-write-password", []string{"REMOTE_WRITE_PASSWORD"}, "Basic auth password for remote-write pushes.", &c.RemoteWrite.Password},
+		{"remote-write-headers", []string{"REMOTE_WRITE_HEADERS"}, "Comma-separated key=value headers sent with remote-write pushes, e.g. X-Scope-OrgID=tenant.", &c.RemoteWrite.Headers},
+		{"statsd-address", []string{"STATSD_ADDRESS"}, "StatsD/DogStatsD address, host:port for UDP or unix:///path for a Unix socket; disabled when empty.", &c.StatsD.Address},
+		{"statsd-prefix", []string{"STATSD_PREFIX"}, "Prefix prepended to every StatsD metric name.", &c.StatsD.Prefix},
+		{"statsd-tags", []string{"STATSD_TAGS"}, "StatsD tag format: dogstatsd or none.", &c.StatsD.Tags},
+		{"statsd-global-tags", []string{"STATSD_GLOBAL_TAGS"}, "Comma-separated tags added to every StatsD metric, e.g. env:prod.", &c.StatsD.GlobalTags},
+		{"influx-url", []string{"INFLUX_URL"}, "InfluxDB v2 base URL to write metrics to after each refresh, e.g. http://influxdb:8086; disabled when empty.", &c.Influx.URL},
+		{"influx-org", []string{"INFLUX_ORG"}, "InfluxDB organization.", &c.Influx.Org},
+		{"influx-bucket", []string{"INFLUX_BUCKET"}, "InfluxDB bucket.", &c.Influx.Bucket},
+		{"influx-token", []string{"INFLUX_TOKEN"}, "InfluxDB API token with write access to the bucket.", &c.Influx.Token},
+		{"influx-timeout", []string{"INFLUX_TIMEOUT"}, "Timeout for each InfluxDB write.", &c.Influx.Timeout},
+		{"graphite-address", []string{"GRAPHITE_ADDRESS"}, "Graphite plaintext host:port, usually port 2003; disabled when empty.", &c.Graphite.Address},
+		{"graphite-prefix", []string{"GRAPHITE_PREFIX"}, "First path component of every Graphite metric.", &c.Graphite.Prefix},
+		{"graphite-interval", []string{"GRAPHITE_INTERVAL"}, "Graphite push interval.", &c.Graphite.Interval},
+		{"cloudwatch-enabled", []string{"CLOUDWATCH_ENABLED"}, "Publish metrics to Amazon CloudWatch with PutMetricData.", &c.CloudWatch.Enabled},
+		{"cloudwatch-namespace", []string{"CLOUDWATCH_NAMESPACE"}, "CloudWatch namespace of the metrics.", &c.CloudWatch.Namespace},
+		{"cloudwatch-region", []string{"CLOUDWATCH_REGION"}, "CloudWatch region; AWS_REGION when empty.", &c.CloudWatch.Region},
+		{"cloudwatch-interval", []string{"CLOUDWATCH_INTERVAL"}, "CloudWatch push interval.", &c.CloudWatch.Interval},
+		{"cloudwatch-timeout", []string{"CLOUDWATCH_TIMEOUT"}, "Timeout for each CloudWatch push.", &c.CloudWatch.Timeout},
+		{"alerts-config-file", []string{"ALERTS_CONFIG_FILE"}, "YAML file with alerting rules evaluated against every new snapshot, the notifiers they send to and scheduled summary emails; disabled when empty.", &c.Alerts.ConfigFile},
+		{"anomaly-detection", []string{"ANOMALY_DETECTION"}, "Flag sudden drops and spikes in the active leases of each license server as nvidia_cls_lease_anomaly.", &c.Anomaly.Enabled},
+		{"anomaly-alpha", []string{"ANOMALY_ALPHA"}, "Weight of the latest refresh in the moving mean and standard deviation of active leases, from 0 to 1.", &c.Anomaly.Alpha},
+		{"anomaly-threshold", []string{"ANOMALY_THRESHOLD"}, "Standard deviations from the moving mean at which active leases are anomalous.", &c.Anomaly.Threshold},
+		{"anomaly-warmup", []string{"ANOMALY_WARMUP"}, "Refreshes of a license server observed before its leases can be anomalous.", &c.Anomaly.Warmup},
+		{"anomaly-min-deviation", []string{"ANOMALY_MIN_DEVIATION"}, "Fewest leases from the moving mean that are anomalous.", &c.Anomaly.MinDeviation},
+		{"history-db-path", []string{"HISTORY_DB_PATH"}, "File of an embedded database recording every refresh for historical queries; disabled when empty.", &c.HistoryDB.Path},
+		{"history-db-retention", []string{"HISTORY_DB_RETENTION"}, "How long the history database keeps the record of every refresh (0 keeps them forever).", &c.HistoryDB.Retention},
+		{"history-db-hourly-retention", []string{"HISTORY_DB_HOURLY_RETENTION"}, "How long the history database keeps hourly rollups (0 keeps them forever).", &c.HistoryDB.HourlyRetention},
+		{"history-db-daily-retention", []string{"HISTORY_DB_DAILY_RETENTION"}, "How long the history database keeps daily rollups (0 keeps them forever).", &c.HistoryDB.DailyRetention},
+		{"history-db-forecast-window", []string{"HISTORY_DB_FORECAST_WINDOW"}, "How far back the license exhaustion forecasts fit the trend of daily peaks.", &c.HistoryDB.ForecastWindow},
+		{"report-schedule", []string{"REPORT_SCHEDULE"}, "Cron expression at which the audit report is written to -report-destination, e.g. \"0 6 1 * *\"; disabled when empty.", &c.Report.Schedule},
+		{"report-format", []string{"REPORT_FORMAT"}, "Format of scheduled audit reports: csv or xlsx.", &c.Report.Format},
+		{"report-range", []string{"REPORT_RANGE"}, "Reporting period of scheduled audit reports, before each scheduled time.", &c.Report.Range},
+		{"report-destination", []string{"REPORT_DESTINATION"}, "Directory, s3://<bucket>/<prefix> or gs://<bucket>/<prefix> scheduled audit reports are written to.", &c.Report.Destination},
+		{"http-disabled", []string{"HTTP_DISABLED"}, "Do not start the HTTP listener at all and only push; requires -otel-enabled, -remote-write-url, -statsd-address, -influx-url, -graphite-address or -cloudwatch-enabled.", &c.Server.HTTPDisabled},
+		{"web-config-file", []string{"WEB_CONFIG_FILE"}, "YAML web config file with basic_auth_users and/or bearer_token_file required for /metrics and the JSON API, and tls_server_config for HTTPS and client certificates.", &c.Server.WebConfigFile},
+		{"ready-max-age", []string{"READY_MAX_AGE"}, "/-/ready fails unless every target has a snapshot collected within this window.", &c.Server.ReadyMaxAge},
+		{"shutdown-delay", []string{"SHUTDOWN_DELAY"}, "On SIGTERM, keep serving with /-/ready failing for this long before closing the listener, for rolling updates (0 = close at once).", &c.Server.ShutdownDelay},
+		{"http-read-header-timeout", []string{"HTTP_READ_HEADER_TIMEOUT"}, "Maximum time to read the request headers, on every listener; cuts off slow-loris clients (0 = -http-read-timeout on the main listener, else none).", &c.Server.ReadHeaderTimeout},
+		{"http-read-timeout", []string{"HTTP_READ_TIMEOUT"}, "Maximum time to read a request, including the body (0 = none).", &c.Server.ReadTimeout},
+		{"http-write-timeout", []string{"HTTP_WRITE_TIMEOUT"}, "Maximum time from the end of the request headers to the end of the response (0 = none).", &c.Server.WriteTimeout},
+		{"http-request-timeout", []string{"HTTP_REQUEST_TIMEOUT"}, "Handler deadline; slower requests get 503. Keep it above -scrape-timeout and below -http-write-timeout (0 = none).", &c.Server.RequestTimeout},
+		{"http-idle-timeout", []string{"HTTP_IDLE_TIMEOUT"}, "Maximum time a keep-alive connection waits for the next request, on every listener (0 = -http-read-timeout on the main listener, else none).", &c.Server.IdleTimeout},
+		{"http-max-header-bytes", []string{"HTTP_MAX_HEADER_BYTES"}, "Maximum size of the request headers, on every listener (0 = Go's default of 1MB).", &c.Server.MaxHeaderBytes},
+		{"metrics-max-inflight", []string{"METRICS_MAX_INFLIGHT"}, "Maximum concurrent requests to the metrics path; more get 503 at once (0 = unlimited).", &c.Server.MetricsMaxInflight},
+		{"enable-pprof", []string{"ENABLE_PPROF"}, "Serve net/http/pprof profiles under /debug/pprof/ on -debug-listen-address.", &c.Server.EnablePprof},
+		{"rate-limit", []string{"RATE_LIMIT"}, "Requests per second each client address may make to the metrics path and /api/; excess requests get 429 (0 = unlimited).", &c.Server.RateLimit},
+		{"rate-limit-global", []string{"RATE_LIMIT_GLOBAL"}, "Requests per second all clients together may make to the metrics path and /api/ (0 = unlimited).", &c.Server.RateLimitGlobal},
+		{"rate-limit-burst", []string{"RATE_LIMIT_BURST"}, "Requests above -rate-limit and -rate-limit-global allowed in a burst.", &c.Server.RateLimitBurst},
+		{"debug-listen-address", []string{"DEBUG_LISTEN_ADDRESS"}, "Separate listener for pprof; empty serves it on -health-listen-address if set, else on -listen-address behind the web config authentication.", &c.Server.DebugListenAddress},
+		{"health-listen-address", []string{"HEALTH_LISTEN_ADDRESS"}, "Separate listener serving only /healthz, /-/healthy and /-/ready, for load balancers; with -enable-pprof, also pprof when -debug-listen-address is empty or the same address.", &c.Server.HealthListenAddress},
+		{"allow-cidrs", []string{"ALLOW_CIDRS"}, "Comma-separated CIDRs or IPs allowed to reach -listen-address; others get 403, except for health checks. Empty allows all.", &c.Server.AllowCIDRs},
+		{"trusted-proxies", []string{"TRUSTED_PROXIES"}, "Comma-separated CIDRs or IPs of reverse proxies whose Forwarded or X-Forwarded-For header names the client, for the access log, -allow-cidrs and -rate-limit. Empty trusts none.", &c.Server.TrustedProxies},
+		{"admin-token", []string{"ADMIN_TOKEN"}, "Bearer token required by admin endpoints such as /-/refresh; admin endpoints are disabled when empty.", &c.Server.AdminToken},
+	}
+}
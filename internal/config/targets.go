@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Target is one CLS org to scrape, with its own credentials. Empty
+// settings fall back to those of the cls and cache sections.
+type Target struct {
+	OrgName string `yaml:"org_name"`
+	APIKey  string `yaml:"api_key"`
+	// APIKeyFile names a file holding the API key, read by Load into
+	// APIKey and polled every CLS.APIKeyFilePollInterval.
+	APIKeyFile        string `yaml:"api_key_file"`
+	ServiceInstanceID string `yaml:"service_instance_id"`
+	// BaseURL overrides CLS.BaseURL, and CacheTTL Cache.TTL.
+	BaseURL  string        `yaml:"base_url"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// VirtualGroups limits the target to the virtual groups with these
+	// names or IDs.
+	VirtualGroups []string `yaml:"virtual_groups"`
+}
+
+// ScrapeTargets returns the orgs to scrape: the org of the cls section, if
+// set, followed by the targets section or, instead, every -target.
+func (c *Config) ScrapeTargets() []Target {
+	var out []Target
+	if strings.TrimSpace(c.CLS.OrgName) != "" {
+		out = append(out, Target{
+			OrgName:           c.CLS.OrgName,
+			APIKey:            c.CLS.APIKey,
+			APIKeyFile:        c.CLS.APIKeyFile,
+			ServiceInstanceID: c.CLS.ServiceInstanceID,
+		})
+	}
+	return append(out, c.Targets...)
+}
+
+// ParseTarget parses a -target value, a comma-separated list of key=value
+// pairs such as org=lic-abc,api-key-file=/secrets/a,service-instance-id=si-1.
+// org and api-key-file are required.
+func ParseTarget(s string) (Target, error) {
+	var t Target
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return Target{}, fmt.Errorf("invalid target %q: %q is not key=value", s, pair)
+		}
+		switch key {
+		case "org":
+			t.OrgName = value
+		case "api-key-file":
+			t.APIKeyFile = value
+		case "service-instance-id":
+			t.ServiceInstanceID = value
+		default:
+			return Target{}, fmt.Errorf("invalid target %q: unknown key %q, use org, api-key-file or service-instance-id", s, key)
+		}
+	}
+	if t.OrgName == "" {
+		return Target{}, fmt.Errorf("invalid target %q: org is not set", s)
+	}
+	if t.APIKeyFile == "" {
+		return Target{}, fmt.Errorf("invalid target %q: api-key-file is not set", s)
+	}
+	return t, nil
+}
+
+// String formats t as a -target value, without the API key.
+func (t Target) String() string {
+	parts := []string{"org=" + t.OrgName}
+	if t.APIKeyFile != "" {
+		parts = append(parts, "api-key-file="+t.APIKeyFile)
+	}
+	if t.ServiceInstanceID != "" {
+		parts = append(parts, "service-instance-id="+t.ServiceInstanceID)
+	}
+	return strings.Join(parts, ",")
+}
+
+// resolveAPIKey checks that exactly one API key source is set and reads
+// APIKeyFile into APIKey.
+func (t *Target) resolveAPIKey() error {
+	if strings.TrimSpace(t.APIKey) != "" && strings.TrimSpace(t.APIKeyFile) != "" {
+		return errors.New("set only one of the API key and the API key file")
+	}
+	path := strings.TrimSpace(t.APIKeyFile)
+	if path == "" {
+		return nil
+	}
+	key, err := ReadSecretFile(path)
+	if err != nil {
+		return fmt.Errorf("api key file: %w", err)
+	}
+	t.APIKey = key
+	return nil
+}
+
+// targetFlag appends a Target for every -target.
+type targetFlag struct {
+	targets *[]Target
+}
+
+func (f targetFlag) String() string {
+	if f.targets == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*f.targets))
+	for _, t := range *f.targets {
+		parts = append(parts, t.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f targetFlag) Set(s string) error {
+	t, err := ParseTarget(s)
+	if err != nil {
+		return err
+	}
+	*f.targets = append(*f.targets, t)
+	return nil
+}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/logging"
+	"nvidia-license-server-exporter/internal/report"
+	"nvidia-license-server-exporter/internal/snapshot"
+	"nvidia-license-server-exporter/internal/web"
+)
+
+// MinimalExample is the smallest configuration the exporter runs with,
+// shown along with validation errors.
+const MinimalExample = `Set at least the org and an API key, in the environment:
+
+  NVIDIA_ORG_NAME=lic-0123456789abcdef
+  NVIDIA_API_KEY=<Licensing State API key>
+
+or in a config file passed with -config:
+
+  cls:
+    org_name: lic-0123456789abcdef
+    api_key_file: /run/secrets/nvidia-api-key
+`
+
+// Validate checks the whole configuration and returns every problem found,
+// joined with errors.Join, rather than only the first. Each problem names
+// the config file key along with its flag and environment variables.
+// Checks that need I/O, such as reading the web config file or reaching
+// Redis, are left to the components that use them.
+func (c *Config) Validate() error {
+	v := validator{c: c}
+
+	// With -target, the org of the cls section is optional.
+	if len(c.Targets) == 0 || strings.TrimSpace(c.CLS.OrgName) != "" {
+		if strings.TrimSpace(c.CLS.OrgName) == "" {
+			v.fail(&c.CLS.OrgName, "cls.org_name", "not set")
+		}
+		if strings.TrimSpace(c.CLS.APIKey) == "" && strings.TrimSpace(c.CLS.APIKeySecret) == "" {
+			v.fail(&c.CLS.APIKey, "cls.api_key", "not set; or set cls.api_key_file (-nvidia-api-key-file, NVIDIA_API_KEY_FILE) or cls.api_key_secret (-nvidia-api-key-secret, NVIDIA_API_KEY_SECRET)")
+		}
+	}
+	seen := map[string]bool{strings.TrimSpace(c.CLS.OrgName): true}
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		key := fmt.Sprintf("targets[%d]", i)
+		switch org := strings.TrimSpace(t.OrgName); {
+		case org == "":
+			v.fail(nil, key+".org_name", "not set")
+		case seen[org]:
+			v.fail(nil, key+".org_name", "%s is scraped more than once", org)
+		default:
+			seen[org] = true
+		}
+		if strings.TrimSpace(t.APIKey) == "" {
+			v.fail(nil, key+".api_key", "not set; or set api_key_file")
+		}
+		v.url(&t.BaseURL, key+".base_url", false)
+		v.nonNegative(&t.CacheTTL, key+".cache_ttl")
+	}
+	v.url(&c.CLS.BaseURL, "cls.base_url", true)
+	v.positive(&c.CLS.ScrapeTimeout, "cls.scrape_timeout")
+	if c.CLS.Parallelism < 0 && c.CLS.Parallelism != ParallelismAuto {
+		v.fail(&c.CLS.Parallelism, "cls.parallelism", "%d is negative; use a number or auto", c.CLS.Parallelism)
+	}
+	switch {
+	case c.CLS.TotalShards < 0:
+		v.fail(&c.CLS.TotalShards, "cls.total_shards", "%d is negative", c.CLS.TotalShards)
+	case c.CLS.Shard < 0 || c.CLS.Shard >= max(c.CLS.TotalShards, 1):
+		v.fail(&c.CLS.Shard, "cls.shard", "%d is not between 0 and cls.total_shards - 1 (%d)", c.CLS.Shard, max(c.CLS.TotalShards, 1)-1)
+	}
+	if c.CLS.MaxResponseBytes < 0 {
+		v.fail(&c.CLS.MaxResponseBytes, "cls.max_response_bytes", "%d is negative", c.CLS.MaxResponseBytes)
+	}
+	if _, err := web.ParseTLSVersion(c.CLS.TLSMinVersion); err != nil {
+		v.fail(&c.CLS.TLSMinVersion, "cls.tls_min_version", "%v", err)
+	}
+	if _, err := web.ParseCipherSuites(strings.Split(c.CLS.TLSCipherSuites, ",")); err != nil {
+		v.fail(&c.CLS.TLSCipherSuites, "cls.tls_cipher_suites", "%v", err)
+	}
+	v.nonNegative(&c.CLS.APIKeyFilePollInterval, "cls.api_key_file_poll_interval")
+	v.nonNegative(&c.CLS.APIKeySecretRefresh, "cls.api_key_secret_refresh")
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		v.fail(&c.Log.Level, "log.level", "%v", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Log.Format)) {
+	case "", logging.FormatText, logging.FormatJSON:
+	default:
+		v.fail(&c.Log.Format, "log.format", "unsupported %q: use text or json", c.Log.Format)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Log.AccessLog)) {
+	case "", "log", "json", "common", "off":
+	default:
+		v.fail(&c.Log.AccessLog, "log.access_log", "unsupported %q: use log, json, common or off", c.Log.AccessLog)
+	}
+	if c.Log.AccessLogSampleRate < 0 || c.Log.AccessLogSampleRate > 1 {
+		v.fail(&c.Log.AccessLogSampleRate, "log.access_log_sample_rate", "%g is not between 0 and 1", c.Log.AccessLogSampleRate)
+	}
+
+	if !c.Server.HTTPDisabled {
+		if strings.TrimSpace(c.Server.ListenAddress) == "" {
+			v.fail(&c.Server.ListenAddress, "server.listen_address", "not set")
+		}
+		if !strings.HasPrefix(c.Server.MetricsPath, "/") {
+			v.fail(&c.Server.MetricsPath, "server.metrics_path", "%q does not start with /", c.Server.MetricsPath)
+		}
+	} else if !c.OTEL.Enabled && !c.CloudWatch.Enabled && strings.TrimSpace(c.RemoteWrite.URL+c.StatsD.Address+c.Influx.URL+c.Graphite.Address) == "" {
+		v.fail(&c.Server.HTTPDisabled, "server.http_disabled", "nothing would be exported: also set otel.enabled, remote_write.url, statsd.address, influx.url, graphite.address or cloudwatch.enabled")
+	}
+	v.nonNegative(&c.Server.ReadyMaxAge, "server.ready_max_age")
+	for _, d := range []struct {
+		ptr *time.Duration
+		key string
+	}{
+		{&c.Server.ShutdownDelay, "server.shutdown_delay"},
+		{&c.Server.ReadHeaderTimeout, "server.read_header_timeout"},
+		{&c.Server.ReadTimeout, "server.read_timeout"},
+		{&c.Server.WriteTimeout, "server.write_timeout"},
+		{&c.Server.RequestTimeout, "server.request_timeout"},
+		{&c.Server.IdleTimeout, "server.idle_timeout"},
+	} {
+		v.nonNegative(d.ptr, d.key)
+	}
+	if c.Server.ReadHeaderTimeout > 0 && c.Server.ReadTimeout > 0 && c.Server.ReadHeaderTimeout > c.Server.ReadTimeout {
+		v.fail(&c.Server.ReadHeaderTimeout, "server.read_header_timeout", "%s is above server.read_timeout %s, which already bounds reading the headers", c.Server.ReadHeaderTimeout, c.Server.ReadTimeout)
+	}
+	if c.Server.RequestTimeout > 0 && c.Server.WriteTimeout > 0 && c.Server.RequestTimeout >= c.Server.WriteTimeout {
+		v.fail(&c.Server.RequestTimeout, "server.request_timeout", "%s is not below server.write_timeout %s, so slow requests are cut off without a 503", c.Server.RequestTimeout, c.Server.WriteTimeout)
+	}
+	for _, n := range []struct {
+		ptr any
+		key string
+		val float64
+	}{
+		{&c.Server.MetricsMaxInflight, "server.metrics_max_inflight", float64(c.Server.MetricsMaxInflight)},
+		{&c.Server.MaxHeaderBytes, "server.max_header_bytes", float64(c.Server.MaxHeaderBytes)},
+		{&c.Server.RateLimit, "server.rate_limit", c.Server.RateLimit},
+		{&c.Server.RateLimitGlobal, "server.rate_limit_global", c.Server.RateLimitGlobal},
+	} {
+		if n.val < 0 {
+			v.fail(n.ptr, n.key, "%g is negative", n.val)
+		}
+	}
+
+	v.nonNegative(&c.Cache.TTL, "cache.ttl")
+	v.nonNegative(&c.Cache.MaxStale, "cache.max_stale")
+	v.nonNegative(&c.Cache.FailureBackoff, "cache.failure_backoff")
+	v.nonNegative(&c.Cache.FailureBackoffMax, "cache.failure_backoff_max")
+	v.nonNegative(&c.Cache.LeaseRefreshInterval, "cache.lease_refresh_interval")
+	v.nonNegative(&c.Cache.HistoryInterval, "cache.history_interval")
+	switch strings.ToLower(strings.TrimSpace(c.Cache.Backend)) {
+	case "", "memory":
+	case "redis":
+		if strings.TrimSpace(c.Cache.Redis.Address) == "" {
+			v.fail(&c.Cache.Redis.Address, "cache.redis.address", "not set, but cache.backend is redis")
+		}
+	default:
+		v.fail(&c.Cache.Backend, "cache.backend", "unsupported %q: use memory or redis", c.Cache.Backend)
+	}
+	switch snapshot.StalePolicy(c.Cache.StalePolicy) {
+	case snapshot.StaleServe, snapshot.StaleError:
+	default:
+		v.fail(&c.Cache.StalePolicy, "cache.stale_policy", "unsupported %q: use serve or error", c.Cache.StalePolicy)
+	}
+	if c.Cache.HistorySize < 0 {
+		v.fail(&c.Cache.HistorySize, "cache.history_size", "%d is negative", c.Cache.HistorySize)
+	}
+
+	if le := &c.LeaderElection; le.Enabled {
+		if strings.TrimSpace(le.LeaseName) == "" {
+			v.fail(&le.LeaseName, "leader_election.lease_name", "not set")
+		}
+		v.positive(&le.LeaseDuration, "leader_election.lease_duration")
+		v.positive(&le.RenewDeadline, "leader_election.renew_deadline")
+		v.positive(&le.RetryPeriod, "leader_election.retry_period")
+		if le.RenewDeadline >= le.LeaseDuration {
+			v.fail(&le.RenewDeadline, "leader_election.renew_deadline", "%s is not below leader_election.lease_duration %s", le.RenewDeadline, le.LeaseDuration)
+		}
+		if le.RetryPeriod >= le.RenewDeadline {
+			v.fail(&le.RetryPeriod, "leader_election.retry_period", "%s is not below leader_election.renew_deadline %s", le.RetryPeriod, le.RenewDeadline)
+		}
+	}
+
+	if c.OTEL.Enabled {
+		v.nonNegative(&c.OTEL.PushInterval, "otel.push_interval")
+		v.nonNegative(&c.OTEL.ExportTimeout, "otel.export_timeout")
+	} else {
+		if c.OTEL.Traces {
+			v.fail(&c.OTEL.Traces, "otel.traces", "requires otel.enabled")
+		}
+		if c.OTEL.Logs {
+			v.fail(&c.OTEL.Logs, "otel.logs", "requires otel.enabled")
+		}
+	}
+	if strings.TrimSpace(c.RemoteWrite.URL) != "" {
+		v.url(&c.RemoteWrite.URL, "remote_write.url", false)
+		v.nonNegative(&c.RemoteWrite.Interval, "remote_write.interval")
+		v.nonNegative(&c.RemoteWrite.Timeout, "remote_write.timeout")
+		if c.RemoteWrite.BearerToken != "" && c.RemoteWrite.Username != "" {
+			v.fail(&c.RemoteWrite.BearerToken, "remote_write.bearer_token", "set only one of remote_write.bearer_token and remote_write.username")
+		}
+	}
+	if strings.TrimSpace(c.Influx.URL) != "" {
+		v.url(&c.Influx.URL, "influx.url", false)
+		v.nonNegative(&c.Influx.Timeout, "influx.timeout")
+		if strings.TrimSpace(c.Influx.Org) == "" {
+			v.fail(&c.Influx.Org, "influx.org", "not set, but influx.url is")
+		}
+		if strings.TrimSpace(c.Influx.Bucket) == "" {
+			v.fail(&c.Influx.Bucket, "influx.bucket", "not set, but influx.url is")
+		}
+	}
+	if strings.TrimSpace(c.Graphite.Address) != "" {
+		v.nonNegative(&c.Graphite.Interval, "graphite.interval")
+	}
+	if c.CloudWatch.Enabled {
+		if strings.HasPrefix(strings.TrimSpace(c.CloudWatch.Namespace), "AWS/") {
+			v.fail(&c.CloudWatch.Namespace, "cloudwatch.namespace", "%q is reserved for AWS services", c.CloudWatch.Namespace)
+		}
+		v.nonNegative(&c.CloudWatch.Interval, "cloudwatch.interval")
+		v.nonNegative(&c.CloudWatch.Timeout, "cloudwatch.timeout")
+	}
+	if c.Anomaly.Enabled {
+		if c.Anomaly.Alpha <= 0 || c.Anomaly.Alpha > 1 {
+			v.fail(&c.Anomaly.Alpha, "anomaly.alpha", "%g is not in (0, 1]", c.Anomaly.Alpha)
+		}
+		if c.Anomaly.Threshold <= 0 {
+			v.fail(&c.Anomaly.Threshold, "anomaly.threshold", "%g is not positive", c.Anomaly.Threshold)
+		}
+		if c.Anomaly.Warmup < 1 {
+			v.fail(&c.Anomaly.Warmup, "anomaly.warmup", "%d is below 1", c.Anomaly.Warmup)
+		}
+		if c.Anomaly.MinDeviation < 0 {
+			v.fail(&c.Anomaly.MinDeviation, "anomaly.min_deviation", "%g is negative", c.Anomaly.MinDeviation)
+		}
+	}
+	for _, r := range []struct {
+		ptr *time.Duration
+		key string
+		min time.Duration
+	}{
+		{&c.HistoryDB.Retention, "history_db.retention", historydb.MinRetention},
+		{&c.HistoryDB.HourlyRetention, "history_db.hourly_retention", historydb.MinHourlyRetention},
+		{&c.HistoryDB.DailyRetention, "history_db.daily_retention", 0},
+	} {
+		v.nonNegative(r.ptr, r.key)
+		if *r.ptr > 0 && *r.ptr < r.min {
+			v.fail(r.ptr, r.key, "%s is below %s, so records would expire before they are downsampled", *r.ptr, r.min)
+		}
+	}
+	if window := historydb.MinForecastDays * 24 * time.Hour; c.HistoryDB.ForecastWindow < window {
+		v.fail(&c.HistoryDB.ForecastWindow, "history_db.forecast_window", "%s is below %s, the least history a forecast is fitted to", c.HistoryDB.ForecastWindow, window)
+	}
+	if schedule := strings.TrimSpace(c.Report.Schedule); schedule != "" {
+		if _, err := cron.ParseStandard(schedule); err != nil {
+			v.fail(&c.Report.Schedule, "report.schedule", "%v", err)
+		}
+		if c.Report.Format != report.FormatCSV && c.Report.Format != report.FormatXLSX {
+			v.fail(&c.Report.Format, "report.format", "%q is not csv or xlsx", c.Report.Format)
+		}
+		v.positive(&c.Report.Range, "report.range")
+		if strings.TrimSpace(c.Report.Destination) == "" {
+			v.fail(&c.Report.Destination, "report.destination", "not set, but report.schedule is")
+		} else if _, err := report.ParseDestination(c.Report.Destination, nil); err != nil {
+			v.fail(&c.Report.Destination, "report.destination", "%v", err)
+		}
+	}
+	return errors.Join(v.problems...)
+}
+
+// validator collects the problems found by Validate.
+type validator struct {
+	c        *Config
+	problems []error
+}
+
+// fail records a problem with the setting at ptr, whose config file key is
+// key.
+func (v *validator) fail(ptr any, key, format string, args ...any) {
+	name := key
+	for _, s := range v.c.settings() {
+		if s.ptr == ptr {
+			name = fmt.Sprintf("%s (-%s, %s)", key, s.flag, strings.Join(s.env, ", "))
+			break
+		}
+	}
+	v.problems = append(v.problems, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) positive(d *time.Duration, key string) {
+	if *d <= 0 {
+		v.fail(d, key, "%s is not positive", *d)
+	}
+}
+
+func (v *validator) nonNegative(d *time.Duration, key string) {
+	if *d < 0 {
+		v.fail(d, key, "%s is negative", *d)
+	}
+}
+
+// url checks that raw is an absolute http or https URL. required reports
+// whether an empty value is a problem. Passwords in the URL are not
+// repeated in the problem.
+func (v *validator) url(raw *string, key string, required bool) {
+	s := strings.TrimSpace(*raw)
+	if s == "" {
+		if required {
+			v.fail(raw, key, "not set")
+		}
+		return
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail(raw, key, "%q is not an http or https URL", redactURL(s))
+	}
+}
@@ -2,7 +2,8 @@ package exporter
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -12,8 +13,9 @@ import (
 )
 
 type Collector struct {
-	snapshotSvc   *snapshot.Service
+	manager       *snapshot.Manager
 	scrapeTimeout time.Duration
+	bypassCache   bool
 
 	upDesc                  *prometheus.Desc
 	scrapeDurationDesc      *prometheus.Desc
@@ -22,71 +24,147 @@ type Collector struct {
 	serverInfoDesc          *prometheus.Desc
 	serverFeatureCapacity   *prometheus.Desc
 	serverFeatureActiveDesc *prometheus.Desc
+	validationFailuresDesc  *prometheus.Desc
+	snapshotBytesDesc       *prometheus.Desc
+	snapshotElementsDesc    *prometheus.Desc
+	snapshotTruncatedDesc   *prometheus.Desc
+	consecutiveFailuresDesc *prometheus.Desc
+	backoffRemainingDesc    *prometheus.Desc
+	phaseDurationDesc       *prometheus.Desc
+	phaseItemsDesc          *prometheus.Desc
+	lastErrorDesc           *prometheus.Desc
+	leaseChangesDesc        *prometheus.Desc
+	apiRequestsDesc         *prometheus.Desc
 
-	descs []*prometheus.Desc
+	descs   []*prometheus.Desc
+	metrics []Metric
 }
 
-func NewCollector(snapshotSvc *snapshot.Service, orgName string, scrapeTimeout time.Duration) *Collector {
-	constLabel := prometheus.Labels{"org_name": orgName}
-
+func NewCollector(manager *snapshot.Manager, scrapeTimeout time.Duration) *Collector {
 	c := &Collector{
-		snapshotSvc:   snapshotSvc,
+		manager:       manager,
 		scrapeTimeout: scrapeTimeout,
-
-		upDesc: prometheus.NewDesc(
-			"nvidia_cls_up",
-			"Whether the NVIDIA CLS scrape is successful (1 = up, 0 = down).",
-			nil,
-			constLabel,
-		),
-		scrapeDurationDesc: prometheus.NewDesc(
-			"nvidia_cls_scrape_duration_seconds",
-			"Time spent querying NVIDIA CLS APIs.",
-			nil,
-			constLabel,
-		),
-		scrapeTimestampDesc: prometheus.NewDesc(
-			"nvidia_cls_scrape_timestamp_seconds",
-			"Unix timestamp for when the scrape snapshot was collected.",
-			nil,
-			constLabel,
-		),
-		entitlementTotalDesc: prometheus.NewDesc(
-			"nvidia_cls_entitlement_total_quantity",
-			"Total entitlement quantity by virtual group and feature (contract capacity).",
-			[]string{"virtual_group_id", "virtual_group_name", "feature_name", "feature_version", "product_name", "license_type"},
-			constLabel,
-		),
-		serverInfoDesc: prometheus.NewDesc(
-			"nvidia_cls_license_server_info",
-			"Static information about a license server.",
-			[]string{"virtual_group_id", "virtual_group_name", "server_id", "server_name", "status", "deployed_on", "leasing_mode"},
-			constLabel,
-		),
-		serverFeatureCapacity: prometheus.NewDesc(
-			"nvidia_cls_license_server_feature_total_quantity",
-			"Total server feature capacity from license-server features.",
-			[]string{"virtual_group_id", "virtual_group_name", "server_id", "server_name", "feature_name", "product_name", "license_type"},
-			constLabel,
-		),
-		serverFeatureActiveDesc: prometheus.NewDesc(
-			"nvidia_cls_license_server_feature_active_leases",
-			"Active lease count by server feature from CLS active-lease data.",
-			[]string{"virtual_group_id", "virtual_group_name", "server_id", "server_name", "feature_name", "product_name", "license_type"},
-			constLabel,
-		),
-	}
-
-	c.descs = []*prometheus.Desc{
-		c.upDesc,
-		c.scrapeDurationDesc,
-		c.scrapeTimestampDesc,
-		c.entitlementTotalDesc,
-		c.serverInfoDesc,
-		c.serverFeatureCapacity,
-		c.serverFeatureActiveDesc,
 	}
+	c.upDesc = c.newDesc(
+		"nvidia_cls_up",
+		"Whether the NVIDIA CLS scrape is successful (1 = up, 0 = down).",
+		"org_name",
+	)
+	c.scrapeDurationDesc = c.newDesc(
+		"nvidia_cls_scrape_duration_seconds",
+		"Time spent querying NVIDIA CLS APIs.",
+		"org_name",
+	)
+	c.scrapeTimestampDesc = c.newDesc(
+		"nvidia_cls_scrape_timestamp_seconds",
+		"Unix timestamp for when the scrape snapshot was collected.",
+		"org_name",
+	)
+	c.entitlementTotalDesc = c.newDesc(
+		"nvidia_cls_entitlement_total_quantity",
+		"Total entitlement quantity by virtual group and feature (contract capacity).",
+		"org_name", "virtual_group_id", "virtual_group_name", "feature_name", "feature_version", "product_name", "license_type",
+	)
+	c.serverInfoDesc = c.newDesc(
+		"nvidia_cls_license_server_info",
+		"Static information about a license server.",
+		"org_name", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "status", "deployed_on", "leasing_mode",
+	)
+	c.serverFeatureCapacity = c.newDesc(
+		"nvidia_cls_license_server_feature_total_quantity",
+		"Total server feature capacity from license-server features.",
+		"org_name", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "feature_name", "product_name", "license_type",
+	)
+	c.serverFeatureActiveDesc = c.newDesc(
+		"nvidia_cls_license_server_feature_active_leases",
+		"Active lease count by server feature from CLS active-lease data.",
+		"org_name", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "feature_name", "product_name", "license_type",
+	)
+	c.validationFailuresDesc = c.newDesc(
+		"nvidia_cls_exporter_snapshot_validation_failures_total",
+		"Number of fetched snapshots that failed a sanity check, by check.",
+		"org_name", "check",
+	)
+	c.snapshotBytesDesc = c.newDesc(
+		"nvidia_cls_exporter_snapshot_bytes",
+		"Approximate in-memory size of the cached snapshot in bytes.",
+		"org_name",
+	)
+	c.snapshotElementsDesc = c.newDesc(
+		"nvidia_cls_exporter_snapshot_elements",
+		"Number of elements in each section of the cached snapshot.",
+		"org_name", "section",
+	)
+	c.snapshotTruncatedDesc = c.newDesc(
+		"nvidia_cls_exporter_snapshot_truncated",
+		"Whether sections were dropped from the cached snapshot to respect the size cap (1 = truncated).",
+		"org_name",
+	)
+	c.consecutiveFailuresDesc = c.newDesc(
+		"nvidia_cls_exporter_refresh_consecutive_failures",
+		"Number of consecutive failed CLS snapshot fetches.",
+		"org_name",
+	)
+	c.backoffRemainingDesc = c.newDesc(
+		"nvidia_cls_exporter_refresh_backoff_remaining_seconds",
+		"Seconds until CLS fetches are retried after consecutive failures (0 = not backing off).",
+		"org_name",
+	)
+	c.phaseDurationDesc = c.newDesc(
+		"nvidia_cls_scrape_phase_duration_seconds",
+		"Time spent in each phase of the most recent successful CLS fetch.",
+		"org_name", "phase",
+	)
+	c.phaseItemsDesc = c.newDesc(
+		"nvidia_cls_scrape_phase_items",
+		"Number of items returned by each phase of the most recent successful CLS fetch.",
+		"org_name", "phase",
+	)
+	c.lastErrorDesc = c.newDesc(
+		"nvidia_cls_last_error_info",
+		"Error of the most recent failed refresh; absent once a refresh succeeds.",
+		"org_name", "error",
+	)
+	c.leaseChangesDesc = c.newDesc(
+		"nvidia_cls_lease_changes_total",
+		"Active leases gained and lost between consecutive snapshots, by direction.",
+		"org_name", "direction",
+	)
+	c.apiRequestsDesc = c.newDesc(
+		"nvidia_cls_exporter_api_requests_total",
+		"Number of CLS API requests by operation and response code.",
+		"org_name", "operation", "code",
+	)
+	return c
+}
+
+// newDesc returns the descriptor of a metric of the collector and records it
+// for Describe and Metrics.
+func (c *Collector) newDesc(name, help string, labels ...string) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labels, nil)
+	c.descs = append(c.descs, desc)
+	c.metrics = append(c.metrics, Metric{Name: name, Help: help, Labels: labels})
+	return desc
+}
+
+// Metric describes a metric of the Collector.
+type Metric struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+// Metrics returns every metric of the collector, in the order of Describe,
+// for generated dashboards and documentation.
+func (c *Collector) Metrics() []Metric {
+	return slices.Clone(c.metrics)
+}
 
+// NewCacheBypassCollector returns a collector that force-refreshes every
+// target on each scrape instead of serving the cached snapshot.
+func NewCacheBypassCollector(manager *snapshot.Manager, scrapeTimeout time.Duration) *Collector {
+	c := NewCollector(manager, scrapeTimeout)
+	c.bypassCache = true
 	return c
 }
 
@@ -100,24 +178,46 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTimeout)
 	defer cancel()
 
-	snapshot, meta, err := c.snapshotSvc.Get(ctx)
-	if err != nil {
-		log.Printf("cls scrape failed: %v", err)
-		lastMeta := c.snapshotSvc.Meta()
-		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0)
-		ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, lastMeta.DurationSeconds)
+	var results []snapshot.Result
+	if c.bypassCache {
+		results = c.manager.ForceRefreshAll(ctx)
+	} else {
+		results = c.manager.GetAll(ctx)
+	}
+	for _, result := range results {
+		c.collectTarget(ch, result)
+	}
+}
+
+func (c *Collector) collectTarget(ch chan<- prometheus.Metric, result snapshot.Result) {
+	org := result.Service.Target()
+	c.collectValidationFailures(ch, result.Service)
+	c.collectFootprint(ch, result.Service)
+	c.collectBackoff(ch, result.Service)
+	c.collectActivity(ch, result.Service)
+	if result.Err != nil {
+		slog.Error("cls scrape failed", "org", org, "error", result.Err)
+	}
+	if result.Snapshot == nil {
+		lastMeta := result.Service.Meta()
+		ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, 0, org)
+		ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, lastMeta.DurationSeconds, org)
 		if !lastMeta.Timestamp.IsZero() {
-			ch <- prometheus.MustNewConstMetric(c.scrapeTimestampDesc, prometheus.GaugeValue, float64(lastMeta.Timestamp.Unix()))
+			ch <- prometheus.MustNewConstMetric(c.scrapeTimestampDesc, prometheus.GaugeValue, float64(lastMeta.Timestamp.Unix()), org)
 		}
+		c.collectMeta(ch, org, lastMeta)
 		return
 	}
 
-	ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, meta.Up)
-	ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, meta.DurationSeconds)
-	ch <- prometheus.MustNewConstMetric(c.scrapeTimestampDesc, prometheus.GaugeValue, float64(meta.Timestamp.Unix()))
+	snapshot, meta := result.Snapshot, result.Meta
+	ch <- prometheus.MustNewConstMetric(c.upDesc, prometheus.GaugeValue, meta.Up, org)
+	ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, meta.DurationSeconds, org)
+	ch <- prometheus.MustNewConstMetric(c.scrapeTimestampDesc, prometheus.GaugeValue, float64(meta.Timestamp.Unix()), org)
+	c.collectMeta(ch, org, meta)
 
 	for _, item := range snapshot.EntitlementFeatures {
 		labels := []string{
+			org,
 			strconv.Itoa(item.VirtualGroupID),
 			safeLabel(item.VirtualGroupName),
 			safeLabel(item.FeatureName),
@@ -130,6 +230,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 
 	for _, item := range snapshot.ServerFeatureCapacity {
 		labels := []string{
+			org,
 			strconv.Itoa(item.VirtualGroupID),
 			safeLabel(item.VirtualGroupName),
 			safeLabel(item.ServerID),
@@ -143,6 +244,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 
 	for _, item := range snapshot.ServerFeatureActiveLeases {
 		labels := []string{
+			org,
 			strconv.Itoa(item.VirtualGroupID),
 			safeLabel(item.VirtualGroupName),
 			safeLabel(item.ServerID),
@@ -156,6 +258,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 
 	for _, item := range snapshot.ServerUsage {
 		infoLabels := []string{
+			org,
 			strconv.Itoa(item.VirtualGroupID),
 			safeLabel(item.VirtualGroupName),
 			safeLabel(item.ServerID),
@@ -168,6 +271,73 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+func (c *Collector) collectValidationFailures(ch chan<- prometheus.Metric, svc *snapshot.Service) {
+	failures := svc.ValidationFailures()
+	for _, check := range snapshot.ValidationChecks {
+		ch <- prometheus.MustNewConstMetric(c.validationFailuresDesc, prometheus.CounterValue, failures[check], svc.Target(), check)
+	}
+}
+
+func (c *Collector) collectFootprint(ch chan<- prometheus.Metric, svc *snapshot.Service) {
+	org := svc.Target()
+	fp := svc.Footprint()
+	truncated := 0.0
+	if len(fp.Dropped) > 0 {
+		truncated = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.snapshotBytesDesc, prometheus.GaugeValue, float64(fp.Bytes), org)
+	ch <- prometheus.MustNewConstMetric(c.snapshotTruncatedDesc, prometheus.GaugeValue, truncated, org)
+	for _, section := range snapshot.Sections {
+		ch <- prometheus.MustNewConstMetric(c.snapshotElementsDesc, prometheus.GaugeValue, float64(fp.Elements[section]), org, section)
+	}
+}
+
+func (c *Collector) collectBackoff(ch chan<- prometheus.Metric, svc *snapshot.Service) {
+	org := svc.Target()
+	backoff := svc.Backoff()
+	remaining := time.Until(backoff.Until).Seconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.consecutiveFailuresDesc, prometheus.GaugeValue, float64(backoff.ConsecutiveFailures), org)
+	ch <- prometheus.MustNewConstMetric(c.backoffRemainingDesc, prometheus.GaugeValue, remaining, org)
+}
+
+func (c *Collector) collectActivity(ch chan<- prometheus.Metric, svc *snapshot.Service) {
+	org := svc.Target()
+	churn := svc.LeaseChurn()
+
+	ch <- prometheus.MustNewConstMetric(c.leaseChangesDesc, prometheus.CounterValue, churn.Added, org, "added")
+	ch <- prometheus.MustNewConstMetric(c.leaseChangesDesc, prometheus.CounterValue, churn.Removed, org, "removed")
+	for _, req := range svc.APIRequests() {
+		ch <- prometheus.MustNewConstMetric(c.apiRequestsDesc, prometheus.CounterValue, req.Count, org, req.Operation, req.Code)
+	}
+}
+
+func (c *Collector) collectMeta(ch chan<- prometheus.Metric, org string, meta snapshot.Meta) {
+	for _, phase := range meta.Phases {
+		ch <- prometheus.MustNewConstMetric(c.phaseDurationDesc, prometheus.GaugeValue, phase.DurationSeconds, org, phase.Name)
+		ch <- prometheus.MustNewConstMetric(c.phaseItemsDesc, prometheus.GaugeValue, float64(phase.Items), org, phase.Name)
+	}
+	if meta.LastError != "" {
+		ch <- prometheus.MustNewConstMetric(c.lastErrorDesc, prometheus.GaugeValue, 1, org, truncateLabel(meta.LastError, maxErrorLabelLength))
+	}
+}
+
+// maxErrorLabelLength bounds the error label so that long upstream responses
+// do not bloat the series.
+const maxErrorLabelLength = 256
+
+func truncateLabel(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit]) + "..."
+}
+
 func safeLabel(value string) string {
 	value = strings.TrimSpace(value)
 	if value == "" {
@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type staticFetcher struct {
+	snap *cls.Snapshot
+}
+
+func (f staticFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return f.snap, nil
+}
+
+func newTestManager(t *testing.T, snaps map[string]*cls.Snapshot) *snapshot.Manager {
+	t.Helper()
+	services := make([]*snapshot.Service, 0, len(snaps))
+	for _, org := range []string{"org-1", "org-2"} {
+		snap, ok := snaps[org]
+		if !ok {
+			continue
+		}
+		services = append(services, snapshot.NewService(staticFetcher{snap: snap}, snapshot.Config{Target: org, CacheTTL: time.Minute}))
+	}
+	manager, err := snapshot.NewManager(services...)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	return manager
+}
+
+func TestCollectorLabelsSeriesPerTarget(t *testing.T) {
+	ts := time.Unix(1700000000, 0).UTC()
+	manager := newTestManager(t, map[string]*cls.Snapshot{
+		"org-1": {
+			CollectedAt: ts,
+			EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+				{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "A", TotalQuantity: 10},
+			},
+		},
+		"org-2": {
+			CollectedAt: ts,
+			EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+				{VirtualGroupID: 2, VirtualGroupName: "VG", FeatureName: "A", TotalQuantity: 20},
+			},
+		},
+	})
+	collector := NewCollector(manager, time.Second)
+
+	expected := `
+# HELP nvidia_cls_entitlement_total_quantity Total entitlement quantity by virtual group and feature (contract capacity).
+# TYPE nvidia_cls_entitlement_total_quantity gauge
+nvidia_cls_entitlement_total_quantity{feature_name="A",feature_version="unknown",license_type="unknown",org_name="org-1",product_name="unknown",virtual_group_id="1",virtual_group_name="VG"} 10
+nvidia_cls_entitlement_total_quantity{feature_name="A",feature_version="unknown",license_type="unknown",org_name="org-2",product_name="unknown",virtual_group_id="2",virtual_group_name="VG"} 20
+# HELP nvidia_cls_up Whether the NVIDIA CLS scrape is successful (1 = up, 0 = down).
+# TYPE nvidia_cls_up gauge
+nvidia_cls_up{org_name="org-1"} 1
+nvidia_cls_up{org_name="org-2"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "nvidia_cls_up", "nvidia_cls_entitlement_total_quantity"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestCollectorExposesPhasesAndLastError(t *testing.T) {
+	fetcher := &failingFetcher{err: errors.New("upstream unavailable")}
+	svc := snapshot.NewService(fetcher, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	collector := NewCollector(manager, time.Second)
+
+	expected := `
+# HELP nvidia_cls_last_error_info Error of the most recent failed refresh; absent once a refresh succeeds.
+# TYPE nvidia_cls_last_error_info gauge
+nvidia_cls_last_error_info{error="upstream unavailable",org_name="org-1"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "nvidia_cls_last_error_info"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+
+	fetcher.err = nil
+	fetcher.snap = &cls.Snapshot{
+		CollectedAt: time.Now(),
+		Phases:      []cls.Phase{{Name: cls.PhaseLicensePools, DurationSeconds: 0.25, Items: 4}},
+	}
+	svc.Invalidate()
+
+	expected = `
+# HELP nvidia_cls_scrape_phase_duration_seconds Time spent in each phase of the most recent successful CLS fetch.
+# TYPE nvidia_cls_scrape_phase_duration_seconds gauge
+nvidia_cls_scrape_phase_duration_seconds{org_name="org-1",phase="license_pools"} 0.25
+# HELP nvidia_cls_scrape_phase_items Number of items returned by each phase of the most recent successful CLS fetch.
+# TYPE nvidia_cls_scrape_phase_items gauge
+nvidia_cls_scrape_phase_items{org_name="org-1",phase="license_pools"} 4
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"nvidia_cls_scrape_phase_duration_seconds", "nvidia_cls_scrape_phase_items", "nvidia_cls_last_error_info"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+type failingFetcher struct {
+	snap *cls.Snapshot
+	err  error
+}
+
+func (f *failingFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return f.snap, f.err
+}
+
+// TestCollectorConcurrentWithRefresh is meant to run under -race: scrapes
+// read the cached snapshot while refreshes replace it.
+func TestCollectorConcurrentWithRefresh(t *testing.T) {
+	fetcher := &countingFetcher{}
+	svc := snapshot.NewService(fetcher, snapshot.Config{Target: "org-1", CacheTTL: time.Millisecond})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(manager, time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if _, err := registry.Gather(); err != nil {
+					t.Errorf("gather: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			manager.ForceRefreshAll(ctx)
+		}
+	}()
+	wg.Wait()
+}
+
+type countingFetcher struct {
+	calls atomic.Int64
+}
+
+func (f *countingFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	n := float64(f.calls.Add(1))
+	return &cls.Snapshot{
+		CollectedAt: time.Now(),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "A", TotalQuantity: n},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{{VirtualGroupID: 1, ServerID: "srv-1", InUse: n}},
+		Phases:      []cls.Phase{{Name: cls.PhaseLicensePools, DurationSeconds: n}},
+	}, nil
+}
+
+func TestCacheBypassCollectorRefetches(t *testing.T) {
+	fetcher := &countingFetcher{}
+	svc := snapshot.NewService(fetcher, snapshot.Config{Target: "org-1", CacheTTL: time.Hour})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	cached := NewCollector(manager, time.Second)
+	bypass := NewCacheBypassCollector(manager, time.Second)
+	for _, c := range []prometheus.Collector{cached, cached, bypass, bypass} {
+		if n := testutil.CollectAndCount(c, "nvidia_cls_up"); n != 1 {
+			t.Fatalf("expected one up series, got %d", n)
+		}
+	}
+	if got := fetcher.calls.Load(); got != 3 {
+		t.Fatalf("expected 1 cached + 2 bypass fetches, got %d", got)
+	}
+}
+
+func TestCollectorServesStaleSnapshotWithError(t *testing.T) {
+	fetcher := &failingFetcher{snap: &cls.Snapshot{
+		CollectedAt: time.Unix(1700000000, 0),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "A", TotalQuantity: 10},
+		},
+	}}
+	svc := snapshot.NewService(fetcher, snapshot.Config{Target: "org-1", CacheTTL: time.Minute, StalePolicy: snapshot.StaleError})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	fetcher.snap, fetcher.err = nil, errors.New("upstream unavailable")
+	svc.Invalidate()
+
+	expected := `
+# HELP nvidia_cls_entitlement_total_quantity Total entitlement quantity by virtual group and feature (contract capacity).
+# TYPE nvidia_cls_entitlement_total_quantity gauge
+nvidia_cls_entitlement_total_quantity{feature_name="A",feature_version="unknown",license_type="unknown",org_name="org-1",product_name="unknown",virtual_group_id="1",virtual_group_name="VG"} 10
+# HELP nvidia_cls_up Whether the NVIDIA CLS scrape is successful (1 = up, 0 = down).
+# TYPE nvidia_cls_up gauge
+nvidia_cls_up{org_name="org-1"} 0
+`
+	if err := testutil.CollectAndCompare(NewCollector(manager, time.Second), strings.NewReader(expected), "nvidia_cls_up", "nvidia_cls_entitlement_total_quantity"); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package exporter
+
+import (
+	"strconv"
+
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// Sample is one value of a core metric, for push backends that do not use
+// the Prometheus exposition format. Names and labels match the collector.
+type Sample struct {
+	Name   string
+	Value  float64
+	Labels []Label
+}
+
+// Label is one label of a Sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// CoreSamples returns the core gauges of svc from its cached snapshot,
+// without triggering a refresh: health, entitlements, server capacity and
+// active leases. org_name is always the first label.
+func CoreSamples(svc *snapshot.Service) []Sample {
+	org := Label{Name: "org_name", Value: svc.Target()}
+	var samples []Sample
+	add := func(name string, value float64, labels ...Label) {
+		samples = append(samples, Sample{Name: name, Value: value, Labels: append([]Label{org}, labels...)})
+	}
+
+	add("nvidia_cls_exporter_refresh_consecutive_failures", float64(svc.Backoff().ConsecutiveFailures))
+	snap, meta, ok := svc.Latest()
+	add("nvidia_cls_up", meta.Up)
+	add("nvidia_cls_scrape_duration_seconds", meta.DurationSeconds)
+	if !ok {
+		return samples
+	}
+
+	for _, item := range snap.EntitlementFeatures {
+		add("nvidia_cls_entitlement_total_quantity", item.TotalQuantity,
+			Label{"virtual_group_id", strconv.Itoa(item.VirtualGroupID)},
+			Label{"virtual_group_name", safeLabel(item.VirtualGroupName)},
+			Label{"feature_name", safeLabel(item.FeatureName)},
+			Label{"feature_version", safeLabel(item.FeatureVersion)},
+			Label{"product_name", safeLabel(item.ProductName)},
+			Label{"license_type", safeLabel(item.LicenseType)},
+		)
+	}
+	for _, item := range snap.ServerFeatureCapacity {
+		add("nvidia_cls_license_server_feature_total_quantity", item.TotalQuantity, serverFeatureLabels(
+			item.VirtualGroupID, item.VirtualGroupName, item.ServerID, item.ServerName, item.FeatureName, item.ProductName, item.LicenseType)...)
+	}
+	for _, item := range snap.ServerFeatureActiveLeases {
+		add("nvidia_cls_license_server_feature_active_leases", item.ActiveLeases, serverFeatureLabels(
+			item.VirtualGroupID, item.VirtualGroupName, item.ServerID, item.ServerName, item.FeatureName, item.ProductName, item.LicenseType)...)
+	}
+	return samples
+}
+
+func serverFeatureLabels(vgID int, vgName, serverID, serverName, feature, product, licenseType string) []Label {
+	return []Label{
+		{"virtual_group_id", strconv.Itoa(vgID)},
+		{"virtual_group_name", safeLabel(vgName)},
+		{"server_id", safeLabel(serverID)},
+		{"server_name", safeLabel(serverName)},
+		{"feature_name", safeLabel(feature)},
+		{"product_name", safeLabel(product)},
+		{"license_type", safeLabel(licenseType)},
+	}
+}
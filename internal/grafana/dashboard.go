@@ -0,0 +1,143 @@
+// Package grafana renders a Grafana dashboard for the exporter's metrics.
+// It is generated from the collector's metric descriptors rather than kept
+// as a JSON file, so that every metric added to the collector shows up in
+// it without anyone editing the dashboard.
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/exporter"
+)
+
+// UID is the dashboard UID, stable so that re-provisioning replaces the
+// dashboard instead of adding a copy.
+const UID = "nvidia-cls"
+
+// Panels are laid out two per row.
+const (
+	panelWidth  = 12
+	panelHeight = 8
+)
+
+// Dashboard returns the dashboard JSON with a panel per metric, templated
+// by a Prometheus data source, org and virtual group. Metrics with a
+// virtual_group_name label are filtered by the virtual group variable,
+// counters (named *_total) are shown as rates and info metrics (named
+// *_info) as tables of their labels.
+func Dashboard(metrics []exporter.Metric) ([]byte, error) {
+	panels := make([]map[string]any, 0, len(metrics))
+	for i, m := range metrics {
+		panels = append(panels, panel(i+1, m))
+	}
+	dashboard := map[string]any{
+		"uid":           UID,
+		"title":         "NVIDIA License System (CLS)",
+		"tags":          []string{"nvidia", "licensing"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"editable":      true,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{
+			{
+				"name":  "datasource",
+				"label": "Data source",
+				"type":  "datasource",
+				"query": "prometheus",
+			},
+			variable("org_name", "Org", "label_values(nvidia_cls_up, org_name)"),
+			variable("virtual_group_name", "Virtual group", `label_values(nvidia_cls_entitlement_total_quantity{org_name=~"$org_name"}, virtual_group_name)`),
+		}},
+		"panels": panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// variable is a multi-value query variable defaulting to all values.
+func variable(name, label, query string) map[string]any {
+	return map[string]any{
+		"name":       name,
+		"label":      label,
+		"type":       "query",
+		"datasource": datasource,
+		"query":      map[string]string{"query": query, "refId": name},
+		"definition": query,
+		"refresh":    2,
+		"multi":      true,
+		"includeAll": true,
+		"allValue":   ".*",
+		"current":    map[string]any{"text": "All", "value": "$__all"},
+		"sort":       1,
+	}
+}
+
+var datasource = map[string]string{"type": "prometheus", "uid": "${datasource}"}
+
+func panel(id int, m exporter.Metric) map[string]any {
+	matchers := []string{`org_name=~"$org_name"`}
+	if slices.Contains(m.Labels, "virtual_group_name") {
+		matchers = append(matchers, `virtual_group_name=~"$virtual_group_name"`)
+	}
+	selector := fmt.Sprintf("%s{%s}", m.Name, strings.Join(matchers, ","))
+
+	var legend []string
+	for _, label := range m.Labels {
+		if label != "virtual_group_id" {
+			legend = append(legend, "{{"+label+"}}")
+		}
+	}
+	target := map[string]any{
+		"refId":        "A",
+		"datasource":   datasource,
+		"expr":         selector,
+		"legendFormat": strings.Join(legend, " "),
+	}
+	p := map[string]any{
+		"id":          id,
+		"title":       m.Name,
+		"description": m.Help,
+		"datasource":  datasource,
+		"gridPos": map[string]int{
+			"x": (id - 1) % 2 * panelWidth,
+			"y": (id - 1) / 2 * panelHeight,
+			"w": panelWidth,
+			"h": panelHeight,
+		},
+		"targets": []map[string]any{target},
+	}
+	switch {
+	case strings.HasSuffix(m.Name, "_info"):
+		target["instant"] = true
+		target["format"] = "table"
+		p["type"] = "table"
+		p["transformations"] = []map[string]any{{
+			"id":      "organize",
+			"options": map[string]any{"excludeByName": map[string]bool{"Time": true, "Value": true, "__name__": true}},
+		}}
+	case strings.HasSuffix(m.Name, "_total"):
+		target["expr"] = fmt.Sprintf("rate(%s[$__rate_interval])", selector)
+		p["type"] = "timeseries"
+		p["fieldConfig"] = map[string]any{"defaults": map[string]string{"unit": "ops"}}
+	default:
+		p["type"] = "timeseries"
+		p["fieldConfig"] = map[string]any{"defaults": map[string]string{"unit": unit(m.Name)}}
+	}
+	return p
+}
+
+// unit picks the Grafana unit from the metric name suffix.
+func unit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		return "dateTimeFromNow"
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "bytes"
+	}
+	return "short"
+}
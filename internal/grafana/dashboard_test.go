@@ -0,0 +1,74 @@
+package grafana
+
+import (
+	"encoding/json"
+	"testing"
+
+	"nvidia-license-server-exporter/internal/exporter"
+)
+
+type testDashboard struct {
+	UID        string `json:"uid"`
+	Templating struct {
+		List []struct {
+			Name string `json:"name"`
+		} `json:"list"`
+	} `json:"templating"`
+	Panels []struct {
+		ID      int    `json:"id"`
+		Type    string `json:"type"`
+		Title   string `json:"title"`
+		GridPos struct {
+			X, Y int
+		} `json:"gridPos"`
+		Targets []struct {
+			Expr string `json:"expr"`
+		} `json:"targets"`
+	} `json:"panels"`
+}
+
+func TestDashboard(t *testing.T) {
+	raw, err := Dashboard([]exporter.Metric{
+		{Name: "nvidia_cls_up", Help: "Up.", Labels: []string{"org_name"}},
+		{Name: "nvidia_cls_entitlement_total_quantity", Help: "Quantity.", Labels: []string{"org_name", "virtual_group_id", "virtual_group_name"}},
+		{Name: "nvidia_cls_scrape_errors_total", Help: "Errors.", Labels: []string{"org_name"}},
+		{Name: "nvidia_cls_last_error_info", Help: "Last error.", Labels: []string{"org_name", "error"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d testDashboard
+	if err := json.Unmarshal(raw, &d); err != nil {
+		t.Fatalf("decode dashboard: %v", err)
+	}
+	if d.UID != UID {
+		t.Errorf("uid %q, want %q", d.UID, UID)
+	}
+	var variables []string
+	for _, v := range d.Templating.List {
+		variables = append(variables, v.Name)
+	}
+	if len(variables) != 3 || variables[1] != "org_name" || variables[2] != "virtual_group_name" {
+		t.Errorf("unexpected variables %v", variables)
+	}
+
+	want := []struct{ title, typ, expr string }{
+		{"nvidia_cls_up", "timeseries", `nvidia_cls_up{org_name=~"$org_name"}`},
+		{"nvidia_cls_entitlement_total_quantity", "timeseries", `nvidia_cls_entitlement_total_quantity{org_name=~"$org_name",virtual_group_name=~"$virtual_group_name"}`},
+		{"nvidia_cls_scrape_errors_total", "timeseries", `rate(nvidia_cls_scrape_errors_total{org_name=~"$org_name"}[$__rate_interval])`},
+		{"nvidia_cls_last_error_info", "table", `nvidia_cls_last_error_info{org_name=~"$org_name"}`},
+	}
+	if len(d.Panels) != len(want) {
+		t.Fatalf("expected %d panels, got %d", len(want), len(d.Panels))
+	}
+	for i, w := range want {
+		p := d.Panels[i]
+		if p.Title != w.title || p.Type != w.typ || len(p.Targets) != 1 || p.Targets[0].Expr != w.expr {
+			t.Errorf("panel %d: got %s %s %+v, want %s %s %s", i, p.Title, p.Type, p.Targets, w.title, w.typ, w.expr)
+		}
+	}
+	// Two panels per row.
+	if p := d.Panels[3]; p.GridPos.X != panelWidth || p.GridPos.Y != panelHeight {
+		t.Errorf("panel 4 at %+v", p.GridPos)
+	}
+}
@@ -0,0 +1,205 @@
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	DefaultPrefix = "nvidia_cls"
+
+	defaultInterval = 60 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+type Config struct {
+	// Address is the host:port of a carbon plaintext listener, usually
+	// port 2003.
+	Address string
+	// Prefix is the first path component of every metric; DefaultPrefix
+	// when empty.
+	Prefix   string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Pusher periodically sends the core gauges of every target to Graphite over
+// the plaintext protocol. Each label value becomes a path component, so
+// nvidia_cls_license_server_feature_active_leases for org lic-1 becomes
+// nvidia_cls.lic-1.license_server_feature_active_leases.<vg id>.<vg name>...
+type Pusher struct {
+	cfg     Config
+	manager *snapshot.Manager
+	prefix  string
+
+	pushes *prometheus.CounterVec
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPusher(cfg Config, manager *snapshot.Manager) (*Pusher, error) {
+	cfg.Address = strings.TrimPrefix(strings.TrimSpace(cfg.Address), "tcp://")
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("graphite address is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid graphite address %q: %w", cfg.Address, err)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	prefix := strings.Trim(strings.TrimSpace(cfg.Prefix), ".")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	p := &Pusher{
+		cfg:     cfg,
+		manager: manager,
+		prefix:  prefix,
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_graphite_pushes_total",
+			Help: "Graphite pushes by result.",
+		}, []string{"result"}),
+		done: make(chan struct{}),
+	}
+	p.pushes.WithLabelValues("success")
+	p.pushes.WithLabelValues("failure")
+	return p, nil
+}
+
+// Collector exposes the push counters for a Prometheus registry.
+func (p *Pusher) Collector() prometheus.Collector {
+	return p.pushes
+}
+
+func (p *Pusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go func() {
+		defer close(p.done)
+
+		p.pushOnce(ctx)
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.pushOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (p *Pusher) Shutdown(ctx context.Context) error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pusher) pushOnce(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	n, err := p.Push(ctx)
+	if err != nil {
+		p.pushes.WithLabelValues("failure").Inc()
+		slog.ErrorContext(ctx, "graphite push failed", "address", p.cfg.Address, "error", err)
+		return
+	}
+	p.pushes.WithLabelValues("success").Inc()
+	slog.DebugContext(ctx, "graphite push succeeded", "address", p.cfg.Address, "metrics", n)
+}
+
+// Push sends the cached gauges of every target over one connection and
+// returns the number of metrics sent. It never triggers a refresh.
+func (p *Pusher) Push(ctx context.Context) (int, error) {
+	var samples []exporter.Sample
+	for _, svc := range p.manager.Services() {
+		samples = append(samples, exporter.CoreSamples(svc)...)
+	}
+	body := encodeLines(p.prefix, samples, time.Now())
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.cfg.Address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+	if _, err := conn.Write(body); err != nil {
+		return 0, err
+	}
+	return len(samples), nil
+}
+
+// encodeLines renders samples as "path value timestamp" lines. The org is the
+// second path component, then the metric name without its nvidia_cls_
+// prefix, then the remaining label values in order.
+func encodeLines(prefix string, samples []exporter.Sample, now time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(now.Unix(), 10)
+	for _, sample := range samples {
+		buf.WriteString(prefix)
+		labels := sample.Labels
+		if len(labels) > 0 && labels[0].Name == "org_name" {
+			buf.WriteByte('.')
+			buf.WriteString(pathComponent(labels[0].Value))
+			labels = labels[1:]
+		}
+		buf.WriteByte('.')
+		buf.WriteString(pathComponent(strings.TrimPrefix(sample.Name, "nvidia_cls_")))
+		for _, label := range labels {
+			buf.WriteByte('.')
+			buf.WriteString(pathComponent(label.Value))
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// pathComponent replaces everything but letters, digits, '-' and '_' with
+// '_', so a label value can never add a level or break the line.
+func pathComponent(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+}
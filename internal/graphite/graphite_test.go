@@ -0,0 +1,89 @@
+package graphite
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type fetcher struct{}
+
+func (fetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return &cls.Snapshot{
+		CollectedAt: time.Now().UTC(),
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{ServerName: "server.1", FeatureName: "vGPU", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 7},
+		},
+	}, nil
+}
+
+func TestPusherSendsPlaintextLines(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		body, _ := io.ReadAll(conn)
+		received <- string(body)
+	}()
+
+	svc := snapshot.NewService(fetcher{}, snapshot.Config{Target: "lic-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	pusher, err := NewPusher(Config{Address: listener.Addr().String(), Prefix: "factory.licensing."}, manager)
+	if err != nil {
+		t.Fatalf("new pusher: %v", err)
+	}
+	if _, err := pusher.Push(context.Background()); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	var got string
+	select {
+	case got = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no data received")
+	}
+	for _, want := range []string{
+		"factory.licensing.lic-1.up 1 ",
+		"factory.licensing.lic-1.license_server_feature_active_leases.0.unknown.unknown.server_1.vGPU.unknown.CONCURRENT_COUNTED_SINGLE 7 ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestEncodeLinesWithoutOrgLabel(t *testing.T) {
+	got := string(encodeLines("p", []exporter.Sample{{
+		Name:   "nvidia_cls_x",
+		Value:  2.5,
+		Labels: []exporter.Label{{Name: "a", Value: "b c"}, {Name: "d", Value: ""}},
+	}}, time.Unix(100, 0)))
+	if want := "p.x.b_c.unknown 2.5 100\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if _, err := NewPusher(Config{Address: "carbon"}, nil); err == nil {
+		t.Fatalf("expected error for address without port")
+	}
+}
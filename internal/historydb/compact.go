@@ -0,0 +1,208 @@
+package historydb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// settle is how long after a period ends its rollup waits for records of
+// refreshes still in flight.
+const settle = 10 * time.Minute
+
+// The shortest raw and hourly retentions: records must outlive the period
+// they are rolled up in, the settle time and a compaction interval.
+const (
+	MinRetention       = 2 * time.Hour
+	MinHourlyRetention = 48 * time.Hour
+)
+
+// downsampling is a rollup tier and the tier it condenses.
+var downsampling = []struct {
+	src, dst Tier
+	period   time.Duration
+}{
+	{TierRaw, TierHourly, time.Hour},
+	{TierHourly, TierDaily, day},
+}
+
+func (d *DB) retention(tier Tier) time.Duration {
+	switch tier {
+	case TierHourly:
+		return d.cfg.HourlyRetention
+	case TierDaily:
+		return d.cfg.DailyRetention
+	default:
+		return d.cfg.Retention
+	}
+}
+
+// CompactionResult counts what a compaction did per tier.
+type CompactionResult struct {
+	Rollups map[Tier]int
+	Expired map[Tier]int
+	// Records are the records kept per tier afterwards.
+	Records map[Tier]int
+}
+
+// runCompaction compacts the database and records the outcome in the
+// metrics.
+func (d *DB) runCompaction() {
+	start := time.Now()
+	result, err := d.Compact(start)
+	d.compactionDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		d.compactions.WithLabelValues("failure").Inc()
+		slog.Error("history db compaction failed", "path", d.cfg.Path, "error", err)
+		return
+	}
+	d.compactions.WithLabelValues("success").Inc()
+	for _, tier := range tiers {
+		d.records.WithLabelValues(string(tier)).Set(float64(result.Records[tier]))
+	}
+	slog.Debug("history db compacted", "path", d.cfg.Path, "rollups", result.Rollups, "expired", result.Expired, "duration", time.Since(start))
+}
+
+// Compact rolls every period of every org that ended a while before now up
+// into the next tier, then deletes the records of each tier older than its
+// retention. Periods are in UTC, and only those after the newest rollup are
+// rolled up, so a period is never rolled up twice.
+func (d *DB) Compact(now time.Time) (CompactionResult, error) {
+	result := CompactionResult{Rollups: make(map[Tier]int), Expired: make(map[Tier]int), Records: make(map[Tier]int)}
+	err := d.handle.db.Update(func(tx *bolt.Tx) error {
+		orgs, err := orgNames(tx)
+		if err != nil {
+			return err
+		}
+		for _, org := range orgs {
+			for _, ds := range downsampling {
+				n, err := downsample(tx, org, ds.src, ds.dst, ds.period, now)
+				if err != nil {
+					return fmt.Errorf("%s rollups of %s: %w", ds.dst, org, err)
+				}
+				result.Rollups[ds.dst] += n
+			}
+			for _, tier := range tiers {
+				n, err := expire(orgBucket(tx, tier, org), now, d.retention(tier))
+				if err != nil {
+					return fmt.Errorf("expire %s records of %s: %w", tier, org, err)
+				}
+				result.Expired[tier] += n
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	// Bucket statistics only cover committed pages.
+	err = d.handle.db.View(func(tx *bolt.Tx) error {
+		for _, tier := range tiers {
+			err := tx.Bucket([]byte(tier)).ForEachBucket(func(org []byte) error {
+				result.Records[tier] += orgBucket(tx, tier, string(org)).Stats().KeyN
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// orgNames lists the orgs with raw records.
+func orgNames(tx *bolt.Tx) ([]string, error) {
+	var out []string
+	err := tx.Bucket([]byte(TierRaw)).ForEachBucket(func(name []byte) error {
+		out = append(out, string(name))
+		return nil
+	})
+	return out, err
+}
+
+// downsample adds a dst rollup for every complete period of org with src
+// records after the newest dst rollup, and returns how many it added.
+func downsample(tx *bolt.Tx, org string, src, dst Tier, period time.Duration, now time.Time) (int, error) {
+	from := orgBucket(tx, src, org)
+	if from == nil {
+		return 0, nil
+	}
+	to, err := tx.Bucket([]byte(dst)).CreateBucketIfNotExists([]byte(org))
+	if err != nil {
+		return 0, err
+	}
+	var start time.Time
+	if k, _ := to.Cursor().Last(); k != nil {
+		start = keyTime(k).Add(period)
+	} else if k, _ := from.Cursor().First(); k != nil {
+		start = keyTime(k).Truncate(period)
+	} else {
+		return 0, nil
+	}
+	end := timeKey(now.Add(-settle).Truncate(period))
+
+	var (
+		added   int
+		builder *rollupBuilder
+	)
+	flush := func() error {
+		if builder == nil {
+			return nil
+		}
+		value, err := json.Marshal(builder.rollup())
+		if err != nil {
+			return err
+		}
+		added++
+		return to.Put(timeKey(builder.start), value)
+	}
+	c := from.Cursor()
+	for k, v := c.Seek(timeKey(start)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+		periodStart := keyTime(k).Truncate(period)
+		if builder == nil || !builder.start.Equal(periodStart) {
+			if err := flush(); err != nil {
+				return added, err
+			}
+			builder = newRollupBuilder(periodStart)
+		}
+		if src == TierRaw {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return added, fmt.Errorf("decode record at %s: %w", keyTime(k), err)
+			}
+			builder.addRecord(r)
+		} else {
+			var r Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return added, fmt.Errorf("decode rollup at %s: %w", keyTime(k), err)
+			}
+			builder.addRollup(r)
+		}
+	}
+	return added, flush()
+}
+
+// expire deletes the keys of b older than retention and returns how many
+// it deleted. Zero retention keeps every key; b may be nil.
+func expire(b *bolt.Bucket, now time.Time, retention time.Duration) (int, error) {
+	if b == nil || retention <= 0 {
+		return 0, nil
+	}
+	cutoff := timeKey(now.Add(-retention))
+	n := 0
+	// Cursor.Next may skip the key after a deleted one, so each deletion
+	// starts over.
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,153 @@
+package historydb
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+)
+
+// MinForecastDays is the fewest complete days of history a forecast is
+// fitted to.
+const MinForecastDays = 7
+
+// Forecast is when the in-use quantity of an entitlement feature of a
+// virtual group is expected to reach its entitled quantity, from a linear
+// trend of the daily peaks.
+type Forecast struct {
+	VirtualGroupID   int
+	VirtualGroupName string
+	FeatureName      string
+	LicenseType      string
+	// Entitled is the entitled quantity of the latest day.
+	Entitled float64
+	// PeakInUse is the peak in-use quantity of the latest day.
+	PeakInUse float64
+	// Slope is how much the daily peak grows per day.
+	Slope float64
+	// Level is where the trend stands now.
+	Level float64
+	// Days is how many days from now the trend reaches Entitled: zero if it
+	// has already, and +Inf if it is flat or falling below Entitled.
+	Days float64
+	// Points is the number of days the trend is fitted to.
+	Points int
+}
+
+// ExhaustsAt is when the trend reaches Entitled, or the zero time if it
+// never does.
+func (f Forecast) ExhaustsAt(now time.Time) time.Time {
+	if math.IsInf(f.Days, 1) {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(f.Days * float64(day)))
+}
+
+// Forecasts fits a forecast to the complete UTC days of org within window
+// before now, for every feature of the latest of those days that is
+// entitled and was recorded on at least MinForecastDays of them.
+func (d *DB) Forecasts(org string, window time.Duration, now time.Time) ([]Forecast, error) {
+	today := now.UTC().Truncate(day)
+	days, err := d.Daily(org, now.Add(-window), today.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	return forecast(days, now), nil
+}
+
+// forecast fits a least-squares line to the daily peak in-use quantity of
+// each feature of the latest of days, with time in days.
+func forecast(days []Rollup, now time.Time) []Forecast {
+	if len(days) == 0 {
+		return nil
+	}
+	type series struct{ x, y []float64 }
+	points := make(map[featureKey]*series)
+	for _, r := range days {
+		x := float64(r.Start.Unix()) / day.Seconds()
+		for _, f := range r.Features {
+			key := featureKey{f.VirtualGroupID, f.FeatureName, f.LicenseType}
+			s, ok := points[key]
+			if !ok {
+				s = &series{}
+				points[key] = s
+			}
+			s.x = append(s.x, x)
+			s.y = append(s.y, f.InUse.Max)
+		}
+	}
+
+	x := float64(now.Unix()) / day.Seconds()
+	var out []Forecast
+	for _, f := range days[len(days)-1].Features {
+		s := points[featureKey{f.VirtualGroupID, f.FeatureName, f.LicenseType}]
+		if f.Allocated.Max <= 0 || len(s.x) < MinForecastDays {
+			continue
+		}
+		slope, intercept := fitLine(s.x, s.y)
+		fc := Forecast{
+			VirtualGroupID:   f.VirtualGroupID,
+			VirtualGroupName: f.VirtualGroupName,
+			FeatureName:      f.FeatureName,
+			LicenseType:      f.LicenseType,
+			Entitled:         f.Allocated.Max,
+			PeakInUse:        f.InUse.Max,
+			Slope:            slope,
+			Level:            intercept + slope*x,
+			Points:           len(s.x),
+		}
+		switch {
+		case fc.Level >= fc.Entitled:
+			fc.Days = 0
+		case slope <= 0:
+			fc.Days = math.Inf(1)
+		default:
+			fc.Days = (fc.Entitled - fc.Level) / slope
+		}
+		out = append(out, fc)
+	}
+	return out
+}
+
+// fitLine returns the least-squares slope and intercept of y over x. The x
+// values are centered first, as days since the epoch are large next to
+// their spread.
+func fitLine(x, y []float64) (slope, intercept float64) {
+	n := float64(len(x))
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+	var sxy, sxx float64
+	for i := range x {
+		dx := x[i] - meanX
+		sxy += dx * (y[i] - meanY)
+		sxx += dx * dx
+	}
+	if sxx == 0 {
+		return 0, meanY
+	}
+	slope = sxy / sxx
+	return slope, meanY - slope*meanX
+}
+
+// updateForecasts sets the forecast gauge of every org, leaving out
+// features whose trend never reaches their entitled quantity.
+func (d *DB) updateForecasts(now time.Time) {
+	d.forecasts.Reset()
+	for _, svc := range d.manager.Services() {
+		forecasts, err := d.Forecasts(svc.Target(), d.cfg.ForecastWindow, now)
+		if err != nil {
+			slog.Error("history db forecast failed", "org", svc.Target(), "path", d.cfg.Path, "error", err)
+			continue
+		}
+		for _, f := range forecasts {
+			if !math.IsInf(f.Days, 1) {
+				d.forecasts.WithLabelValues(svc.Target(), strconv.Itoa(f.VirtualGroupID), f.VirtualGroupName, f.FeatureName, f.LicenseType).Set(f.Days)
+			}
+		}
+	}
+}
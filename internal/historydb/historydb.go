@@ -0,0 +1,444 @@
+// Package historydb keeps a compact record of every refresh in an embedded
+// bbolt database, so that usage can be queried back in time where
+// Prometheus keeps little history.
+package historydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	// lockTimeout bounds the wait for the file lock held by another process.
+	lockTimeout = 5 * time.Second
+
+	// compactInterval is how often records are downsampled and expired.
+	compactInterval = 15 * time.Minute
+)
+
+type Config struct {
+	// Path is the database file, created if missing.
+	Path string
+	// Retention is how long raw records are kept; zero keeps them forever.
+	Retention time.Duration
+	// HourlyRetention and DailyRetention are how long hourly and daily
+	// rollups are kept; zero keeps them forever.
+	HourlyRetention time.Duration
+	DailyRetention  time.Duration
+	// ForecastWindow is how far back the exhaustion forecasts are fitted.
+	ForecastWindow time.Duration
+}
+
+// Tier is a resolution of the records: raw refreshes, or hourly or daily
+// rollups.
+type Tier string
+
+const (
+	TierRaw    Tier = "raw"
+	TierHourly Tier = "hourly"
+	TierDaily  Tier = "daily"
+)
+
+var tiers = []Tier{TierRaw, TierHourly, TierDaily}
+
+// DB records every refresh of each target and downsamples the records to
+// hourly and daily rollups. Each tier is a root bucket with a bucket per
+// org, keyed by the big-endian time in Unix nanoseconds: the collection
+// time of raw records and the start of the period of rollups.
+type DB struct {
+	cfg     Config
+	handle  *handle
+	manager *snapshot.Manager
+
+	writes             *prometheus.CounterVec
+	compactions        *prometheus.CounterVec
+	compactionDuration prometheus.Gauge
+	records            *prometheus.GaugeVec
+	size               prometheus.GaugeFunc
+	forecasts          *prometheus.GaugeVec
+
+	pending     chan *snapshot.Service
+	unsubscribe []func()
+	started     bool
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// handle is a bbolt database shared by the DBs of one path: a reload builds
+// the new app, opening the file, before closing the old one, and bbolt holds
+// an exclusive lock on the file.
+type handle struct {
+	path string
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	handlesMu sync.Mutex
+	handles   = make(map[string]*handle)
+)
+
+func openHandle(path string) (*handle, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	if h, ok := handles[abs]; ok {
+		h.refs++
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(abs, 0o600, &bolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is locked by another process", abs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h := &handle{path: abs, db: db, refs: 1}
+	handles[abs] = h
+	return h, nil
+}
+
+func (h *handle) close() error {
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	if h.refs--; h.refs > 0 {
+		return nil
+	}
+	delete(handles, h.path)
+	return h.db.Close()
+}
+
+// Open opens or creates the database at cfg.Path.
+func Open(cfg Config, manager *snapshot.Manager) (*DB, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("history db path is required")
+	}
+	h, err := openHandle(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open history db: %w", err)
+	}
+	d := &DB{
+		cfg:     cfg,
+		handle:  h,
+		manager: manager,
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_history_writes_total",
+			Help: "Refreshes recorded in the history database by result.",
+		}, []string{"result"}),
+		compactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_history_compactions_total",
+			Help: "History database downsampling and expiry runs by result.",
+		}, []string{"result"}),
+		compactionDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_history_compaction_duration_seconds",
+			Help: "Duration of the last history database compaction.",
+		}),
+		records: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_history_records",
+			Help: "Records in the history database by tier, as of the last compaction.",
+		}, []string{"tier"}),
+		forecasts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nvidia_cls_feature_exhaustion_forecast_days",
+			Help: "Days until the trend of the daily peak in-use quantity reaches the entitled quantity, as of the last compaction.",
+		}, []string{"org_name", "virtual_group_id", "virtual_group_name", "feature_name", "license_type"}),
+		pending: make(chan *snapshot.Service, len(manager.Services())),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	d.size = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "nvidia_cls_exporter_history_size_bytes",
+		Help: "Size of the history database file, including free pages.",
+	}, func() float64 {
+		var size int64
+		_ = h.db.View(func(tx *bolt.Tx) error {
+			size = tx.Size()
+			return nil
+		})
+		return float64(size)
+	})
+	for _, result := range []string{"success", "failure"} {
+		d.writes.WithLabelValues(result)
+		d.compactions.WithLabelValues(result)
+	}
+	if err := h.db.Update(func(tx *bolt.Tx) error {
+		for _, tier := range tiers {
+			if _, err := tx.CreateBucketIfNotExists([]byte(tier)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = h.close()
+		return nil, fmt.Errorf("open history db: %w", err)
+	}
+	return d, nil
+}
+
+// Collector exposes the write and compaction metrics, the database size and
+// the exhaustion forecasts for a Prometheus registry.
+func (d *DB) Collector() prometheus.Collector {
+	return collectors{d.writes, d.compactions, d.compactionDuration, d.records, d.size, d.forecasts}
+}
+
+type collectors []prometheus.Collector
+
+func (c collectors) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c {
+		collector.Describe(ch)
+	}
+}
+
+func (c collectors) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c {
+		collector.Collect(ch)
+	}
+}
+
+// Start subscribes to every target's refreshes. Writes happen on a separate
+// goroutine so a slow disk never delays a refresh; when it falls behind, a
+// target's pending update is coalesced with the next one. The same
+// goroutine compacts the database and updates the forecasts right away and
+// every compactInterval.
+func (d *DB) Start() {
+	d.started = true
+	for _, svc := range d.manager.Services() {
+		d.unsubscribe = append(d.unsubscribe, svc.Subscribe(func(snapshot.RefreshEvent) {
+			select {
+			case d.pending <- svc:
+			default:
+			}
+		}))
+	}
+
+	go func() {
+		defer close(d.done)
+		d.runCompaction()
+		d.updateForecasts(time.Now())
+		ticker := time.NewTicker(compactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.runCompaction()
+				d.updateForecasts(time.Now())
+			case svc := <-d.pending:
+				snap, _, ok := svc.Latest()
+				if !ok {
+					continue
+				}
+				if err := d.Write(svc.Target(), NewRecord(snap)); err != nil {
+					d.writes.WithLabelValues("failure").Inc()
+					slog.Error("history db write failed", "org", svc.Target(), "path", d.cfg.Path, "error", err)
+					continue
+				}
+				d.writes.WithLabelValues("success").Inc()
+			}
+		}
+	}()
+}
+
+// Shutdown stops recording and closes the database, unless a DB of the
+// same path still uses it.
+func (d *DB) Shutdown(ctx context.Context) error {
+	for _, cancel := range d.unsubscribe {
+		cancel()
+	}
+	close(d.stop)
+	if d.started {
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return d.handle.close()
+}
+
+func timeKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k))).UTC()
+}
+
+// orgBucket returns the bucket of org in tier, or nil if it has none.
+func orgBucket(tx *bolt.Tx, tier Tier, org string) *bolt.Bucket {
+	return tx.Bucket([]byte(tier)).Bucket([]byte(org))
+}
+
+// Write stores r for org, unless a record of the same collection time is
+// stored already.
+func (d *DB) Write(org string, r Record) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.handle.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte(TierRaw)).CreateBucketIfNotExists([]byte(org))
+		if err != nil {
+			return err
+		}
+		if key := timeKey(r.CollectedAt); b.Get(key) == nil {
+			return b.Put(key, value)
+		}
+		return nil
+	})
+}
+
+// Records returns the records of org collected in [from, to], oldest
+// first.
+func (d *DB) Records(org string, from, to time.Time) ([]Record, error) {
+	var out []Record
+	err := d.handle.db.View(func(tx *bolt.Tx) error {
+		return scan(orgBucket(tx, TierRaw, org), from, to, func(k, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("decode record of %s at %s: %w", org, keyTime(k), err)
+			}
+			out = append(out, r)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// Rollups returns the hourly or daily rollups of org whose period starts in
+// [from, to], oldest first.
+func (d *DB) Rollups(org string, tier Tier, from, to time.Time) ([]Rollup, error) {
+	if tier != TierHourly && tier != TierDaily {
+		return nil, fmt.Errorf("no rollups in tier %q", tier)
+	}
+	var out []Rollup
+	err := d.handle.db.View(func(tx *bolt.Tx) error {
+		return scan(orgBucket(tx, tier, org), from, to, func(k, v []byte) error {
+			var r Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("decode %s rollup of %s at %s: %w", tier, org, keyTime(k), err)
+			}
+			out = append(out, r)
+			return nil
+		})
+	})
+	return out, err
+}
+
+const day = 24 * time.Hour
+
+// Daily returns a rollup per UTC day of org, from the day of from through
+// to, from the finest records kept of each day: its daily rollup or, for
+// days not rolled up yet such as today, its hourly rollups followed by the
+// raw records after them. Days without records are left out.
+func (d *DB) Daily(org string, from, to time.Time) ([]Rollup, error) {
+	var out []Rollup
+	err := d.handle.db.View(func(tx *bolt.Tx) error {
+		// next is the start of the time no record added so far covers.
+		next := from.UTC().Truncate(day)
+		err := scan(orgBucket(tx, TierDaily, org), next, to, func(k, v []byte) error {
+			var r Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("decode daily rollup of %s at %s: %w", org, keyTime(k), err)
+			}
+			out = append(out, r)
+			next = r.Start.Add(day)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var b *rollupBuilder
+		builder := func(t time.Time) *rollupBuilder {
+			if start := t.Truncate(day); b == nil || !b.start.Equal(start) {
+				if b != nil {
+					out = append(out, b.rollup())
+				}
+				b = newRollupBuilder(start)
+			}
+			return b
+		}
+		err = scan(orgBucket(tx, TierHourly, org), next, to, func(k, v []byte) error {
+			var r Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("decode hourly rollup of %s at %s: %w", org, keyTime(k), err)
+			}
+			builder(r.Start).addRollup(r)
+			next = r.Start.Add(time.Hour)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = scan(orgBucket(tx, TierRaw, org), next, to, func(k, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("decode record of %s at %s: %w", org, keyTime(k), err)
+			}
+			builder(keyTime(k)).addRecord(r)
+			return nil
+		})
+		if b != nil {
+			out = append(out, b.rollup())
+		}
+		return err
+	})
+	return out, err
+}
+
+// Weekly merges the rollups of days into a rollup per week starting on
+// Monday, in UTC.
+func Weekly(days []Rollup) []Rollup {
+	var out []Rollup
+	var b *rollupBuilder
+	for _, r := range days {
+		start := r.Start.UTC().Truncate(day)
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		if b == nil || !b.start.Equal(start) {
+			if b != nil {
+				out = append(out, b.rollup())
+			}
+			b = newRollupBuilder(start)
+		}
+		b.addRollup(r)
+	}
+	if b != nil {
+		out = append(out, b.rollup())
+	}
+	return out
+}
+
+// scan calls fn for the keys of b in [from, to] in order. b may be nil.
+func scan(b *bolt.Bucket, from, to time.Time, fn func(k, v []byte) error) error {
+	if b == nil {
+		return nil
+	}
+	end := timeKey(to)
+	c := b.Cursor()
+	for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package historydb
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type staticFetcher struct{ snap *cls.Snapshot }
+
+func (f staticFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return f.snap.Clone(), nil
+}
+
+func testManager(t *testing.T) *snapshot.Manager {
+	t.Helper()
+	svc := snapshot.NewService(staticFetcher{&cls.Snapshot{CollectedAt: time.Now()}}, snapshot.Config{Target: "org-1", CacheTTL: time.Hour})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func TestNewRecord(t *testing.T) {
+	r := NewRecord(&cls.Snapshot{
+		CollectedAt: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 10, InUseQuantity: 4},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-2", Allocated: 4, InUse: 1},
+			{VirtualGroupID: 1, ServerID: "srv-1", Allocated: 6, InUse: 3},
+		},
+		ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", ActiveLeases: 3},
+		},
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 3},
+			{VirtualGroupID: 1, ServerID: "srv-2", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 1},
+		},
+	})
+	if f := r.Features[0]; f.Allocated != 10 || f.InUse != 4 || f.ActiveLeases != 4 {
+		t.Errorf("unexpected feature %+v", f)
+	}
+	if s := r.Servers[0]; s.ServerID != "srv-1" || s.ActiveLeases != 3 || r.Servers[1].ActiveLeases != 0 {
+		t.Errorf("unexpected servers %+v", r.Servers)
+	}
+}
+
+func TestWriteAndRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "cls.db")
+	d, err := Open(Config{Path: path, Retention: 24 * time.Hour}, testManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		at := start.Add(time.Duration(i) * 12 * time.Hour)
+		r := Record{CollectedAt: at, Features: []Feature{{FeatureName: "vWS", InUse: float64(i)}}}
+		if err := d.Write("org-1", r); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	// The same collection time again is not stored twice.
+	if err := d.Write("org-1", Record{CollectedAt: start.Add(36 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Compact(start.Add(36 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := d.Records("org-1", start, start.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// At 36h, a day of retention keeps the records from 12h on.
+	if len(records) != 3 || !records[0].CollectedAt.Equal(start.Add(12*time.Hour)) || records[2].Features[0].InUse != 3 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if records, _ := d.Records("org-2", start, start.Add(48*time.Hour)); len(records) != 0 {
+		t.Errorf("expected no records of another org, got %+v", records)
+	}
+
+	// A second DB of the same path, as during a reload, shares the file.
+	other, err := Open(Config{Path: path}, testManager(t))
+	if err != nil {
+		t.Fatalf("open twice: %v", err)
+	}
+	if err := other.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := d.Records("org-1", start, start.Add(48*time.Hour)); err != nil || len(records) != 3 {
+		t.Errorf("expected the first DB to stay open, got %d records, %v", len(records), err)
+	}
+}
+
+func TestRecordsRefreshes(t *testing.T) {
+	manager := testManager(t)
+	d, err := Open(Config{Path: filepath.Join(t.TempDir(), "cls.db")}, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	svc := manager.Services()[0]
+	if _, _, err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records, err := d.Records("org-1", time.Now().Add(-time.Hour), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the refresh to be recorded, got %+v", records)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	d, err := Open(Config{Path: filepath.Join(t.TempDir(), "cls.db"), Retention: 2 * time.Hour, HourlyRetention: 72 * time.Hour}, testManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	// Two days of a record every 30 minutes, in use 0, 1, ..., 95.
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 96 {
+		r := Record{
+			CollectedAt: start.Add(time.Duration(i) * 30 * time.Minute),
+			Features:    []Feature{{VirtualGroupID: 1, FeatureName: "vWS", Allocated: 100, InUse: float64(i)}},
+		}
+		if err := d.Write("org-1", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := start.Add(48*time.Hour + 5*time.Minute)
+	result, err := d.Compact(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last hour ended less than settle ago, so it waits.
+	if result.Rollups[TierHourly] != 47 || result.Rollups[TierDaily] != 1 {
+		t.Fatalf("unexpected rollups %+v", result.Rollups)
+	}
+	// Raw records from 46:05 on are kept.
+	if result.Records[TierRaw] != 3 || result.Expired[TierRaw] != 93 || result.Records[TierHourly] != 47 {
+		t.Errorf("unexpected raw records %+v, expired %+v", result.Records, result.Expired)
+	}
+
+	hourly, err := d.Rollups("org-1", TierHourly, start, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 47 {
+		t.Fatalf("expected 47 hourly rollups, got %d", len(hourly))
+	}
+	if h := hourly[1]; !h.Start.Equal(start.Add(time.Hour)) || h.Samples != 2 || h.Features[0].InUse != (Stats{Min: 2, Max: 3, Sum: 5, Count: 2}) {
+		t.Errorf("unexpected second hour %+v", h)
+	}
+	daily, err := d.Rollups("org-1", TierDaily, start, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 1 || daily[0].Samples != 48 || daily[0].Features[0].InUse.Max != 47 || daily[0].Features[0].InUse.Avg() != 23.5 {
+		t.Fatalf("unexpected daily rollups %+v", daily)
+	}
+
+	// Later compactions only add the periods that ended since: the last
+	// hour, and with it the second day.
+	result, err = d.Compact(now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rollups[TierHourly] != 1 || result.Rollups[TierDaily] != 1 {
+		t.Errorf("unexpected rollups of a later compaction %+v", result.Rollups)
+	}
+	if daily, _ := d.Rollups("org-1", TierDaily, start, now.Add(time.Hour)); len(daily) != 2 || daily[1].Samples != 48 || daily[1].Features[0].InUse.Min != 48 {
+		t.Errorf("unexpected daily rollups %+v", daily)
+	}
+}
+
+func TestDailyAndWeekly(t *testing.T) {
+	d, err := Open(Config{Path: filepath.Join(t.TempDir(), "cls.db")}, testManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	// A record every hour for 60 hours from a Monday, with i active leases.
+	start := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	for i := range 60 {
+		r := Record{
+			CollectedAt: start.Add(time.Duration(i) * time.Hour),
+			Features:    []Feature{{VirtualGroupID: 1, FeatureName: "vWS", Allocated: 100, InUse: float64(i), ActiveLeases: float64(i)}},
+		}
+		if err := d.Write("org-1", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first day is rolled up daily, the second up to 35:00 hourly.
+	if _, err := d.Compact(start.Add(36*time.Hour + 5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := d.Daily("org-1", start.Add(3*time.Hour), start.Add(60*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 3 {
+		t.Fatalf("expected 3 days, got %+v", days)
+	}
+	for i, want := range []struct {
+		samples int
+		max     float64
+		avg     float64
+	}{{24, 23, 11.5}, {24, 47, 35.5}, {12, 59, 53.5}} {
+		leases := days[i].Features[0].ActiveLeases
+		if !days[i].Start.Equal(start.AddDate(0, 0, i)) || days[i].Samples != want.samples || leases.Max != want.max || leases.Avg() != want.avg {
+			t.Errorf("day %d: got %+v, leases %+v, want %+v", i, days[i], leases, want)
+		}
+	}
+
+	weeks := Weekly(days)
+	if len(weeks) != 1 || !weeks[0].Start.Equal(start) || weeks[0].Samples != 60 || weeks[0].Features[0].ActiveLeases.Max != 59 {
+		t.Errorf("unexpected weeks %+v", weeks)
+	}
+}
+
+func TestForecasts(t *testing.T) {
+	d, err := Open(Config{Path: filepath.Join(t.TempDir(), "cls.db"), ForecastWindow: 30 * 24 * time.Hour}, testManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	// A record at noon of each of the last 10 days: vWS grows by 2 a day to
+	// 38 of 50 yesterday, vPC stays at 5 of 10 and vApps is at 10 of 10.
+	// vCS only has the last 3 days. Today's record is left out.
+	today := time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)
+	now := today.Add(12 * time.Hour)
+	for k := 0; k <= 10; k++ {
+		r := Record{CollectedAt: today.AddDate(0, 0, -k).Add(12 * time.Hour), Features: []Feature{
+			{VirtualGroupID: 1, FeatureName: "vWS", Allocated: 50, InUse: float64(40 - 2*k)},
+			{VirtualGroupID: 1, FeatureName: "vPC", Allocated: 10, InUse: 5},
+			{VirtualGroupID: 1, FeatureName: "vApps", Allocated: 10, InUse: 10},
+		}}
+		if k <= 3 {
+			r.Features = append(r.Features, Feature{VirtualGroupID: 1, FeatureName: "vCS", Allocated: 10, InUse: 1})
+		}
+		if err := d.Write("org-1", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forecasts, err := d.Forecasts("org-1", 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Forecast)
+	for _, f := range forecasts {
+		got[f.FeatureName] = f
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected forecasts of vWS, vPC and vApps, got %+v", forecasts)
+	}
+	// The trend reaches 41 by now and grows by 2 a day.
+	if f := got["vWS"]; f.Points != 10 || f.PeakInUse != 38 || f.Entitled != 50 || math.Abs(f.Slope-2) > 1e-9 || math.Abs(f.Days-4.5) > 1e-9 {
+		t.Errorf("unexpected vWS forecast %+v", f)
+	}
+	if at := got["vWS"].ExhaustsAt(now); !at.Equal(now.Add(108 * time.Hour)) {
+		t.Errorf("expected vWS to run out in 4.5 days, got %s", at)
+	}
+	if f := got["vPC"]; !math.IsInf(f.Days, 1) || !f.ExhaustsAt(now).IsZero() {
+		t.Errorf("expected no exhaustion of vPC, got %+v", f)
+	}
+	if f := got["vApps"]; f.Days != 0 {
+		t.Errorf("expected vApps exhausted, got %+v", f)
+	}
+
+	d.updateForecasts(now)
+	if n := testutil.CollectAndCount(d.forecasts); n != 2 {
+		t.Errorf("expected forecast gauges of vWS and vApps, got %d", n)
+	}
+	if v := testutil.ToFloat64(d.forecasts.WithLabelValues("org-1", "1", "", "vWS", "")); math.Abs(v-4.5) > 1e-9 {
+		t.Errorf("expected 4.5 days for vWS, got %v", v)
+	}
+}
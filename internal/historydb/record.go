@@ -0,0 +1,102 @@
+package historydb
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// Record is what the store keeps of one refresh of an org: the quantities
+// of every entitlement feature and license server. Field names are short
+// since every refresh repeats them.
+type Record struct {
+	// CollectedAt is when the snapshot, or its active leases if they were
+	// refreshed since, was collected.
+	CollectedAt time.Time `json:"t"`
+	Features    []Feature `json:"f,omitempty"`
+	Servers     []Server  `json:"s,omitempty"`
+}
+
+// Feature is an entitlement feature of a virtual group.
+type Feature struct {
+	VirtualGroupID   int    `json:"g"`
+	VirtualGroupName string `json:"gn"`
+	FeatureName      string `json:"n"`
+	LicenseType      string `json:"lt"`
+	// Allocated is the entitled quantity.
+	Allocated float64 `json:"a"`
+	InUse     float64 `json:"u"`
+	// ActiveLeases is the sum of the feature's active leases over the
+	// license servers of the virtual group.
+	ActiveLeases float64 `json:"l"`
+}
+
+// Server is a license server.
+type Server struct {
+	VirtualGroupID   int     `json:"g"`
+	VirtualGroupName string  `json:"gn"`
+	ServerID         string  `json:"id"`
+	ServerName       string  `json:"n"`
+	Allocated        float64 `json:"a"`
+	InUse            float64 `json:"u"`
+	ActiveLeases     float64 `json:"l"`
+}
+
+type featureKey struct {
+	virtualGroupID int
+	name           string
+	licenseType    string
+}
+
+type serverKey struct {
+	virtualGroupID int
+	id             string
+}
+
+// NewRecord condenses snap into a Record.
+func NewRecord(snap *cls.Snapshot) Record {
+	featureLeases := make(map[featureKey]float64)
+	for _, l := range snap.ServerFeatureActiveLeases {
+		featureLeases[featureKey{l.VirtualGroupID, l.FeatureName, l.LicenseType}] += l.ActiveLeases
+	}
+	serverLeases := make(map[serverKey]float64)
+	for _, l := range snap.ServerActiveLeases {
+		serverLeases[serverKey{l.VirtualGroupID, l.ServerID}] += l.ActiveLeases
+	}
+
+	r := Record{CollectedAt: snap.CollectedAt.UTC()}
+	if snap.LeasesCollectedAt.After(snap.CollectedAt) {
+		r.CollectedAt = snap.LeasesCollectedAt.UTC()
+	}
+	for _, f := range snap.EntitlementFeatures {
+		r.Features = append(r.Features, Feature{
+			VirtualGroupID:   f.VirtualGroupID,
+			VirtualGroupName: f.VirtualGroupName,
+			FeatureName:      f.FeatureName,
+			LicenseType:      f.LicenseType,
+			Allocated:        f.TotalQuantity,
+			InUse:            f.InUseQuantity,
+			ActiveLeases:     featureLeases[featureKey{f.VirtualGroupID, f.FeatureName, f.LicenseType}],
+		})
+	}
+	for _, s := range snap.ServerUsage {
+		r.Servers = append(r.Servers, Server{
+			VirtualGroupID:   s.VirtualGroupID,
+			VirtualGroupName: s.VirtualGroupName,
+			ServerID:         s.ServerID,
+			ServerName:       s.ServerName,
+			Allocated:        s.Allocated,
+			InUse:            s.InUse,
+			ActiveLeases:     serverLeases[serverKey{s.VirtualGroupID, s.ServerID}],
+		})
+	}
+	slices.SortFunc(r.Features, func(a, b Feature) int {
+		return cmp.Or(cmp.Compare(a.VirtualGroupID, b.VirtualGroupID), cmp.Compare(a.FeatureName, b.FeatureName), cmp.Compare(a.LicenseType, b.LicenseType))
+	})
+	slices.SortFunc(r.Servers, func(a, b Server) int {
+		return cmp.Or(cmp.Compare(a.VirtualGroupID, b.VirtualGroupID), cmp.Compare(a.ServerID, b.ServerID))
+	})
+	return r
+}
@@ -0,0 +1,160 @@
+package historydb
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Stats summarizes the values of a quantity over a period.
+type Stats struct {
+	Min float64 `json:"n"`
+	Max float64 `json:"x"`
+	Sum float64 `json:"s"`
+	// Count is the number of values, which may be below the samples of the
+	// rollup if the feature or server was not in every record.
+	Count int `json:"c"`
+}
+
+// Avg is the mean of the values.
+func (s Stats) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+func (s *Stats) add(v float64) {
+	s.merge(Stats{Min: v, Max: v, Sum: v, Count: 1})
+}
+
+func (s *Stats) merge(o Stats) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = o
+		return
+	}
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+	s.Sum += o.Sum
+	s.Count += o.Count
+}
+
+// Rollup condenses the records of an hour or day.
+type Rollup struct {
+	// Start is the start of the period, in UTC.
+	Start time.Time `json:"t"`
+	// Samples is the number of raw records the rollup covers.
+	Samples  int             `json:"c"`
+	Features []FeatureRollup `json:"f,omitempty"`
+	Servers  []ServerRollup  `json:"s,omitempty"`
+}
+
+type FeatureRollup struct {
+	VirtualGroupID   int    `json:"g"`
+	VirtualGroupName string `json:"gn"`
+	FeatureName      string `json:"n"`
+	LicenseType      string `json:"lt"`
+	Allocated        Stats  `json:"a"`
+	InUse            Stats  `json:"u"`
+	ActiveLeases     Stats  `json:"l"`
+}
+
+type ServerRollup struct {
+	VirtualGroupID   int    `json:"g"`
+	VirtualGroupName string `json:"gn"`
+	ServerID         string `json:"id"`
+	ServerName       string `json:"n"`
+	Allocated        Stats  `json:"a"`
+	InUse            Stats  `json:"u"`
+	ActiveLeases     Stats  `json:"l"`
+}
+
+// rollupBuilder accumulates the records or rollups of a period. Names are
+// those of the latest record added.
+type rollupBuilder struct {
+	start    time.Time
+	samples  int
+	features map[featureKey]*FeatureRollup
+	servers  map[serverKey]*ServerRollup
+}
+
+func newRollupBuilder(start time.Time) *rollupBuilder {
+	return &rollupBuilder{
+		start:    start,
+		features: make(map[featureKey]*FeatureRollup),
+		servers:  make(map[serverKey]*ServerRollup),
+	}
+}
+
+func (b *rollupBuilder) feature(vg int, vgName, name, licenseType string) *FeatureRollup {
+	key := featureKey{vg, name, licenseType}
+	f, ok := b.features[key]
+	if !ok {
+		f = &FeatureRollup{VirtualGroupID: vg, FeatureName: name, LicenseType: licenseType}
+		b.features[key] = f
+	}
+	f.VirtualGroupName = vgName
+	return f
+}
+
+func (b *rollupBuilder) server(vg int, vgName, id, name string) *ServerRollup {
+	key := serverKey{vg, id}
+	s, ok := b.servers[key]
+	if !ok {
+		s = &ServerRollup{VirtualGroupID: vg, ServerID: id}
+		b.servers[key] = s
+	}
+	s.VirtualGroupName, s.ServerName = vgName, name
+	return s
+}
+
+func (b *rollupBuilder) addRecord(r Record) {
+	b.samples++
+	for _, f := range r.Features {
+		acc := b.feature(f.VirtualGroupID, f.VirtualGroupName, f.FeatureName, f.LicenseType)
+		acc.Allocated.add(f.Allocated)
+		acc.InUse.add(f.InUse)
+		acc.ActiveLeases.add(f.ActiveLeases)
+	}
+	for _, s := range r.Servers {
+		acc := b.server(s.VirtualGroupID, s.VirtualGroupName, s.ServerID, s.ServerName)
+		acc.Allocated.add(s.Allocated)
+		acc.InUse.add(s.InUse)
+		acc.ActiveLeases.add(s.ActiveLeases)
+	}
+}
+
+func (b *rollupBuilder) addRollup(r Rollup) {
+	b.samples += r.Samples
+	for _, f := range r.Features {
+		acc := b.feature(f.VirtualGroupID, f.VirtualGroupName, f.FeatureName, f.LicenseType)
+		acc.Allocated.merge(f.Allocated)
+		acc.InUse.merge(f.InUse)
+		acc.ActiveLeases.merge(f.ActiveLeases)
+	}
+	for _, s := range r.Servers {
+		acc := b.server(s.VirtualGroupID, s.VirtualGroupName, s.ServerID, s.ServerName)
+		acc.Allocated.merge(s.Allocated)
+		acc.InUse.merge(s.InUse)
+		acc.ActiveLeases.merge(s.ActiveLeases)
+	}
+}
+
+func (b *rollupBuilder) rollup() Rollup {
+	r := Rollup{Start: b.start, Samples: b.samples}
+	for _, key := range slices.SortedFunc(maps.Keys(b.features), func(a, b featureKey) int {
+		return cmp.Or(cmp.Compare(a.virtualGroupID, b.virtualGroupID), cmp.Compare(a.name, b.name), cmp.Compare(a.licenseType, b.licenseType))
+	}) {
+		r.Features = append(r.Features, *b.features[key])
+	}
+	for _, key := range slices.SortedFunc(maps.Keys(b.servers), func(a, b serverKey) int {
+		return cmp.Or(cmp.Compare(a.virtualGroupID, b.virtualGroupID), cmp.Compare(a.id, b.id))
+	}) {
+		r.Servers = append(r.Servers, *b.servers[key])
+	}
+	return r
+}
@@ -0,0 +1,205 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	userAgent      = "nvidia-license-server-exporter/0.1"
+
+	// maxErrorBody bounds how much of a rejected write's response is kept
+	// in the error.
+	maxErrorBody = 512
+)
+
+type Config struct {
+	// URL is the InfluxDB base URL, e.g. http://influxdb:8086.
+	URL    string
+	Org    string
+	Bucket string
+	// Token is an API token with write access to Bucket.
+	Token      string
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// Writer writes the core gauges of a target to InfluxDB v2 after each of its
+// refreshes. Every metric is a measurement with a single "value" field and
+// the Prometheus labels as tags.
+type Writer struct {
+	cfg      Config
+	manager  *snapshot.Manager
+	client   *http.Client
+	writeURL string
+
+	writes *prometheus.CounterVec
+
+	pending     chan *snapshot.Service
+	unsubscribe []func()
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewWriter(cfg Config, manager *snapshot.Manager) (*Writer, error) {
+	base := strings.TrimSuffix(strings.TrimSpace(cfg.URL), "/")
+	if base == "" {
+		return nil, fmt.Errorf("influx url is required")
+	}
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		return nil, fmt.Errorf("influx url %q must start with http:// or https://", base)
+	}
+	if strings.TrimSpace(cfg.Org) == "" || strings.TrimSpace(cfg.Bucket) == "" {
+		return nil, fmt.Errorf("influx org and bucket are required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	query := url.Values{}
+	query.Set("org", strings.TrimSpace(cfg.Org))
+	query.Set("bucket", strings.TrimSpace(cfg.Bucket))
+	query.Set("precision", "s")
+
+	w := &Writer{
+		cfg:      cfg,
+		manager:  manager,
+		client:   client,
+		writeURL: base + "/api/v2/write?" + query.Encode(),
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_influx_writes_total",
+			Help: "InfluxDB writes by result.",
+		}, []string{"result"}),
+		pending: make(chan *snapshot.Service, len(manager.Services())),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	w.writes.WithLabelValues("success")
+	w.writes.WithLabelValues("failure")
+	return w, nil
+}
+
+// Collector exposes the write counters for a Prometheus registry.
+func (w *Writer) Collector() prometheus.Collector {
+	return w.writes
+}
+
+// Start subscribes to every target's refreshes. Writes happen on a separate
+// goroutine so a slow InfluxDB never delays a refresh; when it falls behind,
+// a target's pending update is coalesced with the next one.
+func (w *Writer) Start() {
+	for _, svc := range w.manager.Services() {
+		w.unsubscribe = append(w.unsubscribe, svc.Subscribe(func(snapshot.RefreshEvent) {
+			select {
+			case w.pending <- svc:
+			default:
+			}
+		}))
+	}
+
+	go func() {
+		defer close(w.done)
+		for {
+			select {
+			case <-w.stop:
+				return
+			case svc := <-w.pending:
+				ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Timeout)
+				err := w.Write(ctx, svc)
+				cancel()
+				if err != nil {
+					w.writes.WithLabelValues("failure").Inc()
+					slog.Error("influx write failed", "org", svc.Target(), "endpoint", w.cfg.URL, "error", err)
+					continue
+				}
+				w.writes.WithLabelValues("success").Inc()
+			}
+		}
+	}()
+}
+
+func (w *Writer) Shutdown(ctx context.Context) error {
+	for _, cancel := range w.unsubscribe {
+		cancel()
+	}
+	close(w.stop)
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Write sends the current gauges of svc in one request.
+func (w *Writer) Write(ctx context.Context, svc *snapshot.Service) error {
+	body := encodeLines(exporter.CoreSamples(svc), time.Now())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", userAgent)
+	if token := strings.TrimSpace(w.cfg.Token); token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("influx write returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// encodeLines renders samples in line protocol with second precision.
+func encodeLines(samples []exporter.Sample, now time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(now.Unix(), 10)
+	for _, sample := range samples {
+		buf.WriteString(measurementEscaper.Replace(sample.Name))
+		for _, label := range sample.Labels {
+			if label.Value == "" {
+				// Empty tag values are invalid in line protocol.
+				continue
+			}
+			buf.WriteByte(',')
+			buf.WriteString(tagEscaper.Replace(label.Name))
+			buf.WriteByte('=')
+			buf.WriteString(tagEscaper.Replace(label.Value))
+		}
+		buf.WriteString(" value=")
+		buf.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,88 @@
+package influx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type fetcher struct{}
+
+func (fetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return &cls.Snapshot{
+		CollectedAt: time.Now().UTC(),
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{ServerName: "server 1", FeatureName: "vGPU", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 7},
+		},
+	}, nil
+}
+
+func TestWriterPostsLineProtocol(t *testing.T) {
+	var (
+		gotQuery string
+		gotAuth  string
+		gotBody  string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		gotQuery = r.URL.RawQuery
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	svc := snapshot.NewService(fetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	writer, err := NewWriter(Config{URL: server.URL + "/", Org: "factory", Bucket: "licenses", Token: "secret"}, manager)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := writer.Write(context.Background(), svc); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if gotQuery != "bucket=licenses&org=factory&precision=s" {
+		t.Fatalf("unexpected query %q", gotQuery)
+	}
+	if gotAuth != "Token secret" {
+		t.Fatalf("unexpected authorization %q", gotAuth)
+	}
+	want := `nvidia_cls_license_server_feature_active_leases,org_name=org-1,virtual_group_id=0,virtual_group_name=unknown,` +
+		`server_id=unknown,server_name=server\ 1,feature_name=vGPU,product_name=unknown,license_type=CONCURRENT_COUNTED_SINGLE value=7 `
+	if !strings.Contains(gotBody, "\n"+want) {
+		t.Fatalf("missing line %q in body:\n%s", want, gotBody)
+	}
+}
+
+func TestEncodeLinesEscapesAndSkipsEmptyTags(t *testing.T) {
+	got := string(encodeLines([]exporter.Sample{{
+		Name:   "a b",
+		Value:  1.5,
+		Labels: []exporter.Label{{Name: "k", Value: "x,y=z"}, {Name: "empty", Value: ""}},
+	}}, time.Unix(100, 0)))
+	if want := `a\ b,k=x\,y\=z value=1.5 100` + "\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if _, err := NewWriter(Config{URL: "influxdb:8086", Org: "o", Bucket: "b"}, nil); err == nil {
+		t.Fatalf("expected error for url without scheme")
+	}
+}
@@ -0,0 +1,412 @@
+// Package leader elects one of several exporter replicas through a
+// Kubernetes Lease, so that only the leader calls CLS. It follows the
+// protocol of client-go's leaderelection package, and so interoperates with
+// it, but speaks to the coordination.k8s.io/v1 API directly with the pod's
+// service account instead of linking client-go.
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+
+	// maxResponseBytes bounds API server responses.
+	maxResponseBytes = 1 << 20
+	// microTimeFormat is the format of Lease acquireTime and renewTime.
+	microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// serviceAccountDir holds the token, CA certificate and namespace mounted
+// into every pod. Overridden in tests.
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type Config struct {
+	// Name is the name of the Lease object.
+	Name string
+	// Namespace of the Lease; the pod's own namespace when empty.
+	Namespace string
+	// Identity names this replica in the Lease, usually the pod name.
+	Identity string
+	// LeaseDuration is how long followers wait after the last renewal
+	// before they take over. The leader renews every RetryPeriod and
+	// steps down when it could not renew for RenewDeadline.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Elector campaigns for the Lease until Shutdown.
+type Elector struct {
+	cfg        Config
+	client     *http.Client
+	collection string
+	lease      string
+	tokenFile  string
+
+	leading atomic.Bool
+	gauge   prometheus.Gauge
+
+	// observed is the Lease as last read or written, and observedAt when
+	// it last changed, by the local clock: a holder's renewTime is not
+	// compared with the local time, so clock skew between nodes does not
+	// matter.
+	mu         sync.Mutex
+	observed   *lease
+	observedAt time.Time
+	renewedAt  time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns an Elector that talks to the API server of the cluster it
+// runs in, found through KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT.
+func New(cfg Config) (*Elector, error) {
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	if cfg.Name == "" {
+		return nil, errors.New("lease name is required")
+	}
+	if strings.TrimSpace(cfg.Identity) == "" {
+		return nil, errors.New("identity is required")
+	}
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = DefaultLeaseDuration
+	}
+	if cfg.RenewDeadline <= 0 {
+		cfg.RenewDeadline = DefaultRenewDeadline
+	}
+	if cfg.RetryPeriod <= 0 {
+		cfg.RetryPeriod = DefaultRetryPeriod
+	}
+	if cfg.RenewDeadline >= cfg.LeaseDuration || cfg.RetryPeriod >= cfg.RenewDeadline {
+		return nil, fmt.Errorf("need retry period %s < renew deadline %s < lease duration %s", cfg.RetryPeriod, cfg.RenewDeadline, cfg.LeaseDuration)
+	}
+	if cfg.Namespace = strings.TrimSpace(cfg.Namespace); cfg.Namespace == "" {
+		namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("no namespace given and not running in a pod: %w", err)
+		}
+		cfg.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set, leader election requires running in Kubernetes")
+	}
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("read service account CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("service account CA holds no certificate")
+	}
+
+	collection := (&url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(cfg.Namespace) + "/leases",
+	}).String()
+	return &Elector{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   cfg.RenewDeadline,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}},
+		},
+		collection: collection,
+		lease:      collection + "/" + url.PathEscape(cfg.Name),
+		tokenFile:  filepath.Join(serviceAccountDir, "token"),
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "nvidia_cls_exporter_leader",
+			Help:        "Whether this replica holds the leader election lease and fetches from CLS.",
+			ConstLabels: prometheus.Labels{"lease": cfg.Namespace + "/" + cfg.Name},
+		}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Collector exposes whether this replica leads.
+func (e *Elector) Collector() prometheus.Collector {
+	return e.gauge
+}
+
+// IsLeader reports whether this replica currently holds the Lease.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Start makes a first attempt to acquire the Lease, so that a replica that
+// can lead does so before it serves, and keeps campaigning in the
+// background.
+func (e *Elector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.campaign(ctx)
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.cfg.RetryPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				e.release()
+				return
+			case <-ticker.C:
+				e.campaign(ctx)
+			}
+		}
+	}()
+}
+
+// Shutdown stops campaigning and, if this replica leads, releases the
+// Lease so that another replica takes over without waiting for it to
+// expire.
+func (e *Elector) Shutdown(ctx context.Context) error {
+	if e.cancel == nil {
+		return nil
+	}
+	e.cancel()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// campaign acquires or renews the Lease once. A leader that fails to renew
+// keeps leading until RenewDeadline has passed since its last renewal, so
+// that a single failed request does not hand over leadership.
+func (e *Elector) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.RenewDeadline)
+	defer cancel()
+	leading, err := e.tryAcquireOrRenew(ctx)
+	now := time.Now()
+	if err != nil {
+		if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		slog.Warn("leader election failed", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "error", err)
+		e.mu.Lock()
+		leading = e.leading.Load() && now.Sub(e.renewedAt) < e.cfg.RenewDeadline
+		e.mu.Unlock()
+	}
+	e.setLeading(leading)
+}
+
+func (e *Elector) setLeading(leading bool) {
+	if e.leading.Swap(leading) == leading {
+		return
+	}
+	if leading {
+		e.gauge.Set(1)
+		slog.Info("became leader, fetching from CLS", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "identity", e.cfg.Identity)
+		return
+	}
+	e.gauge.Set(0)
+	slog.Info("lost leadership, no longer fetching from CLS", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "identity", e.cfg.Identity)
+}
+
+// tryAcquireOrRenew reports whether this replica holds the Lease after one
+// read and, if the Lease is free, expired or already held, one write.
+func (e *Elector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := time.Now()
+	current, err := e.get(ctx)
+	if errors.Is(err, errNotFound) {
+		created := &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		created.Metadata.Name = e.cfg.Name
+		created.Metadata.Namespace = e.cfg.Namespace
+		created.Spec = e.spec(now, now, 0)
+		return e.write(ctx, http.MethodPost, e.collection, created, now)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	if e.observed == nil || e.observed.Metadata.ResourceVersion != current.Metadata.ResourceVersion {
+		e.observed = current
+		e.observedAt = now
+	}
+	observedAt := e.observedAt
+	e.mu.Unlock()
+
+	holder := current.Spec.HolderIdentity
+	duration := time.Duration(current.Spec.LeaseDurationSeconds) * time.Second
+	if holder != "" && holder != e.cfg.Identity && now.Before(observedAt.Add(duration)) {
+		return false, nil
+	}
+
+	acquired, transitions := now, current.Spec.LeaseTransitions
+	if holder == e.cfg.Identity {
+		if t, err := time.Parse(time.RFC3339Nano, current.Spec.AcquireTime); err == nil {
+			acquired = t
+		}
+	} else {
+		transitions++
+	}
+	updated := *current
+	updated.Spec = e.spec(acquired, now, transitions)
+	return e.write(ctx, http.MethodPut, e.lease, &updated, now)
+}
+
+// release hands the Lease back by clearing its holder, if this replica
+// leads. It fails harmlessly when another replica, or a reloaded
+// configuration of this one, has renewed it since.
+func (e *Elector) release() {
+	defer e.setLeading(false)
+	if !e.leading.Load() {
+		return
+	}
+	e.mu.Lock()
+	observed := e.observed
+	e.mu.Unlock()
+	if observed == nil || observed.Spec.HolderIdentity != e.cfg.Identity {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewDeadline)
+	defer cancel()
+	now := time.Now()
+	released := *observed
+	released.Spec = leaseSpec{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now.UTC().Format(microTimeFormat),
+		RenewTime:            now.UTC().Format(microTimeFormat),
+		LeaseTransitions:     observed.Spec.LeaseTransitions,
+	}
+	if _, err := e.write(ctx, http.MethodPut, e.lease, &released, now); err != nil {
+		slog.Warn("leader election lease not released", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "error", err)
+		return
+	}
+	slog.Info("leader election lease released", "lease", e.cfg.Namespace+"/"+e.cfg.Name)
+}
+
+func (e *Elector) spec(acquired, renewed time.Time, transitions int32) leaseSpec {
+	return leaseSpec{
+		HolderIdentity:       e.cfg.Identity,
+		LeaseDurationSeconds: int32(e.cfg.LeaseDuration / time.Second),
+		AcquireTime:          acquired.UTC().Format(microTimeFormat),
+		RenewTime:            renewed.UTC().Format(microTimeFormat),
+		LeaseTransitions:     transitions,
+	}
+}
+
+// lease is the part of a coordination.k8s.io/v1 Lease the elector uses.
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int32  `json:"leaseTransitions,omitempty"`
+}
+
+var (
+	errNotFound = errors.New("lease not found")
+	errConflict = errors.New("lease changed concurrently")
+)
+
+func (e *Elector) get(ctx context.Context) (*lease, error) {
+	var out lease
+	if err := e.do(ctx, http.MethodGet, e.lease, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// write creates or updates the Lease and reports whether this replica now
+// holds it. Losing a race to another replica is not an error.
+func (e *Elector) write(ctx context.Context, method, target string, l *lease, now time.Time) (bool, error) {
+	var out lease
+	err := e.do(ctx, method, target, l, &out)
+	if errors.Is(err, errConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	e.observed = &out
+	e.observedAt = now
+	e.renewedAt = now
+	e.mu.Unlock()
+	return out.Spec.HolderIdentity == e.cfg.Identity, nil
+}
+
+// do sends a request with the service account token, which the kubelet
+// rotates, so it is read for every request.
+func (e *Elector) do(ctx context.Context, method, target string, in, out any) error {
+	token, err := os.ReadFile(e.tokenFile)
+	if err != nil {
+		return fmt.Errorf("read service account token: %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return errConflict
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s %s: status %d: %s", method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,126 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPIServer stores one Lease with optimistic concurrency, as the
+// Kubernetes API server does.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer sa-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	const collection = "/apis/coordination.k8s.io/v1/namespaces/monitoring/leases"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == collection+"/exporter":
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	case r.Method == http.MethodPost && r.URL.Path == collection, r.Method == http.MethodPut && r.URL.Path == collection+"/exporter":
+		var in lease
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if (r.Method == http.MethodPost) != (f.lease == nil) ||
+			(f.lease != nil && in.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		in.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.lease = &in
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(f.lease)
+}
+
+func newTestElector(t *testing.T, identity string) *Elector {
+	t.Helper()
+	e, err := New(Config{Name: "exporter", Identity: identity, LeaseDuration: 3 * time.Hour, RenewDeadline: 2 * time.Hour, RetryPeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("new elector: %v", err)
+	}
+	return e
+}
+
+func TestElector(t *testing.T) {
+	api := &fakeAPIServer{}
+	srv := httptest.NewTLSServer(api)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+
+	dir := t.TempDir()
+	serviceAccountDir = dir
+	t.Cleanup(func() { serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount" })
+	for name, content := range map[string][]byte{
+		"token":     []byte("sa-token\n"),
+		"namespace": []byte("monitoring"),
+		"ca.crt":    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, b := newTestElector(t, "pod-a"), newTestElector(t, "pod-b")
+	a.Start()
+	b.Start()
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("after start: a leads %v, b leads %v; want only a", a.IsLeader(), b.IsLeader())
+	}
+	a.campaign(context.Background())
+	if !a.IsLeader() || api.lease.Spec.LeaseTransitions != 0 {
+		t.Fatalf("renewal: a leads %v, lease %+v", a.IsLeader(), api.lease.Spec)
+	}
+
+	// Releasing hands the lease over without waiting for it to expire.
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if a.IsLeader() || api.lease.Spec.HolderIdentity != "" {
+		t.Fatalf("after shutdown: a leads %v, holder %q", a.IsLeader(), api.lease.Spec.HolderIdentity)
+	}
+	b.campaign(context.Background())
+	if !b.IsLeader() || api.lease.Spec.HolderIdentity != "pod-b" || api.lease.Spec.LeaseTransitions != 1 {
+		t.Fatalf("after release: b leads %v, lease %+v", b.IsLeader(), api.lease.Spec)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestNewOutsideKubernetes(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := New(Config{Name: "exporter", Namespace: "monitoring", Identity: "pod-a"}); err == nil {
+		t.Fatal("expected an error outside Kubernetes")
+	}
+	if _, err := New(Config{Name: "exporter", Namespace: "monitoring", Identity: "pod-a", LeaseDuration: time.Second}); err == nil {
+		t.Fatal("expected an error for a lease duration below the renew deadline")
+	}
+}
@@ -0,0 +1,58 @@
+package otel
+
+import (
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// dropSet builds the set of attribute keys removed from observations. The
+// org_name attribute identifies the target and cannot be dropped.
+func dropSet(names []string) (map[attribute.Key]struct{}, error) {
+	drop := make(map[attribute.Key]struct{}, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "org_name" {
+			return nil, fmt.Errorf("otel attribute org_name cannot be dropped")
+		}
+		drop[attribute.Key(name)] = struct{}{}
+	}
+	return drop, nil
+}
+
+// dropAttributes removes the keys in drop from every observation. Series
+// that become identical are merged by summing their values, so the result
+// matches `sum without(...)` on the full series.
+func dropAttributes(observations []observation, drop map[attribute.Key]struct{}) []observation {
+	if len(drop) == 0 {
+		return observations
+	}
+
+	type seriesKey struct {
+		name  string
+		attrs attribute.Distinct
+	}
+	out := make([]observation, 0, len(observations))
+	index := make(map[seriesKey]int, len(observations))
+	for _, item := range observations {
+		attrs := make([]attribute.KeyValue, 0, len(item.attrs))
+		for _, kv := range item.attrs {
+			if _, ok := drop[kv.Key]; !ok {
+				attrs = append(attrs, kv)
+			}
+		}
+		set := attribute.NewSet(attrs...)
+		key := seriesKey{name: item.name, attrs: set.Equivalent()}
+		if i, ok := index[key]; ok {
+			out[i].value += item.value
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, observation{name: item.name, value: item.value, attrs: attrs})
+	}
+	return out
+}
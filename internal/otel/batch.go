@@ -0,0 +1,148 @@
+package otel
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// batchingExporter splits each export into requests of at most size data
+// points, so that very large orgs do not produce a single request that runs
+// into the export timeout or the collector's message size limit. Metrics
+// larger than size are split across several scope metrics.
+type batchingExporter struct {
+	sdkmetric.Exporter
+	size int
+}
+
+// newBatchingExporter returns exporter unchanged when size is not positive.
+func newBatchingExporter(exporter sdkmetric.Exporter, size int) sdkmetric.Exporter {
+	if size <= 0 {
+		return exporter
+	}
+	return &batchingExporter{Exporter: exporter, size: size}
+}
+
+// Export sends every batch even after a failure and returns the joined
+// errors.
+func (e *batchingExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	var errs []error
+	for _, batch := range splitResourceMetrics(rm, e.size) {
+		if err := e.Exporter.Export(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func splitResourceMetrics(rm *metricdata.ResourceMetrics, size int) []*metricdata.ResourceMetrics {
+	if countDataPoints(rm) <= size {
+		return []*metricdata.ResourceMetrics{rm}
+	}
+
+	var batches []*metricdata.ResourceMetrics
+	current := &metricdata.ResourceMetrics{Resource: rm.Resource}
+	room := size
+	flush := func() {
+		batches = append(batches, current)
+		current = &metricdata.ResourceMetrics{Resource: rm.Resource}
+		room = size
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			for _, part := range splitMetric(m, room, size) {
+				n := metricDataPoints(part)
+				if n > room {
+					flush()
+				}
+				appendMetric(current, sm.Scope, part)
+				room -= n
+				if room == 0 {
+					flush()
+				}
+			}
+		}
+	}
+	if len(current.ScopeMetrics) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// appendMetric adds m to the last scope metrics of rm when it has the same
+// scope, and to a new one otherwise.
+func appendMetric(rm *metricdata.ResourceMetrics, scope instrumentation.Scope, m metricdata.Metrics) {
+	if last := len(rm.ScopeMetrics) - 1; last >= 0 && rm.ScopeMetrics[last].Scope == scope {
+		rm.ScopeMetrics[last].Metrics = append(rm.ScopeMetrics[last].Metrics, m)
+		return
+	}
+	rm.ScopeMetrics = append(rm.ScopeMetrics, metricdata.ScopeMetrics{Scope: scope, Metrics: []metricdata.Metrics{m}})
+}
+
+func metricDataPoints(m metricdata.Metrics) int {
+	return countDataPoints(&metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{Metrics: []metricdata.Metrics{m}}}})
+}
+
+// splitMetric splits m into parts whose first fits into room and whose others
+// hold at most size data points each.
+func splitMetric(m metricdata.Metrics, room, size int) []metricdata.Metrics {
+	switch data := m.Data.(type) {
+	case metricdata.Gauge[float64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.DataPoint[float64]) metricdata.Aggregation {
+			return metricdata.Gauge[float64]{DataPoints: dp}
+		})
+	case metricdata.Gauge[int64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.DataPoint[int64]) metricdata.Aggregation {
+			return metricdata.Gauge[int64]{DataPoints: dp}
+		})
+	case metricdata.Sum[float64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.DataPoint[float64]) metricdata.Aggregation {
+			return metricdata.Sum[float64]{DataPoints: dp, Temporality: data.Temporality, IsMonotonic: data.IsMonotonic}
+		})
+	case metricdata.Sum[int64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.DataPoint[int64]) metricdata.Aggregation {
+			return metricdata.Sum[int64]{DataPoints: dp, Temporality: data.Temporality, IsMonotonic: data.IsMonotonic}
+		})
+	case metricdata.Histogram[float64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.HistogramDataPoint[float64]) metricdata.Aggregation {
+			return metricdata.Histogram[float64]{DataPoints: dp, Temporality: data.Temporality}
+		})
+	case metricdata.Histogram[int64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.HistogramDataPoint[int64]) metricdata.Aggregation {
+			return metricdata.Histogram[int64]{DataPoints: dp, Temporality: data.Temporality}
+		})
+	case metricdata.ExponentialHistogram[float64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.ExponentialHistogramDataPoint[float64]) metricdata.Aggregation {
+			return metricdata.ExponentialHistogram[float64]{DataPoints: dp, Temporality: data.Temporality}
+		})
+	case metricdata.ExponentialHistogram[int64]:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.ExponentialHistogramDataPoint[int64]) metricdata.Aggregation {
+			return metricdata.ExponentialHistogram[int64]{DataPoints: dp, Temporality: data.Temporality}
+		})
+	case metricdata.Summary:
+		return splitPoints(m, data.DataPoints, room, size, func(dp []metricdata.SummaryDataPoint) metricdata.Aggregation {
+			return metricdata.Summary{DataPoints: dp}
+		})
+	default:
+		return []metricdata.Metrics{m}
+	}
+}
+
+func splitPoints[P any](m metricdata.Metrics, points []P, room, size int, wrap func([]P) metricdata.Aggregation) []metricdata.Metrics {
+	if len(points) <= room {
+		return []metricdata.Metrics{m}
+	}
+	var parts []metricdata.Metrics
+	for len(points) > 0 {
+		n := min(room, len(points))
+		part := m
+		part.Data = wrap(points[:n])
+		parts = append(parts, part)
+		points = points[n:]
+		room = size
+	}
+	return parts
+}
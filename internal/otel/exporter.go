@@ -0,0 +1,174 @@
+package otel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	defaultGRPCEndpoint = "127.0.0.1:4317"
+	defaultHTTPEndpoint = "127.0.0.1:4318"
+)
+
+// DefaultEndpoint returns the local collector endpoint for protocol.
+func DefaultEndpoint(protocol string) string {
+	if strings.EqualFold(strings.TrimSpace(protocol), ProtocolHTTP) {
+		return defaultHTTPEndpoint
+	}
+	return defaultGRPCEndpoint
+}
+
+// newMetricExporter builds the OTLP exporter for cfg.Protocol.
+func newMetricExporter(ctx context.Context, cfg Config) (sdkmetric.Exporter, error) {
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	temporality, err := temporalitySelector(cfg.Temporality)
+	if err != nil {
+		return nil, err
+	}
+	aggregation, err := aggregationSelector(cfg.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+	retry := cfg.Retry.settings()
+
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig(retry)),
+			otlpmetricgrpc.WithTimeout(cfg.ExportTimeout),
+			otlpmetricgrpc.WithTemporalitySelector(temporality),
+			otlpmetricgrpc.WithAggregationSelector(aggregation),
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case ProtocolHTTP:
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig(retry)),
+			otlpmetrichttp.WithTimeout(cfg.ExportTimeout),
+			otlpmetrichttp.WithTemporalitySelector(temporality),
+			otlpmetrichttp.WithAggregationSelector(aggregation),
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported otel protocol %q: use %s or %s", cfg.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+}
+
+// tlsConfig builds the client TLS configuration from the CA bundle and
+// client certificate files. It returns nil when none are set, leaving the
+// exporter on the system roots.
+func tlsConfig(cfg Config) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil, nil
+	}
+	if cfg.Insecure {
+		return nil, errors.New("otel TLS files cannot be combined with insecure mode")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("otel client certificate and key must be set together")
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read otel CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in otel CA file %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load otel client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// ParseHeaders parses a comma-separated list of key=value pairs, as used by
+// OTEL_EXPORTER_OTLP_HEADERS. Values may be URL-encoded.
+func ParseHeaders(raw string) (map[string]string, error) {
+	return parseKeyValues("header", raw)
+}
+
+// ParseResourceAttributes parses key=value pairs in the format of
+// OTEL_RESOURCE_ATTRIBUTES.
+func ParseResourceAttributes(raw string) (map[string]string, error) {
+	return parseKeyValues("resource attribute", raw)
+}
+
+func parseKeyValues(kind, raw string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid otel %s %q: expected key=value", kind, strings.TrimSpace(pair))
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid otel %s %q: %w", kind, key, err)
+		}
+		values[key] = decoded
+	}
+	return values, nil
+}
+
+// RedactHeaders renders headers for logging with every value replaced, since
+// they usually carry credentials.
+func RedactHeaders(headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for i, key := range keys {
+		keys[i] = key + "=<redacted>"
+	}
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,94 @@
+package otel
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	exportResultSuccess = "success"
+	exportResultFailure = "failure"
+)
+
+// exportStats counts OTLP metric exports per endpoint so a broken push path
+// shows up on the Prometheus endpoint instead of only in the logs.
+type exportStats struct {
+	exports    *prometheus.CounterVec
+	datapoints *prometheus.CounterVec
+	up         *prometheus.GaugeVec
+}
+
+func newExportStats(endpoints []string) *exportStats {
+	s := &exportStats{
+		exports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_otel_exports_total",
+			Help: "OTLP metric exports by endpoint and result.",
+		}, []string{"endpoint", "result"}),
+		datapoints: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_otel_exported_datapoints_total",
+			Help: "Datapoints in successful OTLP metric exports by endpoint.",
+		}, []string{"endpoint"}),
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_otel_endpoint_up",
+			Help: "Whether the last OTLP metric export to the endpoint succeeded (1) or failed (0).",
+		}, []string{"endpoint"}),
+	}
+	for _, endpoint := range endpoints {
+		s.exports.WithLabelValues(endpoint, exportResultSuccess)
+		s.exports.WithLabelValues(endpoint, exportResultFailure)
+		s.datapoints.WithLabelValues(endpoint)
+	}
+	return s
+}
+
+func (s *exportStats) Describe(ch chan<- *prometheus.Desc) {
+	s.exports.Describe(ch)
+	s.datapoints.Describe(ch)
+	s.up.Describe(ch)
+}
+
+func (s *exportStats) Collect(ch chan<- prometheus.Metric) {
+	s.exports.Collect(ch)
+	s.datapoints.Collect(ch)
+	s.up.Collect(ch)
+}
+
+func (s *exportStats) record(endpoint string, rm *metricdata.ResourceMetrics, err error) {
+	if err != nil {
+		s.exports.WithLabelValues(endpoint, exportResultFailure).Inc()
+		s.up.WithLabelValues(endpoint).Set(0)
+		return
+	}
+	s.exports.WithLabelValues(endpoint, exportResultSuccess).Inc()
+	s.datapoints.WithLabelValues(endpoint).Add(float64(countDataPoints(rm)))
+	s.up.WithLabelValues(endpoint).Set(1)
+}
+
+func countDataPoints(rm *metricdata.ResourceMetrics) int {
+	count := 0
+	for _, scopeMetrics := range rm.ScopeMetrics {
+		for _, m := range scopeMetrics.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[float64]:
+				count += len(data.DataPoints)
+			case metricdata.Gauge[int64]:
+				count += len(data.DataPoints)
+			case metricdata.Sum[float64]:
+				count += len(data.DataPoints)
+			case metricdata.Sum[int64]:
+				count += len(data.DataPoints)
+			case metricdata.Histogram[float64]:
+				count += len(data.DataPoints)
+			case metricdata.Histogram[int64]:
+				count += len(data.DataPoints)
+			case metricdata.ExponentialHistogram[float64]:
+				count += len(data.DataPoints)
+			case metricdata.ExponentialHistogram[int64]:
+				count += len(data.DataPoints)
+			case metricdata.Summary:
+				count += len(data.DataPoints)
+			}
+		}
+	}
+	return count
+}
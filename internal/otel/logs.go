@@ -0,0 +1,123 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"google.golang.org/grpc/credentials"
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+const refreshFailedEvent = "cls.refresh.failed"
+
+// NewLoggerProvider builds a logger provider that batches log records to the
+// same OTLP endpoint, protocol, headers and TLS settings as the metrics
+// pusher.
+func NewLoggerProvider(ctx context.Context, cfg Config) (*sdklog.LoggerProvider, error) {
+	cfg = normalizeConfig(cfg)
+	if strings.TrimSpace(cfg.Endpoint) == "" {
+		return nil, fmt.Errorf("otel endpoint is required")
+	}
+	if strings.TrimSpace(cfg.ServiceName) == "" {
+		return nil, fmt.Errorf("otel service name is required")
+	}
+
+	res, err := newResource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := newLogExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp log exporter: %w", err)
+	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+		sdklog.WithResource(res),
+	), nil
+}
+
+func newLogExporter(ctx context.Context, cfg Config) (sdklog.Exporter, error) {
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	retry := cfg.Retry.settings()
+
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(cfg.Endpoint),
+			otlploggrpc.WithRetry(otlploggrpc.RetryConfig(retry)),
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlploggrpc.WithHeaders(cfg.Headers))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		return otlploggrpc.New(ctx, opts...)
+	case ProtocolHTTP:
+		opts := []otlploghttp.Option{
+			otlploghttp.WithEndpoint(cfg.Endpoint),
+			otlploghttp.WithRetry(otlploghttp.RetryConfig(retry)),
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlploghttp.WithHeaders(cfg.Headers))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlploghttp.WithTLSClientConfig(tlsCfg))
+		}
+		return otlploghttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported otel protocol %q: use %s or %s", cfg.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+}
+
+// ErrorLogger ships refresh failures as structured OTEL log records. Its
+// RefreshFailed method fits snapshot.Config.OnError.
+type ErrorLogger struct {
+	logger otellog.Logger
+}
+
+func NewErrorLogger(provider otellog.LoggerProvider) *ErrorLogger {
+	return &ErrorLogger{logger: provider.Logger("nvidia-license-server-exporter")}
+}
+
+// RefreshFailed emits one error record for target. CLS API errors also carry
+// the endpoint, status code and NVIDIA request ID.
+func (l *ErrorLogger) RefreshFailed(ctx context.Context, target string, err error) {
+	var record otellog.Record
+	record.SetEventName(refreshFailedEvent)
+	record.SetTimestamp(time.Now())
+	record.SetSeverity(otellog.SeverityError)
+	record.SetSeverityText("ERROR")
+	record.SetBody(otellog.StringValue(err.Error()))
+	record.AddAttributes(otellog.String("org_name", target))
+
+	var apiErr *cls.APIError
+	if errors.As(err, &apiErr) {
+		record.AddAttributes(
+			otellog.String("url.full", apiErr.Endpoint),
+			otellog.Int("http.response.status_code", apiErr.StatusCode),
+		)
+		if apiErr.RequestID != "" {
+			record.AddAttributes(otellog.String("cls.request_id", apiErr.RequestID))
+		}
+	}
+
+	l.logger.Emit(ctx, record)
+}
@@ -3,25 +3,23 @@ package otel
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
-	"go.opentelemetry.io/otel/sdk/resource"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"nvidia-license-server-exporter/internal/cls"
 	"nvidia-license-server-exporter/internal/snapshot"
 )
 
 const (
-	defaultPushInterval   = 60 * time.Second
-	defaultRefreshTimeout = 20 * time.Second
+	defaultPushInterval  = 60 * time.Second
+	defaultExportTimeout = 30 * time.Second
 
 	metricUp                  = "nvidia_cls_up"
 	metricScrapeDuration      = "nvidia_cls_scrape_duration_seconds"
@@ -30,26 +28,137 @@ const (
 	metricServerInfo          = "nvidia_cls_license_server_info"
 	metricServerFeatureTotal  = "nvidia_cls_license_server_feature_total_quantity"
 	metricServerFeatureActive = "nvidia_cls_license_server_feature_active_leases"
+	metricPhaseDuration       = "nvidia_cls_scrape_phase_duration_seconds"
+	metricPhaseItems          = "nvidia_cls_scrape_phase_items"
+	metricLastError           = "nvidia_cls_last_error_info"
+	metricValidationFailures  = "nvidia_cls_exporter_snapshot_validation_failures_total"
+	metricSnapshotBytes       = "nvidia_cls_exporter_snapshot_bytes"
+	metricSnapshotElements    = "nvidia_cls_exporter_snapshot_elements"
+	metricSnapshotTruncated   = "nvidia_cls_exporter_snapshot_truncated"
+	metricConsecutiveFailures = "nvidia_cls_exporter_refresh_consecutive_failures"
+	metricBackoffRemaining    = "nvidia_cls_exporter_refresh_backoff_remaining_seconds"
+	metricLeaseChanges        = "nvidia_cls_lease_changes_total"
+	metricAPIRequests         = "nvidia_cls_exporter_api_requests_total"
+	// metricRefreshDuration is OTEL-only: a histogram of all snapshot
+	// fetches.
+	metricRefreshDuration = "nvidia_cls_refresh_duration_seconds"
+
+	// maxErrorAttrLength bounds the error attribute of metricLastError.
+	maxErrorAttrLength = 256
+
+	// LeaseInstrumentGauge and LeaseInstrumentUpDownCounter select the
+	// instrument of active lease metrics.
+	LeaseInstrumentGauge         = "gauge"
+	LeaseInstrumentUpDownCounter = "updowncounter"
 )
 
+// metricDefs lists every pushed metric; it mirrors the Prometheus collector,
+// with help as the instrument description. leases marks active lease counts,
+// whose instrument follows Config.LeaseInstrument.
+var metricDefs = []struct {
+	name    string
+	unit    string
+	help    string
+	counter bool
+	leases  bool
+}{
+	{name: metricUp, unit: "1", help: "Whether the NVIDIA CLS scrape is successful (1 = up, 0 = down)."},
+	{name: metricScrapeDuration, unit: "s", help: "Time spent querying NVIDIA CLS APIs."},
+	{name: metricScrapeTimestamp, unit: "s", help: "Unix timestamp for when the scrape snapshot was collected."},
+	{name: metricEntitlementTotal, unit: "{license}", help: "Total entitlement quantity by virtual group and feature (contract capacity)."},
+	{name: metricServerInfo, unit: "1", help: "Static information about a license server."},
+	{name: metricServerFeatureTotal, unit: "{license}", help: "Total server feature capacity from license-server features."},
+	{name: metricServerFeatureActive, unit: "{lease}", help: "Active lease count by server feature from CLS active-lease data.", leases: true},
+	{name: metricPhaseDuration, unit: "s", help: "Time spent in each phase of the most recent successful CLS fetch."},
+	{name: metricPhaseItems, unit: "{item}", help: "Number of items returned by each phase of the most recent successful CLS fetch."},
+	{name: metricLastError, unit: "1", help: "Error of the most recent failed refresh; absent once a refresh succeeds."},
+	{name: metricValidationFailures, unit: "{snapshot}", help: "Number of fetched snapshots that failed a sanity check, by check.", counter: true},
+	{name: metricSnapshotBytes, unit: "By", help: "Approximate in-memory size of the cached snapshot in bytes."},
+	{name: metricSnapshotElements, unit: "{element}", help: "Number of elements in each section of the cached snapshot."},
+	{name: metricSnapshotTruncated, unit: "1", help: "Whether sections were dropped from the cached snapshot to respect the size cap (1 = truncated)."},
+	{name: metricConsecutiveFailures, unit: "{failure}", help: "Number of consecutive failed CLS snapshot fetches."},
+	{name: metricBackoffRemaining, unit: "s", help: "Seconds until CLS fetches are retried after consecutive failures (0 = not backing off)."},
+	{name: metricLeaseChanges, unit: "{lease}", help: "Active leases gained and lost between consecutive snapshots, by direction.", counter: true},
+	{name: metricAPIRequests, unit: "{request}", help: "Number of CLS API requests by operation and response code.", counter: true},
+}
+
 type Config struct {
-	Enabled           bool
-	Endpoint          string
+	Enabled bool
+	// Protocol is the OTLP transport, ProtocolGRPC or ProtocolHTTP
+	// (HTTP/protobuf). Empty means gRPC.
+	Protocol string
+	// Endpoint is the collector host:port; see DefaultEndpoint.
+	Endpoint string
+	// Endpoints, when set, replaces Endpoint for metrics with several
+	// collectors used according to EndpointMode. Traces and logs still go
+	// to Endpoint, which defaults to the first entry.
+	Endpoints []string
+	// EndpointMode is EndpointModeFanout or EndpointModeFailover. Empty
+	// means fan-out.
+	EndpointMode string
+	// Headers are sent with every export request, e.g. for authentication.
+	Headers           map[string]string
 	ServiceName       string
 	ServiceInstanceID string
-	Insecure          bool
-	PushInterval      time.Duration
-	RefreshTimeout    time.Duration
+	// ServiceNamespace and DeploymentEnv set service.namespace and
+	// deployment.environment when not empty, overriding any other source.
+	ServiceNamespace string
+	DeploymentEnv    string
+	// ResourceDetectors selects the resource detectors run at startup; see
+	// DefaultResourceDetectors. Nil runs none.
+	ResourceDetectors []string
+	// ResourceAttributes are added to the resource after detection and
+	// OTEL_RESOURCE_ATTRIBUTES, e.g. for tenancy routing.
+	ResourceAttributes map[string]string
+	Insecure           bool
+	// CAFile verifies the collector certificate instead of the system
+	// roots. CertFile and KeyFile enable client certificate (mTLS) auth.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Temporality is the temporality preference: TemporalityCumulative,
+	// TemporalityDelta or TemporalityLowMemory. Empty means cumulative.
+	Temporality string
+	// Aggregations overrides the aggregation per instrument kind; see
+	// ParseAggregations.
+	Aggregations map[string]string
+	// DropAttributes removes these attributes from every pushed metric,
+	// summing series that become identical. org_name cannot be dropped.
+	DropAttributes []string
+	// MetricNames is MetricNamesPrometheus (default) or MetricNamesOTEL.
+	// Views match the names selected here.
+	MetricNames string
+	// LeaseInstrument is LeaseInstrumentGauge (default, for compatibility)
+	// or LeaseInstrumentUpDownCounter, which pushes active leases as
+	// non-monotonic sums as OTEL recommends for fluctuating usage.
+	LeaseInstrument string
+	// DurationHistogram selects the aggregation of duration histograms:
+	// DurationHistogramExponential (default) or DurationHistogramExplicit.
+	DurationHistogram string
+	// Views rename metrics, drop attributes or change aggregations before
+	// export; see LoadViews.
+	Views []ViewConfig
+	// Retry configures retries of failed exports for every signal.
+	Retry        RetryConfig
+	PushInterval time.Duration
+	// ExportTimeout bounds each push, collection and all requests included,
+	// and each single export request. Zero uses the default.
+	ExportTimeout time.Duration
+	// MaxExportBatchSize splits pushes into requests of at most this many
+	// data points, exported one after the other. Zero sends one request.
+	MaxExportBatchSize int
 }
 
 type MetricsPusher struct {
-	cfg         Config
-	orgName     string
-	snapshotSvc *snapshot.Service
+	cfg     Config
+	manager *snapshot.Manager
 
 	meterProvider *sdkmetric.MeterProvider
-	cancel        context.CancelFunc
-	done          chan struct{}
+	stats         *exportStats
+	drop          map[attribute.Key]struct{}
+
+	refreshDuration metric.Float64Histogram
+	unsubscribe     []func()
 }
 
 type observation struct {
@@ -58,7 +167,7 @@ type observation struct {
 	attrs []attribute.KeyValue
 }
 
-func NewMetricsPusher(ctx context.Context, cfg Config, orgName string, snapshotSvc *snapshot.Service) (*MetricsPusher, error) {
+func NewMetricsPusher(ctx context.Context, cfg Config, manager *snapshot.Manager) (*MetricsPusher, error) {
 	cfg = normalizeConfig(cfg)
 	if strings.TrimSpace(cfg.Endpoint) == "" {
 		return nil, fmt.Errorf("otel endpoint is required")
@@ -67,167 +176,183 @@ func NewMetricsPusher(ctx context.Context, cfg Config, orgName string, snapshotS
 		return nil, fmt.Errorf("otel service name is required")
 	}
 
-	res, err := resource.New(
-		ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(cfg.ServiceName),
-			semconv.ServiceInstanceIDKey.String(cfg.ServiceInstanceID),
-		),
-	)
+	if cfg.LeaseInstrument != LeaseInstrumentGauge && cfg.LeaseInstrument != LeaseInstrumentUpDownCounter {
+		return nil, fmt.Errorf("unsupported otel lease instrument %q: use %s or %s", cfg.LeaseInstrument, LeaseInstrumentGauge, LeaseInstrumentUpDownCounter)
+	}
+	if cfg.MetricNames != MetricNamesPrometheus && cfg.MetricNames != MetricNamesOTEL {
+		return nil, fmt.Errorf("unsupported otel metric names %q: use %s or %s", cfg.MetricNames, MetricNamesPrometheus, MetricNamesOTEL)
+	}
+	drop, err := dropSet(cfg.DropAttributes)
 	if err != nil {
-		return nil, fmt.Errorf("create otel resource: %w", err)
+		return nil, err
 	}
-
-	expOpts := []otlpmetricgrpc.Option{
-		otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+	views, err := metricViews(cfg.Views, cfg.DurationHistogram)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.Insecure {
-		expOpts = append(expOpts, otlpmetricgrpc.WithInsecure())
+	res, err := newResource(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	baseExporter, err := otlpmetricgrpc.New(ctx, expOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("create otlp metric exporter: %w", err)
+	endpoints := cfg.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{cfg.Endpoint}
+	}
+	stats := newExportStats(endpoints)
+	exporters := make([]sdkmetric.Exporter, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpointCfg := cfg
+		endpointCfg.Endpoint = endpoint
+		baseExporter, err := newMetricExporter(ctx, endpointCfg)
+		if err != nil {
+			for _, created := range exporters {
+				_ = created.Shutdown(ctx)
+			}
+			return nil, fmt.Errorf("create otlp metric exporter for %s: %w", endpoint, err)
+		}
+		exporters = append(exporters, &loggingExporter{
+			endpoint: endpoint,
+			exporter: newBatchingExporter(baseExporter, cfg.MaxExportBatchSize),
+			stats:    stats,
+		})
 	}
-	exporter := &loggingExporter{
-		endpoint: cfg.Endpoint,
-		exporter: baseExporter,
+	exporter, err := newMultiExporter(cfg.EndpointMode, exporters)
+	if err != nil {
+		for _, created := range exporters {
+			_ = created.Shutdown(ctx)
+		}
+		return nil, err
 	}
 
-	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(cfg.PushInterval))
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.PushInterval),
+		sdkmetric.WithTimeout(cfg.ExportTimeout),
+	)
 	meterProvider := sdkmetric.NewMeterProvider(
 		sdkmetric.WithReader(reader),
 		sdkmetric.WithResource(res),
+		sdkmetric.WithView(views...),
 	)
 	meter := meterProvider.Meter(cfg.ServiceName)
 
 	p := &MetricsPusher{
 		cfg:           cfg,
-		orgName:       orgName,
-		snapshotSvc:   snapshotSvc,
+		manager:       manager,
 		meterProvider: meterProvider,
-		done:          make(chan struct{}),
+		stats:         stats,
+		drop:          drop,
 	}
 
 	if err := p.registerMetrics(meter); err != nil {
 		_ = meterProvider.Shutdown(ctx)
 		return nil, err
 	}
+	p.refreshDuration, err = meter.Float64Histogram(instrumentName(cfg.MetricNames, metricRefreshDuration),
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of snapshot fetches from CLS."),
+	)
+	if err != nil {
+		_ = meterProvider.Shutdown(ctx)
+		return nil, fmt.Errorf("create metric %s: %w", metricRefreshDuration, err)
+	}
 
 	return p, nil
 }
 
+// Collector exposes the pusher's export counters for a Prometheus registry.
+func (p *MetricsPusher) Collector() prometheus.Collector {
+	return p.stats
+}
+
+// ForceFlush collects and exports the current metrics immediately instead of
+// waiting for the next push interval.
+func (p *MetricsPusher) ForceFlush(ctx context.Context) error {
+	return p.meterProvider.ForceFlush(ctx)
+}
+
+// Start records the duration of every snapshot fetch. The pusher never
+// refreshes snapshots itself: it observes Service.Latest on each push, and
+// refresh cadence is owned by the snapshot services.
 func (p *MetricsPusher) Start() {
-	ctx, cancel := context.WithCancel(context.Background())
-	p.cancel = cancel
-
-	go func() {
-		defer close(p.done)
-
-		p.refreshOnce()
-		ticker := time.NewTicker(p.cfg.PushInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				p.refreshOnce()
-			}
-		}
-	}()
+	for _, svc := range p.manager.Services() {
+		p.unsubscribe = append(p.unsubscribe, svc.Subscribe(p.recordRefresh))
+	}
 }
 
 func (p *MetricsPusher) Shutdown(ctx context.Context) error {
-	if p.cancel != nil {
-		p.cancel()
-	}
-	select {
-	case <-p.done:
-	case <-ctx.Done():
-		return ctx.Err()
+	for _, cancel := range p.unsubscribe {
+		cancel()
 	}
 	return p.meterProvider.Shutdown(ctx)
 }
 
-func (p *MetricsPusher) refreshOnce() {
-	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.RefreshTimeout)
-	defer cancel()
-
-	_, _, err := p.snapshotSvc.Refresh(ctx)
-	if err != nil {
-		log.Printf("otel refresh failed: %v", err)
+func (p *MetricsPusher) recordRefresh(event snapshot.RefreshEvent) {
+	result := "success"
+	if event.Err != nil {
+		result = "failure"
 	}
+	p.refreshDuration.Record(context.Background(), event.Duration.Seconds(), metric.WithAttributes(
+		attribute.String("org_name", event.Target),
+		attribute.String("result", result),
+	))
 }
 
 func (p *MetricsPusher) registerMetrics(meter metric.Meter) error {
-	up, err := meter.Float64ObservableGauge(metricUp)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_up: %w", err)
-	}
-	scrapeDuration, err := meter.Float64ObservableGauge(metricScrapeDuration)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_scrape_duration_seconds: %w", err)
-	}
-	scrapeTimestamp, err := meter.Float64ObservableGauge(metricScrapeTimestamp)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_scrape_timestamp_seconds: %w", err)
-	}
-	entitlementTotal, err := meter.Float64ObservableGauge(metricEntitlementTotal)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_entitlement_total_quantity: %w", err)
-	}
-	serverInfo, err := meter.Float64ObservableGauge(metricServerInfo)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_license_server_info: %w", err)
-	}
-	serverFeatureTotal, err := meter.Float64ObservableGauge(metricServerFeatureTotal)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_license_server_feature_total_quantity: %w", err)
-	}
-	serverFeatureActive, err := meter.Float64ObservableGauge(metricServerFeatureActive)
-	if err != nil {
-		return fmt.Errorf("create metric nvidia_cls_license_server_feature_active_leases: %w", err)
+	instruments := make(map[string]metric.Float64Observable, len(metricDefs))
+	observables := make([]metric.Observable, 0, len(metricDefs))
+	for _, def := range metricDefs {
+		var (
+			instrument metric.Float64Observable
+			err        error
+		)
+		name := instrumentName(p.cfg.MetricNames, def.name)
+		switch {
+		case def.counter:
+			instrument, err = meter.Float64ObservableCounter(name, metric.WithUnit(def.unit), metric.WithDescription(def.help))
+		case def.leases && p.cfg.LeaseInstrument == LeaseInstrumentUpDownCounter:
+			instrument, err = meter.Float64ObservableUpDownCounter(name, metric.WithUnit(def.unit), metric.WithDescription(def.help))
+		default:
+			instrument, err = meter.Float64ObservableGauge(name, metric.WithUnit(def.unit), metric.WithDescription(def.help))
+		}
+		if err != nil {
+			return fmt.Errorf("create metric %s: %w", name, err)
+		}
+		instruments[def.name] = instrument
+		observables = append(observables, instrument)
 	}
 
-	_, err = meter.RegisterCallback(
-		func(_ context.Context, o metric.Observer) error {
-			snap, meta, ok := p.snapshotSvc.Latest()
+	observe := func(o metric.Observer, observations []observation) {
+		for _, item := range dropAttributes(observations, p.drop) {
+			instrument, ok := instruments[item.name]
 			if !ok {
-				return nil
+				slog.Warn("unknown otel metric name", "metric", item.name)
+				continue
 			}
+			o.ObserveFloat64(instrument, item.value, metric.WithAttributes(item.attrs...))
+		}
+	}
 
-			for _, item := range buildObservations(p.orgName, snap, meta) {
-				switch item.name {
-				case metricUp:
-					o.ObserveFloat64(up, item.value, metric.WithAttributes(item.attrs...))
-				case metricScrapeDuration:
-					o.ObserveFloat64(scrapeDuration, item.value, metric.WithAttributes(item.attrs...))
-				case metricScrapeTimestamp:
-					o.ObserveFloat64(scrapeTimestamp, item.value, metric.WithAttributes(item.attrs...))
-				case metricEntitlementTotal:
-					o.ObserveFloat64(entitlementTotal, item.value, metric.WithAttributes(item.attrs...))
-				case metricServerInfo:
-					o.ObserveFloat64(serverInfo, item.value, metric.WithAttributes(item.attrs...))
-				case metricServerFeatureTotal:
-					o.ObserveFloat64(serverFeatureTotal, item.value, metric.WithAttributes(item.attrs...))
-				case metricServerFeatureActive:
-					o.ObserveFloat64(serverFeatureActive, item.value, metric.WithAttributes(item.attrs...))
-				default:
-					log.Printf("unknown otel metric name: %s", item.name)
+	_, err := meter.RegisterCallback(
+		func(_ context.Context, o metric.Observer) error {
+			now := time.Now()
+			for _, svc := range p.manager.Services() {
+				observe(o, buildHealthObservations(svc.Target(), svc.ValidationFailures(), svc.Footprint(), svc.Backoff(), now))
+				observe(o, buildActivityObservations(svc.Target(), svc.LeaseChurn(), svc.APIRequests()))
+
+				snap, meta, ok := svc.Latest()
+				if !ok {
+					if meta.Timestamp.IsZero() {
+						continue
+					}
+					snap = &cls.Snapshot{}
 				}
+				observe(o, buildObservations(svc.Target(), snap, meta))
 			}
 
 			return nil
 		},
-		up,
-		scrapeDuration,
-		scrapeTimestamp,
-		entitlementTotal,
-		serverInfo,
-		serverFeatureTotal,
-		serverFeatureActive,
+		observables...,
 	)
 	if err != nil {
 		return fmt.Errorf("register otel callback: %w", err)
@@ -237,11 +362,32 @@ func (p *MetricsPusher) registerMetrics(meter metric.Meter) error {
 }
 
 func normalizeConfig(cfg Config) Config {
+	cfg.Protocol = strings.ToLower(strings.TrimSpace(cfg.Protocol))
+	cfg.Temporality = strings.ToLower(strings.TrimSpace(cfg.Temporality))
+	cfg.EndpointMode = strings.ToLower(strings.TrimSpace(cfg.EndpointMode))
+	cfg.DurationHistogram = strings.ToLower(strings.TrimSpace(cfg.DurationHistogram))
+	cfg.LeaseInstrument = strings.ToLower(strings.TrimSpace(cfg.LeaseInstrument))
+	cfg.MetricNames = strings.ToLower(strings.TrimSpace(cfg.MetricNames))
+	if cfg.Protocol == "" {
+		cfg.Protocol = ProtocolGRPC
+	}
+	if cfg.EndpointMode == "" {
+		cfg.EndpointMode = EndpointModeFanout
+	}
+	if cfg.LeaseInstrument == "" {
+		cfg.LeaseInstrument = LeaseInstrumentGauge
+	}
+	if cfg.MetricNames == "" {
+		cfg.MetricNames = MetricNamesPrometheus
+	}
+	if strings.TrimSpace(cfg.Endpoint) == "" && len(cfg.Endpoints) > 0 {
+		cfg.Endpoint = cfg.Endpoints[0]
+	}
 	if cfg.PushInterval <= 0 {
 		cfg.PushInterval = defaultPushInterval
 	}
-	if cfg.RefreshTimeout <= 0 {
-		cfg.RefreshTimeout = defaultRefreshTimeout
+	if cfg.ExportTimeout <= 0 {
+		cfg.ExportTimeout = defaultExportTimeout
 	}
 	return cfg
 }
@@ -249,6 +395,7 @@ func normalizeConfig(cfg Config) Config {
 type loggingExporter struct {
 	endpoint string
 	exporter sdkmetric.Exporter
+	stats    *exportStats
 }
 
 func (e *loggingExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
@@ -260,8 +407,12 @@ func (e *loggingExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.A
 }
 
 func (e *loggingExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
-	if err := e.exporter.Export(ctx, rm); err != nil {
-		log.Printf("otel export failed endpoint=%s err=%v", e.endpoint, err)
+	err := e.exporter.Export(ctx, rm)
+	if e.stats != nil {
+		e.stats.record(e.endpoint, rm, err)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "otel export failed", "endpoint", e.endpoint, "error", err)
 		return err
 	}
 
@@ -270,7 +421,7 @@ func (e *loggingExporter) Export(ctx context.Context, rm *metricdata.ResourceMet
 		metricCount += len(scopeMetrics.Metrics)
 	}
 
-	log.Printf("otel export succeeded endpoint=%s scopes=%d metrics=%d", e.endpoint, len(rm.ScopeMetrics), metricCount)
+	slog.DebugContext(ctx, "otel export succeeded", "endpoint", e.endpoint, "scopes", len(rm.ScopeMetrics), "metrics", metricCount)
 	return nil
 }
 
@@ -292,6 +443,21 @@ func buildObservations(orgName string, snap *cls.Snapshot, meta snapshot.Meta) [
 		observation{name: metricScrapeTimestamp, value: float64(meta.Timestamp.Unix()), attrs: []attribute.KeyValue{orgAttr}},
 	)
 
+	for _, phase := range meta.Phases {
+		phaseAttrs := []attribute.KeyValue{orgAttr, attribute.String("phase", phase.Name)}
+		observations = append(observations,
+			observation{name: metricPhaseDuration, value: phase.DurationSeconds, attrs: phaseAttrs},
+			observation{name: metricPhaseItems, value: float64(phase.Items), attrs: phaseAttrs},
+		)
+	}
+	if meta.LastError != "" {
+		observations = append(observations, observation{
+			name:  metricLastError,
+			value: 1,
+			attrs: []attribute.KeyValue{orgAttr, attribute.String("error", truncate(meta.LastError, maxErrorAttrLength))},
+		})
+	}
+
 	for _, item := range snap.EntitlementFeatures {
 		observations = append(observations, observation{
 			name:  metricEntitlementTotal,
@@ -362,6 +528,73 @@ func buildObservations(orgName string, snap *cls.Snapshot, meta snapshot.Meta) [
 	return observations
 }
 
+// buildHealthObservations covers the per-target metrics that do not depend on
+// the cached snapshot being servable.
+func buildHealthObservations(orgName string, failures map[string]float64, fp snapshot.Footprint, backoff snapshot.BackoffState, now time.Time) []observation {
+	orgAttr := attribute.String("org_name", orgName)
+	observations := make([]observation, 0, 4+len(snapshot.ValidationChecks)+len(snapshot.Sections))
+
+	for _, check := range snapshot.ValidationChecks {
+		observations = append(observations, observation{
+			name:  metricValidationFailures,
+			value: failures[check],
+			attrs: []attribute.KeyValue{orgAttr, attribute.String("check", check)},
+		})
+	}
+
+	truncated := 0.0
+	if len(fp.Dropped) > 0 {
+		truncated = 1
+	}
+	remaining := backoff.Until.Sub(now).Seconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+	observations = append(observations,
+		observation{name: metricSnapshotBytes, value: float64(fp.Bytes), attrs: []attribute.KeyValue{orgAttr}},
+		observation{name: metricSnapshotTruncated, value: truncated, attrs: []attribute.KeyValue{orgAttr}},
+		observation{name: metricConsecutiveFailures, value: float64(backoff.ConsecutiveFailures), attrs: []attribute.KeyValue{orgAttr}},
+		observation{name: metricBackoffRemaining, value: remaining, attrs: []attribute.KeyValue{orgAttr}},
+	)
+	for _, section := range snapshot.Sections {
+		observations = append(observations, observation{
+			name:  metricSnapshotElements,
+			value: float64(fp.Elements[section]),
+			attrs: []attribute.KeyValue{orgAttr, attribute.String("section", section)},
+		})
+	}
+
+	return observations
+}
+
+// buildActivityObservations reports the cumulative lease churn and API
+// request counts; they are pushed as monotonic sums so backends can compute
+// rates under either temporality.
+func buildActivityObservations(orgName string, churn snapshot.LeaseChurn, requests []cls.APIRequestCount) []observation {
+	orgAttr := attribute.String("org_name", orgName)
+	observations := make([]observation, 0, 2+len(requests))
+	observations = append(observations,
+		observation{name: metricLeaseChanges, value: churn.Added, attrs: []attribute.KeyValue{orgAttr, attribute.String("direction", "added")}},
+		observation{name: metricLeaseChanges, value: churn.Removed, attrs: []attribute.KeyValue{orgAttr, attribute.String("direction", "removed")}},
+	)
+	for _, req := range requests {
+		observations = append(observations, observation{
+			name:  metricAPIRequests,
+			value: req.Count,
+			attrs: []attribute.KeyValue{orgAttr, attribute.String("operation", req.Operation), attribute.String("code", req.Code)},
+		})
+	}
+	return observations
+}
+
+func truncate(v string, limit int) string {
+	runes := []rune(v)
+	if len(runes) <= limit {
+		return v
+	}
+	return string(runes[:limit]) + "..."
+}
+
 func safeLabel(v string) string {
 	v = strings.TrimSpace(v)
 	if v == "" {
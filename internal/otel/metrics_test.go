@@ -2,11 +2,32 @@ package otel
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/metric"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/exporter"
 	"nvidia-license-server-exporter/internal/snapshot"
 )
 
@@ -15,8 +36,162 @@ func TestNormalizeConfigDefaults(t *testing.T) {
 	if cfg.PushInterval != defaultPushInterval {
 		t.Fatalf("expected default push interval %s, got %s", defaultPushInterval, cfg.PushInterval)
 	}
-	if cfg.RefreshTimeout != defaultRefreshTimeout {
-		t.Fatalf("expected default refresh timeout %s, got %s", defaultRefreshTimeout, cfg.RefreshTimeout)
+	if cfg.ExportTimeout != defaultExportTimeout {
+		t.Fatalf("expected default export timeout %s, got %s", defaultExportTimeout, cfg.ExportTimeout)
+	}
+}
+
+func TestNormalizeConfigProtocol(t *testing.T) {
+	if cfg := normalizeConfig(Config{}); cfg.Protocol != ProtocolGRPC {
+		t.Fatalf("expected default protocol grpc, got %q", cfg.Protocol)
+	}
+	if cfg := normalizeConfig(Config{Protocol: " HTTP "}); cfg.Protocol != ProtocolHTTP {
+		t.Fatalf("expected protocol http, got %q", cfg.Protocol)
+	}
+	if DefaultEndpoint(ProtocolHTTP) != defaultHTTPEndpoint || DefaultEndpoint("") != defaultGRPCEndpoint {
+		t.Fatalf("unexpected default endpoints")
+	}
+}
+
+func TestNewMetricExporterProtocols(t *testing.T) {
+	for _, protocol := range []string{ProtocolGRPC, ProtocolHTTP} {
+		exp, err := newMetricExporter(context.Background(), normalizeConfig(Config{Protocol: protocol, Endpoint: DefaultEndpoint(protocol), Insecure: true}))
+		if err != nil {
+			t.Fatalf("create %s exporter: %v", protocol, err)
+		}
+		_ = exp.Shutdown(context.Background())
+	}
+	if _, err := newMetricExporter(context.Background(), normalizeConfig(Config{Protocol: "udp"})); err == nil {
+		t.Fatalf("expected unsupported protocol error")
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("Authorization=Bearer%20abc, X-Tenant = team-a,,")
+	if err != nil {
+		t.Fatalf("parse headers: %v", err)
+	}
+	if len(headers) != 2 || headers["Authorization"] != "Bearer abc" || headers["X-Tenant"] != "team-a" {
+		t.Fatalf("unexpected headers: %+v", headers)
+	}
+	if got := RedactHeaders(headers); got != "Authorization=<redacted>,X-Tenant=<redacted>" {
+		t.Fatalf("unexpected redacted headers: %q", got)
+	}
+	if _, err := ParseHeaders("novalue"); err == nil {
+		t.Fatalf("expected error for header without value")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	if tlsCfg, err := tlsConfig(Config{}); err != nil || tlsCfg != nil {
+		t.Fatalf("expected no TLS config without files, got %v, %v", tlsCfg, err)
+	}
+
+	tlsCfg, err := tlsConfig(Config{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("tls config: %v", err)
+	}
+	if tlsCfg.RootCAs == nil || len(tlsCfg.Certificates) != 1 {
+		t.Fatalf("expected CA pool and client certificate, got %+v", tlsCfg)
+	}
+
+	if _, err := tlsConfig(Config{CertFile: certFile}); err == nil {
+		t.Fatalf("expected error for certificate without key")
+	}
+	if _, err := tlsConfig(Config{CAFile: certFile, Insecure: true}); err == nil {
+		t.Fatalf("expected error for TLS files in insecure mode")
+	}
+	if _, err := tlsConfig(Config{CAFile: keyFile}); err == nil {
+		t.Fatalf("expected error for CA file without certificates")
+	}
+}
+
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTemporalitySelector(t *testing.T) {
+	tests := []struct {
+		preference string
+		kind       sdkmetric.InstrumentKind
+		want       metricdata.Temporality
+	}{
+		{TemporalityCumulative, sdkmetric.InstrumentKindCounter, metricdata.CumulativeTemporality},
+		{TemporalityDelta, sdkmetric.InstrumentKindObservableCounter, metricdata.DeltaTemporality},
+		{TemporalityDelta, sdkmetric.InstrumentKindUpDownCounter, metricdata.CumulativeTemporality},
+		{TemporalityLowMemory, sdkmetric.InstrumentKindCounter, metricdata.DeltaTemporality},
+		{TemporalityLowMemory, sdkmetric.InstrumentKindObservableCounter, metricdata.CumulativeTemporality},
+	}
+	for _, tt := range tests {
+		selector, err := temporalitySelector(tt.preference)
+		if err != nil {
+			t.Fatalf("selector %s: %v", tt.preference, err)
+		}
+		if got := selector(tt.kind); got != tt.want {
+			t.Fatalf("%s/%s: expected %s, got %s", tt.preference, tt.kind, tt.want, got)
+		}
+	}
+	if _, err := temporalitySelector("hourly"); err == nil {
+		t.Fatalf("expected error for unknown temporality")
+	}
+}
+
+func TestAggregationOverrides(t *testing.T) {
+	overrides, err := ParseAggregations("Histogram=exponential, observablegauge=drop")
+	if err != nil {
+		t.Fatalf("parse aggregations: %v", err)
+	}
+	selector, err := aggregationSelector(overrides)
+	if err != nil {
+		t.Fatalf("aggregation selector: %v", err)
+	}
+	if _, ok := selector(sdkmetric.InstrumentKindHistogram).(sdkmetric.AggregationBase2ExponentialHistogram); !ok {
+		t.Fatalf("expected exponential histogram aggregation")
+	}
+	if _, ok := selector(sdkmetric.InstrumentKindObservableGauge).(sdkmetric.AggregationDrop); !ok {
+		t.Fatalf("expected drop aggregation")
+	}
+	if _, ok := selector(sdkmetric.InstrumentKindCounter).(sdkmetric.AggregationSum); !ok {
+		t.Fatalf("expected default sum aggregation for counters")
+	}
+	for _, raw := range []string{"histogram", "timer=sum", "counter=median"} {
+		if _, err := ParseAggregations(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
 	}
 }
 
@@ -101,22 +276,275 @@ func TestBuildObservationsMapping(t *testing.T) {
 	}
 }
 
+func TestMetricDefsMatchPrometheusCollector(t *testing.T) {
+	manager, err := snapshot.NewManager(snapshot.NewService(&testFetcher{}, snapshot.Config{Target: "org", CacheTTL: time.Minute}))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	descs := make(chan *prometheus.Desc, 64)
+	exporter.NewCollector(manager, time.Second).Describe(descs)
+	close(descs)
+
+	fqNameAndHelp := regexp.MustCompile(`fqName: "([^"]+)", help: "([^"]*)"`)
+	want := make(map[string]string)
+	for desc := range descs {
+		match := fqNameAndHelp.FindStringSubmatch(desc.String())
+		want[match[1]] = match[2]
+	}
+	got := make(map[string]string, len(metricDefs))
+	for _, def := range metricDefs {
+		got[def.name] = def.help
+		if def.unit == "" {
+			t.Errorf("metric %s has no unit", def.name)
+		}
+	}
+	for name, help := range want {
+		otelHelp, ok := got[name]
+		if !ok {
+			t.Errorf("metric %s is exposed to Prometheus but not pushed over OTEL", name)
+			continue
+		}
+		if otelHelp != help {
+			t.Errorf("metric %s description %q does not match Prometheus help %q", name, otelHelp, help)
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("metric %s is pushed over OTEL but not exposed to Prometheus", name)
+		}
+	}
+}
+
+func TestBuildHealthObservations(t *testing.T) {
+	now := time.Now()
+	obs := buildHealthObservations("org-1",
+		map[string]float64{snapshot.CheckNegativeQuantity: 2},
+		snapshot.Footprint{Bytes: 1024, Dropped: []string{"pool_usage"}},
+		snapshot.BackoffState{ConsecutiveFailures: 3, Until: now.Add(30 * time.Second)},
+		now,
+	)
+
+	values := make(map[string]float64)
+	for _, o := range obs {
+		attrs := attrMap(o.attrs)
+		if attrs["org_name"] != "org-1" {
+			t.Fatalf("observation %s missing org_name attribute", o.name)
+		}
+		key := o.name
+		if check, ok := attrs["check"]; ok {
+			key += "/" + check
+		}
+		values[key] += o.value
+	}
+	if values[metricValidationFailures+"/"+snapshot.CheckNegativeQuantity] != 2 ||
+		values[metricSnapshotBytes] != 1024 ||
+		values[metricSnapshotTruncated] != 1 ||
+		values[metricConsecutiveFailures] != 3 ||
+		values[metricBackoffRemaining] != 30 {
+		t.Fatalf("unexpected health observations: %+v", values)
+	}
+}
+
+func TestBuildActivityObservations(t *testing.T) {
+	obs := buildActivityObservations("org-1",
+		snapshot.LeaseChurn{Added: 4, Removed: 1},
+		[]cls.APIRequestCount{{Operation: "list leases", Code: "200", Count: 3}, {Operation: "list leases", Code: "error", Count: 1}},
+	)
+
+	values := make(map[string]float64)
+	for _, o := range obs {
+		attrs := attrMap(o.attrs)
+		if attrs["org_name"] != "org-1" {
+			t.Fatalf("observation %s missing org_name attribute", o.name)
+		}
+		values[o.name+"/"+attrs["direction"]+attrs["code"]] = o.value
+	}
+	if values[metricLeaseChanges+"/added"] != 4 ||
+		values[metricLeaseChanges+"/removed"] != 1 ||
+		values[metricAPIRequests+"/200"] != 3 ||
+		values[metricAPIRequests+"/error"] != 1 {
+		t.Fatalf("unexpected activity observations: %+v", values)
+	}
+}
+
 func TestNewMetricsPusherValidation(t *testing.T) {
-	svc := snapshot.NewService(&testFetcher{}, time.Minute)
+	manager, err := snapshot.NewManager(snapshot.NewService(&testFetcher{}, snapshot.Config{Target: "org", CacheTTL: time.Minute}))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
 
 	if _, err := NewMetricsPusher(context.Background(), Config{
 		ServiceName: "svc",
-	}, "org", svc); err == nil {
+	}, manager); err == nil {
 		t.Fatalf("expected error for missing endpoint")
 	}
 
 	if _, err := NewMetricsPusher(context.Background(), Config{
 		Endpoint: "127.0.0.1:4317",
-	}, "org", svc); err == nil {
+	}, manager); err == nil {
 		t.Fatalf("expected error for missing service name")
 	}
 }
 
+func TestNewTracerProvider(t *testing.T) {
+	if _, err := NewTracerProvider(context.Background(), Config{ServiceName: "svc"}); err == nil {
+		t.Fatalf("expected error for missing endpoint")
+	}
+	if _, err := NewTracerProvider(context.Background(), Config{Endpoint: "127.0.0.1:4317", ServiceName: "svc", Protocol: "udp"}); err == nil {
+		t.Fatalf("expected error for unsupported protocol")
+	}
+
+	for _, protocol := range []string{ProtocolGRPC, ProtocolHTTP} {
+		tp, err := NewTracerProvider(context.Background(), Config{
+			Protocol:    protocol,
+			Endpoint:    DefaultEndpoint(protocol),
+			ServiceName: "svc",
+			Insecure:    true,
+		})
+		if err != nil {
+			t.Fatalf("%s tracer provider: %v", protocol, err)
+		}
+		if err := tp.Shutdown(context.Background()); err != nil {
+			t.Fatalf("%s shutdown: %v", protocol, err)
+		}
+	}
+}
+
+type recordingProcessor struct {
+	records []sdklog.Record
+}
+
+func (p *recordingProcessor) OnEmit(_ context.Context, record *sdklog.Record) error {
+	p.records = append(p.records, record.Clone())
+	return nil
+}
+
+func (p *recordingProcessor) Shutdown(context.Context) error   { return nil }
+func (p *recordingProcessor) ForceFlush(context.Context) error { return nil }
+
+func TestErrorLoggerRefreshFailed(t *testing.T) {
+	processor := &recordingProcessor{}
+	logger := NewErrorLogger(sdklog.NewLoggerProvider(sdklog.WithProcessor(processor)))
+
+	apiErr := &cls.APIError{Endpoint: "https://cls.example/v1/org/org-1/virtual-groups", StatusCode: 503, RequestID: "req-1"}
+	logger.RefreshFailed(context.Background(), "org-1", fmt.Errorf("list virtual groups: %w", apiErr))
+
+	if len(processor.records) != 1 {
+		t.Fatalf("expected one record, got %d", len(processor.records))
+	}
+	record := processor.records[0]
+	if record.EventName() != refreshFailedEvent || record.Severity() != otellog.SeverityError {
+		t.Fatalf("unexpected record event=%q severity=%v", record.EventName(), record.Severity())
+	}
+	if !strings.Contains(record.Body().AsString(), "status 503") {
+		t.Fatalf("unexpected body %q", record.Body().AsString())
+	}
+	attrs := make(map[string]string)
+	record.WalkAttributes(func(kv otellog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value.String()
+		return true
+	})
+	want := map[string]string{
+		"org_name":                  "org-1",
+		"url.full":                  apiErr.Endpoint,
+		"http.response.status_code": "503",
+		"cls.request_id":            "req-1",
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Fatalf("attribute %s=%q, want %q (all: %v)", key, attrs[key], value, attrs)
+		}
+	}
+}
+
+func TestNewResourceDetectors(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "cloud.region=eu-west-1,service.name=ignored")
+	t.Setenv("K8S_POD_NAME", "exporter-0")
+	t.Setenv("K8S_NODE_NAME", "node-a")
+	t.Setenv("K8S_NAMESPACE_NAME", "")
+	namespaceFile := filepath.Join(t.TempDir(), "namespace")
+	if err := os.WriteFile(namespaceFile, []byte("licensing\n"), 0o600); err != nil {
+		t.Fatalf("write namespace file: %v", err)
+	}
+
+	res, err := newResource(context.Background(), Config{
+		ServiceName:       "svc",
+		ServiceInstanceID: "id-1",
+		ResourceDetectors: []string{DetectorEnv, DetectorHost},
+	})
+	if err != nil {
+		t.Fatalf("new resource: %v", err)
+	}
+	attrs := attrMap(res.Attributes())
+	if attrs["service.name"] != "svc" || attrs["service.instance.id"] != "id-1" {
+		t.Fatalf("configured service attributes must win: %v", attrs)
+	}
+	if attrs["cloud.region"] != "eu-west-1" || attrs["host.name"] == "" {
+		t.Fatalf("expected env and host attributes: %v", attrs)
+	}
+	if _, ok := attrs["k8s.pod.name"]; ok {
+		t.Fatalf("k8s detector ran without being selected: %v", attrs)
+	}
+
+	k8s, err := kubernetesDetector{namespaceFile: namespaceFile}.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("k8s detect: %v", err)
+	}
+	attrs = attrMap(k8s.Attributes())
+	if attrs["k8s.pod.name"] != "exporter-0" || attrs["k8s.namespace.name"] != "licensing" || attrs["k8s.node.name"] != "node-a" {
+		t.Fatalf("unexpected k8s attributes: %v", attrs)
+	}
+
+	if _, err := newResource(context.Background(), Config{ServiceName: "svc", ResourceDetectors: []string{"ec3"}}); err == nil {
+		t.Fatalf("expected error for unknown detector")
+	}
+}
+
+func TestResourceAttributes(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=platform,region=us,deployment.environment=dev")
+	extra, err := ParseResourceAttributes("team=infra, env=prod,owner=a%20b,service.namespace=other")
+	if err != nil {
+		t.Fatalf("parse resource attributes: %v", err)
+	}
+	if _, err := ParseResourceAttributes("team"); err == nil {
+		t.Fatalf("expected error for missing value")
+	}
+
+	res, err := newResource(context.Background(), Config{
+		ServiceName:        "svc",
+		ServiceNamespace:   "licensing",
+		DeploymentEnv:      "production",
+		ResourceDetectors:  []string{DetectorEnv},
+		ResourceAttributes: extra,
+	})
+	if err != nil {
+		t.Fatalf("new resource: %v", err)
+	}
+	attrs := attrMap(res.Attributes())
+	want := map[string]string{"team": "infra", "env": "prod", "owner": "a b", "region": "us", "service.name": "svc",
+		"service.namespace": "licensing", "deployment.environment": "production"}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Fatalf("attribute %s=%q, want %q (all: %v)", key, attrs[key], value, attrs)
+		}
+	}
+}
+
+func TestRetrySettings(t *testing.T) {
+	got := RetryConfig{}.settings()
+	want := retrySettings{Enabled: true, InitialInterval: 5 * time.Second, MaxInterval: 30 * time.Second, MaxElapsedTime: time.Minute}
+	if got != want {
+		t.Fatalf("default retry settings %+v, want %+v", got, want)
+	}
+
+	got = RetryConfig{Disabled: true, InitialInterval: 10 * time.Second, MaxInterval: time.Second, MaxElapsedTime: 5 * time.Minute}.settings()
+	want = retrySettings{Enabled: false, InitialInterval: 10 * time.Second, MaxInterval: 10 * time.Second, MaxElapsedTime: 5 * time.Minute}
+	if got != want {
+		t.Fatalf("retry settings %+v, want %+v", got, want)
+	}
+}
+
 func attrMap(attrs []attribute.KeyValue) map[string]string {
 	out := make(map[string]string, len(attrs))
 	for _, kv := range attrs {
@@ -125,6 +553,319 @@ func attrMap(attrs []attribute.KeyValue) map[string]string {
 	return out
 }
 
+type stubExporter struct {
+	sdkmetric.Exporter
+	err error
+}
+
+func (e *stubExporter) Export(context.Context, *metricdata.ResourceMetrics) error {
+	return e.err
+}
+
+func TestLoggingExporterCountsExports(t *testing.T) {
+	stub := &stubExporter{err: errors.New("collector unavailable")}
+	stats := newExportStats([]string{"collector:4317"})
+	exporter := &loggingExporter{endpoint: "collector:4317", exporter: stub, stats: stats}
+	rm := &metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{
+		Metrics: []metricdata.Metrics{
+			{Name: metricUp, Data: metricdata.Gauge[float64]{DataPoints: make([]metricdata.DataPoint[float64], 2)}},
+			{Name: metricValidationFailures, Data: metricdata.Sum[float64]{DataPoints: make([]metricdata.DataPoint[float64], 3)}},
+		},
+	}}}
+
+	if err := exporter.Export(context.Background(), rm); err == nil {
+		t.Fatalf("expected export error")
+	}
+	stub.err = nil
+	if err := exporter.Export(context.Background(), rm); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	if got := testutil.ToFloat64(stats.exports.WithLabelValues("collector:4317", exportResultFailure)); got != 1 {
+		t.Fatalf("failed exports = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(stats.exports.WithLabelValues("collector:4317", exportResultSuccess)); got != 1 {
+		t.Fatalf("successful exports = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(stats.datapoints.WithLabelValues("collector:4317")); got != 5 {
+		t.Fatalf("exported datapoints = %v, want 5", got)
+	}
+	if got := testutil.ToFloat64(stats.up.WithLabelValues("collector:4317")); got != 1 {
+		t.Fatalf("endpoint up = %v, want 1", got)
+	}
+}
+
+type countingExporter struct {
+	stubExporter
+	calls int
+}
+
+func (e *countingExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	e.calls++
+	return e.stubExporter.Export(ctx, rm)
+}
+
+func TestMultiExporterModes(t *testing.T) {
+	if _, err := newMultiExporter("roundrobin", []sdkmetric.Exporter{&stubExporter{}}); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+
+	primary := &countingExporter{stubExporter: stubExporter{err: errors.New("unreachable")}}
+	secondary := &countingExporter{}
+	failover, err := newMultiExporter(EndpointModeFailover, []sdkmetric.Exporter{primary, secondary})
+	if err != nil {
+		t.Fatalf("failover exporter: %v", err)
+	}
+	if err := failover.Export(context.Background(), &metricdata.ResourceMetrics{}); err != nil {
+		t.Fatalf("failover export: %v", err)
+	}
+	primary.err = nil
+	if err := failover.Export(context.Background(), &metricdata.ResourceMetrics{}); err != nil {
+		t.Fatalf("failover export: %v", err)
+	}
+	if primary.calls != 2 || secondary.calls != 1 {
+		t.Fatalf("failover calls primary=%d secondary=%d, want 2 and 1", primary.calls, secondary.calls)
+	}
+
+	first := &countingExporter{}
+	second := &countingExporter{stubExporter: stubExporter{err: errors.New("unreachable")}}
+	fanout, err := newMultiExporter(EndpointModeFanout, []sdkmetric.Exporter{first, second})
+	if err != nil {
+		t.Fatalf("fanout exporter: %v", err)
+	}
+	if err := fanout.Export(context.Background(), &metricdata.ResourceMetrics{}); err == nil {
+		t.Fatalf("expected fan-out error when one endpoint fails")
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Fatalf("fan-out calls first=%d second=%d, want 1 each", first.calls, second.calls)
+	}
+}
+
+func TestLeaseInstrument(t *testing.T) {
+	svc := snapshot.NewService(snapshotFetcher{&cls.Snapshot{
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{{ServerID: "srv-1", ActiveLeases: 3}},
+	}}, snapshot.Config{Target: "org", CacheTTL: time.Minute})
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	for instrument, wantSum := range map[string]bool{LeaseInstrumentGauge: false, LeaseInstrumentUpDownCounter: true} {
+		reader := sdkmetric.NewManualReader()
+		p := &MetricsPusher{cfg: normalizeConfig(Config{LeaseInstrument: instrument}), manager: manager}
+		if err := p.registerMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")); err != nil {
+			t.Fatalf("register metrics: %v", err)
+		}
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			t.Fatalf("collect: %v", err)
+		}
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != metricServerFeatureActive {
+				continue
+			}
+			sum, isSum := m.Data.(metricdata.Sum[float64])
+			if isSum != wantSum || (isSum && sum.IsMonotonic) {
+				t.Fatalf("%s: active leases pushed as %T", instrument, m.Data)
+			}
+		}
+	}
+}
+
+type snapshotFetcher struct {
+	snap *cls.Snapshot
+}
+
+func (f snapshotFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	snap := *f.snap
+	snap.CollectedAt = time.Now().UTC()
+	return &snap, nil
+}
+
+func TestOTELMetricNames(t *testing.T) {
+	valid := regexp.MustCompile(`^nvidia\.cls(\.[a-z_]+)+$`)
+	seen := make(map[string]bool)
+	for _, name := range append([]string{metricRefreshDuration}, metricNames()...) {
+		otelName := instrumentName(MetricNamesOTEL, name)
+		if !valid.MatchString(otelName) {
+			t.Errorf("metric %s has invalid OTEL name %q", name, otelName)
+		}
+		if seen[otelName] {
+			t.Errorf("OTEL name %q used twice", otelName)
+		}
+		seen[otelName] = true
+		if instrumentName(MetricNamesPrometheus, name) != name {
+			t.Errorf("prometheus mode renamed %s", name)
+		}
+	}
+}
+
+func metricNames() []string {
+	names := make([]string, 0, len(metricDefs))
+	for _, def := range metricDefs {
+		names = append(names, def.name)
+	}
+	return names
+}
+
+func TestBatchingExporterSplitsDataPoints(t *testing.T) {
+	gauge := func(name string, n int) metricdata.Metrics {
+		points := make([]metricdata.DataPoint[float64], n)
+		for i := range points {
+			points[i].Value = float64(i)
+		}
+		return metricdata.Metrics{Name: name, Data: metricdata.Gauge[float64]{DataPoints: points}}
+	}
+	rm := &metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{
+		Metrics: []metricdata.Metrics{gauge("a", 3), gauge("b", 5), gauge("c", 1)},
+	}}}
+
+	var sizes []int
+	recorder := &recordingExporter{export: func(rm *metricdata.ResourceMetrics) {
+		sizes = append(sizes, countDataPoints(rm))
+	}}
+	if err := newBatchingExporter(recorder, 4).Export(context.Background(), rm); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if fmt.Sprint(sizes) != "[4 4 1]" {
+		t.Fatalf("batch sizes %v, want [4 4 1]", sizes)
+	}
+
+	sizes = nil
+	if err := newBatchingExporter(recorder, 0).Export(context.Background(), rm); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if fmt.Sprint(sizes) != "[9]" {
+		t.Fatalf("unbatched sizes %v, want [9]", sizes)
+	}
+}
+
+type recordingExporter struct {
+	stubExporter
+	export func(*metricdata.ResourceMetrics)
+}
+
+func (e *recordingExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	e.export(rm)
+	return nil
+}
+
+func TestDurationViews(t *testing.T) {
+	if _, err := metricViews(nil, "linear"); err == nil {
+		t.Fatalf("expected error for unknown duration histogram")
+	}
+
+	for mode, exponential := range map[string]bool{DurationHistogramExponential: true, DurationHistogramExplicit: false} {
+		views, err := metricViews(nil, mode)
+		if err != nil {
+			t.Fatalf("%s views: %v", mode, err)
+		}
+		reader := sdkmetric.NewManualReader()
+		provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithView(views...))
+		histogram, err := provider.Meter("test").Float64Histogram(metricRefreshDuration, metric.WithUnit("s"))
+		if err != nil {
+			t.Fatalf("create histogram: %v", err)
+		}
+		histogram.Record(context.Background(), 0.25)
+
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			t.Fatalf("collect: %v", err)
+		}
+		data := rm.ScopeMetrics[0].Metrics[0].Data
+		_, isExponential := data.(metricdata.ExponentialHistogram[float64])
+		if isExponential != exponential {
+			t.Fatalf("%s: got aggregation %T", mode, data)
+		}
+	}
+}
+
+func TestMetricViews(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "views.json")
+	if err := os.WriteFile(path, []byte(`[
+		{"instrument": "nvidia_cls_up", "name": "acme.license.up", "description": "CLS reachable"},
+		{"instrument": "nvidia_cls_*", "name": "acme.license.*", "drop_attributes": ["product_name"]},
+		{"instrument": "nvidia_cls_refresh_duration_seconds", "aggregation": "drop"}
+	]`), 0o600); err != nil {
+		t.Fatalf("write views: %v", err)
+	}
+	configs, err := LoadViews(path)
+	if err != nil {
+		t.Fatalf("load views: %v", err)
+	}
+	views, err := metricViews(configs, DurationHistogramExponential)
+	if err != nil {
+		t.Fatalf("metric views: %v", err)
+	}
+	view := views[0]
+
+	stream, ok := view(sdkmetric.Instrument{Name: metricUp, Description: "up", Kind: sdkmetric.InstrumentKindObservableGauge})
+	if !ok || stream.Name != "acme.license.up" || stream.Description != "CLS reachable" || stream.AttributeFilter != nil {
+		t.Fatalf("unexpected stream for exact match: %+v", stream)
+	}
+
+	stream, ok = view(sdkmetric.Instrument{Name: metricServerFeatureActive, Kind: sdkmetric.InstrumentKindObservableGauge})
+	if !ok || stream.Name != "acme.license.license_server_feature_active_leases" || stream.AttributeFilter == nil {
+		t.Fatalf("unexpected stream for prefix match: %+v", stream)
+	}
+	if stream.AttributeFilter(attribute.String("product_name", "p")) || !stream.AttributeFilter(attribute.String("org_name", "o")) {
+		t.Fatalf("attribute filter must drop only product_name")
+	}
+
+	stream, ok = view(sdkmetric.Instrument{Name: metricRefreshDuration, Unit: "s", Kind: sdkmetric.InstrumentKindHistogram})
+	if !ok || stream.Name != "acme.license.refresh_duration_seconds" {
+		t.Fatalf("first matching view must win: %+v", stream)
+	}
+	if _, isExponential := stream.Aggregation.(sdkmetric.AggregationBase2ExponentialHistogram); !isExponential {
+		t.Fatalf("duration histogram lost its exponential aggregation: %T", stream.Aggregation)
+	}
+
+	if _, ok := view(sdkmetric.Instrument{Name: "other", Kind: sdkmetric.InstrumentKindObservableGauge}); ok {
+		t.Fatalf("unrelated instrument must not match")
+	}
+
+	if _, err := metricViews([]ViewConfig{{Instrument: metricUp, Aggregation: "median"}}, ""); err == nil {
+		t.Fatalf("expected error for unknown aggregation")
+	}
+	if _, err := metricViews([]ViewConfig{{Name: "x"}}, ""); err == nil {
+		t.Fatalf("expected error for missing instrument")
+	}
+}
+
+func TestDropAttributes(t *testing.T) {
+	if _, err := dropSet([]string{"org_name"}); err == nil {
+		t.Fatalf("expected error when dropping org_name")
+	}
+	drop, err := dropSet([]string{" virtual_group_name", "", "product_name"})
+	if err != nil {
+		t.Fatalf("drop set: %v", err)
+	}
+
+	org := attribute.String("org_name", "org")
+	observations := []observation{
+		{name: metricUp, value: 1, attrs: []attribute.KeyValue{org}},
+		{name: "quantity", value: 10, attrs: []attribute.KeyValue{org, attribute.String("virtual_group_name", "a"), attribute.String("feature_name", "f")}},
+		{name: "quantity", value: 5, attrs: []attribute.KeyValue{org, attribute.String("virtual_group_name", "b"), attribute.String("feature_name", "f")}},
+		{name: "quantity", value: 2, attrs: []attribute.KeyValue{org, attribute.String("virtual_group_name", "a"), attribute.String("feature_name", "g")}},
+	}
+	got := dropAttributes(observations, drop)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 series, got %+v", got)
+	}
+	if got[1].value != 15 || attrMap(got[1].attrs)["feature_name"] != "f" {
+		t.Fatalf("expected merged series f=15, got %+v", got[1])
+	}
+	if _, ok := attrMap(got[2].attrs)["virtual_group_name"]; ok || got[2].value != 2 {
+		t.Fatalf("unexpected series %+v", got[2])
+	}
+	if same := dropAttributes(observations, nil); len(same) != len(observations) {
+		t.Fatalf("nil drop set must keep all observations")
+	}
+}
+
 type testFetcher struct{}
 
 func (t *testFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
@@ -0,0 +1,93 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	// EndpointModeFanout pushes every export to all endpoints.
+	EndpointModeFanout = "fanout"
+	// EndpointModeFailover pushes to the first endpoint that accepts the
+	// export, trying them in order, so traffic returns to the primary as
+	// soon as it recovers.
+	EndpointModeFailover = "failover"
+)
+
+// multiExporter sends metric exports to several OTLP endpoints. Temporality
+// and aggregation come from the first endpoint; all endpoints share cfg.
+type multiExporter struct {
+	mode      string
+	exporters []sdkmetric.Exporter
+}
+
+func newMultiExporter(mode string, exporters []sdkmetric.Exporter) (sdkmetric.Exporter, error) {
+	switch mode {
+	case EndpointModeFanout, EndpointModeFailover:
+	default:
+		return nil, fmt.Errorf("unsupported otel endpoint mode %q: use %s or %s", mode, EndpointModeFanout, EndpointModeFailover)
+	}
+	if len(exporters) == 1 {
+		return exporters[0], nil
+	}
+	return &multiExporter{mode: mode, exporters: exporters}, nil
+}
+
+func (m *multiExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return m.exporters[0].Temporality(kind)
+}
+
+func (m *multiExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return m.exporters[0].Aggregation(kind)
+}
+
+func (m *multiExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if m.mode == EndpointModeFailover {
+		var errs []error
+		for _, exporter := range m.exporters {
+			err := exporter.Export(ctx, rm)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+
+	return m.each(func(exporter sdkmetric.Exporter) error {
+		return exporter.Export(ctx, rm)
+	})
+}
+
+func (m *multiExporter) ForceFlush(ctx context.Context) error {
+	return m.each(func(exporter sdkmetric.Exporter) error {
+		return exporter.ForceFlush(ctx)
+	})
+}
+
+func (m *multiExporter) Shutdown(ctx context.Context) error {
+	return m.each(func(exporter sdkmetric.Exporter) error {
+		return exporter.Shutdown(ctx)
+	})
+}
+
+// each calls fn for every exporter concurrently, so a slow endpoint does not
+// delay the others.
+func (m *multiExporter) each(fn func(sdkmetric.Exporter) error) error {
+	errs := make([]error, len(m.exporters))
+	var wg sync.WaitGroup
+	for i, exporter := range m.exporters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(exporter)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package otel
+
+const (
+	// MetricNamesPrometheus keeps the nvidia_cls_* names of the Prometheus
+	// endpoint; MetricNamesOTEL uses dot-separated names following the OTEL
+	// naming guidelines, without unit or _total suffixes.
+	MetricNamesPrometheus = "prometheus"
+	MetricNamesOTEL       = "otel"
+)
+
+// otelMetricNames maps every pushed metric to its MetricNamesOTEL name.
+var otelMetricNames = map[string]string{
+	metricUp:                  "nvidia.cls.up",
+	metricScrapeDuration:      "nvidia.cls.scrape.duration",
+	metricScrapeTimestamp:     "nvidia.cls.scrape.timestamp",
+	metricEntitlementTotal:    "nvidia.cls.entitlement.limit",
+	metricServerInfo:          "nvidia.cls.license_server.info",
+	metricServerFeatureTotal:  "nvidia.cls.licenses.limit",
+	metricServerFeatureActive: "nvidia.cls.licenses.in_use",
+	metricPhaseDuration:       "nvidia.cls.scrape.phase.duration",
+	metricPhaseItems:          "nvidia.cls.scrape.phase.items",
+	metricLastError:           "nvidia.cls.last_error.info",
+	metricValidationFailures:  "nvidia.cls.exporter.snapshot.validation_failures",
+	metricSnapshotBytes:       "nvidia.cls.exporter.snapshot.size",
+	metricSnapshotElements:    "nvidia.cls.exporter.snapshot.elements",
+	metricSnapshotTruncated:   "nvidia.cls.exporter.snapshot.truncated",
+	metricConsecutiveFailures: "nvidia.cls.exporter.refresh.consecutive_failures",
+	metricBackoffRemaining:    "nvidia.cls.exporter.refresh.backoff_remaining",
+	metricLeaseChanges:        "nvidia.cls.lease.changes",
+	metricAPIRequests:         "nvidia.cls.exporter.api.requests",
+	metricRefreshDuration:     "nvidia.cls.refresh.duration",
+}
+
+// instrumentName returns the name name is pushed under in the given mode.
+func instrumentName(mode, name string) string {
+	if mode == MetricNamesOTEL {
+		return otelMetricNames[name]
+	}
+	return name
+}
@@ -0,0 +1,143 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	DetectorEnv       = "env"
+	DetectorHost      = "host"
+	DetectorOS        = "os"
+	DetectorProcess   = "process"
+	DetectorContainer = "container"
+	DetectorK8s       = "k8s"
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// DefaultResourceDetectors lists every supported detector.
+var DefaultResourceDetectors = []string{DetectorEnv, DetectorHost, DetectorOS, DetectorProcess, DetectorContainer, DetectorK8s}
+
+// newResource describes this exporter instance to the collector. Metrics,
+// traces and logs share it so all signals correlate. Detected attributes come
+// first, then OTEL_RESOURCE_ATTRIBUTES, then cfg.ResourceAttributes, then
+// the service and deployment attributes from cfg, which always win.
+func newResource(ctx context.Context, cfg Config) (*resource.Resource, error) {
+	opts, err := detectorOptions(cfg.ResourceDetectors)
+	if err != nil {
+		return nil, err
+	}
+	extra := make([]attribute.KeyValue, 0, len(cfg.ResourceAttributes))
+	for _, key := range sortedKeys(cfg.ResourceAttributes) {
+		extra = append(extra, attribute.String(key, cfg.ResourceAttributes[key]))
+	}
+	service := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		semconv.ServiceInstanceIDKey.String(cfg.ServiceInstanceID),
+	}
+	if ns := strings.TrimSpace(cfg.ServiceNamespace); ns != "" {
+		service = append(service, semconv.ServiceNamespaceKey.String(ns))
+	}
+	if env := strings.TrimSpace(cfg.DeploymentEnv); env != "" {
+		service = append(service, semconv.DeploymentEnvironmentKey.String(env))
+	}
+	opts = append(opts,
+		resource.WithAttributes(extra...),
+		resource.WithAttributes(service...),
+	)
+
+	res, err := resource.New(ctx, opts...)
+	if errors.Is(err, resource.ErrPartialResource) {
+		// Detectors that fail, e.g. container outside of a cgroup, are
+		// skipped; the remaining attributes are still usable.
+		slog.Warn("otel resource detection incomplete", "error", err)
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create otel resource: %w", err)
+	}
+	return res, nil
+}
+
+func detectorOptions(names []string) ([]resource.Option, error) {
+	var opts []resource.Option
+	var fromEnv bool
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case DetectorEnv:
+			fromEnv = true
+		case DetectorHost:
+			opts = append(opts, resource.WithHost())
+		case DetectorOS:
+			opts = append(opts, resource.WithOS())
+		case DetectorProcess:
+			opts = append(opts,
+				resource.WithProcessPID(),
+				resource.WithProcessExecutableName(),
+				resource.WithProcessRuntimeName(),
+				resource.WithProcessRuntimeVersion(),
+			)
+		case DetectorContainer:
+			opts = append(opts, resource.WithContainer())
+		case DetectorK8s:
+			opts = append(opts, resource.WithDetectors(kubernetesDetector{namespaceFile: serviceAccountNamespaceFile}))
+		default:
+			return nil, fmt.Errorf("unsupported otel resource detector %q: use %s", name, strings.Join(DefaultResourceDetectors, ", "))
+		}
+	}
+	if fromEnv {
+		// After the detectors so OTEL_RESOURCE_ATTRIBUTES can correct them.
+		opts = append(opts, resource.WithFromEnv())
+	}
+	return opts, nil
+}
+
+// kubernetesDetector reads pod attributes from the downward API environment
+// variables K8S_POD_NAME, K8S_POD_UID, K8S_NAMESPACE_NAME and K8S_NODE_NAME.
+// Inside a pod, the namespace falls back to the service account mount and
+// the pod name to the hostname.
+type kubernetesDetector struct {
+	namespaceFile string
+}
+
+func (d kubernetesDetector) Detect(context.Context) (*resource.Resource, error) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" && os.Getenv("K8S_POD_NAME") == "" {
+		return resource.Empty(), nil
+	}
+
+	podName := os.Getenv("K8S_POD_NAME")
+	if podName == "" {
+		podName, _ = os.Hostname()
+	}
+	namespace := os.Getenv("K8S_NAMESPACE_NAME")
+	if namespace == "" && d.namespaceFile != "" {
+		if raw, err := os.ReadFile(d.namespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(raw))
+		}
+	}
+
+	var attrs []attribute.KeyValue
+	if podName != "" {
+		attrs = append(attrs, semconv.K8SPodName(podName))
+	}
+	if uid := os.Getenv("K8S_POD_UID"); uid != "" {
+		attrs = append(attrs, semconv.K8SPodUID(uid))
+	}
+	if namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(namespace))
+	}
+	if node := os.Getenv("K8S_NODE_NAME"); node != "" {
+		attrs = append(attrs, semconv.K8SNodeName(node))
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
@@ -0,0 +1,54 @@
+package otel
+
+import "time"
+
+const (
+	defaultRetryInitialInterval = 5 * time.Second
+	defaultRetryMaxInterval     = 30 * time.Second
+	defaultRetryMaxElapsedTime  = time.Minute
+)
+
+// RetryConfig controls how OTLP exports are retried after transient
+// failures such as an unreachable collector. Zero durations use the exporter
+// defaults of 5s, 30s and 1m.
+type RetryConfig struct {
+	Disabled bool
+	// InitialInterval is the wait after the first failed attempt. It
+	// doubles per retry up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// MaxElapsedTime bounds the time spent on one batch, after which its
+	// data is dropped.
+	MaxElapsedTime time.Duration
+}
+
+// retrySettings has the layout of the RetryConfig types of the OTLP
+// exporters, so it converts to each of them.
+type retrySettings struct {
+	Enabled         bool
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+func (r RetryConfig) settings() retrySettings {
+	s := retrySettings{
+		Enabled:         !r.Disabled,
+		InitialInterval: r.InitialInterval,
+		MaxInterval:     r.MaxInterval,
+		MaxElapsedTime:  r.MaxElapsedTime,
+	}
+	if s.InitialInterval <= 0 {
+		s.InitialInterval = defaultRetryInitialInterval
+	}
+	if s.MaxInterval <= 0 {
+		s.MaxInterval = defaultRetryMaxInterval
+	}
+	if s.MaxInterval < s.InitialInterval {
+		s.MaxInterval = s.InitialInterval
+	}
+	if s.MaxElapsedTime <= 0 {
+		s.MaxElapsedTime = defaultRetryMaxElapsedTime
+	}
+	return s
+}
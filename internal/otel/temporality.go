@@ -0,0 +1,138 @@
+package otel
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+	TemporalityLowMemory  = "lowmemory"
+)
+
+// temporalitySelector maps a temporality preference to a selector, following
+// the OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE semantics.
+func temporalitySelector(preference string) (sdkmetric.TemporalitySelector, error) {
+	switch preference {
+	case "", TemporalityCumulative:
+		return sdkmetric.DefaultTemporalitySelector, nil
+	case TemporalityDelta:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter:
+				return metricdata.CumulativeTemporality
+			}
+			return metricdata.DeltaTemporality
+		}, nil
+	case TemporalityLowMemory:
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			}
+			return metricdata.CumulativeTemporality
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported otel temporality %q: use %s, %s or %s",
+			preference, TemporalityCumulative, TemporalityDelta, TemporalityLowMemory)
+	}
+}
+
+var instrumentKinds = map[string]sdkmetric.InstrumentKind{
+	"counter":                 sdkmetric.InstrumentKindCounter,
+	"updowncounter":           sdkmetric.InstrumentKindUpDownCounter,
+	"histogram":               sdkmetric.InstrumentKindHistogram,
+	"gauge":                   sdkmetric.InstrumentKindGauge,
+	"observablecounter":       sdkmetric.InstrumentKindObservableCounter,
+	"observableupdowncounter": sdkmetric.InstrumentKindObservableUpDownCounter,
+	"observablegauge":         sdkmetric.InstrumentKindObservableGauge,
+}
+
+var aggregations = map[string]sdkmetric.Aggregation{
+	"default":     sdkmetric.AggregationDefault{},
+	"drop":        sdkmetric.AggregationDrop{},
+	"sum":         sdkmetric.AggregationSum{},
+	"lastvalue":   sdkmetric.AggregationLastValue{},
+	"explicit":    sdkmetric.AggregationExplicitBucketHistogram{Boundaries: defaultHistogramBoundaries},
+	"exponential": sdkmetric.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 20},
+}
+
+// defaultHistogramBoundaries are the SDK's default explicit bucket bounds.
+var defaultHistogramBoundaries = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+// ParseAggregations parses comma-separated kind=aggregation overrides such as
+// "histogram=exponential,observablegauge=lastvalue".
+func ParseAggregations(raw string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kind, aggregation, ok := strings.Cut(pair, "=")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		aggregation = strings.ToLower(strings.TrimSpace(aggregation))
+		if !ok {
+			return nil, fmt.Errorf("invalid otel aggregation %q: expected kind=aggregation", strings.TrimSpace(pair))
+		}
+		if _, known := instrumentKinds[kind]; !known {
+			return nil, fmt.Errorf("unknown instrument kind %q: use one of %s", kind, strings.Join(sortedKeys(instrumentKinds), ", "))
+		}
+		if _, known := aggregations[aggregation]; !known {
+			return nil, fmt.Errorf("unknown aggregation %q: use one of %s", aggregation, strings.Join(sortedKeys(aggregations), ", "))
+		}
+		overrides[kind] = aggregation
+	}
+	return overrides, nil
+}
+
+// aggregationSelector applies overrides on top of the SDK defaults.
+func aggregationSelector(overrides map[string]string) (sdkmetric.AggregationSelector, error) {
+	byKind := make(map[sdkmetric.InstrumentKind]sdkmetric.Aggregation, len(overrides))
+	for kind, name := range overrides {
+		instrumentKind, ok := instrumentKinds[kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown instrument kind %q", kind)
+		}
+		aggregation, ok := aggregations[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown aggregation %q", name)
+		}
+		byKind[instrumentKind] = aggregation
+	}
+	return func(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+		if aggregation, ok := byKind[kind]; ok {
+			return aggregation
+		}
+		return sdkmetric.DefaultAggregationSelector(kind)
+	}, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+const (
+	DurationHistogramExponential = "exponential"
+	DurationHistogramExplicit    = "explicit"
+)
+
+// durationAggregation returns the aggregation for duration histograms, i.e.
+// histograms with unit "s", or nil to keep the exporter's aggregation.
+// Exponential buckets give backends such as Honeycomb high-resolution
+// latency data; explicit keeps any -otel-aggregation override.
+func durationAggregation(mode string, inst sdkmetric.Instrument) sdkmetric.Aggregation {
+	if mode == DurationHistogramExplicit || inst.Kind != sdkmetric.InstrumentKindHistogram || inst.Unit != "s" {
+		return nil
+	}
+	return aggregations["exponential"]
+}
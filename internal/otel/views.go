@@ -0,0 +1,110 @@
+package otel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// ViewConfig rewrites the stream of matching instruments before export.
+type ViewConfig struct {
+	// Instrument is the instrument name to match. A trailing * matches
+	// every name with that prefix.
+	Instrument string `json:"instrument"`
+	// Name renames the stream. With a prefix match, a * in Name is replaced
+	// by the rest of the matched name, e.g. "nvidia_cls_*" -> "gpu.license.*".
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	// DropAttributes removes attributes from the stream. Gauges that
+	// collapse keep one of the values; see Config.DropAttributes to sum.
+	DropAttributes []string `json:"drop_attributes,omitempty"`
+	// Aggregation is one of the ParseAggregations names.
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// LoadViews reads a JSON array of ViewConfig from path.
+func LoadViews(path string) ([]ViewConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read otel views file: %w", err)
+	}
+	var views []ViewConfig
+	if err := json.Unmarshal(raw, &views); err != nil {
+		return nil, fmt.Errorf("parse otel views file %s: %w", path, err)
+	}
+	return views, nil
+}
+
+// metricViews combines the configured views and the duration histogram mode
+// into a single view, so an instrument yields exactly one stream. The first
+// matching ViewConfig wins.
+func metricViews(configs []ViewConfig, durationMode string) ([]sdkmetric.View, error) {
+	switch durationMode {
+	case "", DurationHistogramExponential, DurationHistogramExplicit:
+	default:
+		return nil, fmt.Errorf("unsupported otel duration histogram %q: use %s or %s",
+			durationMode, DurationHistogramExponential, DurationHistogramExplicit)
+	}
+	for i, view := range configs {
+		if strings.TrimSpace(view.Instrument) == "" {
+			return nil, fmt.Errorf("otel view %d: instrument is required", i)
+		}
+		if view.Aggregation != "" {
+			if _, ok := aggregations[view.Aggregation]; !ok {
+				return nil, fmt.Errorf("otel view %d: unsupported aggregation %q: use %s", i, view.Aggregation, strings.Join(sortedKeys(aggregations), ", "))
+			}
+		}
+	}
+
+	return []sdkmetric.View{func(inst sdkmetric.Instrument) (sdkmetric.Stream, bool) {
+		stream := sdkmetric.Stream{Name: inst.Name, Description: inst.Description, Unit: inst.Unit}
+		matched := false
+		for _, view := range configs {
+			suffix, ok := matchInstrument(view.Instrument, inst.Name)
+			if !ok {
+				continue
+			}
+			matched = true
+			if view.Name != "" {
+				stream.Name = strings.Replace(view.Name, "*", suffix, 1)
+			}
+			if view.Description != "" {
+				stream.Description = view.Description
+			}
+			if len(view.DropAttributes) > 0 {
+				keys := make([]attribute.Key, 0, len(view.DropAttributes))
+				for _, name := range view.DropAttributes {
+					keys = append(keys, attribute.Key(name))
+				}
+				stream.AttributeFilter = attribute.NewDenyKeysFilter(keys...)
+			}
+			if view.Aggregation != "" {
+				stream.Aggregation = aggregations[view.Aggregation]
+			}
+			break
+		}
+		if stream.Aggregation == nil {
+			if aggregation := durationAggregation(durationMode, inst); aggregation != nil {
+				stream.Aggregation = aggregation
+				matched = true
+			}
+		}
+		return stream, matched
+	}}, nil
+}
+
+// matchInstrument reports whether name matches pattern and returns the part
+// of name covered by a trailing *.
+func matchInstrument(pattern, name string) (string, bool) {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		if strings.HasPrefix(name, prefix) {
+			return name[len(prefix):], true
+		}
+		return "", false
+	}
+	return "", pattern == name
+}
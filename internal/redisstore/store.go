@@ -0,0 +1,106 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	Address  string
+	Username string
+	Password string
+	DB       int
+}
+
+// unlockScript deletes a lock only while it still holds the caller's token,
+// so a holder whose lock expired cannot release the lock of the next one.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Store implements snapshot.Store on top of Redis.
+type Store struct {
+	client *redis.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func New(ctx context.Context, cfg Config) (*Store, error) {
+	if strings.TrimSpace(cfg.Address) == "" {
+		return nil, errors.New("redis address is required")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     strings.TrimSpace(cfg.Address),
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("ping redis %s: %w", cfg.Address, err)
+	}
+
+	return &Store{client: client, tokens: make(map[string]string)}, nil
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// TryLock sets key to a random token if nobody holds it. The token is kept
+// until Unlock, which only releases the lock while Redis still holds it.
+func (s *Store) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return false, fmt.Errorf("generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	locked, err := s.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !locked {
+		return false, err
+	}
+	s.mu.Lock()
+	s.tokens[key] = token
+	s.mu.Unlock()
+	return true, nil
+}
+
+func (s *Store) Unlock(ctx context.Context, key string) error {
+	s.mu.Lock()
+	token, ok := s.tokens[key]
+	delete(s.tokens, key)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return unlockScript.Run(ctx, s.client, []string{key}, token).Err()
+}
+
+func (s *Store) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,96 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestStore(t *testing.T, mr *miniredis.Miniredis) *Store {
+	t.Helper()
+	store, err := New(context.Background(), Config{Address: mr.Addr()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestStoreGetSet(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := newTestStore(t, mr)
+	ctx := context.Background()
+
+	if _, ok, err := store.Get(ctx, "snapshot"); err != nil || ok {
+		t.Fatalf("expected missing key, got ok=%v err=%v", ok, err)
+	}
+	if err := store.Set(ctx, "snapshot", []byte("data"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	value, ok, err := store.Get(ctx, "snapshot")
+	if err != nil || !ok || string(value) != "data" {
+		t.Fatalf("expected stored value, got %q ok=%v err=%v", value, ok, err)
+	}
+	if ttl := mr.TTL("snapshot"); ttl != time.Minute {
+		t.Fatalf("expected 1m TTL, got %s", ttl)
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	first := newTestStore(t, mr)
+	second := newTestStore(t, mr)
+	ctx := context.Background()
+
+	if locked, err := first.TryLock(ctx, "lock", time.Minute); err != nil || !locked {
+		t.Fatalf("expected first lock, got locked=%v err=%v", locked, err)
+	}
+	if locked, err := second.TryLock(ctx, "lock", time.Minute); err != nil || locked {
+		t.Fatalf("expected held lock, got locked=%v err=%v", locked, err)
+	}
+	if err := second.Unlock(ctx, "lock"); err != nil {
+		t.Fatalf("Unlock by non-holder: %v", err)
+	}
+	if !mr.Exists("lock") {
+		t.Fatal("non-holder released the lock")
+	}
+	if err := first.Unlock(ctx, "lock"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if mr.Exists("lock") {
+		t.Fatal("holder did not release the lock")
+	}
+	if locked, err := second.TryLock(ctx, "lock", time.Minute); err != nil || !locked {
+		t.Fatalf("expected released lock to be free, got locked=%v err=%v", locked, err)
+	}
+}
+
+func TestStoreUnlockAfterExpiryKeepsNextHolder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	first := newTestStore(t, mr)
+	second := newTestStore(t, mr)
+	ctx := context.Background()
+
+	if locked, err := first.TryLock(ctx, "lock", time.Second); err != nil || !locked {
+		t.Fatalf("expected first lock, got locked=%v err=%v", locked, err)
+	}
+	mr.FastForward(2 * time.Second)
+	if locked, err := second.TryLock(ctx, "lock", time.Minute); err != nil || !locked {
+		t.Fatalf("expected expired lock to be free, got locked=%v err=%v", locked, err)
+	}
+
+	if err := first.Unlock(ctx, "lock"); err != nil {
+		t.Fatalf("Unlock after expiry: %v", err)
+	}
+	if !mr.Exists("lock") {
+		t.Fatal("expired holder released the next holder's lock")
+	}
+	if err := second.Unlock(ctx, "lock"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if mr.Exists("lock") {
+		t.Fatal("holder did not release the lock")
+	}
+}
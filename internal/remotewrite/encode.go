@@ -0,0 +1,82 @@
+package remotewrite
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"nvidia-license-server-exporter/internal/exporter"
+)
+
+// Field numbers of the remote-write 1.0 protobuf messages
+// (prometheus/prompb): WriteRequest, TimeSeries, Label and Sample.
+const (
+	fieldWriteRequestTimeseries = 1
+	fieldTimeSeriesLabels       = 1
+	fieldTimeSeriesSamples      = 2
+	fieldLabelName              = 1
+	fieldLabelValue             = 2
+	fieldSampleValue            = 1
+	fieldSampleTimestamp        = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// toSeries turns samples into one series each, stamped with nowMs.
+func toSeries(samples []exporter.Sample, nowMs int64) []series {
+	out := make([]series, 0, len(samples))
+	for _, sample := range samples {
+		labels := make([]label, 0, len(sample.Labels)+1)
+		for _, l := range sample.Labels {
+			labels = append(labels, label{name: l.Name, value: l.Value})
+		}
+		labels = append(labels, label{name: "__name__", value: sample.Name})
+		out = append(out, series{labels: sortLabels(labels), value: sample.Value, timestamp: nowMs})
+	}
+	return out
+}
+
+// sortLabels sorts by name; receivers reject series with unsorted labels.
+func sortLabels(labels []label) []label {
+	slices.SortFunc(labels, func(a, b label) int { return cmp.Compare(a.name, b.name) })
+	return labels
+}
+
+// marshalWriteRequest encodes a prompb.WriteRequest by hand, which avoids
+// depending on the Prometheus server module for four small messages.
+func marshalWriteRequest(all []series) []byte {
+	var buf []byte
+	for _, s := range all {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, fieldLabelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, fieldLabelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, fieldTimeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, fieldSampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, fieldSampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, fieldTimeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		buf = protowire.AppendTag(buf, fieldWriteRequestTimeseries, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
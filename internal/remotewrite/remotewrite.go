@@ -0,0 +1,206 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	defaultInterval = 60 * time.Second
+	defaultTimeout  = 30 * time.Second
+	userAgent       = "nvidia-license-server-exporter/0.1"
+
+	// maxErrorBody bounds how much of a rejected request's response is kept
+	// in the error.
+	maxErrorBody = 512
+)
+
+type Config struct {
+	// URL is the receiver's remote-write endpoint, e.g.
+	// https://mimir.example.com/api/v1/push.
+	URL string
+	// BearerToken, or Username and Password, authenticate the pushes.
+	// Setting both is an error.
+	BearerToken string
+	Username    string
+	Password    string
+	// Headers are sent with every push, e.g. X-Scope-OrgID for Mimir
+	// tenants.
+	Headers    map[string]string
+	Interval   time.Duration
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// Pusher periodically pushes the core gauges of every target to a
+// remote-write receiver, with the names and labels of the collector.
+type Pusher struct {
+	cfg     Config
+	manager *snapshot.Manager
+	client  *http.Client
+
+	pushes  *prometheus.CounterVec
+	samples prometheus.Counter
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPusher(cfg Config, manager *snapshot.Manager) (*Pusher, error) {
+	cfg.URL = strings.TrimSpace(cfg.URL)
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("remote write url is required")
+	}
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("remote write url %q must start with http:// or https://", cfg.URL)
+	}
+	if cfg.BearerToken != "" && cfg.Username != "" {
+		return nil, fmt.Errorf("remote write bearer token and basic auth are mutually exclusive")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	p := &Pusher{
+		cfg:     cfg,
+		manager: manager,
+		client:  client,
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_remote_write_pushes_total",
+			Help: "Remote-write pushes by result.",
+		}, []string{"result"}),
+		samples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_remote_write_samples_total",
+			Help: "Samples in successful remote-write pushes.",
+		}),
+		done: make(chan struct{}),
+	}
+	p.pushes.WithLabelValues("success")
+	p.pushes.WithLabelValues("failure")
+	return p, nil
+}
+
+// Collector exposes the push counters for a Prometheus registry.
+func (p *Pusher) Collector() prometheus.Collector {
+	return collector{p}
+}
+
+type collector struct{ p *Pusher }
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	c.p.pushes.Describe(ch)
+	c.p.samples.Describe(ch)
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	c.p.pushes.Collect(ch)
+	c.p.samples.Collect(ch)
+}
+
+func (p *Pusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	go func() {
+		defer close(p.done)
+
+		p.pushOnce(ctx)
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.pushOnce(ctx)
+			}
+		}
+	}()
+}
+
+func (p *Pusher) Shutdown(ctx context.Context) error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pusher) pushOnce(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	n, err := p.Push(ctx)
+	if err != nil {
+		p.pushes.WithLabelValues("failure").Inc()
+		slog.ErrorContext(ctx, "remote write failed", "endpoint", p.cfg.URL, "error", err)
+		return
+	}
+	p.pushes.WithLabelValues("success").Inc()
+	p.samples.Add(float64(n))
+	slog.DebugContext(ctx, "remote write succeeded", "endpoint", p.cfg.URL, "samples", n)
+}
+
+// Push sends the cached gauges of every target once and returns the number
+// of samples sent. It never triggers a refresh.
+func (p *Pusher) Push(ctx context.Context) (int, error) {
+	var samples []exporter.Sample
+	for _, svc := range p.manager.Services() {
+		samples = append(samples, exporter.CoreSamples(svc)...)
+	}
+	all := toSeries(samples, time.Now().UnixMilli())
+	body := snappy.Encode(nil, marshalWriteRequest(all))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	switch {
+	case p.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+p.cfg.BearerToken)
+	case p.cfg.Username != "":
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return 0, fmt.Errorf("remote write to %s returned status %d: %s", p.cfg.URL, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return len(all), nil
+}
@@ -0,0 +1,157 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type fetcher struct{}
+
+func (fetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return &cls.Snapshot{
+		CollectedAt: time.Now().UTC(),
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{ServerName: "server-1", FeatureName: "vGPU", ActiveLeases: 7},
+		},
+	}, nil
+}
+
+func newManager(t *testing.T) *snapshot.Manager {
+	t.Helper()
+	svc := snapshot.NewService(fetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	return manager
+}
+
+func TestPushSendsWriteRequest(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			t.Errorf("missing basic auth")
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("snappy decode: %v", err)
+		}
+		got = decodeWriteRequest(t, raw)
+	}))
+	defer srv.Close()
+
+	pusher, err := NewPusher(Config{URL: srv.URL, Username: "user", Password: "secret", Headers: map[string]string{"X-Scope-OrgID": "tenant"}}, newManager(t))
+	if err != nil {
+		t.Fatalf("new pusher: %v", err)
+	}
+	n, err := pusher.Push(context.Background())
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if n != len(got) {
+		t.Fatalf("reported %d samples, pushed %d", n, len(got))
+	}
+
+	// Only the core gauges of the cached snapshot, with sorted labels; no
+	// runtime or self-metrics.
+	for _, want := range []string{
+		`__name__=nvidia_cls_up,org_name=org-1 1`,
+		`__name__=nvidia_cls_exporter_refresh_consecutive_failures,org_name=org-1 0`,
+		`__name__=nvidia_cls_license_server_feature_active_leases,feature_name=vGPU,license_type=unknown,org_name=org-1,product_name=unknown,server_id=unknown,server_name=server-1,virtual_group_id=0,virtual_group_name=unknown 7`,
+	} {
+		if !slices.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, strings.Join(got, "\n"))
+		}
+	}
+	for _, series := range got {
+		if strings.HasPrefix(series, "__name__=go_") || strings.HasPrefix(series, "__name__=process_") {
+			t.Errorf("unexpected series %q", series)
+		}
+	}
+}
+
+func TestPushReportsRejectedRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	pusher, err := NewPusher(Config{URL: srv.URL, BearerToken: "token"}, newManager(t))
+	if err != nil {
+		t.Fatalf("new pusher: %v", err)
+	}
+	if _, err := pusher.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Fatalf("expected rejection error with response body, got %v", err)
+	}
+
+	if _, err := NewPusher(Config{URL: srv.URL, BearerToken: "token", Username: "user"}, newManager(t)); err == nil {
+		t.Fatalf("expected error for bearer token together with basic auth")
+	}
+}
+
+// decodeWriteRequest renders each series as "labels value", dropping
+// timestamps.
+func decodeWriteRequest(t *testing.T, raw []byte) []string {
+	t.Helper()
+	var out []string
+	for _, ts := range fields(t, raw, fieldWriteRequestTimeseries) {
+		var labels []string
+		for _, l := range fields(t, ts, fieldTimeSeriesLabels) {
+			name := string(fields(t, l, fieldLabelName)[0])
+			value := string(fields(t, l, fieldLabelValue)[0])
+			labels = append(labels, name+"="+value)
+		}
+		sample := fields(t, ts, fieldTimeSeriesSamples)[0]
+		bits, _ := protowire.ConsumeFixed64(fields(t, sample, fieldSampleValue)[0])
+		value := math.Float64frombits(bits)
+		out = append(out, strings.Join(labels, ",")+" "+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return out
+}
+
+// fields returns the raw values of every field num in msg; fixed64 values are
+// returned as their eight bytes.
+func fields(t *testing.T, msg []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for len(msg) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(msg)
+		if tagLen < 0 {
+			t.Fatalf("bad tag")
+		}
+		msg = msg[tagLen:]
+		valueLen := protowire.ConsumeFieldValue(n, typ, msg)
+		if valueLen < 0 {
+			t.Fatalf("bad field %d", n)
+		}
+		if n == num {
+			value := msg[:valueLen]
+			if typ == protowire.BytesType {
+				value, _ = protowire.ConsumeBytes(value)
+			}
+			out = append(out, value)
+		}
+		msg = msg[valueLen:]
+	}
+	return out
+}
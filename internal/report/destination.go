@@ -0,0 +1,187 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cloud"
+)
+
+// Overridden in tests.
+var gcsEndpoint = "https://storage.googleapis.com"
+
+// maxErrorBytes bounds the error responses of object storage.
+const maxErrorBytes = 64 << 10
+
+// Destination stores rendered reports by name.
+type Destination interface {
+	Put(ctx context.Context, name, contentType string, body []byte) error
+	// String names the destination in logs.
+	String() string
+}
+
+// ParseDestination returns the destination of raw: s3://<bucket>[/<prefix>]
+// for Amazon S3, gs://<bucket>[/<prefix>] for Google Cloud Storage, and a
+// local directory otherwise. client sends the object storage requests.
+func ParseDestination(raw string, client *http.Client) (Destination, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, errors.New("empty destination")
+	}
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return &dirDestination{dir: raw}, nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("destination %q has no bucket", raw)
+	}
+	prefix = strings.Trim(prefix, "/")
+	switch strings.ToLower(scheme) {
+	case "s3":
+		return &s3Destination{bucket: bucket, prefix: prefix, client: client}, nil
+	case "gs":
+		return &gcsDestination{bucket: bucket, prefix: prefix, client: client}, nil
+	case "file":
+		return &dirDestination{dir: rest}, nil
+	}
+	return nil, fmt.Errorf("unsupported destination scheme %q: use s3, gs or a directory", scheme)
+}
+
+// dirDestination writes reports to a local directory, created if missing.
+type dirDestination struct {
+	dir string
+}
+
+func (d *dirDestination) String() string {
+	return d.dir
+}
+
+// Put writes name through a temporary file that is renamed once complete,
+// so that a collector of the directory never picks up a partial report.
+func (d *dirDestination) Put(_ context.Context, name, _ string, body []byte) (err error) {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(body); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+}
+
+// s3Destination uploads reports with PutObject. The region and credentials
+// come from the environment as for the aws-sm secrets; AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL select an S3-compatible store, addressed path-style.
+type s3Destination struct {
+	bucket, prefix string
+	client         *http.Client
+}
+
+func (d *s3Destination) String() string {
+	return "s3://" + path.Join(d.bucket, d.prefix)
+}
+
+func (d *s3Destination) Put(ctx context.Context, name, contentType string, body []byte) error {
+	region := cloud.AWSRegion()
+	if region == "" {
+		return errors.New("no region: set AWS_REGION")
+	}
+	creds, err := cloud.LoadAWSCredentials(ctx, d.client, region)
+	if err != nil {
+		return err
+	}
+	key := objectKey(d.prefix, name)
+	target := "https://" + d.bucket + ".s3." + region + ".amazonaws.com/" + key
+	if endpoint := cloud.AWSEndpoint("", "AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		target = endpoint + "/" + d.bucket + "/" + key
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	cloud.SignAWSv4(req, body, creds, region, "s3", time.Now())
+	return put(d.client, req)
+}
+
+// gcsDestination uploads reports through the XML API of Cloud Storage with
+// the access token of cloud.GCPToken.
+type gcsDestination struct {
+	bucket, prefix string
+	client         *http.Client
+}
+
+func (d *gcsDestination) String() string {
+	return "gs://" + path.Join(d.bucket, d.prefix)
+}
+
+func (d *gcsDestination) Put(ctx context.Context, name, contentType string, body []byte) error {
+	token, err := cloud.GCPToken(ctx, d.client)
+	if err != nil {
+		return err
+	}
+	target := gcsEndpoint + "/" + d.bucket + "/" + objectKey(d.prefix, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	return put(d.client, req)
+}
+
+func objectKey(prefix, name string) string {
+	if prefix == "" {
+		return url.PathEscape(name)
+	}
+	return prefix + "/" + url.PathEscape(name)
+}
+
+// put sends an upload and reports a non-2xx response with its body, which
+// names the reason, such as AccessDenied.
+func put(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+		return fmt.Errorf("PUT %s: status %d: %s", req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,256 @@
+// Package report builds the audit report of the licenses of every org:
+// entitlements against what is deployed on license servers and the peak
+// usage of each feature, and the license server inventory, as CSV or XLSX.
+package report
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// Table names, in the order of the report.
+const (
+	TableOrgs           = "orgs"
+	TableFeatures       = "features"
+	TableServers        = "servers"
+	TableServerFeatures = "server_features"
+)
+
+var Tables = []string{TableOrgs, TableFeatures, TableServers, TableServerFeatures}
+
+// ContentTypeCSV is the media type of WriteCSV output.
+const ContentTypeCSV = "text/csv; charset=utf-8"
+
+// Source is the data of an org the report is built from.
+type Source struct {
+	Org      string
+	Snapshot *cls.Snapshot
+	// Days are the daily rollups of the reporting period from the history
+	// database, if enabled. Without them, peaks are those of Snapshot.
+	Days []historydb.Rollup
+}
+
+// Collect returns the latest snapshot of every service that has one, with
+// its days from from through to in db, if set. It never refreshes.
+func Collect(services []*snapshot.Service, db *historydb.DB, from, to time.Time) ([]Source, error) {
+	var sources []Source
+	for _, svc := range services {
+		snap, _, ok := svc.Latest()
+		if !ok {
+			continue
+		}
+		src := Source{Org: svc.Target(), Snapshot: snap}
+		if db != nil {
+			days, err := db.Daily(svc.Target(), from, to)
+			if err != nil {
+				return nil, err
+			}
+			src.Days = days
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// Report is a set of tables, each with a header row.
+type Report struct {
+	GeneratedAt time.Time
+	Tables      []Table
+}
+
+// Table holds rows of string, int and float64 cells.
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]any
+}
+
+// Table returns the table called name, or false if there is none.
+func (r *Report) Table(name string) (Table, bool) {
+	for _, t := range r.Tables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Table{}, false
+}
+
+type featureKey struct {
+	virtualGroupID int
+	name           string
+	licenseType    string
+}
+
+type serverKey struct {
+	virtualGroupID int
+	id             string
+}
+
+type serverFeatureKey struct {
+	server      serverKey
+	name        string
+	licenseType string
+}
+
+type peaks struct {
+	inUse, activeLeases float64
+}
+
+// Build returns the report of sources, in their order, generated at now.
+func Build(sources []Source, now time.Time) *Report {
+	orgs := Table{Name: TableOrgs, Columns: []string{
+		"org", "collected_at", "period_start", "period_end", "peak_source", "virtual_groups", "license_servers", "features",
+	}}
+	features := Table{Name: TableFeatures, Columns: []string{
+		"org", "virtual_group_id", "virtual_group_name", "product_name", "feature_name", "feature_version", "license_type",
+		"entitled", "deployed", "unassigned", "in_use", "active_leases", "peak_in_use", "peak_active_leases", "peak_utilization",
+	}}
+	servers := Table{Name: TableServers, Columns: []string{
+		"org", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "status", "deployed_on", "leasing_mode",
+		"allocated", "in_use", "available", "active_leases",
+	}}
+	serverFeatures := Table{Name: TableServerFeatures, Columns: []string{
+		"org", "virtual_group_id", "virtual_group_name", "server_id", "server_name", "product_name", "feature_name", "license_type",
+		"deployed", "active_leases",
+	}}
+
+	for _, src := range sources {
+		snap := src.Snapshot
+		deployed := make(map[featureKey]float64)
+		for _, c := range snap.ServerFeatureCapacity {
+			deployed[featureKey{c.VirtualGroupID, c.FeatureName, c.LicenseType}] += c.TotalQuantity
+		}
+		featureLeases := make(map[featureKey]float64)
+		serverFeatureLeases := make(map[serverFeatureKey]float64)
+		for _, l := range snap.ServerFeatureActiveLeases {
+			featureLeases[featureKey{l.VirtualGroupID, l.FeatureName, l.LicenseType}] += l.ActiveLeases
+			serverFeatureLeases[serverFeatureKey{serverKey{l.VirtualGroupID, l.ServerID}, l.FeatureName, l.LicenseType}] += l.ActiveLeases
+		}
+		serverLeases := make(map[serverKey]float64)
+		for _, l := range snap.ServerActiveLeases {
+			serverLeases[serverKey{l.VirtualGroupID, l.ServerID}] += l.ActiveLeases
+		}
+		peak := make(map[featureKey]peaks)
+		for _, day := range src.Days {
+			for _, f := range day.Features {
+				key := featureKey{f.VirtualGroupID, f.FeatureName, f.LicenseType}
+				p := peak[key]
+				peak[key] = peaks{max(p.inUse, f.InUse.Max), max(p.activeLeases, f.ActiveLeases.Max)}
+			}
+		}
+
+		virtualGroups := make(map[int]bool)
+		for _, f := range snap.EntitlementFeatures {
+			virtualGroups[f.VirtualGroupID] = true
+			key := featureKey{f.VirtualGroupID, f.FeatureName, f.LicenseType}
+			p := peak[key]
+			p.inUse = max(p.inUse, f.InUseQuantity)
+			p.activeLeases = max(p.activeLeases, featureLeases[key])
+			utilization := 0.0
+			if f.TotalQuantity > 0 {
+				utilization = p.inUse / f.TotalQuantity
+			}
+			features.Rows = append(features.Rows, []any{
+				src.Org, f.VirtualGroupID, f.VirtualGroupName, f.ProductName, f.FeatureName, f.FeatureVersion, f.LicenseType,
+				f.TotalQuantity, deployed[key], f.Unassigned, f.InUseQuantity, featureLeases[key], p.inUse, p.activeLeases, utilization,
+			})
+		}
+		for _, s := range snap.ServerUsage {
+			virtualGroups[s.VirtualGroupID] = true
+			servers.Rows = append(servers.Rows, []any{
+				src.Org, s.VirtualGroupID, s.VirtualGroupName, s.ServerID, s.ServerName, s.ServerStatus, s.DeployedOn, s.LeasingMode,
+				s.Allocated, s.InUse, s.Available, serverLeases[serverKey{s.VirtualGroupID, s.ServerID}],
+			})
+		}
+		capacity := slices.Clone(snap.ServerFeatureCapacity)
+		slices.SortStableFunc(capacity, func(a, b cls.ServerFeatureCapacitySnapshot) int {
+			return cmp.Or(cmp.Compare(a.VirtualGroupID, b.VirtualGroupID), cmp.Compare(a.ServerName, b.ServerName), cmp.Compare(a.ServerID, b.ServerID), cmp.Compare(a.FeatureName, b.FeatureName))
+		})
+		for _, c := range capacity {
+			serverFeatures.Rows = append(serverFeatures.Rows, []any{
+				src.Org, c.VirtualGroupID, c.VirtualGroupName, c.ServerID, c.ServerName, c.ProductName, c.FeatureName, c.LicenseType,
+				c.TotalQuantity, serverFeatureLeases[serverFeatureKey{serverKey{c.VirtualGroupID, c.ServerID}, c.FeatureName, c.LicenseType}],
+			})
+		}
+
+		periodStart, periodEnd, source := "", "", "snapshot"
+		if len(src.Days) > 0 {
+			periodStart = src.Days[0].Start.Format(time.DateOnly)
+			periodEnd = src.Days[len(src.Days)-1].Start.Format(time.DateOnly)
+			source = "history"
+		}
+		orgs.Rows = append(orgs.Rows, []any{
+			src.Org, snap.CollectedAt.UTC().Format(time.RFC3339), periodStart, periodEnd, source,
+			len(virtualGroups), len(snap.ServerUsage), len(snap.EntitlementFeatures),
+		})
+	}
+	return &Report{GeneratedAt: now, Tables: []Table{orgs, features, servers, serverFeatures}}
+}
+
+// WriteCSV writes tables of r, each with a header row, separated by an
+// empty line, as the dump command does. A single table is plain CSV. Text
+// cells that a spreadsheet would take for a formula are prefixed with '.
+func (r *Report) WriteCSV(w io.Writer, tables []string) error {
+	cw := csv.NewWriter(w)
+	for i, name := range tables {
+		t, ok := r.Table(name)
+		if !ok {
+			return fmt.Errorf("no table %q", name)
+		}
+		if i > 0 {
+			cw.Flush()
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(t.Columns); err != nil {
+			return err
+		}
+		for _, row := range t.Rows {
+			record := make([]string, len(row))
+			for j, cell := range row {
+				record[j] = formatCell(cell)
+				if _, ok := cell.(string); ok {
+					record[j] = neutralizeFormula(record[j])
+				}
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatCell(cell any) string {
+	switch v := cell.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// neutralizeFormula prefixes text that starts like a formula with ', so
+// names from CLS cannot run as formulas when the CSV is opened.
+func neutralizeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
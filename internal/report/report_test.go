@@ -0,0 +1,326 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+func testReport() *Report {
+	snap := &cls.Snapshot{
+		CollectedAt: time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", FeatureName: "vWS", ProductName: "RTX vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 50, InUseQuantity: 20, Unassigned: 10},
+		},
+		ServerFeatureCapacity: []cls.ServerFeatureCapacitySnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-2", ServerName: "dls-2", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 15},
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-1", ServerName: "dls-1", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", TotalQuantity: 25},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-1", ServerName: "dls-1", ServerStatus: "ENABLED", Allocated: 25, InUse: 18, Available: 7},
+			{VirtualGroupID: 1, VirtualGroupName: "VG", ServerID: "srv-2", ServerName: "dls-2", ServerStatus: "ENABLED", Allocated: 15, InUse: 2, Available: 13},
+		},
+		ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", ActiveLeases: 18},
+			{VirtualGroupID: 1, ServerID: "srv-2", ActiveLeases: 2},
+		},
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 18},
+			{VirtualGroupID: 1, ServerID: "srv-2", FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 2},
+		},
+	}
+	days := []historydb.Rollup{
+		{Start: time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC), Features: []historydb.FeatureRollup{
+			{VirtualGroupID: 1, FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", InUse: historydb.Stats{Max: 45, Count: 1}, ActiveLeases: historydb.Stats{Max: 44, Count: 1}},
+		}},
+		{Start: time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC), Features: []historydb.FeatureRollup{
+			{VirtualGroupID: 1, FeatureName: "vWS", LicenseType: "CONCURRENT_COUNTED_SINGLE", InUse: historydb.Stats{Max: 30, Count: 1}, ActiveLeases: historydb.Stats{Max: 30, Count: 1}},
+		}},
+	}
+	return Build([]Source{{Org: "org-1", Snapshot: snap, Days: days}}, time.Date(2024, 5, 20, 9, 0, 0, 0, time.UTC))
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteCSV(&buf, []string{TableFeatures}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"org-1", "1", "VG", "RTX vWS", "vWS", "", "CONCURRENT_COUNTED_SINGLE", "50", "40", "10", "20", "20", "45", "44", "0.9"}
+	if len(rows) != 2 || strings.Join(rows[1], ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected features table:\n%v\nwant %v", rows, want)
+	}
+
+	buf.Reset()
+	if err := testReport().WriteCSV(&buf, Tables); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"org-1,2024-05-20T08:00:00Z,2024-05-18,2024-05-19,history,1,2,1\n\norg,virtual_group_id",
+		"org-1,1,VG,srv-1,dls-1,ENABLED,,,25,18,7,18\n",
+		// Server features are ordered by server name.
+		"org-1,1,VG,srv-1,dls-1,,vWS,CONCURRENT_COUNTED_SINGLE,25,18\norg-1,1,VG,srv-2,dls-2,,vWS,CONCURRENT_COUNTED_SINGLE,15,2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+	if err := testReport().WriteCSV(io.Discard, []string{"licenses"}); err == nil {
+		t.Error("expected an error for an unknown table")
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteXLSX(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip file: %v", err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(content)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet4.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook lacks %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="server_features" sheetId="4" r:id="rId4"/>`) {
+		t.Errorf("unexpected workbook:\n%s", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet2.xml"]
+	for _, want := range []string{
+		`<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">org</t></is></c>`,
+		`<c r="O2"><v>0.9</v></c>`,
+		`<c r="E2" t="inlineStr"><is><t xml:space="preserve">vWS</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("expected %s in:\n%s", want, sheet)
+		}
+	}
+}
+
+func TestFormulaText(t *testing.T) {
+	r := &Report{Tables: []Table{{
+		Name:    TableServers,
+		Columns: []string{"a", "b", "c", "d", "e", "f"},
+		Rows:    [][]any{{`=HYPERLINK("http://x")`, "+1", "-2", "@SUM(A1)", "dls-1", -3}},
+	}}}
+
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf, []string{TableServers}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`'=HYPERLINK("http://x")`, "'+1", "'-2", "'@SUM(A1)", "dls-1", "-3"}
+	if len(rows) != 2 || strings.Join(rows[1], "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected CSV rows:\n%v\nwant %v", rows, want)
+	}
+
+	// XLSX keeps the text as is, in inline strings that are never
+	// evaluated, and numbers as numbers.
+	buf.Reset()
+	if err := r.WriteXLSX(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip file: %v", err)
+	}
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(f)
+	f.Close()
+	sheet := string(content)
+	for _, want := range []string{
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://x&#34;)</t></is></c>`,
+		`<c r="C2" t="inlineStr"><is><t xml:space="preserve">-2</t></is></c>`,
+		`<c r="D2" t="inlineStr"><is><t xml:space="preserve">@SUM(A1)</t></is></c>`,
+		`<c r="F2"><v>-3</v></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("expected %s in:\n%s", want, sheet)
+		}
+	}
+	if strings.Contains(sheet, "<f>") {
+		t.Errorf("unexpected formula in:\n%s", sheet)
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	used := make(map[string]bool)
+	for _, tt := range []struct{ name, want string }{
+		{"server_features", "server_features"},
+		{"a[b]:c*d?e/f\\g", "a_b__c_d_e_f_g"},
+		{"'quoted'", "quoted"},
+		{"", "Sheet4"},
+		{"license_server_feature_capacity_by_org", "license_server_feature_capacity"},
+		{"Server_Features", "Server_Features_6"},
+		{"license_server_feature_capacity_by_pool", "license_server_feature_capaci_7"},
+	} {
+		n := len(used) + 1
+		if got := sheetName(tt.name, n, used); got != tt.want {
+			t.Errorf("sheetName(%q, %d) = %q, want %q", tt.name, n, got, tt.want)
+		}
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("column %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+type staticFetcher struct{ snap *cls.Snapshot }
+
+func (f staticFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return f.snap.Clone(), nil
+}
+
+func TestSchedulerWrite(t *testing.T) {
+	svc := snapshot.NewService(staticFetcher{&cls.Snapshot{CollectedAt: time.Now()}}, snapshot.Config{Target: "org-1", CacheTTL: time.Hour})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "reports")
+	s, err := NewScheduler(ScheduleConfig{Schedule: "0 6 1 * *", Format: FormatXLSX, Destination: dir}, manager, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 6, 1, 6, 0, 0, 0, time.UTC)
+	if _, err := s.Write(context.Background(), at); err == nil || !strings.Contains(err.Error(), "no current snapshot") {
+		t.Fatalf("expected no report before the first refresh, got %v", err)
+	}
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	name, err := s.Write(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "nvidia-cls-report-20240601T060000Z.xlsx" {
+		t.Errorf("unexpected name %s", name)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != name {
+		t.Fatalf("expected only %s in %s, got %v, %v", name, dir, entries, err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil || !bytes.HasPrefix(content, []byte("PK")) {
+		t.Fatalf("expected an XLSX workbook, got %v", err)
+	}
+
+	for _, cfg := range []ScheduleConfig{
+		{Schedule: "monthly", Destination: dir},
+		{Schedule: "@monthly", Format: "pdf", Destination: dir},
+		{Schedule: "@monthly", Destination: "ftp://host/reports"},
+		{Schedule: "@monthly", Destination: "s3:///reports"},
+	} {
+		if _, err := NewScheduler(cfg, manager, nil, nil); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestS3Destination(t *testing.T) {
+	body := []byte("org,collected_at\n")
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		content, _ := io.ReadAll(r.Body)
+		hash := sha256.Sum256(content)
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") ||
+			!strings.Contains(auth, "x-amz-content-sha256") || r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	dest, err := ParseDestination("s3://compliance/nvidia/", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != "s3://compliance/nvidia" {
+		t.Errorf("unexpected destination %s", dest)
+	}
+	if err := dest.Put(context.Background(), "report.csv", ContentTypeCSV, body); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodPut || got.URL.Path != "/compliance/nvidia/report.csv" || got.Header.Get("Content-Type") != ContentTypeCSV {
+		t.Errorf("unexpected request %s %s %q", got.Method, got.URL.Path, got.Header.Get("Content-Type"))
+	}
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if err := dest.Put(context.Background(), "report.csv", ContentTypeCSV, body); err == nil || !strings.Contains(err.Error(), "no AWS credentials") {
+		t.Errorf("expected missing credentials to be reported, got %v", err)
+	}
+}
+
+func TestGCSDestination(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/compliance/report.xlsx" || r.Header.Get("Authorization") != "Bearer gcp-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("AccessDenied"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	defer func(endpoint string) { gcsEndpoint = endpoint }(gcsEndpoint)
+	gcsEndpoint = srv.URL
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "gcp-token")
+
+	dest, err := ParseDestination("gs://compliance", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dest.Put(context.Background(), "report.xlsx", ContentTypeXLSX, []byte("PK")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dest.Put(context.Background(), "other.xlsx", ContentTypeXLSX, []byte("PK")); err == nil || !strings.Contains(err.Error(), "status 403: AccessDenied") {
+		t.Errorf("expected the 403 to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"nvidia-license-server-exporter/internal/historydb"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+// Formats of a rendered report.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+const (
+	defaultRange   = 30 * 24 * time.Hour
+	defaultTimeout = time.Minute
+)
+
+type ScheduleConfig struct {
+	// Schedule is a standard cron expression, such as "0 6 1 * *" for
+	// 06:00 on the first of every month, in local time.
+	Schedule string
+	// Format is FormatCSV or FormatXLSX.
+	Format string
+	// Range is the reporting period before each scheduled time.
+	Range time.Duration
+	// Destination is where reports are written; see ParseDestination.
+	Destination string
+	// Timeout bounds the upload of a report.
+	Timeout time.Duration
+}
+
+// Scheduler renders the report of every org on a schedule and writes it to
+// a destination, named after the scheduled time, such as
+// nvidia-cls-report-20240601T060000Z.xlsx.
+type Scheduler struct {
+	cfg      ScheduleConfig
+	schedule cron.Schedule
+	dest     Destination
+	manager  *snapshot.Manager
+	db       *historydb.DB
+	standby  func() bool
+
+	runs        *prometheus.CounterVec
+	lastSuccess prometheus.Gauge
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewScheduler parses the schedule and destination of cfg. db, if set,
+// provides the peaks of the reporting period. standby, if set, reports
+// whether another replica writes the reports, such as the leader of a
+// leader election; while it does, scheduled reports are skipped.
+func NewScheduler(cfg ScheduleConfig, manager *snapshot.Manager, db *historydb.DB, standby func() bool) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(strings.TrimSpace(cfg.Schedule))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = FormatCSV
+	case FormatCSV, FormatXLSX:
+	default:
+		return nil, fmt.Errorf("format must be %s or %s", FormatCSV, FormatXLSX)
+	}
+	if cfg.Range <= 0 {
+		cfg.Range = defaultRange
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	dest, err := ParseDestination(cfg.Destination, &http.Client{Timeout: cfg.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		cfg:      cfg,
+		schedule: schedule,
+		dest:     dest,
+		manager:  manager,
+		db:       db,
+		standby:  standby,
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nvidia_cls_exporter_scheduled_reports_total",
+			Help: "Scheduled audit reports by result.",
+		}, []string{"result"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nvidia_cls_exporter_scheduled_report_last_success_timestamp_seconds",
+			Help: "When the last scheduled audit report was written, as a Unix timestamp.",
+		}),
+		done: make(chan struct{}),
+	}
+	s.runs.WithLabelValues("success")
+	s.runs.WithLabelValues("failure")
+	return s, nil
+}
+
+// Collector exposes the report counters for a Prometheus registry.
+func (s *Scheduler) Collector() prometheus.Collector {
+	return collectors{s.runs, s.lastSuccess}
+}
+
+type collectors []prometheus.Collector
+
+func (c collectors) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c {
+		collector.Describe(ch)
+	}
+}
+
+func (c collectors) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c {
+		collector.Collect(ch)
+	}
+}
+
+// Start writes a report at every scheduled time until Shutdown.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		defer close(s.done)
+		for {
+			next := s.schedule.Next(time.Now())
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if s.standby != nil && s.standby() {
+				slog.Debug("scheduled report skipped on standby")
+				continue
+			}
+			name, err := s.Write(ctx, next)
+			if err != nil {
+				s.runs.WithLabelValues("failure").Inc()
+				slog.Error("scheduled report failed", "destination", s.dest.String(), "error", err)
+				continue
+			}
+			s.runs.WithLabelValues("success").Inc()
+			s.lastSuccess.SetToCurrentTime()
+			slog.Info("scheduled report written", "destination", s.dest.String(), "name", name)
+		}
+	}()
+}
+
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Write renders the report of the period before at from the latest
+// snapshots and writes it to the destination, returning its name.
+func (s *Scheduler) Write(ctx context.Context, at time.Time) (string, error) {
+	sources, err := Collect(s.manager.Services(), s.db, at.Add(-s.cfg.Range), at)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return "", errors.New("no current snapshot")
+	}
+	r := Build(sources, at)
+	var buf bytes.Buffer
+	contentType := ContentTypeCSV
+	if s.cfg.Format == FormatXLSX {
+		err = r.WriteXLSX(&buf)
+		contentType = ContentTypeXLSX
+	} else {
+		err = r.WriteCSV(&buf, Tables)
+	}
+	if err != nil {
+		return "", err
+	}
+	name := "nvidia-cls-report-" + at.UTC().Format("20060102T150405Z") + "." + s.cfg.Format
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	if err := s.dest.Put(ctx, name, contentType, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("write %s to %s: %w", name, s.dest, err)
+	}
+	return name, nil
+}
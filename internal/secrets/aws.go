@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cloud"
+)
+
+// fetchAWS calls Secrets Manager GetSecretValue. The region is taken from an
+// ARN, else from AWS_REGION or AWS_DEFAULT_REGION. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or from a
+// web identity (EKS IAM roles for service accounts) through
+// AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN. AWS_ENDPOINT_URL_SECRETS_MANAGER
+// or AWS_ENDPOINT_URL override the endpoint, e.g. for VPC endpoints.
+func (f *Fetcher) fetchAWS(ctx context.Context, ref Ref) (string, error) {
+	region := awsRegion(ref.Path)
+	if region == "" {
+		return "", errors.New("no region: use a secret ARN or set AWS_REGION")
+	}
+	creds, err := cloud.LoadAWSCredentials(ctx, f.client(), region)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := cloud.AWSEndpoint("https://secretsmanager."+region+".amazonaws.com", "AWS_ENDPOINT_URL_SECRETS_MANAGER", "AWS_ENDPOINT_URL")
+	body, err := json.Marshal(map[string]string{"SecretId": ref.Path})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	cloud.SignAWSv4(req, body, creds, region, "secretsmanager", time.Now())
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := f.do(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.SecretString == "" {
+		return "", errors.New("secret has no SecretString; binary secrets are not supported")
+	}
+	return field(resp.SecretString, ref.Field)
+}
+
+// awsRegion returns the region of an ARN
+// (arn:aws:secretsmanager:<region>:<account>:secret:<name>) or the
+// configured default.
+func awsRegion(secretID string) string {
+	if parts := strings.Split(secretID, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return cloud.AWSRegion()
+}
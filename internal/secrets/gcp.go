@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"nvidia-license-server-exporter/internal/cloud"
+)
+
+// Overridden in tests.
+var gcpEndpoint = "https://secretmanager.googleapis.com"
+
+// fetchGCP calls Secret Manager AccessSecretVersion, with the access token
+// in GOOGLE_OAUTH_ACCESS_TOKEN or else one from the metadata server, as on
+// GCE and GKE with Workload Identity.
+func (f *Fetcher) fetchGCP(ctx context.Context, ref Ref) (string, error) {
+	name, err := gcpVersionName(ref.Path)
+	if err != nil {
+		return "", err
+	}
+	token, err := cloud.GCPToken(ctx, f.client())
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpEndpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := f.do(req, &resp); err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decode payload: %w", err)
+	}
+	return field(string(raw), ref.Field)
+}
+
+// gcpVersionName expands projects/<p>/secrets/<s> to its latest version.
+func gcpVersionName(path string) (string, error) {
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		return path + "/versions/latest", nil
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		return path, nil
+	}
+	return "", errors.New("gcp-sm path must be projects/<project>/secrets/<secret>[/versions/<version>]")
+}
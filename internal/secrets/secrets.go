@@ -0,0 +1,204 @@
+// Package secrets fetches credentials from secrets managers named by URI:
+//
+//	vault://<path>#<field>
+//	aws-sm://<secret name or ARN>[#<field>]
+//	gcp-sm://projects/<project>/secrets/<secret>[/versions/<version>][#<field>]
+//
+// Each backend is configured through the environment variables of its own
+// tooling, such as VAULT_ADDR, AWS_REGION or GOOGLE_OAUTH_ACCESS_TOKEN.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SchemeVault = "vault"
+	SchemeAWS   = "aws-sm"
+	SchemeGCP   = "gcp-sm"
+
+	defaultTimeout = 10 * time.Second
+	// maxResponseBytes bounds secrets manager responses.
+	maxResponseBytes = 1 << 20
+)
+
+// Ref is a parsed secret URI.
+type Ref struct {
+	Scheme string
+	// Path is everything between "://" and "#".
+	Path string
+	// Field selects a key of a JSON object secret. Required for Vault.
+	Field string
+}
+
+func (r Ref) String() string {
+	if r.Field == "" {
+		return r.Scheme + "://" + r.Path
+	}
+	return r.Scheme + "://" + r.Path + "#" + r.Field
+}
+
+// Parse parses and validates a secret URI.
+func Parse(uri string) (Ref, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(uri), "://")
+	if !ok {
+		return Ref{}, fmt.Errorf("invalid secret URI %q: expected <scheme>://<path>[#<field>]", uri)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	ref := Ref{Scheme: strings.ToLower(scheme), Path: strings.Trim(path, "/"), Field: field}
+	if ref.Path == "" {
+		return Ref{}, fmt.Errorf("invalid secret URI %q: empty path", uri)
+	}
+	switch ref.Scheme {
+	case SchemeVault:
+		if ref.Field == "" {
+			return Ref{}, fmt.Errorf("invalid secret URI %q: vault needs a #field", uri)
+		}
+	case SchemeAWS:
+	case SchemeGCP:
+		if _, err := gcpVersionName(ref.Path); err != nil {
+			return Ref{}, fmt.Errorf("invalid secret URI %q: %w", uri, err)
+		}
+	default:
+		return Ref{}, fmt.Errorf("unsupported secret URI scheme %q: use vault, aws-sm or gcp-sm", ref.Scheme)
+	}
+	return ref, nil
+}
+
+// Fetcher fetches secrets. The zero value is ready to use.
+type Fetcher struct {
+	// HTTPClient is used for every request. Nil uses a client with a
+	// 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Fetch returns the current value of the secret ref names, trimmed.
+func (f *Fetcher) Fetch(ctx context.Context, ref Ref) (string, error) {
+	var (
+		value string
+		err   error
+	)
+	switch ref.Scheme {
+	case SchemeVault:
+		value, err = f.fetchVault(ctx, ref)
+	case SchemeAWS:
+		value, err = f.fetchAWS(ctx, ref)
+	case SchemeGCP:
+		value, err = f.fetchGCP(ctx, ref)
+	default:
+		err = fmt.Errorf("unsupported secret URI scheme %q", ref.Scheme)
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", ref, err)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("fetch %s: secret is empty", ref)
+	}
+	return value, nil
+}
+
+// Watch fetches ref every interval and calls apply with the value whenever
+// it changed from current. Failed fetches are logged and the previous
+// value stays in use. Watch blocks until ctx is done.
+func (f *Fetcher) Watch(ctx context.Context, ref Ref, interval time.Duration, current string, apply func(string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fetchCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		value, err := f.Fetch(fetchCtx, ref)
+		cancel()
+		if err != nil {
+			slog.Warn("secret refresh failed, keeping the current value", "secret", ref.String(), "error", err)
+			continue
+		}
+		if value != current {
+			current = value
+			apply(value)
+			slog.Info("secret rotated", "secret", ref.String())
+		}
+	}
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.HTTPClient != nil {
+		return f.HTTPClient
+	}
+	return &http.Client{Timeout: defaultTimeout}
+}
+
+// do sends req and decodes a JSON response into out.
+func (f *Fetcher) do(req *http.Request, out any) error {
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The body of an error response carries no secret, only a reason.
+		return &statusError{
+			request:    req.Method + " " + redactURL(req.URL),
+			statusCode: resp.StatusCode,
+			body:       strings.TrimSpace(string(body)),
+		}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// statusError reports a non-2xx response.
+type statusError struct {
+	request    string
+	statusCode int
+	body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status %d: %s", e.request, e.statusCode, e.body)
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	return redacted.String()
+}
+
+// field returns key of the JSON object raw, or raw itself if key is empty.
+func field(raw, key string) (string, error) {
+	if key == "" {
+		return raw, nil
+	}
+	var object map[string]any
+	if err := json.Unmarshal([]byte(raw), &object); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select field %q", key)
+	}
+	return stringField(object, key)
+}
+
+func stringField(object map[string]any, key string) (string, error) {
+	value, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,156 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for uri, want := range map[string]Ref{
+		"vault://secret/nvidia#api_key":                             {Scheme: SchemeVault, Path: "secret/nvidia", Field: "api_key"},
+		"aws-sm://prod/nvidia":                                      {Scheme: SchemeAWS, Path: "prod/nvidia"},
+		"gcp-sm://projects/p/secrets/nvidia/versions/3#k":           {Scheme: SchemeGCP, Path: "projects/p/secrets/nvidia/versions/3", Field: "k"},
+		"aws-sm://arn:aws:secretsmanager:eu-west-1:1:secret:nvidia": {Scheme: SchemeAWS, Path: "arn:aws:secretsmanager:eu-west-1:1:secret:nvidia"},
+	} {
+		got, err := Parse(uri)
+		if err != nil || got != want {
+			t.Fatalf("Parse(%q) = %+v, %v; want %+v", uri, got, err, want)
+		}
+	}
+	for _, uri := range []string{"secret/nvidia", "vault://secret/nvidia", "s3://bucket/key", "gcp-sm://nvidia", "aws-sm://"} {
+		if _, err := Parse(uri); err == nil {
+			t.Fatalf("Parse(%q): expected error", uri)
+		}
+	}
+}
+
+func TestFetchVault(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/nvidia":
+			_, _ = w.Write([]byte(`{"data":{"data":{"api_key":"kv2-key"},"metadata":{"version":3}}}`))
+		case "/v1/kv1/nvidia":
+			_, _ = w.Write([]byte(`{"data":{"api_key":"kv1-key"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	var f Fetcher
+	for uri, want := range map[string]string{"vault://secret/nvidia#api_key": "kv2-key", "vault://kv1/nvidia#api_key": "kv1-key"} {
+		ref, _ := Parse(uri)
+		if got, err := f.Fetch(context.Background(), ref); err != nil || got != want {
+			t.Fatalf("Fetch(%s) = %q, %v; want %q", uri, got, err, want)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	ref, _ := Parse("vault://secret/nvidia#api_key")
+	if _, err := f.Fetch(context.Background(), ref); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected the 403 to be reported, got %v", err)
+	}
+}
+
+func TestFetchAWS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"Name":"nvidia","SecretString":"{\"api_key\":\"aws-key\"}"}`))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", srv.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	// The region of the ARN wins over AWS_REGION.
+	ref, _ := Parse("aws-sm://arn:aws:secretsmanager:eu-west-1:123456789012:secret:nvidia#api_key")
+	var f Fetcher
+	if got, err := f.Fetch(context.Background(), ref); err != nil || got != "aws-key" {
+		t.Fatalf("Fetch = %q, %v", got, err)
+	}
+}
+
+func TestFetchGCP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"gcp-token","expires_in":3599}`))
+		case "/v1/projects/p/secrets/nvidia/versions/latest:access":
+			if r.Header.Get("Authorization") != "Bearer gcp-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"payload":{"data":"` + base64.StdEncoding.EncodeToString([]byte("gcp-key\n")) + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	defer func(endpoint string) { gcpEndpoint = endpoint }(gcpEndpoint)
+	gcpEndpoint = srv.URL
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+
+	ref, _ := Parse("gcp-sm://projects/p/secrets/nvidia")
+	var f Fetcher
+	if got, err := f.Fetch(context.Background(), ref); err != nil || got != "gcp-key" {
+		t.Fatalf("Fetch = %q, %v", got, err)
+	}
+}
+
+func TestWatchAppliesRotation(t *testing.T) {
+	var version atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		key := "key-1"
+		if version.Load() > 0 {
+			key = "key-2"
+		}
+		_, _ = w.Write([]byte(`{"data":{"api_key":"` + key + `"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan string, 1)
+	ref, _ := Parse("vault://secret/data/nvidia#api_key")
+	var f Fetcher
+	go f.Watch(ctx, ref, 10*time.Millisecond, "key-1", func(key string) { applied <- key })
+
+	version.Store(1)
+	select {
+	case key := <-applied:
+		if key != "key-2" {
+			t.Fatalf("expected key-2, got %q", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("rotation was not applied")
+	}
+}
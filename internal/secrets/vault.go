@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// fetchVault reads a KV secret from the server at VAULT_ADDR with the token
+// in VAULT_TOKEN, or in the file named by VAULT_TOKEN_FILE as written by
+// Vault Agent. VAULT_NAMESPACE is sent for Vault Enterprise. The path is
+// the one given to "vault kv get": KV version 2 is tried first, at
+// <mount>/data/<rest>, then version 1 at the path itself.
+func (f *Fetcher) fetchVault(ctx context.Context, ref Ref) (string, error) {
+	addr := strings.TrimSuffix(strings.TrimSpace(os.Getenv("VAULT_ADDR")), "/")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return "", err
+	}
+
+	paths := []string{ref.Path}
+	if mount, rest, ok := strings.Cut(ref.Path, "/"); ok && !strings.HasPrefix(rest, "data/") {
+		paths = []string{mount + "/data/" + rest, ref.Path}
+	}
+	var err404 error
+	for _, path := range paths {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if ns := strings.TrimSpace(os.Getenv("VAULT_NAMESPACE")); ns != "" {
+			req.Header.Set("X-Vault-Namespace", ns)
+		}
+		var resp struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := f.do(req, &resp); err != nil {
+			var statusErr *statusError
+			if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+				err404 = err
+				continue
+			}
+			return "", err
+		}
+		data := resp.Data
+		// KV version 2 nests the secret under data.data.
+		if nested, ok := data["data"].(map[string]any); ok {
+			if _, versioned := data["metadata"]; versioned {
+				data = nested
+			}
+		}
+		return stringField(data, ref.Field)
+	}
+	return "", err404
+}
+
+func vaultToken() (string, error) {
+	if token := strings.TrimSpace(os.Getenv("VAULT_TOKEN")); token != "" {
+		return token, nil
+	}
+	if path := strings.TrimSpace(os.Getenv("VAULT_TOKEN_FILE")); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read VAULT_TOKEN_FILE: %w", err)
+		}
+		if token := strings.TrimSpace(string(raw)); token != "" {
+			return token, nil
+		}
+	}
+	return "", errors.New("neither VAULT_TOKEN nor VAULT_TOKEN_FILE is set")
+}
@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+)
+
+// BackoffState describes the refresh backoff after consecutive failures.
+type BackoffState struct {
+	ConsecutiveFailures int
+	Until               time.Time
+	LastError           error
+}
+
+// Active reports whether refreshes are currently being skipped.
+func (b BackoffState) Active(now time.Time) bool {
+	return now.Before(b.Until)
+}
+
+// Backoff returns the current failure backoff state.
+func (s *Service) Backoff() BackoffState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backoff
+}
+
+// recordFetchLocked updates the backoff state after a fetch attempt. The
+// window doubles with every consecutive failure, capped at backoffMax.
+func (s *Service) recordFetchLocked(now time.Time, err error) {
+	if err == nil {
+		s.backoff = BackoffState{}
+		return
+	}
+
+	s.backoff.ConsecutiveFailures++
+	s.backoff.LastError = err
+	if s.backoffInitial <= 0 {
+		return
+	}
+
+	window := s.backoffInitial
+	for i := 1; i < s.backoff.ConsecutiveFailures && window < s.backoffMax; i++ {
+		window *= 2
+	}
+	if window > s.backoffMax {
+		window = s.backoffMax
+	}
+	s.backoff.Until = now.Add(window)
+}
+
+// backoffResultLocked serves the cached snapshot without fetching while the
+// backoff window is open. ok is false when a fetch should be attempted.
+func (s *Service) backoffResultLocked(now time.Time) (res refreshResult, err error, ok bool) {
+	if !s.backoff.Active(now) {
+		return refreshResult{}, nil, false
+	}
+
+	cause := fmt.Errorf("refresh suppressed until %s after %d consecutive failures: %w",
+		s.backoff.Until.Format(time.RFC3339), s.backoff.ConsecutiveFailures, s.backoff.LastError)
+	if s.cached == nil {
+		return refreshResult{}, cause, true
+	}
+	if s.tooStaleLocked(now) {
+		return refreshResult{}, fmt.Errorf("cached snapshot exceeds max stale %s: %w", s.maxStale, cause), true
+	}
+	snap, err := s.cached.load()
+	if err != nil {
+		return refreshResult{}, fmt.Errorf("load stale snapshot: %w", err), true
+	}
+	return refreshResult{snapshot: snap, meta: s.meta}, nil, true
+}
@@ -0,0 +1,56 @@
+package snapshot
+
+import "nvidia-license-server-exporter/internal/cls"
+
+// LeaseChurn counts active-lease changes between consecutive snapshots.
+// Added sums the per-server increases and Removed the decreases, so a lease
+// released and another granted on the same server between two snapshots
+// cancel out.
+type LeaseChurn struct {
+	Added   float64
+	Removed float64
+}
+
+// APIRequestCounter is implemented by fetchers that count their CLS API
+// requests.
+type APIRequestCounter interface {
+	APIRequests() []cls.APIRequestCount
+}
+
+// LeaseChurn returns the lease changes accumulated since the service was
+// created. The first snapshot only sets the baseline.
+func (s *Service) LeaseChurn() LeaseChurn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leaseChurn
+}
+
+// APIRequests returns the fetcher's API request counts, or nil when the
+// fetcher does not count them.
+func (s *Service) APIRequests() []cls.APIRequestCount {
+	if counter, ok := s.fetcher.(APIRequestCounter); ok {
+		return counter.APIRequests()
+	}
+	return nil
+}
+
+// recordChurnLocked adds the difference between the previous per-server
+// leases and leases to the churn counters. Servers that disappear count as
+// all of their leases removed.
+func (s *Service) recordChurnLocked(leases map[string]float64) {
+	if s.serverLeases != nil {
+		for id, after := range leases {
+			if delta := after - s.serverLeases[id]; delta > 0 {
+				s.leaseChurn.Added += delta
+			} else {
+				s.leaseChurn.Removed -= delta
+			}
+		}
+		for id, before := range s.serverLeases {
+			if _, ok := leases[id]; !ok {
+				s.leaseChurn.Removed += before
+			}
+		}
+	}
+	s.serverLeases = leases
+}
@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// Diff describes what changed between two snapshots.
+type Diff struct {
+	From             time.Time    `json:"from"`
+	To               time.Time    `json:"to"`
+	Servers          Changes      `json:"servers"`
+	Pools            Changes      `json:"pools"`
+	Features         Changes      `json:"features"`
+	ActiveLeaseDelta float64      `json:"active_lease_delta"`
+	ServerLeases     []LeaseDelta `json:"server_leases"`
+}
+
+type Changes struct {
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+}
+
+// DiffEntry identifies a server, pool or entitlement feature.
+type DiffEntry struct {
+	VirtualGroupID int    `json:"virtual_group_id"`
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+}
+
+// LeaseDelta is the change in active leases on a single server.
+type LeaseDelta struct {
+	ServerID   string  `json:"server_id"`
+	ServerName string  `json:"server_name"`
+	Before     float64 `json:"before"`
+	After      float64 `json:"after"`
+	Delta      float64 `json:"delta"`
+}
+
+// DiffSnapshots compares prev to next. Servers only present in one of them
+// are reported as added or removed rather than as lease deltas.
+func DiffSnapshots(prev, next *cls.Snapshot) Diff {
+	prevServers, nextServers := serverEntries(prev), serverEntries(next)
+	diff := Diff{
+		From:             prev.CollectedAt,
+		To:               next.CollectedAt,
+		Servers:          diffEntries(prevServers, nextServers),
+		Pools:            diffEntries(poolEntries(prev), poolEntries(next)),
+		Features:         diffEntries(featureEntries(prev), featureEntries(next)),
+		ActiveLeaseDelta: next.ActiveLeaseTotal - prev.ActiveLeaseTotal,
+		ServerLeases:     []LeaseDelta{},
+	}
+
+	before := serverLeases(prev)
+	after := serverLeases(next)
+	for key, entry := range nextServers {
+		if _, ok := prevServers[key]; !ok {
+			continue
+		}
+		if delta := after[entry.ID] - before[entry.ID]; delta != 0 {
+			diff.ServerLeases = append(diff.ServerLeases, LeaseDelta{
+				ServerID:   entry.ID,
+				ServerName: entry.Name,
+				Before:     before[entry.ID],
+				After:      after[entry.ID],
+				Delta:      delta,
+			})
+		}
+	}
+	slices.SortFunc(diff.ServerLeases, func(a, b LeaseDelta) int {
+		return cmp.Compare(a.ServerID, b.ServerID)
+	})
+	return diff
+}
+
+func diffEntries(prev, next map[string]DiffEntry) Changes {
+	changes := Changes{Added: []DiffEntry{}, Removed: []DiffEntry{}}
+	for key, entry := range next {
+		if _, ok := prev[key]; !ok {
+			changes.Added = append(changes.Added, entry)
+		}
+	}
+	for key, entry := range prev {
+		if _, ok := next[key]; !ok {
+			changes.Removed = append(changes.Removed, entry)
+		}
+	}
+	slices.SortFunc(changes.Added, compareEntries)
+	slices.SortFunc(changes.Removed, compareEntries)
+	return changes
+}
+
+func compareEntries(a, b DiffEntry) int {
+	return cmp.Or(cmp.Compare(a.VirtualGroupID, b.VirtualGroupID), cmp.Compare(a.ID, b.ID))
+}
+
+func serverEntries(snap *cls.Snapshot) map[string]DiffEntry {
+	entries := make(map[string]DiffEntry, len(snap.ServerUsage))
+	for _, usage := range snap.ServerUsage {
+		entries[usage.ServerID] = DiffEntry{VirtualGroupID: usage.VirtualGroupID, ID: usage.ServerID, Name: usage.ServerName}
+	}
+	return entries
+}
+
+func poolEntries(snap *cls.Snapshot) map[string]DiffEntry {
+	entries := make(map[string]DiffEntry, len(snap.PoolUsage))
+	for _, pool := range snap.PoolUsage {
+		entries[pool.ServerID+"/"+pool.PoolID] = DiffEntry{VirtualGroupID: pool.VirtualGroupID, ID: pool.PoolID, Name: pool.PoolName}
+	}
+	return entries
+}
+
+func featureEntries(snap *cls.Snapshot) map[string]DiffEntry {
+	entries := make(map[string]DiffEntry, len(snap.EntitlementFeatures))
+	for _, feature := range snap.EntitlementFeatures {
+		id := feature.FeatureName
+		if feature.FeatureVersion != "" {
+			id += "@" + feature.FeatureVersion
+		}
+		entries[strconv.Itoa(feature.VirtualGroupID)+"/"+id] = DiffEntry{VirtualGroupID: feature.VirtualGroupID, ID: id, Name: feature.ProductName}
+	}
+	return entries
+}
+
+func serverLeases(snap *cls.Snapshot) map[string]float64 {
+	leases := make(map[string]float64, len(snap.ServerActiveLeases))
+	for _, lease := range snap.ServerActiveLeases {
+		leases[lease.ServerID] += lease.ActiveLeases
+	}
+	return leases
+}
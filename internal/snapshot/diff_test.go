@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	t0 := time.Unix(1700000000, 0).UTC()
+	prev := &cls.Snapshot{
+		CollectedAt: t0,
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, FeatureName: "vGPU", FeatureVersion: "17.0"},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", ServerName: "one"},
+			{VirtualGroupID: 1, ServerID: "srv-2", ServerName: "two"},
+		},
+		ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{
+			{ServerID: "srv-1", ActiveLeases: 5},
+			{ServerID: "srv-2", ActiveLeases: 1},
+		},
+		PoolUsage:        []cls.PoolUsageSnapshot{{VirtualGroupID: 1, ServerID: "srv-1", PoolID: "pool-1"}},
+		ActiveLeaseTotal: 6,
+	}
+	next := &cls.Snapshot{
+		CollectedAt: t0.Add(time.Hour),
+		EntitlementFeatures: []cls.EntitlementFeatureSnapshot{
+			{VirtualGroupID: 1, FeatureName: "vGPU", FeatureVersion: "18.0"},
+		},
+		ServerUsage: []cls.ServerUsageSnapshot{
+			{VirtualGroupID: 1, ServerID: "srv-1", ServerName: "one"},
+			{VirtualGroupID: 1, ServerID: "srv-3", ServerName: "three"},
+		},
+		ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{
+			{ServerID: "srv-1", ActiveLeases: 8},
+			{ServerID: "srv-3", ActiveLeases: 2},
+		},
+		PoolUsage:        []cls.PoolUsageSnapshot{{VirtualGroupID: 1, ServerID: "srv-1", PoolID: "pool-1"}},
+		ActiveLeaseTotal: 10,
+	}
+
+	diff := DiffSnapshots(prev, next)
+	if !diff.From.Equal(t0) || !diff.To.Equal(t0.Add(time.Hour)) {
+		t.Fatalf("unexpected range %s..%s", diff.From, diff.To)
+	}
+	if len(diff.Servers.Added) != 1 || diff.Servers.Added[0].ID != "srv-3" {
+		t.Fatalf("unexpected added servers: %+v", diff.Servers.Added)
+	}
+	if len(diff.Servers.Removed) != 1 || diff.Servers.Removed[0].ID != "srv-2" {
+		t.Fatalf("unexpected removed servers: %+v", diff.Servers.Removed)
+	}
+	if len(diff.Pools.Added) != 0 || len(diff.Pools.Removed) != 0 {
+		t.Fatalf("expected unchanged pools, got %+v", diff.Pools)
+	}
+	if len(diff.Features.Added) != 1 || diff.Features.Added[0].ID != "vGPU@18.0" ||
+		len(diff.Features.Removed) != 1 || diff.Features.Removed[0].ID != "vGPU@17.0" {
+		t.Fatalf("unexpected feature changes: %+v", diff.Features)
+	}
+	if diff.ActiveLeaseDelta != 4 {
+		t.Fatalf("expected lease delta 4, got %v", diff.ActiveLeaseDelta)
+	}
+	if len(diff.ServerLeases) != 1 || diff.ServerLeases[0].ServerID != "srv-1" || diff.ServerLeases[0].Delta != 3 {
+		t.Fatalf("unexpected server lease deltas: %+v", diff.ServerLeases)
+	}
+}
+
+func TestServiceHistoryRetention(t *testing.T) {
+	t0 := time.Unix(1700000000, 0).UTC()
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: t0}},
+			{snapshot: &cls.Snapshot{CollectedAt: t0.Add(time.Minute)}},
+			{snapshot: &cls.Snapshot{CollectedAt: t0.Add(time.Hour)}},
+			{snapshot: &cls.Snapshot{CollectedAt: t0.Add(2 * time.Hour)}},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, HistorySize: 2, HistoryInterval: time.Hour})
+	for range fetcher.results {
+		if _, _, err := svc.Refresh(context.Background()); err != nil {
+			t.Fatalf("refresh error: %v", err)
+		}
+	}
+
+	if _, ok, _ := svc.SnapshotAt(t0.Add(30 * time.Minute)); ok {
+		t.Fatalf("expected the oldest snapshot to be evicted")
+	}
+	snap, ok, err := svc.SnapshotAt(t0.Add(90 * time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("expected retained snapshot, ok=%v err=%v", ok, err)
+	}
+	if !snap.CollectedAt.Equal(t0.Add(time.Hour)) {
+		t.Fatalf("expected snapshot from t0+1h, got %s", snap.CollectedAt)
+	}
+}
@@ -0,0 +1,146 @@
+package snapshot
+
+import (
+	"log/slog"
+	"strings"
+	"unsafe"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+const (
+	SectionEntitlementFeatures       = "entitlement_features"
+	SectionServerFeatureCapacity     = "server_feature_capacity"
+	SectionServerUsage               = "server_usage"
+	SectionServerActiveLeases        = "server_active_leases"
+	SectionServerFeatureActiveLeases = "server_feature_active_leases"
+	SectionPoolUsage                 = "pool_usage"
+)
+
+// Sections lists every snapshot section in a stable order.
+var Sections = []string{
+	SectionEntitlementFeatures,
+	SectionServerFeatureCapacity,
+	SectionServerUsage,
+	SectionServerActiveLeases,
+	SectionServerFeatureActiveLeases,
+	SectionPoolUsage,
+}
+
+// dropOrder is the order in which sections are discarded when a snapshot
+// exceeds the size cap: the most granular, per-client derived data goes
+// first, server inventory and entitlements are never dropped.
+var dropOrder = []string{
+	SectionServerFeatureActiveLeases,
+	SectionPoolUsage,
+	SectionServerActiveLeases,
+	SectionServerFeatureCapacity,
+}
+
+type Footprint struct {
+	Bytes    int
+	Elements map[string]int
+	Dropped  []string
+}
+
+// MeasureFootprint approximates the resident size of snap: struct sizes plus
+// string payloads. It ignores allocator overhead and the cached topology.
+func MeasureFootprint(snap *cls.Snapshot) Footprint {
+	fp := Footprint{Elements: make(map[string]int, len(Sections))}
+	if snap == nil {
+		return fp
+	}
+
+	fp.Bytes = int(unsafe.Sizeof(*snap))
+	for _, section := range Sections {
+		n, size := sectionSize(snap, section)
+		fp.Elements[section] = n
+		fp.Bytes += size
+	}
+	return fp
+}
+
+// enforceMaxBytes returns snap unchanged when it fits in maxBytes, otherwise
+// a shallow copy with sections removed in dropOrder until it fits.
+func enforceMaxBytes(target string, snap *cls.Snapshot, maxBytes int) (*cls.Snapshot, Footprint) {
+	fp := MeasureFootprint(snap)
+	if maxBytes <= 0 || snap == nil || fp.Bytes <= maxBytes {
+		return snap, fp
+	}
+
+	trimmed := *snap
+	var dropped []string
+	for _, section := range dropOrder {
+		if fp.Bytes <= maxBytes {
+			break
+		}
+		if fp.Elements[section] == 0 {
+			continue
+		}
+		clearSection(&trimmed, section)
+		dropped = append(dropped, section)
+		fp = MeasureFootprint(&trimmed)
+	}
+	fp.Dropped = dropped
+
+	slog.Warn("snapshot exceeds max bytes", "org", target, "max_bytes", maxBytes, "bytes", fp.Bytes, "dropped", strings.Join(dropped, ","))
+	return &trimmed, fp
+}
+
+func sectionSize(snap *cls.Snapshot, section string) (int, int) {
+	size := 0
+	switch section {
+	case SectionEntitlementFeatures:
+		for _, item := range snap.EntitlementFeatures {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.FeatureName, item.FeatureVersion, item.ProductName, item.LicenseType)
+		}
+		return len(snap.EntitlementFeatures), size
+	case SectionServerFeatureCapacity:
+		for _, item := range snap.ServerFeatureCapacity {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.ServerID, item.ServerName, item.ServerStatus, item.DeployedOn, item.LeasingMode, item.FeatureName, item.ProductName, item.LicenseType)
+		}
+		return len(snap.ServerFeatureCapacity), size
+	case SectionServerUsage:
+		for _, item := range snap.ServerUsage {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.ServerID, item.ServerName, item.ServerStatus, item.DeployedOn, item.LeasingMode)
+		}
+		return len(snap.ServerUsage), size
+	case SectionServerActiveLeases:
+		for _, item := range snap.ServerActiveLeases {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.ServerID, item.ServerName)
+		}
+		return len(snap.ServerActiveLeases), size
+	case SectionServerFeatureActiveLeases:
+		for _, item := range snap.ServerFeatureActiveLeases {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.ServerID, item.ServerName, item.FeatureName, item.ProductName, item.LicenseType)
+		}
+		return len(snap.ServerFeatureActiveLeases), size
+	case SectionPoolUsage:
+		for _, item := range snap.PoolUsage {
+			size += int(unsafe.Sizeof(item)) + stringsSize(item.VirtualGroupName, item.ServerID, item.ServerName, item.PoolID, item.PoolName, item.FeatureName, item.ProductName, item.LicenseType)
+		}
+		return len(snap.PoolUsage), size
+	}
+	return 0, 0
+}
+
+func clearSection(snap *cls.Snapshot, section string) {
+	switch section {
+	case SectionServerFeatureCapacity:
+		snap.ServerFeatureCapacity = nil
+	case SectionServerActiveLeases:
+		snap.ServerActiveLeases = nil
+	case SectionServerFeatureActiveLeases:
+		snap.ServerFeatureActiveLeases = nil
+	case SectionPoolUsage:
+		snap.PoolUsage = nil
+	}
+}
+
+func stringsSize(values ...string) int {
+	size := 0
+	for _, v := range values {
+		size += len(v)
+	}
+	return size
+}
@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+func TestEnforceMaxBytesDropsGranularSectionsFirst(t *testing.T) {
+	snap := &cls.Snapshot{
+		ServerUsage:               []cls.ServerUsageSnapshot{{ServerID: "srv-1"}},
+		ServerFeatureActiveLeases: make([]cls.ServerFeatureActiveLeaseSnapshot, 100),
+		PoolUsage:                 make([]cls.PoolUsageSnapshot, 2),
+	}
+	full := MeasureFootprint(snap)
+	if full.Elements[SectionServerFeatureActiveLeases] != 100 || full.Elements[SectionServerUsage] != 1 {
+		t.Fatalf("unexpected element counts: %+v", full.Elements)
+	}
+
+	withoutLeases := *snap
+	withoutLeases.ServerFeatureActiveLeases = nil
+	limit := MeasureFootprint(&withoutLeases).Bytes
+
+	trimmed, fp := enforceMaxBytes("org", snap, limit)
+	if trimmed == snap {
+		t.Fatalf("expected a trimmed copy")
+	}
+	if len(fp.Dropped) != 1 || fp.Dropped[0] != SectionServerFeatureActiveLeases {
+		t.Fatalf("expected only feature active leases dropped, got %v", fp.Dropped)
+	}
+	if len(trimmed.PoolUsage) != 2 || len(trimmed.ServerUsage) != 1 {
+		t.Fatalf("expected remaining sections to be kept")
+	}
+	if len(snap.ServerFeatureActiveLeases) != 100 {
+		t.Fatalf("expected original snapshot to be left untouched")
+	}
+
+	same, fp := enforceMaxBytes("org", snap, 0)
+	if same != snap || len(fp.Dropped) != 0 {
+		t.Fatalf("expected no trimming without a cap")
+	}
+}
+
+func TestServiceCompressedSnapshotRoundTrip(t *testing.T) {
+	usage := make([]cls.ServerUsageSnapshot, 500)
+	for i := range usage {
+		usage[i] = cls.ServerUsageSnapshot{VirtualGroupName: "virtual-group", ServerID: "server", ServerName: "license-server", Allocated: 10}
+	}
+	snap := &cls.Snapshot{CollectedAt: time.Now().UTC(), ServerUsage: usage}
+	svc := NewService(&fakeFetcher{results: []fetchResult{{snapshot: snap}}}, Config{Target: "org", CacheTTL: time.Minute, Compress: true})
+
+	if _, _, err := svc.Get(context.Background()); err != nil {
+		t.Fatalf("first get: %v", err)
+	}
+	cached, meta, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("cached get: %v", err)
+	}
+	if !meta.CacheHit || cached == snap {
+		t.Fatalf("expected a materialized copy from the packed cache")
+	}
+	if len(cached.ServerUsage) != 500 || cached.ServerUsage[499].ServerName != "license-server" {
+		t.Fatalf("unexpected materialized snapshot")
+	}
+
+	fp := svc.Footprint()
+	if fp.Elements[SectionServerUsage] != 500 {
+		t.Fatalf("expected element counts to survive packing, got %+v", fp.Elements)
+	}
+	if fp.Bytes >= MeasureFootprint(snap).Bytes {
+		t.Fatalf("expected packed snapshot to be smaller: packed=%d plain=%d", fp.Bytes, MeasureFootprint(snap).Bytes)
+	}
+}
@@ -0,0 +1,52 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// recordHistoryLocked retains cached as a history entry if at least
+// historyInterval has passed since the newest retained one, dropping the
+// oldest entries beyond historySize.
+func (s *Service) recordHistoryLocked(cached *cachedSnapshot) {
+	if s.historySize <= 0 {
+		return
+	}
+	if n := len(s.history); n > 0 && cached.collectedAt.Sub(s.history[n-1].collectedAt) < s.historyInterval {
+		return
+	}
+	s.history = append(s.history, cached)
+	if over := len(s.history) - s.historySize; over > 0 {
+		s.history = append(s.history[:0:0], s.history[over:]...)
+	}
+}
+
+// HistoryEnabled reports whether earlier snapshots are retained.
+func (s *Service) HistoryEnabled() bool {
+	return s.historySize > 0
+}
+
+// SnapshotAt returns the newest retained snapshot collected at or before ts.
+// ok is false when no retained snapshot is old enough.
+func (s *Service) SnapshotAt(ts time.Time) (snap *cls.Snapshot, ok bool, err error) {
+	s.mu.RLock()
+	var found *cachedSnapshot
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if !s.history[i].collectedAt.After(ts) {
+			found = s.history[i]
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if found == nil {
+		return nil, false, nil
+	}
+	snap, err = found.load()
+	if err != nil {
+		return nil, false, fmt.Errorf("load retained snapshot: %w", err)
+	}
+	return s.handOut(snap), true, nil
+}
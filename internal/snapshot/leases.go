@@ -0,0 +1,93 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// LeaseRefresher is implemented by fetchers that can re-fetch only the
+// active-lease sections of an existing snapshot.
+type LeaseRefresher interface {
+	RefreshLeases(ctx context.Context, base *cls.Snapshot) (*cls.Snapshot, error)
+}
+
+var errNoLeaseRefresher = errors.New("fetcher does not support lease-only refresh")
+
+// RefreshLeases merges freshly fetched active leases into the cached
+// snapshot, reusing its server topology. The cache TTL is left untouched, so
+// the next full refresh still happens on schedule. It is a no-op until a
+// full snapshot has been cached, and on standby.
+func (s *Service) RefreshLeases(ctx context.Context) error {
+	if s.standby != nil && s.standby() {
+		return nil
+	}
+	refresher, ok := s.fetcher.(LeaseRefresher)
+	if !ok {
+		return errNoLeaseRefresher
+	}
+
+	_, err, _ := s.sf.Do("leases/"+s.target, func() (interface{}, error) {
+		s.mu.RLock()
+		cached := s.cached
+		s.mu.RUnlock()
+		if cached == nil {
+			return nil, nil
+		}
+		base, err := cached.load()
+		if err != nil {
+			return nil, err
+		}
+
+		merged, err := refresher.RefreshLeases(ctx, base)
+		if err != nil {
+			return nil, err
+		}
+		leases := serverLeases(merged)
+		merged, footprint := enforceMaxBytes(s.target, merged, s.maxBytes)
+		mergedCached, err := s.newCached(merged, &footprint)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// A full refresh may have replaced the snapshot while leases were
+		// being fetched; its data is newer, so keep it.
+		if s.cached == cached {
+			s.cached = mergedCached
+			s.footprint = footprint
+			s.recordChurnLocked(leases)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// RunLeaseRefresh calls RefreshLeases every interval until ctx is done.
+func (s *Service) RunLeaseRefresh(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := s.RefreshLeases(refreshCtx)
+			cancel()
+			if errors.Is(err, errNoLeaseRefresher) {
+				slog.Warn("lease refresh disabled", "org", s.target, "error", err)
+				return
+			}
+			if err != nil {
+				slog.Error("lease refresh failed", "org", s.target, "error", err)
+				s.reportError(ctx, err)
+			}
+		}
+	}
+}
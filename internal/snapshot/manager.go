@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// Manager owns one Service per scrape target and fans operations out to all
+// of them concurrently.
+type Manager struct {
+	services []*Service
+	byTarget map[string]*Service
+}
+
+// Result is the outcome of one service call. Snapshot may be set together
+// with Err when a stale snapshot is served under StaleError.
+type Result struct {
+	Service  *Service
+	Snapshot *cls.Snapshot
+	Meta     Meta
+	Err      error
+}
+
+func NewManager(services ...*Service) (*Manager, error) {
+	if len(services) == 0 {
+		return nil, fmt.Errorf("at least one snapshot service is required")
+	}
+
+	byTarget := make(map[string]*Service, len(services))
+	for _, svc := range services {
+		if _, exists := byTarget[svc.Target()]; exists {
+			return nil, fmt.Errorf("duplicate snapshot target %q", svc.Target())
+		}
+		byTarget[svc.Target()] = svc
+	}
+
+	return &Manager{
+		services: services,
+		byTarget: byTarget,
+	}, nil
+}
+
+// Services returns the managed services in registration order.
+func (m *Manager) Services() []*Service {
+	return m.services
+}
+
+func (m *Manager) Service(target string) (*Service, bool) {
+	svc, ok := m.byTarget[target]
+	return svc, ok
+}
+
+// GetAll calls Get on every service concurrently. Results are returned in
+// registration order; a failing target does not affect the others.
+func (m *Manager) GetAll(ctx context.Context) []Result {
+	return m.each(func(svc *Service) Result {
+		snap, meta, err := svc.Get(ctx)
+		return Result{Service: svc, Snapshot: snap, Meta: meta, Err: err}
+	})
+}
+
+// RefreshAll calls Refresh on every service concurrently.
+func (m *Manager) RefreshAll(ctx context.Context) []Result {
+	return m.each(func(svc *Service) Result {
+		snap, meta, err := svc.Refresh(ctx)
+		return Result{Service: svc, Snapshot: snap, Meta: meta, Err: err}
+	})
+}
+
+// ForceRefreshAll calls ForceRefresh on every service concurrently.
+func (m *Manager) ForceRefreshAll(ctx context.Context) []Result {
+	return m.each(func(svc *Service) Result {
+		snap, meta, err := svc.ForceRefresh(ctx)
+		return Result{Service: svc, Snapshot: snap, Meta: meta, Err: err}
+	})
+}
+
+// RunRefresh runs Service.RunRefresh for every service and blocks until ctx
+// is done.
+func (m *Manager) RunRefresh(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, svc := range m.services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.RunRefresh(ctx, timeout)
+		}()
+	}
+	wg.Wait()
+}
+
+// RunLeaseRefresh runs Service.RunLeaseRefresh for every service and blocks
+// until ctx is done.
+func (m *Manager) RunLeaseRefresh(ctx context.Context, interval, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, svc := range m.services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.RunLeaseRefresh(ctx, interval, timeout)
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *Manager) each(fn func(*Service) Result) []Result {
+	results := make([]Result, len(m.services))
+	var wg sync.WaitGroup
+	for i, svc := range m.services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = fn(svc)
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+func TestNewManagerRejectsDuplicateTargets(t *testing.T) {
+	a := NewService(&fakeFetcher{}, Config{Target: "org-1"})
+	b := NewService(&fakeFetcher{}, Config{Target: "org-1"})
+	if _, err := NewManager(a, b); err == nil {
+		t.Fatalf("expected duplicate target error")
+	}
+	if _, err := NewManager(); err == nil {
+		t.Fatalf("expected error without services")
+	}
+}
+
+func TestManagerGetAllIsolatesTargets(t *testing.T) {
+	now := time.Now().UTC()
+	healthy := NewService(&fakeFetcher{
+		results: []fetchResult{{snapshot: &cls.Snapshot{CollectedAt: now}}},
+	}, Config{Target: "org-1"})
+	broken := NewService(&fakeFetcher{
+		results: []fetchResult{{err: errors.New("boom")}},
+	}, Config{Target: "org-2"})
+
+	manager, err := NewManager(healthy, broken)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	results := manager.GetAll(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Service != healthy || results[0].Err != nil || results[0].Meta.Up != 1 {
+		t.Fatalf("unexpected healthy result: %+v", results[0])
+	}
+	if results[1].Service != broken || results[1].Err == nil {
+		t.Fatalf("unexpected broken result: %+v", results[1])
+	}
+	if svc, ok := manager.Service("org-2"); !ok || svc != broken {
+		t.Fatalf("expected lookup by target to return org-2 service")
+	}
+}
@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+// cachedSnapshot holds the cached snapshot either as-is or, when compression
+// is enabled, gzip-packed so that large fleets do not keep every series
+// resident between scrapes. Packed snapshots are materialized on each read
+// and dropped by the caller once the series have been emitted.
+type cachedSnapshot struct {
+	snap        *cls.Snapshot
+	packed      []byte
+	collectedAt time.Time
+}
+
+func newCachedSnapshot(snap *cls.Snapshot, compress bool) (*cachedSnapshot, error) {
+	if !compress {
+		return &cachedSnapshot{snap: snap, collectedAt: snap.CollectedAt}, nil
+	}
+
+	data, err := cls.EncodeSnapshot(snap)
+	if err != nil {
+		return nil, fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("compress snapshot: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress snapshot: %w", err)
+	}
+
+	return &cachedSnapshot{
+		packed:      bytes.Clone(buf.Bytes()),
+		collectedAt: snap.CollectedAt,
+	}, nil
+}
+
+// load returns the snapshot, decompressing it if needed. A nil receiver
+// yields a nil snapshot.
+func (c *cachedSnapshot) load() (*cls.Snapshot, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.packed == nil {
+		return c.snap, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(c.packed))
+	if err != nil {
+		return nil, fmt.Errorf("decompress snapshot: %w", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress snapshot: %w", err)
+	}
+	return cls.DecodeSnapshot(data)
+}
@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RefreshEvent describes a completed fetch attempt. Cache hits and fetches
+// skipped during backoff do not produce one.
+type RefreshEvent struct {
+	Target   string
+	Duration time.Duration
+	Err      error
+}
+
+// Subscribe calls fn after every fetch attempt until the returned cancel
+// function is called. fn runs on the refreshing goroutine and must not
+// block.
+func (s *Service) Subscribe(fn func(RefreshEvent)) (cancel func()) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[int]func(RefreshEvent))
+	}
+	id := s.nextSub
+	s.nextSub++
+	s.subs[id] = fn
+	return func() {
+		s.subsMu.Lock()
+		delete(s.subs, id)
+		s.subsMu.Unlock()
+	}
+}
+
+func (s *Service) notify(event RefreshEvent) {
+	s.subsMu.Lock()
+	subs := make([]func(RefreshEvent), 0, len(s.subs))
+	for _, fn := range s.subs {
+		subs = append(subs, fn)
+	}
+	s.subsMu.Unlock()
+
+	for _, fn := range subs {
+		fn(event)
+	}
+}
+
+// RunRefresh keeps the snapshot fresh in the background so readers that only
+// observe Latest, such as the OTEL pusher, never drive fetches themselves. It
+// refreshes once per cache TTL, starting with a Get so that a snapshot cached
+// by warm-up or a scrape is reused, and blocks until ctx is done.
+func (s *Service) RunRefresh(ctx context.Context, timeout time.Duration) {
+	refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+	_, _, err := s.Get(refreshCtx)
+	cancel()
+	if err != nil {
+		slog.Error("background refresh failed", "org", s.target, "error", err)
+	}
+
+	ticker := time.NewTicker(s.cacheTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+			_, _, err := s.Refresh(refreshCtx)
+			cancel()
+			if err != nil {
+				slog.Error("background refresh failed", "org", s.target, "error", err)
+			}
+		}
+	}
+}
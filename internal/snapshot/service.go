@@ -2,7 +2,12 @@ package snapshot
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -16,62 +21,265 @@ type Fetcher interface {
 }
 
 type Meta struct {
-	Up              float64
-	DurationSeconds float64
-	Timestamp       time.Time
-	CacheHit        bool
+	Up              float64   `json:"up"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Timestamp       time.Time `json:"timestamp"`
+	CacheHit        bool      `json:"cache_hit"`
+	// Phases holds per-phase timings and item counts of the most recent
+	// successful fetch.
+	Phases []cls.Phase `json:"phases,omitempty"`
+	// LastError is the error of the most recent failed refresh, cleared
+	// once a refresh succeeds.
+	LastError string `json:"last_error,omitempty"`
+	// Err is the underlying error behind LastError, for errors.Is checks.
+	Err error `json:"-"`
+}
+
+// StalePolicy decides what Refresh reports when a fetch fails but a stale
+// snapshot can still be served.
+type StalePolicy string
+
+const (
+	// StaleServe returns the stale snapshot without an error; the failure
+	// is only visible through Meta.
+	StaleServe StalePolicy = "serve"
+	// StaleError returns the stale snapshot together with the fetch error.
+	StaleError StalePolicy = "error"
+)
+
+type Config struct {
+	// Target identifies the scrape target, usually the CLS org name. It
+	// keys in-flight refreshes and labels the target's series.
+	Target   string
+	CacheTTL time.Duration
+	// MaxStale bounds how old a cached snapshot may be before it is no
+	// longer served as a stale fallback. Zero disables the cutoff.
+	MaxStale time.Duration
+	// ValidationTolerance is the fraction by which in-use may exceed
+	// allocated before the snapshot is flagged. Zero uses the default.
+	ValidationTolerance float64
+	// RejectInvalid drops snapshots that fail validation and keeps serving
+	// the previous one as if the fetch had failed.
+	RejectInvalid bool
+	// MaxBytes caps the approximate in-memory size of a cached snapshot.
+	// Oversized snapshots lose their most granular sections first. Zero
+	// disables the cap.
+	MaxBytes int
+	// Store optionally shares snapshots between exporter replicas.
+	Store Store
+	// StoreKeyPrefix namespaces keys in Store. Empty uses the default.
+	StoreKeyPrefix string
+	// Compress keeps the cached snapshot gzip-packed and materializes it
+	// on each read, trading CPU per scrape for resident memory.
+	Compress bool
+	// BackoffInitial is how long refreshes are skipped after a failed
+	// fetch; the window doubles per consecutive failure up to BackoffMax.
+	// Zero disables the backoff.
+	BackoffInitial time.Duration
+	BackoffMax     time.Duration
+	// CopyOnRead hands every caller a deep copy of the snapshot instead of
+	// the shared, read-only instance. Use it when callers need to modify
+	// what they get back.
+	CopyOnRead bool
+	// HistorySize is how many earlier snapshots are retained for diffing,
+	// spaced at least HistoryInterval apart. Zero disables the history.
+	HistorySize     int
+	HistoryInterval time.Duration
+	// StalePolicy selects whether a failed refresh that falls back to a
+	// stale snapshot also returns the error. Empty means StaleServe.
+	StalePolicy StalePolicy
+	// OnError is called with every failed snapshot or lease refresh, for
+	// shipping errors to an external log pipeline. It must not block.
+	OnError func(ctx context.Context, target string, err error)
+	// Standby, if set, reports whether another replica fetches from CLS,
+	// such as the leader of a leader election. While it does, refreshes
+	// only read the snapshot that replica published to Store and fail
+	// with ErrStandby otherwise, without a backoff.
+	Standby func() bool
 }
 
 type Service struct {
+	target   string
 	fetcher  Fetcher
 	cacheTTL time.Duration
+	maxStale time.Duration
 
-	mu       sync.RWMutex
-	snapshot *cls.Snapshot
-	meta     Meta
-	cachedAt time.Time
+	validationTolerance float64
+	rejectInvalid       bool
+	maxBytes            int
+	store               Store
+	storePrefix         string
+	backoffInitial      time.Duration
+	backoffMax          time.Duration
+	compress            bool
+	copyOnRead          bool
+	historySize         int
+	historyInterval     time.Duration
+	stalePolicy         StalePolicy
+	onError             func(ctx context.Context, target string, err error)
+	standby             func() bool
+
+	mu                 sync.RWMutex
+	cached             *cachedSnapshot
+	meta               Meta
+	cachedAt           time.Time
+	validationFailures map[string]float64
+	// validationBaseline holds the server usage of the last fetched
+	// snapshot, accepted or not, for checks that compare consecutive
+	// fetches. A lasting drop is thus rejected once rather than forever.
+	validationBaseline *cls.Snapshot
+	footprint          Footprint
+	backoff            BackoffState
+	history            []*cachedSnapshot
+	serverLeases       map[string]float64
+	leaseChurn         LeaseChurn
+
+	subsMu  sync.Mutex
+	subs    map[int]func(RefreshEvent)
+	nextSub int
+
+	// cacheHits and cacheMisses count Get calls answered from the cache
+	// and those that had to refresh.
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
 
 	sf singleflight.Group
 }
 
-func NewService(fetcher Fetcher, cacheTTL time.Duration) *Service {
+func NewService(fetcher Fetcher, cfg Config) *Service {
+	cacheTTL := cfg.CacheTTL
 	if cacheTTL <= 0 {
 		cacheTTL = defaultCacheTTL
 	}
+	maxStale := cfg.MaxStale
+	if maxStale < 0 {
+		maxStale = 0
+	}
+	tolerance := cfg.ValidationTolerance
+	if tolerance <= 0 {
+		tolerance = defaultValidationTolerance
+	}
+
+	backoffMax := cfg.BackoffMax
+	if backoffMax < cfg.BackoffInitial {
+		backoffMax = cfg.BackoffInitial
+	}
+
+	storePrefix := cfg.StoreKeyPrefix
+	if storePrefix == "" {
+		storePrefix = storeKeyPrefixDefault
+	}
 
 	return &Service{
-		fetcher:  fetcher,
-		cacheTTL: cacheTTL,
+		target:              cfg.Target,
+		fetcher:             fetcher,
+		cacheTTL:            cacheTTL,
+		maxStale:            maxStale,
+		validationTolerance: tolerance,
+		rejectInvalid:       cfg.RejectInvalid,
+		maxBytes:            cfg.MaxBytes,
+		store:               cfg.Store,
+		storePrefix:         storePrefix,
+		backoffInitial:      cfg.BackoffInitial,
+		backoffMax:          backoffMax,
+		compress:            cfg.Compress,
+		copyOnRead:          cfg.CopyOnRead,
+		historySize:         cfg.HistorySize,
+		historyInterval:     cfg.HistoryInterval,
+		stalePolicy:         cfg.StalePolicy,
+		onError:             cfg.OnError,
+		standby:             cfg.Standby,
+		validationFailures:  make(map[string]float64, len(ValidationChecks)),
 	}
 }
 
 func (s *Service) Get(ctx context.Context) (*cls.Snapshot, Meta, error) {
 	s.mu.RLock()
-	snapshot := s.snapshot
+	cached := s.cached
 	meta := s.meta
 	cachedAt := s.cachedAt
 	cacheTTL := s.cacheTTL
+	tooStale := s.tooStaleLocked(time.Now())
 	s.mu.RUnlock()
 
-	if snapshot != nil && time.Since(cachedAt) < cacheTTL {
-		meta.CacheHit = true
-		meta.DurationSeconds = 0
-		return snapshot, meta, nil
+	if cached != nil && !tooStale && time.Since(cachedAt) < cacheTTL {
+		snapshot, err := cached.load()
+		if err == nil {
+			meta.CacheHit = true
+			meta.DurationSeconds = 0
+			s.cacheHits.Add(1)
+			return s.handOut(snapshot), meta, nil
+		}
+		slog.Error("cached snapshot unreadable", "org", s.target, "error", err)
 	}
 
+	s.cacheMisses.Add(1)
 	return s.Refresh(ctx)
 }
 
+// CacheLookups returns how many Get calls were answered from the cache and
+// how many had to refresh, since the service was created.
+func (s *Service) CacheLookups() (hits, misses uint64) {
+	return s.cacheHits.Load(), s.cacheMisses.Load()
+}
+
+// Refresh re-fetches the snapshot. With a shared store configured, a snapshot
+// another replica stored within the cache TTL is reused instead. When the
+// fetch fails and a stale snapshot is served, the error is returned alongside
+// it only under StaleError; it is always available as Meta.Err.
 func (s *Service) Refresh(ctx context.Context) (*cls.Snapshot, Meta, error) {
-	type result struct {
-		snapshot *cls.Snapshot
-		meta     Meta
-	}
+	return s.refresh(ctx, false)
+}
+
+// ForceRefresh re-fetches the snapshot from CLS, ignoring any shared store
+// entry, and publishes the result to the store.
+func (s *Service) ForceRefresh(ctx context.Context) (*cls.Snapshot, Meta, error) {
+	return s.refresh(ctx, true)
+}
+
+type refreshResult struct {
+	snapshot *cls.Snapshot
+	meta     Meta
+}
+
+func (s *Service) refresh(ctx context.Context, force bool) (*cls.Snapshot, Meta, error) {
+	v, err, _ := s.sf.Do("refresh/"+s.target, func() (interface{}, error) {
+		if !force {
+			s.mu.RLock()
+			res, backoffErr, skip := s.backoffResultLocked(time.Now())
+			s.mu.RUnlock()
+			if skip {
+				return res, backoffErr
+			}
+		}
 
-	v, err, _ := s.sf.Do("refresh", func() (interface{}, error) {
 		start := time.Now()
-		fetched, fetchErr := s.fetcher.FetchSnapshot(ctx)
+		fetched, fromStore, fetchErr := s.fetchShared(ctx, force)
 		duration := time.Since(start).Seconds()
+		if errors.Is(fetchErr, ErrStandby) {
+			return s.standbyResult()
+		}
+
+		if fetchErr == nil {
+			if fromStore {
+				s.mu.Lock()
+				s.validationBaseline = newValidationBaseline(fetched)
+				s.mu.Unlock()
+			} else {
+				fetchErr = s.validate(fetched)
+			}
+		}
+		var footprint Footprint
+		var cached *cachedSnapshot
+		var leases map[string]float64
+		if fetchErr == nil {
+			// Before enforceMaxBytes, which may drop the lease section.
+			leases = serverLeases(fetched)
+			fetched, footprint = enforceMaxBytes(s.target, fetched, s.maxBytes)
+			cached, fetchErr = s.newCached(fetched, &footprint)
+		}
+
+		s.notify(RefreshEvent{Target: s.target, Duration: time.Since(start), Err: fetchErr})
 
 		now := time.Now()
 		if fetchErr == nil {
@@ -80,29 +288,62 @@ func (s *Service) Refresh(ctx context.Context) (*cls.Snapshot, Meta, error) {
 				DurationSeconds: duration,
 				Timestamp:       fetched.CollectedAt,
 				CacheHit:        false,
+				Phases:          fetched.Phases,
 			}
 
 			s.mu.Lock()
-			s.snapshot = fetched
+			s.recordFetchLocked(now, nil)
+			s.cached = cached
+			s.recordHistoryLocked(cached)
+			s.recordChurnLocked(leases)
+			s.footprint = footprint
 			s.meta = meta
 			s.cachedAt = now
+			if fromStore {
+				// Expire together with the replica that fetched it.
+				s.cachedAt = fetched.CollectedAt
+			}
 			s.mu.Unlock()
 
-			return result{snapshot: fetched, meta: meta}, nil
+			return refreshResult{snapshot: fetched, meta: meta}, nil
 		}
 
+		s.reportError(ctx, fetchErr)
+
 		s.mu.Lock()
 		defer s.mu.Unlock()
+		s.recordFetchLocked(now, fetchErr)
+		lastPhases := s.meta.Phases
+
+		if s.cached != nil && s.tooStaleLocked(now) {
+			s.meta = Meta{
+				Up:              0,
+				DurationSeconds: duration,
+				Timestamp:       s.cached.collectedAt,
+				CacheHit:        false,
+				Phases:          lastPhases,
+				LastError:       fetchErr.Error(),
+				Err:             fetchErr,
+			}
+			return nil, fmt.Errorf("cached snapshot exceeds max stale %s: %w", s.maxStale, fetchErr)
+		}
 
-		if s.snapshot != nil {
+		if s.cached != nil {
 			staleMeta := Meta{
 				Up:              0,
 				DurationSeconds: duration,
-				Timestamp:       s.snapshot.CollectedAt,
+				Timestamp:       s.cached.collectedAt,
 				CacheHit:        false,
+				Phases:          lastPhases,
+				LastError:       fetchErr.Error(),
+				Err:             fetchErr,
 			}
 			s.meta = staleMeta
-			return result{snapshot: s.snapshot, meta: staleMeta}, nil
+			stale, loadErr := s.cached.load()
+			if loadErr != nil {
+				return nil, fmt.Errorf("load stale snapshot: %w", loadErr)
+			}
+			return refreshResult{snapshot: stale, meta: staleMeta}, nil
 		}
 
 		s.meta = Meta{
@@ -110,6 +351,8 @@ func (s *Service) Refresh(ctx context.Context) (*cls.Snapshot, Meta, error) {
 			DurationSeconds: duration,
 			Timestamp:       now,
 			CacheHit:        false,
+			LastError:       fetchErr.Error(),
+			Err:             fetchErr,
 		}
 		return nil, fetchErr
 	})
@@ -117,18 +360,59 @@ func (s *Service) Refresh(ctx context.Context) (*cls.Snapshot, Meta, error) {
 		return nil, Meta{}, err
 	}
 
-	res := v.(result)
-	return res.snapshot, res.meta, nil
+	res := v.(refreshResult)
+	if s.stalePolicy == StaleError && res.meta.Err != nil {
+		return s.handOut(res.snapshot), res.meta, res.meta.Err
+	}
+	return s.handOut(res.snapshot), res.meta, nil
+}
+
+func (s *Service) reportError(ctx context.Context, err error) {
+	if s.onError != nil {
+		s.onError(ctx, s.target, err)
+	}
+}
+
+func (s *Service) Target() string {
+	return s.target
+}
+
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	s.cachedAt = time.Time{}
+	s.mu.Unlock()
 }
 
 func (s *Service) Latest() (*cls.Snapshot, Meta, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.snapshot == nil {
+	if s.cached == nil {
 		return nil, Meta{}, false
 	}
-	return s.snapshot, s.meta, true
+	if s.tooStaleLocked(time.Now()) {
+		meta := s.meta
+		meta.Up = 0
+		return nil, meta, false
+	}
+	snap, err := s.cached.load()
+	if err != nil {
+		slog.Error("cached snapshot unreadable", "org", s.target, "error", err)
+		return nil, s.meta, false
+	}
+	return s.handOut(snap), s.meta, true
+}
+
+// LastSuccess returns when the cached snapshot was collected, by this or
+// another replica sharing the store, or the zero time before the first
+// successful refresh.
+func (s *Service) LastSuccess() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cached == nil {
+		return time.Time{}
+	}
+	return s.cached.collectedAt
 }
 
 func (s *Service) Meta() Meta {
@@ -136,3 +420,77 @@ func (s *Service) Meta() Meta {
 	defer s.mu.RUnlock()
 	return s.meta
 }
+
+// Footprint returns the approximate size of the cached snapshot.
+func (s *Service) Footprint() Footprint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.footprint
+}
+
+// ValidationFailures returns the cumulative number of failed validations per
+// check name since the service was created.
+func (s *Service) ValidationFailures() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string]float64, len(s.validationFailures))
+	for check, count := range s.validationFailures {
+		out[check] = count
+	}
+	return out
+}
+
+func (s *Service) validate(fetched *cls.Snapshot) error {
+	s.mu.Lock()
+	failed := Validate(s.validationBaseline, fetched, s.validationTolerance)
+	for _, check := range failed {
+		s.validationFailures[check]++
+	}
+	s.validationBaseline = newValidationBaseline(fetched)
+	s.mu.Unlock()
+
+	if len(failed) == 0 {
+		return nil
+	}
+	if !s.rejectInvalid {
+		slog.Warn("snapshot validation failed", "org", s.target, "checks", strings.Join(failed, ","))
+		return nil
+	}
+	return fmt.Errorf("snapshot rejected by validation checks=%s", strings.Join(failed, ","))
+}
+
+// newValidationBaseline keeps only what Validate compares across snapshots.
+func newValidationBaseline(snap *cls.Snapshot) *cls.Snapshot {
+	return &cls.Snapshot{ServerUsage: snap.ServerUsage}
+}
+
+// handOut returns snap as given to callers. Snapshots are shared and must
+// be treated as read-only unless copy-on-read is enabled. Packed snapshots
+// are decoded per read and therefore already private.
+func (s *Service) handOut(snap *cls.Snapshot) *cls.Snapshot {
+	if !s.copyOnRead || s.compress {
+		return snap
+	}
+	return snap.Clone()
+}
+
+func (s *Service) tooStaleLocked(now time.Time) bool {
+	if s.maxStale <= 0 || s.cached == nil {
+		return false
+	}
+	return now.Sub(s.cached.collectedAt) > s.maxStale
+}
+
+// newCached wraps snap for caching and, when it is packed, records the
+// packed size in footprint.
+func (s *Service) newCached(snap *cls.Snapshot, footprint *Footprint) (*cachedSnapshot, error) {
+	cached, err := newCachedSnapshot(snap, s.compress)
+	if err != nil {
+		return nil, err
+	}
+	if cached.packed != nil {
+		footprint.Bytes = len(cached.packed)
+	}
+	return cached, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -51,7 +52,7 @@ func TestServiceGetCacheHit(t *testing.T) {
 			{snapshot: &cls.Snapshot{CollectedAt: now}},
 		},
 	}
-	svc := NewService(fetcher, time.Minute)
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
 
 	snap1, meta1, err := svc.Get(context.Background())
 	if err != nil {
@@ -83,6 +84,9 @@ func TestServiceGetCacheHit(t *testing.T) {
 	if fetcher.CallCount() != 1 {
 		t.Fatalf("expected 1 fetch call, got %d", fetcher.CallCount())
 	}
+	if hits, misses := svc.CacheLookups(); hits != 1 || misses != 1 {
+		t.Fatalf("expected 1 hit and 1 miss, got %d and %d", hits, misses)
+	}
 }
 
 func TestServiceGetCacheExpiry(t *testing.T) {
@@ -94,7 +98,7 @@ func TestServiceGetCacheExpiry(t *testing.T) {
 			{snapshot: &cls.Snapshot{CollectedAt: t1}},
 		},
 	}
-	svc := NewService(fetcher, 20*time.Millisecond)
+	svc := NewService(fetcher, Config{CacheTTL: 20 * time.Millisecond})
 
 	first, _, err := svc.Get(context.Background())
 	if err != nil {
@@ -124,7 +128,7 @@ func TestServiceRefreshStaleFallback(t *testing.T) {
 			{err: errors.New("boom")},
 		},
 	}
-	svc := NewService(fetcher, time.Minute)
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
 
 	first, _, err := svc.Get(context.Background())
 	if err != nil {
@@ -143,13 +147,112 @@ func TestServiceRefreshStaleFallback(t *testing.T) {
 	}
 }
 
+func TestServiceReportsRefreshErrors(t *testing.T) {
+	fetchErr := errors.New("boom")
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{err: fetchErr},
+			{snapshot: &cls.Snapshot{CollectedAt: time.Now()}},
+		},
+	}
+	var reported []error
+	svc := NewService(fetcher, Config{
+		Target:   "org-1",
+		CacheTTL: time.Minute,
+		OnError: func(_ context.Context, target string, err error) {
+			if target != "org-1" {
+				t.Errorf("unexpected target %q", target)
+			}
+			reported = append(reported, err)
+		},
+	})
+
+	if _, _, err := svc.Refresh(context.Background()); err == nil {
+		t.Fatalf("expected refresh error")
+	}
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("second refresh error: %v", err)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], fetchErr) {
+		t.Fatalf("expected one reported error, got %v", reported)
+	}
+}
+
+func TestServiceMetaTracksPhasesAndLastError(t *testing.T) {
+	now := time.Now().UTC()
+	phases := []cls.Phase{{Name: cls.PhaseVirtualGroups, DurationSeconds: 0.5, Items: 2}}
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: now, Phases: phases}},
+			{err: errors.New("boom")},
+			{snapshot: &cls.Snapshot{CollectedAt: now, Phases: phases}},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
+
+	_, meta, err := svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("first refresh error: %v", err)
+	}
+	if len(meta.Phases) != 1 || meta.LastError != "" {
+		t.Fatalf("unexpected meta after success: %+v", meta)
+	}
+
+	_, meta, err = svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("stale refresh error: %v", err)
+	}
+	if meta.LastError != "boom" {
+		t.Fatalf("expected last error boom, got %q", meta.LastError)
+	}
+	if len(meta.Phases) != 1 {
+		t.Fatalf("expected phases of the last success to be kept, got %+v", meta.Phases)
+	}
+
+	_, meta, err = svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("third refresh error: %v", err)
+	}
+	if meta.LastError != "" {
+		t.Fatalf("expected last error to clear, got %q", meta.LastError)
+	}
+}
+
+func TestServiceRefreshStaleErrorPolicy(t *testing.T) {
+	now := time.Now().UTC()
+	boom := errors.New("boom")
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: now}},
+			{err: boom},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, StalePolicy: StaleError})
+
+	first, _, err := svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("first refresh error: %v", err)
+	}
+
+	second, meta, err := svc.Refresh(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected fetch error to propagate, got %v", err)
+	}
+	if second != first {
+		t.Fatalf("expected stale snapshot alongside the error")
+	}
+	if meta.Up != 0 || !errors.Is(meta.Err, boom) {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+}
+
 func TestServiceRefreshErrorWithoutCache(t *testing.T) {
 	fetcher := &fakeFetcher{
 		results: []fetchResult{
 			{err: errors.New("boom")},
 		},
 	}
-	svc := NewService(fetcher, time.Minute)
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
 
 	_, _, err := svc.Get(context.Background())
 	if err == nil {
@@ -164,7 +267,7 @@ func TestServiceRefreshSingleflight(t *testing.T) {
 			{snapshot: &cls.Snapshot{CollectedAt: now}, blockFor: 50 * time.Millisecond},
 		},
 	}
-	svc := NewService(fetcher, time.Minute)
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
 
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
@@ -183,3 +286,347 @@ func TestServiceRefreshSingleflight(t *testing.T) {
 		t.Fatalf("expected single fetch due to singleflight, got %d", fetcher.CallCount())
 	}
 }
+
+func TestServiceInvalidateForcesRefetch(t *testing.T) {
+	now := time.Now().UTC()
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: now}},
+			{snapshot: &cls.Snapshot{CollectedAt: now.Add(time.Second)}},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
+
+	if _, _, err := svc.Get(context.Background()); err != nil {
+		t.Fatalf("first get error: %v", err)
+	}
+	svc.Invalidate()
+
+	_, meta, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("second get error: %v", err)
+	}
+	if meta.CacheHit {
+		t.Fatalf("expected invalidated cache to miss")
+	}
+	if fetcher.CallCount() != 2 {
+		t.Fatalf("expected 2 fetch calls, got %d", fetcher.CallCount())
+	}
+}
+
+func TestServiceRefreshMaxStaleCutoff(t *testing.T) {
+	old := time.Now().UTC().Add(-time.Hour)
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: old}},
+			{err: errors.New("boom")},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, MaxStale: 15 * time.Minute})
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("first refresh error: %v", err)
+	}
+
+	snap, _, err := svc.Refresh(context.Background())
+	if err == nil {
+		t.Fatalf("expected error once cached snapshot exceeds max stale")
+	}
+	if snap != nil {
+		t.Fatalf("expected no snapshot beyond max stale, got %+v", snap)
+	}
+	if meta := svc.Meta(); meta.Up != 0 || !meta.Timestamp.Equal(old) {
+		t.Fatalf("unexpected meta after cutoff: %+v", meta)
+	}
+	if _, _, ok := svc.Latest(); ok {
+		t.Fatalf("expected Latest to withhold snapshot beyond max stale")
+	}
+}
+
+type leaseFetcher struct {
+	fakeFetcher
+	leaseCalls int
+}
+
+func (f *leaseFetcher) RefreshLeases(_ context.Context, base *cls.Snapshot) (*cls.Snapshot, error) {
+	f.mu.Lock()
+	f.leaseCalls++
+	f.mu.Unlock()
+
+	merged := *base
+	merged.ActiveLeaseTotal = base.ActiveLeaseTotal + 1
+	return &merged, nil
+}
+
+func TestServiceRefreshLeasesMergesIntoCache(t *testing.T) {
+	now := time.Now().UTC()
+	fetcher := &leaseFetcher{
+		fakeFetcher: fakeFetcher{
+			results: []fetchResult{
+				{snapshot: &cls.Snapshot{CollectedAt: now, ActiveLeaseTotal: 3}},
+			},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
+
+	if err := svc.RefreshLeases(context.Background()); err != nil {
+		t.Fatalf("lease refresh before first snapshot: %v", err)
+	}
+	if fetcher.leaseCalls != 0 {
+		t.Fatalf("expected no lease fetch without a cached snapshot")
+	}
+
+	if _, _, err := svc.Get(context.Background()); err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	if err := svc.RefreshLeases(context.Background()); err != nil {
+		t.Fatalf("lease refresh error: %v", err)
+	}
+
+	snap, meta, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("get after lease refresh error: %v", err)
+	}
+	if snap.ActiveLeaseTotal != 4 {
+		t.Fatalf("expected merged lease total 4, got %v", snap.ActiveLeaseTotal)
+	}
+	if !meta.CacheHit {
+		t.Fatalf("expected lease refresh to keep the cache warm")
+	}
+	if fetcher.CallCount() != 1 {
+		t.Fatalf("expected a single full fetch, got %d", fetcher.CallCount())
+	}
+}
+
+func TestServiceLeaseChurn(t *testing.T) {
+	leases := func(srv1, srv2 float64) *cls.Snapshot {
+		snap := &cls.Snapshot{CollectedAt: time.Now().UTC(), ServerActiveLeases: []cls.ServerActiveLeaseSnapshot{{ServerID: "srv-1", ActiveLeases: srv1}}}
+		if srv2 > 0 {
+			snap.ServerActiveLeases = append(snap.ServerActiveLeases, cls.ServerActiveLeaseSnapshot{ServerID: "srv-2", ActiveLeases: srv2})
+		}
+		return snap
+	}
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: leases(5, 2)},
+			{snapshot: leases(7, 0)},
+			{err: errors.New("boom")},
+			{snapshot: leases(4, 0)},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute})
+
+	for range fetcher.results {
+		_, _, _ = svc.Refresh(context.Background())
+	}
+	// 5,2 -> 7,- adds 2 and removes 2; the failed fetch is skipped; 7 -> 4
+	// removes 3.
+	if churn := svc.LeaseChurn(); churn != (LeaseChurn{Added: 2, Removed: 5}) {
+		t.Fatalf("unexpected lease churn: %+v", churn)
+	}
+}
+
+func TestServiceRunRefreshNotifiesSubscribers(t *testing.T) {
+	fetcher := &churnFetcher{}
+	svc := NewService(fetcher, Config{Target: "org-1", CacheTTL: 10 * time.Millisecond})
+
+	var events atomic.Int64
+	cancelSub := svc.Subscribe(func(event RefreshEvent) {
+		if event.Target != "org-1" || event.Err != nil {
+			t.Errorf("unexpected refresh event: %+v", event)
+		}
+		events.Add(1)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	svc.RunRefresh(ctx, time.Second)
+	cancelSub()
+
+	fetches := fetcher.calls.Load()
+	if fetches < 3 {
+		t.Fatalf("expected repeated background fetches, got %d", fetches)
+	}
+	if events.Load() != fetches {
+		t.Fatalf("expected one event per fetch, got %d events for %d fetches", events.Load(), fetches)
+	}
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if events.Load() != fetches {
+		t.Fatalf("expected no events after unsubscribing")
+	}
+}
+
+func TestServiceBackoffSkipsFetches(t *testing.T) {
+	now := time.Now().UTC()
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{CollectedAt: now}},
+			{err: errors.New("boom")},
+			{err: errors.New("boom")},
+			{snapshot: &cls.Snapshot{CollectedAt: now.Add(time.Second)}},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Millisecond, BackoffInitial: time.Hour, BackoffMax: 2 * time.Hour})
+
+	first, _, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("first get error: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := svc.Get(context.Background()); err != nil {
+		t.Fatalf("expected stale fallback, got %v", err)
+	}
+
+	backoff := svc.Backoff()
+	if backoff.ConsecutiveFailures != 1 || !backoff.Active(time.Now()) {
+		t.Fatalf("expected active backoff after one failure, got %+v", backoff)
+	}
+
+	snap, meta, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("expected stale snapshot during backoff, got %v", err)
+	}
+	if snap != first || meta.Up != 0 {
+		t.Fatalf("expected stale snapshot with up=0 during backoff")
+	}
+	if fetcher.CallCount() != 2 {
+		t.Fatalf("expected backoff to skip fetching, got %d calls", fetcher.CallCount())
+	}
+
+	if _, _, err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("force refresh error: %v", err)
+	}
+	if got := svc.Backoff(); got.ConsecutiveFailures != 2 || got.Until.Sub(time.Now()) < time.Hour {
+		t.Fatalf("expected doubled backoff window, got %+v", got)
+	}
+
+	if _, _, err := svc.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("force refresh error: %v", err)
+	}
+	if got := svc.Backoff(); got.ConsecutiveFailures != 0 || got.Active(time.Now()) {
+		t.Fatalf("expected backoff reset after success, got %+v", got)
+	}
+}
+
+func TestServiceCopyOnRead(t *testing.T) {
+	now := time.Now().UTC()
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: &cls.Snapshot{
+				CollectedAt: now,
+				ServerUsage: []cls.ServerUsageSnapshot{{ServerID: "srv-1", InUse: 3}},
+			}},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, CopyOnRead: true})
+
+	first, _, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("first get error: %v", err)
+	}
+	first.ServerUsage[0].InUse = 99
+
+	second, _, err := svc.Get(context.Background())
+	if err != nil {
+		t.Fatalf("second get error: %v", err)
+	}
+	if second == first {
+		t.Fatalf("expected a private copy per read")
+	}
+	if second.ServerUsage[0].InUse != 3 {
+		t.Fatalf("mutation leaked into the cache: %+v", second.ServerUsage[0])
+	}
+	latest, _, ok := svc.Latest()
+	if !ok || latest == second {
+		t.Fatalf("expected Latest to return a private copy")
+	}
+}
+
+// TestServiceConcurrentReadersAndRefreshes is meant to run under -race: it
+// reads every section of served snapshots while full and lease-only
+// refreshes replace the cache.
+func TestServiceConcurrentReadersAndRefreshes(t *testing.T) {
+	for _, cfg := range []Config{
+		{CacheTTL: time.Millisecond},
+		{CacheTTL: time.Millisecond, Compress: true},
+		{CacheTTL: time.Millisecond, CopyOnRead: true},
+	} {
+		fetcher := &churnFetcher{}
+		svc := NewService(fetcher, cfg)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					snap, _, err := svc.Get(ctx)
+					if err == nil && snap != nil {
+						readSnapshot(snap)
+					}
+					if latest, _, ok := svc.Latest(); ok {
+						readSnapshot(latest)
+					}
+				}
+			}()
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_ = svc.RefreshLeases(ctx)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_, _, _ = svc.ForceRefresh(ctx)
+			}
+		}()
+		wg.Wait()
+		cancel()
+	}
+}
+
+// churnFetcher returns a new snapshot on every call.
+type churnFetcher struct {
+	calls atomic.Int64
+}
+
+func (f *churnFetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	n := float64(f.calls.Add(1))
+	now := time.Now().UTC()
+	return &cls.Snapshot{
+		CollectedAt:       now,
+		LeasesCollectedAt: now,
+		ServerUsage:       []cls.ServerUsageSnapshot{{ServerID: "srv-1", InUse: n}},
+		PoolUsage:         []cls.PoolUsageSnapshot{{PoolID: "pool-1", InUse: n}},
+		ActiveLeaseTotal:  n,
+	}, nil
+}
+
+func (f *churnFetcher) RefreshLeases(_ context.Context, base *cls.Snapshot) (*cls.Snapshot, error) {
+	merged := *base
+	merged.LeasesCollectedAt = time.Now().UTC()
+	merged.ActiveLeaseTotal = float64(f.calls.Add(1))
+	merged.ServerFeatureActiveLeases = []cls.ServerFeatureActiveLeaseSnapshot{{ServerID: "srv-1", ActiveLeases: merged.ActiveLeaseTotal}}
+	return &merged, nil
+}
+
+func readSnapshot(snap *cls.Snapshot) float64 {
+	total := snap.ActiveLeaseTotal
+	for _, usage := range snap.ServerUsage {
+		total += usage.InUse
+	}
+	for _, pool := range snap.PoolUsage {
+		total += pool.InUse
+	}
+	for _, lease := range snap.ServerFeatureActiveLeases {
+		total += lease.ActiveLeases
+	}
+	return total
+}
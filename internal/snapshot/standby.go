@@ -0,0 +1,35 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStandby is returned by refreshes on standby when the shared store has
+// no fresh snapshot; see Config.Standby.
+var ErrStandby = errors.New("standby: another replica fetches from CLS")
+
+// standbyResult serves the cached snapshot, marked as not up, when a
+// refresh on standby found nothing new in the store. Unlike a failed fetch
+// it starts no backoff, so the store is read again on the next refresh.
+func (s *Service) standbyResult() (refreshResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached == nil {
+		return refreshResult{}, ErrStandby
+	}
+	if s.tooStaleLocked(time.Now()) {
+		return refreshResult{}, fmt.Errorf("cached snapshot exceeds max stale %s: %w", s.maxStale, ErrStandby)
+	}
+	s.meta.Up = 0
+	s.meta.CacheHit = false
+	s.meta.LastError = ErrStandby.Error()
+	s.meta.Err = ErrStandby
+	snap, err := s.cached.load()
+	if err != nil {
+		return refreshResult{}, fmt.Errorf("load stale snapshot: %w", err)
+	}
+	return refreshResult{snapshot: snap, meta: s.meta}, nil
+}
@@ -0,0 +1,143 @@
+package snapshot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+const (
+	defaultStoreLockTTL   = 30 * time.Second
+	storePollInterval     = 250 * time.Millisecond
+	storeSnapshotKeyStem  = "snapshot/"
+	storeLockKeyStem      = "lock/"
+	storeKeyPrefixDefault = "nvidia-license-server-exporter/"
+)
+
+// Store is a shared key/value cache that lets several exporter replicas
+// reuse one CLS fetch per cache TTL.
+type Store interface {
+	// Get returns the value for key, or ok=false when it does not exist.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// TryLock acquires key for ttl if nobody holds it.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, key string) error
+}
+
+// fetchShared fetches a snapshot, consulting the shared store first unless
+// force is set. fromStore reports whether the snapshot came from another
+// replica's fetch.
+//
+// On standby, only the store is read, even with force, and ErrStandby is
+// returned when it holds no fresh snapshot.
+func (s *Service) fetchShared(ctx context.Context, force bool) (snap *cls.Snapshot, fromStore bool, err error) {
+	snapshotKey := s.storePrefix + storeSnapshotKeyStem + s.target
+	lockKey := s.storePrefix + storeLockKeyStem + s.target
+
+	if s.standby != nil && s.standby() {
+		if s.store != nil {
+			if shared := s.loadShared(ctx, snapshotKey); shared != nil {
+				return shared, true, nil
+			}
+		}
+		return nil, false, ErrStandby
+	}
+	if s.store == nil {
+		snap, err = s.fetcher.FetchSnapshot(ctx)
+		return snap, false, err
+	}
+
+	if !force {
+		if shared := s.loadShared(ctx, snapshotKey); shared != nil {
+			return shared, true, nil
+		}
+	}
+
+	locked, lockErr := s.store.TryLock(ctx, lockKey, storeLockTTL(ctx))
+	if lockErr != nil {
+		slog.WarnContext(ctx, "shared cache lock failed", "org", s.target, "error", lockErr)
+	}
+	if lockErr == nil && !locked && !force {
+		if shared := s.waitShared(ctx, snapshotKey); shared != nil {
+			return shared, true, nil
+		}
+	}
+	if locked {
+		defer func() {
+			if unlockErr := s.store.Unlock(context.WithoutCancel(ctx), lockKey); unlockErr != nil {
+				slog.WarnContext(ctx, "shared cache unlock failed", "org", s.target, "error", unlockErr)
+			}
+		}()
+	}
+
+	snap, err = s.fetcher.FetchSnapshot(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, encodeErr := cls.EncodeSnapshot(snap)
+	if encodeErr != nil {
+		slog.WarnContext(ctx, "shared cache encode failed", "org", s.target, "error", encodeErr)
+		return snap, false, nil
+	}
+	if setErr := s.store.Set(ctx, snapshotKey, data, s.cacheTTL); setErr != nil {
+		slog.WarnContext(ctx, "shared cache write failed", "org", s.target, "error", setErr)
+	}
+	return snap, false, nil
+}
+
+// loadShared returns the stored snapshot if it is still within the cache TTL.
+func (s *Service) loadShared(ctx context.Context, key string) *cls.Snapshot {
+	data, ok, err := s.store.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "shared cache read failed", "org", s.target, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	snap, err := cls.DecodeSnapshot(data)
+	if err != nil {
+		slog.WarnContext(ctx, "shared cache decode failed", "org", s.target, "error", err)
+		return nil
+	}
+	if time.Since(snap.CollectedAt) >= s.cacheTTL {
+		return nil
+	}
+	return snap
+}
+
+// waitShared polls the store while another replica holds the fetch lock.
+// It gives up when the lock is released without a fresh snapshot appearing
+// or ctx is done, in which case the caller fetches on its own.
+func (s *Service) waitShared(ctx context.Context, key string) *cls.Snapshot {
+	ticker := time.NewTicker(storePollInterval)
+	defer ticker.Stop()
+
+	lockKey := s.storePrefix + storeLockKeyStem + s.target
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if shared := s.loadShared(ctx, key); shared != nil {
+			return shared
+		}
+		if _, held, err := s.store.Get(ctx, lockKey); err != nil || !held {
+			return nil
+		}
+	}
+}
+
+func storeLockTTL(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 {
+			return remaining
+		}
+	}
+	return defaultStoreLockTTL
+}
@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{values: make(map[string][]byte)}
+}
+
+func (m *memoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	return value, ok, nil
+}
+
+func (m *memoryStore) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memoryStore) TryLock(_ context.Context, key string, _ time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, held := m.values[key]; held {
+		return false, nil
+	}
+	m.values[key] = []byte("1")
+	return true, nil
+}
+
+func (m *memoryStore) Unlock(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func TestServiceSharesSnapshotsThroughStore(t *testing.T) {
+	now := time.Now().UTC()
+	store := newMemoryStore()
+	first := &fakeFetcher{results: []fetchResult{{snapshot: &cls.Snapshot{CollectedAt: now, ActiveLeaseTotal: 7}}}}
+	second := &fakeFetcher{results: []fetchResult{{snapshot: &cls.Snapshot{CollectedAt: now}}}}
+
+	replicaA := NewService(first, Config{Target: "org-1", CacheTTL: time.Minute, Store: store})
+	replicaB := NewService(second, Config{Target: "org-1", CacheTTL: time.Minute, Store: store})
+
+	if _, _, err := replicaA.Get(context.Background()); err != nil {
+		t.Fatalf("replica A get: %v", err)
+	}
+	snap, meta, err := replicaB.Get(context.Background())
+	if err != nil {
+		t.Fatalf("replica B get: %v", err)
+	}
+	if second.CallCount() != 0 {
+		t.Fatalf("expected replica B to reuse the shared snapshot, got %d fetches", second.CallCount())
+	}
+	if snap.ActiveLeaseTotal != 7 || meta.Up != 1 {
+		t.Fatalf("unexpected shared snapshot: total=%v meta=%+v", snap.ActiveLeaseTotal, meta)
+	}
+
+	if _, _, err := replicaB.ForceRefresh(context.Background()); err != nil {
+		t.Fatalf("replica B force refresh: %v", err)
+	}
+	if second.CallCount() != 1 {
+		t.Fatalf("expected force refresh to bypass the shared snapshot, got %d fetches", second.CallCount())
+	}
+}
+
+func TestServiceStandbyReadsOnlyTheStore(t *testing.T) {
+	now := time.Now().UTC()
+	store := newMemoryStore()
+	leader := &fakeFetcher{results: []fetchResult{{snapshot: &cls.Snapshot{CollectedAt: now, ActiveLeaseTotal: 7}}}}
+	follower := &fakeFetcher{}
+	var standby atomic.Bool
+	standby.Store(true)
+
+	alone := NewService(follower, Config{Target: "org-1", CacheTTL: time.Minute, BackoffInitial: time.Hour, Standby: standby.Load})
+	if _, _, err := alone.Get(context.Background()); !errors.Is(err, ErrStandby) {
+		t.Fatalf("expected ErrStandby without a store, got %v", err)
+	}
+	if alone.Backoff().ConsecutiveFailures != 0 {
+		t.Fatalf("standby started a backoff: %+v", alone.Backoff())
+	}
+
+	replicaA := NewService(leader, Config{Target: "org-1", CacheTTL: time.Minute, Store: store})
+	replicaB := NewService(follower, Config{Target: "org-1", CacheTTL: time.Minute, Store: store, Standby: standby.Load})
+	if _, _, err := replicaB.ForceRefresh(context.Background()); !errors.Is(err, ErrStandby) {
+		t.Fatalf("expected ErrStandby before the leader stored a snapshot, got %v", err)
+	}
+	if _, _, err := replicaA.Get(context.Background()); err != nil {
+		t.Fatalf("leader get: %v", err)
+	}
+	snap, meta, err := replicaB.ForceRefresh(context.Background())
+	if err != nil || snap.ActiveLeaseTotal != 7 || meta.Up != 1 {
+		t.Fatalf("follower refresh = %+v, %+v, %v; want the leader's snapshot", snap, meta, err)
+	}
+	if follower.CallCount() != 0 {
+		t.Fatalf("follower fetched from CLS %d times", follower.CallCount())
+	}
+
+	standby.Store(false)
+	follower.results = []fetchResult{{snapshot: &cls.Snapshot{CollectedAt: now}}}
+	if _, _, err := replicaB.ForceRefresh(context.Background()); err != nil || follower.CallCount() != 1 {
+		t.Fatalf("new leader refresh: %v after %d fetches", err, follower.CallCount())
+	}
+}
@@ -0,0 +1,87 @@
+package snapshot
+
+import "nvidia-license-server-exporter/internal/cls"
+
+const (
+	CheckNegativeQuantity      = "negative_quantity"
+	CheckInUseExceedsAllocated = "in_use_exceeds_allocated"
+	CheckServerCountDrop       = "server_count_drop"
+
+	defaultValidationTolerance = 0.05
+)
+
+// ValidationChecks lists every check name Validate can report, in a stable
+// order, so callers can expose zero-valued series for checks that never failed.
+var ValidationChecks = []string{
+	CheckNegativeQuantity,
+	CheckInUseExceedsAllocated,
+	CheckServerCountDrop,
+}
+
+// Validate runs sanity checks against next, using prev (which may be nil) as
+// the baseline for checks that compare consecutive snapshots. tolerance is the
+// fraction by which in-use may exceed allocated before it is flagged. The
+// returned slice holds the names of the failed checks, each at most once.
+func Validate(prev, next *cls.Snapshot, tolerance float64) []string {
+	if next == nil {
+		return nil
+	}
+	if tolerance < 0 {
+		tolerance = 0
+	}
+
+	failed := make([]string, 0, len(ValidationChecks))
+	if hasNegativeQuantity(next) {
+		failed = append(failed, CheckNegativeQuantity)
+	}
+	if inUseExceedsAllocated(next, tolerance) {
+		failed = append(failed, CheckInUseExceedsAllocated)
+	}
+	if prev != nil && len(prev.ServerUsage) > 0 && float64(len(next.ServerUsage)) < float64(len(prev.ServerUsage))/2 {
+		failed = append(failed, CheckServerCountDrop)
+	}
+	return failed
+}
+
+func hasNegativeQuantity(snap *cls.Snapshot) bool {
+	for _, item := range snap.EntitlementFeatures {
+		if item.TotalQuantity < 0 || item.InUseQuantity < 0 || item.Unassigned < 0 {
+			return true
+		}
+	}
+	for _, item := range snap.ServerFeatureCapacity {
+		if item.TotalQuantity < 0 {
+			return true
+		}
+	}
+	for _, item := range snap.ServerUsage {
+		if item.Allocated < 0 || item.InUse < 0 {
+			return true
+		}
+	}
+	for _, item := range snap.PoolUsage {
+		if item.Allocated < 0 || item.InUse < 0 {
+			return true
+		}
+	}
+	for _, item := range snap.ServerFeatureActiveLeases {
+		if item.ActiveLeases < 0 {
+			return true
+		}
+	}
+	return snap.ActiveLeaseTotal < 0
+}
+
+func inUseExceedsAllocated(snap *cls.Snapshot, tolerance float64) bool {
+	for _, item := range snap.ServerUsage {
+		if item.Allocated > 0 && item.InUse > item.Allocated*(1+tolerance) {
+			return true
+		}
+	}
+	for _, item := range snap.PoolUsage {
+		if item.Allocated > 0 && item.InUse > item.Allocated*(1+tolerance) {
+			return true
+		}
+	}
+	return false
+}
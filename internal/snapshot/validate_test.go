@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+)
+
+func TestValidateChecks(t *testing.T) {
+	servers := func(n int) []cls.ServerUsageSnapshot {
+		out := make([]cls.ServerUsageSnapshot, n)
+		for i := range out {
+			out[i] = cls.ServerUsageSnapshot{Allocated: 10, InUse: 5}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		prev *cls.Snapshot
+		next *cls.Snapshot
+		want []string
+	}{
+		{
+			name: "healthy snapshot",
+			prev: &cls.Snapshot{ServerUsage: servers(4)},
+			next: &cls.Snapshot{ServerUsage: servers(3)},
+			want: []string{},
+		},
+		{
+			name: "negative entitlement",
+			next: &cls.Snapshot{EntitlementFeatures: []cls.EntitlementFeatureSnapshot{{TotalQuantity: -1}}},
+			want: []string{CheckNegativeQuantity},
+		},
+		{
+			name: "in use within tolerance",
+			next: &cls.Snapshot{PoolUsage: []cls.PoolUsageSnapshot{{Allocated: 100, InUse: 104}}},
+			want: []string{},
+		},
+		{
+			name: "in use beyond tolerance",
+			next: &cls.Snapshot{PoolUsage: []cls.PoolUsageSnapshot{{Allocated: 100, InUse: 120}}},
+			want: []string{CheckInUseExceedsAllocated},
+		},
+		{
+			name: "server count halves",
+			prev: &cls.Snapshot{ServerUsage: servers(5)},
+			next: &cls.Snapshot{ServerUsage: servers(2)},
+			want: []string{CheckServerCountDrop},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate(tt.prev, tt.next, defaultValidationTolerance)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServiceRejectInvalidKeepsPrevious(t *testing.T) {
+	now := time.Now().UTC()
+	good := &cls.Snapshot{CollectedAt: now, ServerUsage: []cls.ServerUsageSnapshot{{Allocated: 1}, {Allocated: 1}}}
+	bad := &cls.Snapshot{CollectedAt: now.Add(time.Second)}
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: good},
+			{snapshot: bad},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, RejectInvalid: true})
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("first refresh error: %v", err)
+	}
+	snap, meta, err := svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("expected stale fallback, got %v", err)
+	}
+	if snap != good || meta.Up != 0 {
+		t.Fatalf("expected previous snapshot with up=0, got snap=%p meta=%+v", snap, meta)
+	}
+	if got := svc.ValidationFailures()[CheckServerCountDrop]; got != 1 {
+		t.Fatalf("expected 1 server_count_drop failure, got %v", got)
+	}
+}
+
+func TestServiceRejectInvalidAcceptsPersistentDrop(t *testing.T) {
+	now := time.Now().UTC()
+	servers := func(n int) []cls.ServerUsageSnapshot {
+		return make([]cls.ServerUsageSnapshot, n)
+	}
+	before := &cls.Snapshot{CollectedAt: now, ServerUsage: servers(6)}
+	dropped := &cls.Snapshot{CollectedAt: now.Add(time.Second), ServerUsage: servers(2)}
+	stillDropped := &cls.Snapshot{CollectedAt: now.Add(2 * time.Second), ServerUsage: servers(2)}
+	fetcher := &fakeFetcher{
+		results: []fetchResult{
+			{snapshot: before},
+			{snapshot: dropped},
+			{snapshot: stillDropped},
+		},
+	}
+	svc := NewService(fetcher, Config{CacheTTL: time.Minute, RejectInvalid: true})
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("first refresh error: %v", err)
+	}
+	snap, meta, err := svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("expected stale fallback, got %v", err)
+	}
+	if snap != before || meta.Up != 0 {
+		t.Fatalf("expected the drop to be rejected once, got snap=%p meta=%+v", snap, meta)
+	}
+	snap, meta, err = svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("third refresh error: %v", err)
+	}
+	if snap != stillDropped || meta.Up != 1 {
+		t.Fatalf("expected the lasting drop to be accepted, got snap=%p meta=%+v", snap, meta)
+	}
+	if got := svc.ValidationFailures()[CheckServerCountDrop]; got != 1 {
+		t.Fatalf("expected 1 server_count_drop failure, got %v", got)
+	}
+}
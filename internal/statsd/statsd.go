@@ -0,0 +1,223 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"nvidia-license-server-exporter/internal/exporter"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+const (
+	// TagsDogStatsD appends tags as |#key:value,...; TagsNone sends plain
+	// statsd lines for servers that do not understand tags.
+	TagsDogStatsD = "dogstatsd"
+	TagsNone      = "none"
+
+	// Payload limits that keep a datagram from being fragmented or
+	// truncated: the usual Ethernet MTU minus headers for UDP, and the
+	// DogStatsD agent's default buffer for Unix sockets.
+	maxUDPPayload  = 1432
+	maxUnixPayload = 8192
+
+	writeTimeout = 100 * time.Millisecond
+)
+
+type Config struct {
+	// Address is host:port or udp://host:port for UDP, or unix:///path for
+	// a Unix datagram socket.
+	Address string
+	// Prefix is prepended to every metric name, e.g. "licensing.".
+	Prefix string
+	// Tags is TagsDogStatsD (default) or TagsNone.
+	Tags string
+	// GlobalTags are added to every metric, e.g. env:prod.
+	GlobalTags []string
+}
+
+// Emitter sends the core gauges of a target after each of its refreshes.
+type Emitter struct {
+	cfg        Config
+	manager    *snapshot.Manager
+	network    string
+	address    string
+	maxPayload int
+
+	conn        net.Conn
+	pending     chan *snapshot.Service
+	unsubscribe []func()
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewEmitter(cfg Config, manager *snapshot.Manager) (*Emitter, error) {
+	network, address, err := parseAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Tags = strings.ToLower(strings.TrimSpace(cfg.Tags))
+	if cfg.Tags == "" {
+		cfg.Tags = TagsDogStatsD
+	}
+	if cfg.Tags != TagsDogStatsD && cfg.Tags != TagsNone {
+		return nil, fmt.Errorf("unsupported statsd tags %q: use %s or %s", cfg.Tags, TagsDogStatsD, TagsNone)
+	}
+
+	maxPayload := maxUDPPayload
+	if network == "unixgram" {
+		maxPayload = maxUnixPayload
+	}
+	return &Emitter{
+		cfg:        cfg,
+		manager:    manager,
+		network:    network,
+		address:    address,
+		maxPayload: maxPayload,
+		pending:    make(chan *snapshot.Service, len(manager.Services())),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func parseAddress(raw string) (network, address string, err error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return "", "", fmt.Errorf("statsd address is required")
+	case strings.HasPrefix(raw, "unix://"):
+		return "unixgram", strings.TrimPrefix(raw, "unix://"), nil
+	case strings.HasPrefix(raw, "udp://"):
+		raw = strings.TrimPrefix(raw, "udp://")
+	}
+	if _, _, err := net.SplitHostPort(raw); err != nil {
+		return "", "", fmt.Errorf("invalid statsd address %q: %w", raw, err)
+	}
+	return "udp", raw, nil
+}
+
+// Start subscribes to every target's refreshes. Sending happens on a
+// separate goroutine so a slow socket never delays a refresh; when it falls
+// behind, a target's pending update is coalesced with the next one.
+func (e *Emitter) Start() {
+	for _, svc := range e.manager.Services() {
+		e.unsubscribe = append(e.unsubscribe, svc.Subscribe(func(snapshot.RefreshEvent) {
+			select {
+			case e.pending <- svc:
+			default:
+			}
+		}))
+	}
+
+	go func() {
+		defer close(e.done)
+		for {
+			select {
+			case <-e.stop:
+				return
+			case svc := <-e.pending:
+				if err := e.Emit(svc); err != nil {
+					slog.Error("statsd emit failed", "org", svc.Target(), "address", e.cfg.Address, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (e *Emitter) Shutdown(ctx context.Context) error {
+	for _, cancel := range e.unsubscribe {
+		cancel()
+	}
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if e.conn != nil {
+		return e.conn.Close()
+	}
+	return nil
+}
+
+// Emit sends the current gauges of svc. It is not safe for concurrent use.
+func (e *Emitter) Emit(svc *snapshot.Service) error {
+	for _, packet := range pack(e.lines(svc), e.maxPayload) {
+		if err := e.write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write sends one datagram, dialing lazily so that an agent restart, which
+// recreates its Unix socket, is picked up on the next emit.
+func (e *Emitter) write(packet []byte) error {
+	if e.conn == nil {
+		conn, err := net.Dial(e.network, e.address)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	_ = e.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := e.conn.Write(packet); err != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (e *Emitter) lines(svc *snapshot.Service) []string {
+	samples := exporter.CoreSamples(svc)
+	lines := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		line := e.cfg.Prefix + sample.Name + ":" + strconv.FormatFloat(sample.Value, 'f', -1, 64) + "|g"
+		if e.cfg.Tags == TagsDogStatsD {
+			tags := make([]string, 0, len(sample.Labels)+len(e.cfg.GlobalTags))
+			for _, label := range sample.Labels {
+				tags = append(tags, label.Name+":"+tagValue(label.Value))
+			}
+			line += "|#" + strings.Join(append(tags, e.cfg.GlobalTags...), ",")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "\n", "_", " ", "_")
+
+// tagValue replaces the characters that delimit tags and lines.
+func tagValue(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "unknown"
+	}
+	return tagReplacer.Replace(v)
+}
+
+// pack joins lines into newline-separated datagrams of at most limit bytes.
+// A single line longer than limit is sent on its own.
+func pack(lines []string, limit int) [][]byte {
+	var packets [][]byte
+	var current []byte
+	for _, line := range lines {
+		if len(current) > 0 && len(current)+1+len(line) > limit {
+			packets = append(packets, current)
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+	}
+	if len(current) > 0 {
+		packets = append(packets, current)
+	}
+	return packets
+}
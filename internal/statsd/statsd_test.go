@@ -0,0 +1,75 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"nvidia-license-server-exporter/internal/cls"
+	"nvidia-license-server-exporter/internal/snapshot"
+)
+
+type fetcher struct{}
+
+func (fetcher) FetchSnapshot(context.Context) (*cls.Snapshot, error) {
+	return &cls.Snapshot{
+		CollectedAt: time.Now().UTC(),
+		ServerFeatureActiveLeases: []cls.ServerFeatureActiveLeaseSnapshot{
+			{ServerName: "server 1", FeatureName: "vGPU", LicenseType: "CONCURRENT_COUNTED_SINGLE", ActiveLeases: 7},
+		},
+	}, nil
+}
+
+func TestEmitterSendsGaugesAfterRefresh(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	svc := snapshot.NewService(fetcher{}, snapshot.Config{Target: "org-1", CacheTTL: time.Minute})
+	manager, err := snapshot.NewManager(svc)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	emitter, err := NewEmitter(Config{Address: "udp://" + conn.LocalAddr().String(), Prefix: "lic.", GlobalTags: []string{"env:test"}}, manager)
+	if err != nil {
+		t.Fatalf("new emitter: %v", err)
+	}
+	emitter.Start()
+	defer func() { _ = emitter.Shutdown(context.Background()) }()
+
+	if _, _, err := svc.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxUDPPayload)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	got := string(buf[:n])
+	for _, want := range []string{
+		"lic.nvidia_cls_up:1|g|#org_name:org-1,env:test",
+		"lic.nvidia_cls_license_server_feature_active_leases:7|g|#org_name:org-1,virtual_group_id:0,virtual_group_name:unknown," +
+			"server_id:unknown,server_name:server_1,feature_name:vGPU,product_name:unknown,license_type:CONCURRENT_COUNTED_SINGLE,env:test",
+	} {
+		if !strings.Contains(got, want+"\n") && !strings.HasSuffix(got, want) {
+			t.Fatalf("missing line %q in packet:\n%s", want, got)
+		}
+	}
+}
+
+func TestPackSplitsAtPayloadLimit(t *testing.T) {
+	packets := pack([]string{"a:1|g", "b:2|g", "c:3|g"}, 11)
+	if len(packets) != 2 || string(packets[0]) != "a:1|g\nb:2|g" || string(packets[1]) != "c:3|g" {
+		t.Fatalf("unexpected packets: %q", packets)
+	}
+
+	if _, err := NewEmitter(Config{Address: "localhost"}, nil); err == nil {
+		t.Fatalf("expected error for address without port")
+	}
+}
@@ -0,0 +1,86 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X nvidia-license-server-exporter/internal/version.Version=v1.2.0 \
+//	  -X nvidia-license-server-exporter/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X nvidia-license-server-exporter/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty fall back to the module and VCS information Go embeds
+// in the binary, then to "unknown".
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+// Get returns the build information of the running binary.
+func Get() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+			info.Version = embedded.Main.Version
+		}
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+	for _, field := range []*string{&info.Version, &info.Commit, &info.BuildDate} {
+		if *field == "" {
+			*field = "unknown"
+		}
+	}
+	return info
+}
+
+// String formats the build information for -version.
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("nvidia-license-server-exporter, version %s (commit: %s, build date: %s, go: %s)", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
+
+// NewCollector returns the constant nvidia_cls_exporter_build_info gauge.
+func NewCollector() prometheus.Collector {
+	info := Get()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nvidia_cls_exporter_build_info",
+		Help: "Build information of the exporter; the value is always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"build_date": info.BuildDate,
+			"goversion":  info.GoVersion,
+		},
+	})
+	gauge.Set(1)
+	return gauge
+}
@@ -0,0 +1,39 @@
+package version
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBuildInfo(t *testing.T) {
+	Version, Commit, BuildDate = "v1.2.3", "abc123", "2026-10-16T00:00:00Z"
+	defer func() { Version, Commit, BuildDate = "", "", "" }()
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.BuildDate != "2026-10-16T00:00:00Z" || info.GoVersion != runtime.Version() {
+		t.Fatalf("unexpected build info: %+v", info)
+	}
+	if got := info.String(); !strings.Contains(got, "version v1.2.3") || !strings.Contains(got, "commit: abc123") {
+		t.Fatalf("unexpected version string %q", got)
+	}
+
+	want := `
+# HELP nvidia_cls_exporter_build_info Build information of the exporter; the value is always 1.
+# TYPE nvidia_cls_exporter_build_info gauge
+nvidia_cls_exporter_build_info{build_date="2026-10-16T00:00:00Z",commit="abc123",goversion="` + runtime.Version() + `",version="v1.2.3"} 1
+`
+	if err := testutil.CollectAndCompare(NewCollector(), strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildInfoDefaultsToUnknown(t *testing.T) {
+	info := Get()
+	// Test binaries carry no VCS stamp or module version.
+	if info.Version != "unknown" || info.GoVersion == "" {
+		t.Fatalf("unexpected build info: %+v", info)
+	}
+}
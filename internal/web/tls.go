@@ -0,0 +1,161 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// TLSServerConfig turns the listener into HTTPS, optionally with client
+// certificate verification (mutual TLS).
+type TLSServerConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuthType is one of the crypto/tls names, e.g.
+	// RequireAndVerifyClientCert. It defaults to RequireAndVerifyClientCert
+	// when ClientCAFile is set and to NoClientCert otherwise.
+	ClientAuthType string `yaml:"client_auth_type"`
+	// ClientCAFile is the PEM bundle client certificates are verified
+	// against.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAllowedCNs, when set, restricts verified client certificates to
+	// these subject common names.
+	ClientAllowedCNs []string `yaml:"client_allowed_cns"`
+	// MinVersion is the lowest TLS version accepted, TLS10 to TLS13; see
+	// ParseTLSVersion. It defaults to TLS12.
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites restricts TLS 1.2 connections to these suites, by their
+	// crypto/tls names; see ParseCipherSuites. TLS 1.3 suites are not
+	// configurable.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version as written in the exporter-toolkit
+// web config, TLS10 to TLS13. Empty returns TLS 1.2.
+func ParseTLSVersion(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, use TLS10, TLS11, TLS12 or TLS13", s)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses cipher suite names as in crypto/tls, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites Go considers insecure are
+// rejected. No names returns nil, which leaves the Go defaults.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		i := slices.IndexFunc(tls.CipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name })
+		if i < 0 {
+			if slices.ContainsFunc(tls.InsecureCipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name }) {
+				return nil, fmt.Errorf("cipher suite %s is insecure", name)
+			}
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, tls.CipherSuites()[i].ID)
+	}
+	return ids, nil
+}
+
+// build loads the certificates and returns the server TLS config.
+func (t *TLSServerConfig) build() (*tls.Config, error) {
+	if strings.TrimSpace(t.CertFile) == "" || strings.TrimSpace(t.KeyFile) == "" {
+		return nil, fmt.Errorf("tls_server_config requires cert_file and key_file")
+	}
+	authType := strings.TrimSpace(t.ClientAuthType)
+	if authType == "" {
+		authType = "NoClientCert"
+		if strings.TrimSpace(t.ClientCAFile) != "" {
+			authType = "RequireAndVerifyClientCert"
+		}
+	}
+	clientAuth, ok := clientAuthTypes[authType]
+	if !ok {
+		return nil, fmt.Errorf("tls_server_config: unsupported client_auth_type %q", t.ClientAuthType)
+	}
+	verifies := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+	if verifies && strings.TrimSpace(t.ClientCAFile) == "" {
+		return nil, fmt.Errorf("tls_server_config: client_auth_type %s requires client_ca_file", authType)
+	}
+	if len(t.ClientAllowedCNs) > 0 && !verifies {
+		return nil, fmt.Errorf("tls_server_config: client_allowed_cns requires client_auth_type VerifyClientCertIfGiven or RequireAndVerifyClientCert")
+	}
+	minVersion, err := ParseTLSVersion(t.MinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("tls_server_config: min_version: %w", err)
+	}
+	cipherSuites, err := ParseCipherSuites(t.CipherSuites)
+	if err != nil {
+		return nil, fmt.Errorf("tls_server_config: cipher_suites: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls_server_config: load certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+	if len(cipherSuites) > 0 && minVersion < tls.VersionTLS13 &&
+		!slices.Contains(cipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!slices.Contains(cipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		// HTTP/2 clients reject TLS 1.2 connections without one of these.
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+	if strings.TrimSpace(t.ClientCAFile) != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls_server_config: read client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls_server_config: no certificates found in client_ca_file %s", t.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	if len(t.ClientAllowedCNs) > 0 {
+		allowed := slices.Clone(t.ClientAllowedCNs)
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				// Only reachable with VerifyClientCertIfGiven.
+				return nil
+			}
+			cn := cs.PeerCertificates[0].Subject.CommonName
+			if !slices.Contains(allowed, cn) {
+				return fmt.Errorf("client certificate common name %q is not allowed", cn)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,197 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func issue(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key, pem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+func (c *testCert) keyPEM(t *testing.T) string {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestTLSServerConfigVerifiesClientCertificates(t *testing.T) {
+	ca := issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "exporter"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := func(cn string) *testCert {
+		return issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca)
+	}
+
+	cfg, err := LoadConfig(writeFile(t, "web.yml", "tls_server_config:\n"+
+		"  cert_file: "+writeFile(t, "server.crt", server.pem)+"\n"+
+		"  key_file: "+writeFile(t, "server.key", server.keyPEM(t))+"\n"+
+		"  client_ca_file: "+writeFile(t, "ca.crt", ca.pem)+"\n"+
+		"  client_allowed_cns: [prometheus]\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.TLS().ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("client_ca_file should default to RequireAndVerifyClientCert, got %v", cfg.TLS().ClientAuth)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = cfg.TLS()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) error {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := httpClient.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(client("prometheus").tlsCertificate()); err != nil {
+		t.Fatalf("allowed client rejected: %v", err)
+	}
+	if err := get(); err == nil {
+		t.Fatalf("request without client certificate accepted")
+	}
+	if err := get(client("intruder").tlsCertificate()); err == nil {
+		t.Fatalf("client with disallowed common name accepted")
+	}
+	other := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	stranger := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "prometheus"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, other)
+	if err := get(stranger.tlsCertificate()); err == nil {
+		t.Fatalf("client certificate from another CA accepted")
+	}
+}
+
+func TestTLSServerConfigRejectsInvalidSettings(t *testing.T) {
+	for name, tlsConfig := range map[string]string{
+		"missing key":           "  cert_file: server.crt\n",
+		"unknown auth type":     "  cert_file: a\n  key_file: b\n  client_auth_type: Always\n",
+		"verify without ca":     "  cert_file: a\n  key_file: b\n  client_auth_type: RequireAndVerifyClientCert\n",
+		"cns without verifying": "  cert_file: a\n  key_file: b\n  client_allowed_cns: [prometheus]\n",
+	} {
+		_, err := LoadConfig(writeFile(t, "web.yml", "tls_server_config:\n"+tlsConfig))
+		// Settings are checked before the (missing) files are read.
+		if err == nil || strings.Contains(err.Error(), "load certificate") {
+			t.Fatalf("%s: expected settings error, got %v", name, err)
+		}
+	}
+}
+
+func TestTLSServerConfigVersionAndCipherSuites(t *testing.T) {
+	server := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "exporter"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	files := "  cert_file: " + writeFile(t, "server.crt", server.pem) + "\n" +
+		"  key_file: " + writeFile(t, "server.key", server.keyPEM(t)) + "\n"
+
+	cfg, err := LoadConfig(writeFile(t, "web.yml", "tls_server_config:\n"+files+
+		"  min_version: TLS12\n"+
+		"  cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := cfg.TLS().CipherSuites; len(got) != 1 || got[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Fatalf("cipher suites: got %v", got)
+	}
+	if slices.Contains(cfg.TLS().NextProtos, "h2") {
+		t.Fatalf("HTTP/2 offered without a cipher suite it requires")
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	srv.TLS = cfg.TLS()
+	srv.StartTLS()
+	defer srv.Close()
+	dial := func(client *tls.Config) error {
+		client.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), client)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	if err := dial(&tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}}); err != nil {
+		t.Fatalf("allowed cipher suite rejected: %v", err)
+	}
+	if err := dial(&tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}}); err == nil {
+		t.Fatalf("other cipher suite accepted")
+	}
+	if err := dial(&tls.Config{MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Fatalf("TLS 1.1 accepted")
+	}
+
+	for name, setting := range map[string]string{
+		"unknown version": "  min_version: SSL3\n",
+		"unknown suite":   "  cipher_suites: [TLS_FAST]\n",
+		"insecure suite":  "  cipher_suites: [TLS_RSA_WITH_RC4_128_SHA]\n",
+	} {
+		if _, err := LoadConfig(writeFile(t, "web.yml", "tls_server_config:\n"+files+setting)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
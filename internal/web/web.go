@@ -0,0 +1,168 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the web config file, which protects the HTTP endpoints. It
+// follows the Prometheus exporter-toolkit layout, so existing files can be
+// reused:
+//
+//	basic_auth_users:
+//	  prometheus: $2y$10$...
+//	bearer_token_file: /run/secrets/scrape-token
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+type Config struct {
+	// BasicAuthUsers maps user names to bcrypt password hashes.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	// BearerTokenFile holds a static token accepted as
+	// "Authorization: Bearer <token>". Surrounding whitespace is ignored.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	// TLSServerConfig serves HTTPS instead of HTTP when set.
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config"`
+
+	bearerToken string
+	tlsConfig   *tls.Config
+}
+
+// LoadConfig reads and validates the web config file at path, including the
+// bearer token and certificate files it references.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read web config file: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse web config file %s: %w", path, err)
+	}
+	for user, hash := range cfg.BasicAuthUsers {
+		if user == "" {
+			return nil, fmt.Errorf("web config: basic_auth_users has an empty user name")
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("web config: invalid bcrypt hash for user %q: %w", user, err)
+		}
+	}
+	if strings.TrimSpace(cfg.BearerTokenFile) != "" {
+		token, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("web config: read bearer_token_file: %w", err)
+		}
+		cfg.bearerToken = strings.TrimSpace(string(token))
+		if cfg.bearerToken == "" {
+			return nil, fmt.Errorf("web config: bearer_token_file %s is empty", cfg.BearerTokenFile)
+		}
+	}
+	if cfg.TLSServerConfig != nil {
+		tlsConfig, err := cfg.TLSServerConfig.build()
+		if err != nil {
+			return nil, fmt.Errorf("web config: %w", err)
+		}
+		cfg.tlsConfig = tlsConfig
+	}
+	return cfg, nil
+}
+
+// TLS returns the server TLS config, or nil to serve plain HTTP. The
+// certificates are loaded once by LoadConfig.
+func (c *Config) TLS() *tls.Config {
+	return c.tlsConfig
+}
+
+// BearerToken returns the token read from BearerTokenFile, so that it can
+// be kept out of logs.
+func (c *Config) BearerToken() string {
+	return c.bearerToken
+}
+
+// AuthEnabled reports whether requests have to authenticate.
+func (c *Config) AuthEnabled() bool {
+	return len(c.BasicAuthUsers) > 0 || c.bearerToken != ""
+}
+
+// RequireAuth returns next behind the configured authentication. A request is
+// let through with valid basic auth credentials or the bearer token; when
+// neither is configured, every request is.
+func (c *Config) RequireAuth(next http.Handler) http.Handler {
+	if !c.AuthEnabled() {
+		return next
+	}
+	verified := &verifiedCache{seen: make(map[[sha256.Size]byte]struct{})}
+	challenge := `Bearer realm="nvidia-license-server-exporter"`
+	if len(c.BasicAuthUsers) > 0 {
+		challenge = `Basic realm="nvidia-license-server-exporter"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.authenticate(r, verified) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// dummyHash is compared against for unknown users, so that a response does
+// not reveal whether a user exists.
+const dummyHash = "$2a$10$g/EKr6v11Z6uKBIBv/yaFeg/qgl4KArBY6HqIGDsxDfaR9WGhLFVu"
+
+func (c *Config) authenticate(r *http.Request, verified *verifiedCache) bool {
+	if c.bearerToken != "" {
+		if got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			return subtle.ConstantTimeCompare([]byte(got), []byte(c.bearerToken)) == 1
+		}
+	}
+	if len(c.BasicAuthUsers) == 0 {
+		return false
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, known := c.BasicAuthUsers[user]
+	if !known {
+		hash = dummyHash
+	}
+	// bcrypt is deliberately slow; Prometheus scrapes with the same
+	// credentials every interval, so remember the ones that passed.
+	key := sha256.Sum256([]byte(hash + "\x00" + user + "\x00" + password))
+	if verified.has(key) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !known {
+		return false
+	}
+	verified.add(key)
+	return true
+}
+
+type verifiedCache struct {
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]struct{}
+}
+
+func (v *verifiedCache) has(key [sha256.Size]byte) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, ok := v.seen[key]
+	return ok
+}
+
+func (v *verifiedCache) add(key [sha256.Size]byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.seen[key] = struct{}{}
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// secretHash is bcrypt("secret") at the minimum cost.
+const secretHash = "$2a$04$ShL0Xgcelc0BwEetKeUdi.ExjnJcPnIWmVV7KndGPi1gx5U4NSLdq"
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestRequireAuth(t *testing.T) {
+	tokenFile := writeFile(t, "token", "s3cr3t-token\n")
+	cfg, err := LoadConfig(writeFile(t, "web.yml", "basic_auth_users:\n  prometheus: "+secretHash+"\nbearer_token_file: "+tokenFile+"\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	handler := cfg.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name string
+		set  func(*http.Request)
+		want int
+	}{
+		{"none", func(*http.Request) {}, http.StatusUnauthorized},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") }, http.StatusOK},
+		{"basic again", func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") }, http.StatusOK},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("prometheus", "guess") }, http.StatusUnauthorized},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t-token") }, http.StatusOK},
+		{"wrong bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		tt.set(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: missing WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestLoadConfigRejectsInvalidFiles(t *testing.T) {
+	for name, content := range map[string]string{
+		"plaintext password": "basic_auth_users:\n  prometheus: secret\n",
+		"unknown key":        "basic_auth_user:\n  prometheus: " + secretHash + "\n",
+		"empty token file":   "bearer_token_file: " + writeFile(t, "empty", "\n") + "\n",
+		"missing token file": "bearer_token_file: /nonexistent/token\n",
+	} {
+		if _, err := LoadConfig(writeFile(t, "web.yml", content)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	cfg, err := LoadConfig(writeFile(t, "web.yml", "{}\n"))
+	if err != nil {
+		t.Fatalf("load empty: %v", err)
+	}
+	if cfg.AuthEnabled() {
+		t.Fatalf("empty web config should not enable auth")
+	}
+}